@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"godb/internal/storage"
+)
+
+// The check command prints the report as JSON on stdout and exits with status
+// 1 when problems were found. Without -wal the database is opened read-only,
+// so it can be checked while another process only reads it.
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database, replayed before the check")
+	flags.Parse(args)
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{
+		WALPath:  *walPath,
+		ReadOnly: *walPath == "",
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Check()
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(report); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d problems found", len(report.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"godb/internal/query"
+	"godb/internal/storage"
+)
+
+// The dump command writes a SQL script that recreates the database when run
+// with `godb sql`, which is how data moves between file-format versions.
+// Without -wal the database is opened read-only.
+func runDump(args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database, replayed before dumping")
+	out := flags.String("out", "", "file to write, stdout when empty")
+	batch := flags.Int("batch", query.DefaultDumpBatchSize, "rows per INSERT statement")
+	flags.Parse(args)
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{
+		WALPath:  *walPath,
+		ReadOnly: *walPath == "",
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := query.DumpOptions{BatchSize: *batch}
+	if *out == "" {
+		_, err := query.Dump(db, os.Stdout, opts)
+		return err
+	}
+
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	report, err := query.Dump(db, file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Dumped %d tables, %d indexes and %d rows to %s\n", report.Tables, report.Indexes, report.Rows, *out)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"godb/internal/query"
+	"godb/internal/storage"
+)
+
+// The export command writes a table, or the rows of a SELECT, like COPY ... TO
+// does. Without -out the rows go to stdout, and without -wal the database is
+// opened read-only.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database, replayed before exporting")
+	tableName := flags.String("table", "", "table to export")
+	sql := flags.String("query", "", "SELECT whose rows to export")
+	out := flags.String("out", "", "file to write, stdout when empty")
+	format := flags.String("format", query.FormatCSV, "csv or ndjson")
+	header := flags.Bool("header", true, "CSV only: write the column names first")
+	delimiter := flags.String("delimiter", ",", "CSV only: field separator")
+	flags.Parse(args)
+
+	if (*tableName == "") == (*sql == "") {
+		return errors.New("one of -table or -query is required")
+	}
+	if *format != query.FormatCSV && *format != query.FormatNDJSON {
+		return fmt.Errorf("unknown -format %q, expected csv or ndjson", *format)
+	}
+	if utf8.RuneCountInString(*delimiter) != 1 {
+		return errors.New("-delimiter must be a single character")
+	}
+	comma, _ := utf8.DecodeRuneInString(*delimiter)
+
+	source := &query.Query{Type: query.QuerySelect, Fields: []string{"*"}, Table: *tableName}
+	if *sql != "" {
+		parsed, err := query.ParseSQL(*sql)
+		if err != nil {
+			return err
+		}
+		if parsed.Type != query.QuerySelect {
+			return fmt.Errorf("-query must be a SELECT, got %s", parsed.Type)
+		}
+		source = parsed
+	}
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{
+		WALPath:  *walPath,
+		ReadOnly: *walPath == "",
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := query.CopyOptions{Format: *format, Header: *header, Delimiter: comma}
+	if *out == "" {
+		_, err := query.Export(db, source, os.Stdout, opts)
+		return err
+	}
+	n, err := query.ExportFile(db, source, *out, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rows to %s\n", n, *out)
+	return nil
+}
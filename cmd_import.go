@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"unicode/utf8"
+
+	"godb/internal/query"
+	"godb/internal/storage"
+)
+
+// The import command loads a CSV file like COPY ... FROM does. Rejected rows
+// are listed with their line numbers and do not stop the import.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database")
+	tableName := flags.String("table", "", "table to load into")
+	file := flags.String("file", "", "CSV file to load")
+	header := flags.Bool("header", false, "the first line names the columns")
+	delimiter := flags.String("delimiter", ",", "field separator")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	if *tableName == "" || *file == "" {
+		return errors.New("-table and -file are required")
+	}
+	if utf8.RuneCountInString(*delimiter) != 1 {
+		return errors.New("-delimiter must be a single character")
+	}
+	comma, _ := utf8.DecodeRuneInString(*delimiter)
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{WALPath: *walPath})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := query.ImportCSVFile(db, *tableName, *file, query.CopyOptions{Header: *header, Delimiter: comma})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+
+	fmt.Printf("Loaded %d rows into %s\n", report.Loaded, *tableName)
+	if len(report.Rejected) > 0 {
+		fmt.Printf("Rejected %d rows:\n", len(report.Rejected))
+		for _, row := range report.Rejected {
+			fmt.Printf("  line %d: %s\n", row.Line, row.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"godb/internal/storage"
+)
+
+// The inspect command prints one page with -page or the page list of a table
+// or index with -table. Like check, it opens the database read-only unless a WAL has
+// to be replayed first.
+func runInspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database, replayed before inspecting")
+	pageID := flags.Int64("page", -1, "page to decode")
+	tableName := flags.String("table", "", "table or index whose pages to list")
+	dump := flags.Bool("hex", false, "with -page, include hex dumps of the page and each record")
+	asJSON := flags.Bool("json", false, "print JSON instead of text")
+	flags.Parse(args)
+
+	if (*pageID < 0) == (*tableName == "") {
+		return errors.New("one of -page or -table is required")
+	}
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{
+		WALPath:  *walPath,
+		ReadOnly: *walPath == "",
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *tableName != "" {
+		pages, err := db.InspectTable(*tableName)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(pages)
+		}
+		printTablePages(*tableName, pages)
+		return nil
+	}
+
+	page, err := db.InspectPage(uint64(*pageID))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(pageOutput(page, *dump))
+	}
+	printPage(page, *dump)
+	return nil
+}
+
+// inspectedPage adds optional hex dumps to the JSON form of a page
+type inspectedPage struct {
+	*storage.PageInfo
+	Slots []inspectedSlot `json:"slots"`
+	Hex   string          `json:"hex,omitempty"`
+}
+
+type inspectedSlot struct {
+	storage.SlotInfo
+	Hex string `json:"hex,omitempty"`
+}
+
+func pageOutput(page *storage.PageInfo, dump bool) inspectedPage {
+	out := inspectedPage{PageInfo: page, Slots: make([]inspectedSlot, len(page.Slots))}
+	for i, slot := range page.Slots {
+		out.Slots[i].SlotInfo = slot
+		if dump {
+			out.Slots[i].Hex = hex.EncodeToString(slot.Raw(page))
+		}
+	}
+	if dump {
+		out.Hex = hex.EncodeToString(page.Data)
+	}
+	return out
+}
+
+func printJSON(v interface{}) error {
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(v)
+}
+
+func printTablePages(name string, pages []storage.PageSummary) {
+	fmt.Printf("table %s: %d pages\n", name, len(pages))
+	fmt.Printf("%8s %6s %6s %10s %10s\n", "page", "slots", "live", "free", "lsn")
+	for _, page := range pages {
+		fmt.Printf("%8d %6d %6d %10d %10d\n", page.ID, page.Slots, page.Live, page.FreeBytes, page.LSN)
+	}
+}
+
+func printPage(page *storage.PageInfo, dump bool) {
+	owner := page.Table
+	if owner == "" {
+		owner = "no known table"
+	}
+	h := page.Header
+	fmt.Printf("page %d (%s page, %s)\n", page.ID, h.Type, owner)
+	if page.File != nil {
+		fmt.Printf("  file format version %d, %d byte pages\n", page.File.Version, page.File.PageSize)
+		if dump {
+			fmt.Printf("\nraw page:\n%s", hex.Dump(page.Data))
+		}
+		return
+	}
+	if page.Node != nil {
+		printNode(page, dump)
+		return
+	}
+	if page.Hash != nil {
+		printHashPage(page, dump)
+		return
+	}
+	fmt.Printf("  slot count %d, last slot %d, free space pointer %d\n", h.SlotCount, h.LastSlotID, h.FreeSpace)
+	fmt.Printf("  table ID %d, LSN %d, %d bytes free\n\n", h.TableID, h.LSN, page.FreeBytes)
+
+	fmt.Printf("%6s %8s %8s %7s  %s\n", "slot", "offset", "length", "flags", "record")
+	for _, slot := range page.Slots {
+		record := fmt.Sprint(slot.Values)
+		switch {
+		case slot.Error != "":
+			record = "error: " + slot.Error
+		case slot.Deleted:
+			record = "deleted " + record
+		}
+		fmt.Printf("%6d %8d %8d %#7x  %s\n", slot.Slot, slot.Offset, slot.Length, slot.Flags, record)
+		if dump {
+			fmt.Print(hex.Dump(slot.Raw(page)))
+		}
+	}
+
+	if dump {
+		fmt.Printf("\nraw page:\n%s", hex.Dump(page.Data))
+	}
+}
+
+func printNode(page *storage.PageInfo, dump bool) {
+	h, node := page.Header, page.Node
+	fmt.Printf("  index ID %d, LSN %d, %d bytes free\n", h.TableID, h.LSN, page.FreeBytes)
+	switch {
+	case node.Error != "":
+		fmt.Printf("  error: %s\n", node.Error)
+	case node.Leaf:
+		fmt.Printf("  leaf node, %d keys, previous leaf %d, next leaf %d\n\n", len(node.Keys), node.Prev, node.Next)
+		fmt.Printf("%20s  %s\n", "key", "record")
+		for i, key := range node.Keys {
+			fmt.Printf("%20s  %d:%d\n", key, node.Values[i].PageID, node.Values[i].SlotNum)
+		}
+	default:
+		fmt.Printf("  internal node, %d keys\n\n", len(node.Keys))
+		fmt.Printf("%20s  %-12s  %s\n", "key", "record", "child page")
+		fmt.Printf("%20s  %-12s  %d\n", "", "", node.Children[0])
+		for i, key := range node.Keys {
+			record := fmt.Sprintf("%d:%d", node.Values[i].PageID, node.Values[i].SlotNum)
+			fmt.Printf("%20s  %-12s  %d\n", key, record, node.Children[i+1])
+		}
+	}
+
+	if dump {
+		fmt.Printf("\nraw page:\n%s", hex.Dump(page.Data))
+	}
+}
+
+func printHashPage(page *storage.PageInfo, dump bool) {
+	h, hash := page.Header, page.Hash
+	fmt.Printf("  index ID %d, LSN %d, %d bytes free\n", h.TableID, h.LSN, page.FreeBytes)
+	switch {
+	case hash.Error != "":
+		fmt.Printf("  error: %s\n", hash.Error)
+	case hash.Kind == "root":
+		fmt.Printf("  directory root, global depth %d, directory pages %v\n", hash.Depth, hash.Pages)
+	case hash.Kind == "directory":
+		fmt.Printf("  directory page, %d slots naming buckets %v\n", len(hash.Pages), hash.Pages)
+	default:
+		fmt.Printf("  bucket, local depth %d, %d keys, next overflow page %d\n\n", hash.Depth, len(hash.Keys), hash.Next)
+		fmt.Printf("%20s  %s\n", "key", "record")
+		for i, key := range hash.Keys {
+			fmt.Printf("%20s  %d:%d\n", key, hash.Values[i].PageID, hash.Values[i].SlotNum)
+		}
+	}
+
+	if dump {
+		fmt.Printf("\nraw page:\n%s", hex.Dump(page.Data))
+	}
+}
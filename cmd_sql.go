@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"godb/internal/query"
+	"godb/internal/storage"
+)
+
+// The sql command runs the statements of a script, such as a dump, from
+// -file or stdin and prints each result
+func runSQL(args []string) error {
+	flags := flag.NewFlagSet("sql", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database")
+	file := flags.String("file", "", "script to run, stdin when empty")
+	quiet := flags.Bool("q", false, "only print the results of SELECT")
+	flags.Parse(args)
+
+	in := os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := storage.NewDatabaseWithOptions(*dbPath, storage.Options{WALPath: *walPath})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	_, err = query.NewQueryProcessor(db).ExecuteScript(in, func(statement string, result query.Result) error {
+		printResult(out, result, *quiet)
+		return nil
+	})
+	return err
+}
+
+// printResult writes the rows of a SELECT tab separated, then the message
+func printResult(out *bufio.Writer, result query.Result, quiet bool) {
+	if result.Columns != nil {
+		fmt.Fprintln(out, strings.Join(result.Columns, "\t"))
+		for _, row := range result.Rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				fields[i] = fmt.Sprint(value)
+				if value == nil {
+					fields[i] = "NULL"
+				}
+			}
+			fmt.Fprintln(out, strings.Join(fields, "\t"))
+		}
+	}
+	if !quiet || result.Columns != nil {
+		fmt.Fprintln(out, result.Message)
+	}
+}
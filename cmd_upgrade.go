@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"godb/internal/storage"
+)
+
+// The upgrade command rewrites a database written by an older build in the
+// current format version, see FORMAT.md. The database must not be open
+// elsewhere. Pass the log with -wal, or its changes are lost.
+func runUpgrade(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	dbPath := flags.String("db", "testdb.db", "database file")
+	walPath := flags.String("wal", "", "write-ahead log of the database, replayed and rewritten with it")
+	flags.Parse(args)
+
+	report, err := storage.Upgrade(*dbPath, storage.Options{WALPath: *walPath})
+	if err != nil {
+		return err
+	}
+	if !report.Upgraded() {
+		fmt.Printf("%s is already at format version %d\n", *dbPath, report.To)
+		return nil
+	}
+	fmt.Printf("Upgraded %s from format version %d to %d (%d pages, %d log entries replayed)\n",
+		*dbPath, report.From, report.To, report.Pages, report.Replayed)
+	return nil
+}
@@ -0,0 +1,222 @@
+package query
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"godb/internal/storage"
+)
+
+// COPY moves rows between tables and CSV files; COPY TO, in export.go, also
+// writes NDJSON. CSV fields map to column types as follows when loading; an
+// empty field is NULL.
+//
+//	INTEGER    decimal integer
+//	VARCHAR    the text as is, at most Length bytes when Length is set
+//	BOOLEAN    true/false, t/f or 1/0 in any case
+//	TIMESTAMP  Unix seconds, or RFC 3339 text that is stored as Unix seconds
+
+// ImportReport describes the result of a CSV import
+type ImportReport struct {
+	Loaded   int           `json:"loaded"`
+	Rejected []RejectedRow `json:"rejected"`
+}
+
+// RejectedRow is a CSV row that could not be loaded
+type RejectedRow struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportCSVFile loads the CSV file at path into a table, see ImportCSV
+func ImportCSVFile(db *storage.Database, tableName, path string, opts CopyOptions) (*ImportReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ImportCSV(db, tableName, file, opts)
+}
+
+// ImportCSV loads CSV rows into a table. Rows that do not parse or do not
+// match the table's columns, or whose key a unique index already holds, are
+// skipped and reported with their line number; the error is only set when the
+// import cannot go on at all. Should the indexes fail to take the rows, none
+// of them is loaded.
+func ImportCSV(db *storage.Database, tableName string, r io.Reader, opts CopyOptions) (*ImportReport, error) {
+	table, ok := db.Tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", tableName)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // checked per row so a short row is rejected, not fatal
+	reader.ReuseRecord = true
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+
+	// columns[i] is the table column filled by CSV field i
+	columns := make([]int, len(table.Columns))
+	for i := range columns {
+		columns[i] = i
+	}
+	if opts.Header {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading header: %w", err)
+		}
+		if columns, err = headerColumns(table, header); err != nil {
+			return nil, fmt.Errorf("header: %w", err)
+		}
+	}
+
+	loader, err := db.RecordManager.NewBulkLoader(table)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Rejected: []RejectedRow{}}
+	lines := make(map[storage.RecordID]int) // line of each loaded row
+	var readErr error
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Rejected = append(report.Rejected, RejectedRow{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		line, _ := reader.FieldPos(0)
+		record, err := csvRecord(db, table, columns, fields)
+		if err != nil {
+			report.Rejected = append(report.Rejected, RejectedRow{Line: line, Error: err.Error()})
+			continue
+		}
+		rid, err := loader.Insert(record)
+		if err != nil {
+			report.Rejected = append(report.Rejected, RejectedRow{Line: line, Error: err.Error()})
+			continue
+		}
+		lines[*rid] = line
+	}
+
+	// Rows whose key a unique index refuses are only found at the end
+	rejected, err := loader.Finish()
+	if err != nil {
+		return nil, errors.Join(readErr, err)
+	}
+	for _, r := range rejected {
+		report.Rejected = append(report.Rejected, RejectedRow{Line: lines[r.RID], Error: r.Err.Error()})
+	}
+	slices.SortStableFunc(report.Rejected, func(a, b RejectedRow) int { return a.Line - b.Line })
+	report.Loaded = loader.Loaded()
+	return report, readErr
+}
+
+// headerColumns maps the names in a CSV header or the column list of an
+// INSERT to table columns. Columns missing from the list are loaded as NULL.
+func headerColumns(table *storage.Table, header []string) ([]int, error) {
+	columns := make([]int, len(header))
+	seen := make(map[int]bool)
+	for i, name := range header {
+		index := columnIndex(table, strings.TrimSpace(name))
+		if index < 0 {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[index] {
+			return nil, fmt.Errorf("column %q named twice", name)
+		}
+		seen[index] = true
+		columns[i] = index
+	}
+	return columns, nil
+}
+
+// columnIndex finds a column by name, ignoring case, or returns -1
+func columnIndex(table *storage.Table, name string) int {
+	for i, column := range table.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// csvRecord converts the fields of one CSV row into a record of the table
+func csvRecord(db *storage.Database, table *storage.Table, columns []int, fields []string) (*storage.Record, error) {
+	if len(fields) != len(columns) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(columns), len(fields))
+	}
+
+	values := make([]interface{}, len(table.Columns))
+	for i, field := range fields {
+		column := table.Columns[columns[i]]
+		value, err := parseField(column, field)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		values[columns[i]] = value
+	}
+	if err := fillAutoIncrement(db, table, values); err != nil {
+		return nil, err
+	}
+	for i, column := range table.Columns {
+		if column.NotNull && values[i] == nil {
+			return nil, fmt.Errorf("column %s: NULL in a NOT NULL column", column.Name)
+		}
+	}
+	return &storage.Record{Values: values}, nil
+}
+
+// parseField converts CSV text into a value for the column, see the table above
+func parseField(column storage.Column, text string) (interface{}, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	switch column.DataType {
+	case storage.TypeInteger:
+		n, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", text)
+		}
+		return n, nil
+	case storage.TypeVarchar:
+		if column.Length > 0 && len(text) > column.Length {
+			return nil, fmt.Errorf("%d bytes is longer than %d", len(text), column.Length)
+		}
+		return text, nil
+	case storage.TypeBoolean:
+		b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(text)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", text)
+		}
+		return b, nil
+	case storage.TypeTimestamp:
+		text = strings.TrimSpace(text)
+		if n, err := strconv.Atoi(text); err == nil {
+			return n, nil
+		}
+		ts, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", text)
+		}
+		return int(ts.Unix()), nil
+	}
+	return nil, fmt.Errorf("unsupported column type %d", column.DataType)
+}
@@ -0,0 +1,99 @@
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godb/internal/storage"
+)
+
+func TestCopyFrom(t *testing.T) {
+	query, err := ParseSQL("COPY users FROM 'users.csv' WITH (HEADER, DELIMITER ';');")
+	if err != nil {
+		t.Fatalf("Failed to parse COPY: %v", err)
+	}
+	if query.Table != "users" || query.File != "users.csv" || !query.Copy.Header || query.Copy.Delimiter != ';' {
+		t.Errorf("Unexpected COPY query %+v", query)
+	}
+
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	if err := db.CreateTable("users", []storage.Column{
+		{Name: "id", DataType: storage.TypeInteger, NotNull: true},
+		{Name: "name", DataType: storage.TypeVarchar, Length: 10},
+		{Name: "active", DataType: storage.TypeBoolean},
+		{Name: "joined", DataType: storage.TypeTimestamp},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	// Columns in a different order than the table, with bad rows mixed in
+	path := filepath.Join(t.TempDir(), "users.csv")
+	data := strings.Join([]string{
+		"name;id;active;joined",
+		"Alice;1;true;2024-01-02T03:04:05Z",
+		"Bob;two;false;0",
+		"\"Carol; C.\";3;;",
+		"Dave;4",
+		"Eve;;true;0",
+		"Frank with a long name;6;false;0",
+		"Grace;7;F;1700000000",
+		"Heidi;1;true;0",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+
+	qp := NewQueryProcessor(db)
+	result, err := qp.Execute("COPY users FROM '" + path + "' WITH (HEADER, DELIMITER ';')")
+	if err != nil {
+		t.Fatalf("COPY failed: %v", err)
+	}
+	if !strings.HasPrefix(result.Message, "COPY 3, 5 rows rejected") {
+		t.Errorf("Unexpected result %q", result.Message)
+	}
+	for _, want := range []string{"line 3: column id", "line 5: expected 4 fields", "line 6: column id: NULL", "line 7: column name", "line 9: duplicate key 1"} {
+		if !strings.Contains(result.Message, want) {
+			t.Errorf("Expected %q in result %q", want, result.Message)
+		}
+	}
+
+	var got [][]interface{}
+	err = db.RecordManager.Scan(db.Tables["users"], func(rid storage.RecordID, record *storage.Record) error {
+		got = append(got, record.Values)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	want := [][]interface{}{
+		{1, "Alice", true, 1704164645},
+		{3, "Carol; C.", nil, nil},
+		{7, "Grace", false, 1700000000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want %v", got, want)
+	}
+
+	// Keys already in the table are refused when the load ends
+	report, err := ImportCSV(db, "users", strings.NewReader("id\n3\n8\n8\n"), CopyOptions{Header: true})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Loaded != 1 || len(report.Rejected) != 2 || report.Rejected[0].Line != 2 || report.Rejected[1].Line != 4 {
+		t.Errorf("Expected lines 2 and 4 to be rejected, got %+v", report)
+	}
+
+	if _, err := ImportCSV(db, "missing", strings.NewReader(""), CopyOptions{}); err == nil {
+		t.Error("Expected importing into a missing table to fail")
+	}
+	if _, err := ImportCSV(db, "users", strings.NewReader("id,nickname\n"), CopyOptions{Header: true}); err == nil {
+		t.Error("Expected a header with an unknown column to fail")
+	}
+}
@@ -0,0 +1,243 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"godb/internal/storage"
+)
+
+// A dump is a SQL script that recreates a database through the SQL engine:
+// a CREATE SEQUENCE for every sequence of its own, a CREATE TABLE for every
+// table and a CREATE INDEX for every index over a table but the primary key
+// ones, which come with their tables, all in catalog order, then the rows of
+// each table as INSERTs of up to DumpOptions.BatchSize rows, in storage
+// order, and a setval for every sequence that was used. Running it on an
+// empty database with QueryProcessor.ExecuteScript gives the same tables and
+// indexes, created in the same order, holding the same rows, and sequences
+// that continue where they were. Their IDs are handed out one after another,
+// so they differ from the original's where a table or index was dropped.
+
+// DefaultDumpBatchSize is the number of rows per INSERT when none is set
+const DefaultDumpBatchSize = 100
+
+// DumpOptions control the shape of a dump
+type DumpOptions struct {
+	BatchSize int // rows per INSERT statement
+}
+
+// DumpReport counts what a dump wrote
+type DumpReport struct {
+	Tables  int `json:"tables"`
+	Indexes int `json:"indexes"` // created by CREATE INDEX, not counting primary keys
+	Rows    int `json:"rows"`
+}
+
+// Dump writes a dump of the database to w. The rows come from a snapshot,
+// see storage.Database.Snapshot, so writers are not blocked meanwhile. A
+// database opened read-only has no writers and is dumped as it is.
+func Dump(db *storage.Database, w io.Writer, opts DumpOptions) (*DumpReport, error) {
+	if db.ReadOnly {
+		return dumpDatabase(db, w, opts)
+	}
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("taking snapshot: %w", err)
+	}
+	defer snapshot.Close()
+	return dumpDatabase(snapshot, w, opts)
+}
+
+func dumpDatabase(db *storage.Database, w io.Writer, opts DumpOptions) (*DumpReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultDumpBatchSize
+	}
+
+	tables := make([]*storage.Table, 0, len(db.Tables))
+	for _, table := range db.Tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].ID < tables[j].ID })
+
+	sequences := make([]*storage.Sequence, 0, len(db.Sequences))
+	for _, s := range db.Sequences {
+		sequences = append(sequences, s)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i].Name < sequences[j].Name })
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "-- godb dump")
+	for _, s := range sequences {
+		if s.Table == "" {
+			fmt.Fprintf(out, "\nCREATE SEQUENCE %s START WITH %d INCREMENT BY %d;\n", quoteIdentifier(s.Name), s.Start, s.Increment)
+		}
+	}
+	// Tables and indexes are created in ID order, so they get their IDs back
+	type definition struct {
+		id  uint64
+		sql string
+	}
+	var definitions []definition
+	for _, table := range tables {
+		definitions = append(definitions, definition{table.ID, createTableSQL(table, db.AutoIncrement(table.Name))})
+	}
+	report := &DumpReport{Tables: len(tables)}
+	for _, table := range tables {
+		for _, index := range table.Indexes {
+			if index != table.PrimaryIndex() {
+				definitions = append(definitions, definition{index.Info().ID, createIndexSQL(index, table)})
+				report.Indexes++
+			}
+		}
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].id < definitions[j].id })
+	for _, d := range definitions {
+		fmt.Fprintf(out, "\n%s;\n", d.sql)
+	}
+
+	for _, table := range tables {
+		n, err := dumpRows(db, table, out, opts.BatchSize)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table.Name, err)
+		}
+		report.Rows += n
+	}
+
+	// After the rows, which move AUTOINCREMENT sequences past their values
+	for _, s := range sequences {
+		if last, used := s.LastValue(); used {
+			fmt.Fprintf(out, "\nSELECT setval(%s, %d);\n", stringLiteral(s.Name), last)
+		}
+	}
+	return report, out.Flush()
+}
+
+// createTableSQL returns the CREATE TABLE statement of a table; autoIncrement
+// is the sequence of its AUTOINCREMENT column or nil
+func createTableSQL(table *storage.Table, autoIncrement *storage.Sequence) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE %s (\n", quoteIdentifier(table.Name))
+	for _, column := range table.Columns {
+		fmt.Fprintf(&b, "    %s %s", quoteIdentifier(column.Name), typeSQL(column))
+		if column.NotNull {
+			b.WriteString(" NOT NULL")
+		}
+		if autoIncrement != nil && autoIncrement.Column == column.Name {
+			b.WriteString(" AUTOINCREMENT")
+		}
+		b.WriteString(",\n")
+	}
+	fmt.Fprintf(&b, "    PRIMARY KEY (%s)\n)", quoteIdentifier(table.Columns[table.PrimaryKey].Name))
+	return b.String()
+}
+
+func typeSQL(column storage.Column) string {
+	switch column.DataType {
+	case storage.TypeInteger:
+		return "INTEGER"
+	case storage.TypeVarchar:
+		if column.Length > 0 {
+			return fmt.Sprintf("VARCHAR(%d)", column.Length)
+		}
+		return "VARCHAR"
+	case storage.TypeBoolean:
+		return "BOOLEAN"
+	case storage.TypeTimestamp:
+		return "TIMESTAMP"
+	}
+	return fmt.Sprintf("TYPE%d", int(column.DataType))
+}
+
+// dumpRows writes the rows of a table as INSERT statements
+func dumpRows(db *storage.Database, table *storage.Table, w io.Writer, batchSize int) (int, error) {
+	names := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		names[i] = quoteIdentifier(column.Name)
+	}
+	insert := fmt.Sprintf("\nINSERT INTO %s (%s) VALUES\n", quoteIdentifier(table.Name), strings.Join(names, ", "))
+
+	n := 0
+	literals := make([]string, len(table.Columns))
+	err := db.RecordManager.Scan(table, func(rid storage.RecordID, record *storage.Record) error {
+		if len(record.Values) != len(table.Columns) {
+			return fmt.Errorf("record %v has %d values, table has %d columns", rid, len(record.Values), len(table.Columns))
+		}
+		for i, value := range record.Values {
+			literal, err := sqlLiteral(value)
+			if err != nil {
+				return fmt.Errorf("record %v: %w", rid, err)
+			}
+			literals[i] = literal
+		}
+
+		separator := ",\n"
+		if n%batchSize == 0 {
+			if n > 0 {
+				separator = ";\n" + insert
+			} else {
+				separator = insert
+			}
+		}
+		n++
+		_, err := fmt.Fprintf(w, "%s    (%s)", separator, strings.Join(literals, ", "))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		if _, err := io.WriteString(w, ";\n"); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// sqlLiteral writes a value the way the parser reads it back
+func sqlLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case string:
+		return stringLiteral(v), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("%v has no SQL literal", v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v of type %T", value, value)
+}
+
+func stringLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteIdentifier leaves names the lexer reads as one word alone and puts
+// the others in double quotes
+func quoteIdentifier(name string) string {
+	plain := name != ""
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || (i > 0 && unicode.IsDigit(r))) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godb/internal/storage"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	qp := NewQueryProcessor(db)
+	script := `
+		CREATE TABLE users (
+			id INTEGER NOT NULL,
+			name VARCHAR(40),
+			active BOOLEAN,
+			joined TIMESTAMP
+		);
+		CREATE INDEX users_joined ON users (joined DESC, name NULLS FIRST);
+		-- the key is not the first column; "order items" needs quoting
+		CREATE TABLE "order items" (note TEXT, "primary" INT NOT NULL PRIMARY KEY);
+		CREATE TABLE empty (id INT);
+		CREATE INDEX "order notes" ON "order items" USING HASH (note);
+		INSERT INTO "order items" ("primary", note) VALUES (1, 'it''s; -- not a comment'), (2, NULL), (3, 'two
+lines');
+		CREATE SEQUENCE invoices START WITH 1000 INCREMENT BY 10;
+		CREATE SEQUENCE unused;
+		CREATE TABLE events (id INTEGER PRIMARY KEY AUTOINCREMENT, note TEXT);
+		CREATE UNIQUE INDEX "events by note" ON events (note);
+		INSERT INTO events (note) VALUES ('a'), ('b');
+		INSERT INTO events VALUES (nextval('invoices'), 'c');
+	`
+	if _, err := qp.ExecuteScript(strings.NewReader(script), nil); err != nil {
+		t.Fatalf("Setup script failed: %v", err)
+	}
+	for i := 0; i < 250; i++ {
+		sql := fmt.Sprintf("INSERT INTO users VALUES (%d, 'User %d', %t, %d)", i, i, i%2 == 0, 1700000000+i)
+		if i%7 == 0 {
+			sql = fmt.Sprintf("INSERT INTO users (id, joined) VALUES (%d, '2024-01-02T03:04:05Z')", i)
+		}
+		if _, err := qp.Execute(sql); err != nil {
+			t.Fatalf("Insert %d failed: %v", i, err)
+		}
+	}
+
+	var dump strings.Builder
+	report, err := Dump(db, &dump, DumpOptions{BatchSize: 100})
+	if err != nil {
+		t.Fatalf("Dump failed: %v", err)
+	}
+	if report.Tables != 4 || report.Indexes != 3 || report.Rows != 256 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if n := strings.Count(dump.String(), "INSERT INTO users"); n != 3 {
+		t.Errorf("Expected 250 users in 3 INSERTs, got %d", n)
+	}
+
+	copyDB, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer copyDB.Close()
+	if _, err := NewQueryProcessor(copyDB).ExecuteScript(strings.NewReader(dump.String()), nil); err != nil {
+		t.Fatalf("Loading the dump failed: %v\n%s", err, dump.String())
+	}
+
+	for name, table := range db.Tables {
+		copied, ok := copyDB.Tables[name]
+		if !ok {
+			t.Errorf("Table %s is missing from the copy", name)
+			continue
+		}
+		if copied.ID != table.ID || copied.PrimaryKey != table.PrimaryKey || !reflect.DeepEqual(copied.Columns, table.Columns) {
+			t.Errorf("Table %s differs: %+v, want %+v", name, copied, table)
+		}
+		if got, want := tableRows(t, copyDB, copied), tableRows(t, db, table); !reflect.DeepEqual(got, want) {
+			t.Errorf("Rows of %s differ: %v, want %v", name, got, want)
+		}
+	}
+	for name, index := range db.Indexes {
+		copied, ok := copyDB.Indexes[name]
+		if !ok {
+			t.Errorf("Index %s is missing from the copy", name)
+			continue
+		}
+		if copied.ID != index.ID || copied.TableID != index.TableID || copied.Unique != index.Unique ||
+			!reflect.DeepEqual(copied.Columns, index.Columns) || !reflect.DeepEqual(copied.TableColumns, index.TableColumns) {
+			t.Errorf("Index %s differs: %+v, want %+v", name, copied, index)
+		}
+	}
+	if len(copyDB.Indexes) != len(db.Indexes) {
+		t.Errorf("Copy has %d indexes, want %d", len(copyDB.Indexes), len(db.Indexes))
+	}
+	for name, index := range db.HashIndexes {
+		copied, ok := copyDB.HashIndexes[name]
+		if !ok || !reflect.DeepEqual(copied.IndexInfo, index.IndexInfo) {
+			t.Errorf("Hash index %s differs in the copy", name)
+		}
+	}
+	if len(copyDB.HashIndexes) != len(db.HashIndexes) {
+		t.Errorf("Copy has %d hash indexes, want %d", len(copyDB.HashIndexes), len(db.HashIndexes))
+	}
+	if len(copyDB.Tables) != len(db.Tables) {
+		t.Errorf("Copy has %d tables, want %d", len(copyDB.Tables), len(db.Tables))
+	}
+
+	var again strings.Builder
+	if _, err := Dump(copyDB, &again, DumpOptions{BatchSize: 100}); err != nil {
+		t.Fatalf("Dump of the copy failed: %v", err)
+	}
+	if again.String() != dump.String() {
+		t.Error("Dump of the copy differs from the original dump")
+	}
+
+	// The copy never hands out a value the original already did
+	for name, s := range db.Sequences {
+		copied, ok := copyDB.Sequences[name]
+		if !ok {
+			t.Errorf("Sequence %s is missing from the copy", name)
+			continue
+		}
+		want, _ := s.NextVal()
+		if got, _ := copied.NextVal(); got < want || copied.Table != s.Table {
+			t.Errorf("Sequence %s of the copy continues at %d, the original at %d", name, got, want)
+		}
+	}
+}
+
+func tableRows(t *testing.T, db *storage.Database, table *storage.Table) [][]interface{} {
+	t.Helper()
+	var rows [][]interface{}
+	err := db.RecordManager.Scan(table, func(rid storage.RecordID, record *storage.Record) error {
+		rows = append(rows, record.Values)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan of %s failed: %v", table.Name, err)
+	}
+	return rows
+}
+
+func TestExecuteScriptErrors(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	qp := NewQueryProcessor(db)
+
+	script := "CREATE TABLE t (id INT NOT NULL, name VARCHAR(3));\n\n-- bad rows\nINSERT INTO t VALUES\n  (1, 'a'),\n  (NULL, 'b');\nINSERT INTO t VALUES (2, 'c');"
+	n, err := qp.ExecuteScript(strings.NewReader(script), nil)
+	if err == nil || n != 1 || !strings.HasPrefix(err.Error(), "line 4: row 2: column id: NULL") {
+		t.Errorf("Expected the INSERT on line 4 to fail after 1 statement, got %d, %v", n, err)
+	}
+	if rows := tableRows(t, db, db.Tables["t"]); len(rows) != 0 {
+		t.Errorf("A failed INSERT stored %v", rows)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO t VALUES (1, 'long')",
+		"INSERT INTO t VALUES ('1', 'a')",
+		"INSERT INTO t (id, nickname) VALUES (1, 'a')",
+		"INSERT INTO t VALUES (1)",
+		"CREATE TABLE t (id INT)",
+		"CREATE TABLE u (id INT, ID INT)",
+		"CREATE TABLE u (id INT, PRIMARY KEY (name))",
+	} {
+		if _, err := qp.Execute(sql); err == nil {
+			t.Errorf("Expected %q to fail", sql)
+		}
+	}
+
+	if _, err := qp.ExecuteScript(strings.NewReader("SELECT * FROM t; SELECT 'open"), nil); err == nil {
+		t.Error("Expected an unterminated quote to fail")
+	}
+}
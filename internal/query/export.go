@@ -0,0 +1,205 @@
+package query
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"godb/internal/storage"
+)
+
+// COPY TO writes each stored value type as follows:
+//
+//	ValueType   CSV field               NDJSON value
+//	TypeNull    empty field             null
+//	TypeInt     decimal integer         number
+//	TypeString  the text as is          string
+//	TypeBool    true/false              true/false
+//	TypeFloat   shortest decimal form   number, or "NaN"/"+Inf"/"-Inf" as a string
+//
+// TIMESTAMP columns are stored as Unix seconds and come out as integers, which
+// COPY FROM reads back. CSV cannot tell an empty string from NULL, so both load
+// back as NULL; NDJSON keeps them apart. NDJSON objects list the keys in
+// column order.
+
+// ExportFile writes the rows of a SELECT to a new file at path, see Export.
+// An existing file is not overwritten. The new file is removed again when the
+// export fails.
+func ExportFile(db *storage.Database, source *Query, path string, opts CopyOptions) (int, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return 0, err
+	}
+	n, err := Export(db, source, file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return n, nil
+}
+
+// Export streams the rows of a SELECT to w as CSV or NDJSON and returns the
+// number of rows written
+func Export(db *storage.Database, source *Query, w io.Writer, opts CopyOptions) (int, error) {
+	if source == nil || source.Type != QuerySelect {
+		return 0, errors.New("COPY TO needs a SELECT")
+	}
+
+	out := bufio.NewWriter(w)
+	var rows rowWriter
+	switch opts.Format {
+	case "", FormatCSV:
+		rows = newCSVWriter(out, opts)
+	case FormatNDJSON:
+		rows = &ndjsonWriter{w: out}
+	default:
+		return 0, fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	s, err := newSelection(db, source)
+	if err != nil {
+		return 0, err
+	}
+	if err := rows.start(s.columns); err != nil {
+		return 0, err
+	}
+	n := 0
+	err = s.scan(db, func(row []interface{}) error {
+		n++
+		return rows.write(row)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := rows.flush(); err != nil {
+		return 0, err
+	}
+	return n, out.Flush()
+}
+
+// rowWriter encodes exported rows. start is called with the column names
+// before any row is written.
+type rowWriter interface {
+	start(columns []string) error
+	write(row []interface{}) error
+	flush() error
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+	fields []string
+}
+
+func newCSVWriter(w io.Writer, opts CopyOptions) *csvWriter {
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		writer.Comma = opts.Delimiter
+	}
+	return &csvWriter{w: writer, header: opts.Header}
+}
+
+func (c *csvWriter) start(columns []string) error {
+	if !c.header {
+		return nil
+	}
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) write(row []interface{}) error {
+	if c.fields == nil {
+		c.fields = make([]string, len(row))
+	}
+	for i, value := range row {
+		field, err := csvField(value)
+		if err != nil {
+			return err
+		}
+		c.fields[i] = field
+	}
+	return c.w.Write(c.fields)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvField formats a value as CSV text, see the table above
+func csvField(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v of type %T", value, value)
+}
+
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte // JSON encoded column names
+}
+
+func (j *ndjsonWriter) start(columns []string) error {
+	j.keys = make([][]byte, len(columns))
+	for i, name := range columns {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	return nil
+}
+
+func (j *ndjsonWriter) write(row []interface{}) error {
+	j.w.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		j.w.WriteByte(':')
+		data, err := jsonValue(value)
+		if err != nil {
+			return err
+		}
+		j.w.Write(data)
+	}
+	j.w.WriteString("}\n")
+	return nil
+}
+
+func (j *ndjsonWriter) flush() error {
+	return nil
+}
+
+// jsonValue encodes a value for NDJSON, see the table above
+func jsonValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil, int, string, bool:
+		return json.Marshal(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		return json.Marshal(v)
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godb/internal/storage"
+)
+
+func TestCopyTo(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	if err := db.CreateTable("users", []storage.Column{
+		{Name: "id", DataType: storage.TypeInteger, NotNull: true},
+		{Name: "name", DataType: storage.TypeVarchar},
+		{Name: "active", DataType: storage.TypeBoolean},
+		{Name: "score", DataType: storage.TypeInteger},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	rows := [][]interface{}{
+		{1, "Alice", true, 1.5},
+		{2, "Bob, \"the builder\"\nJr.", false, nil},
+		{3, "", nil, math.Inf(1)},
+	}
+	for _, row := range rows {
+		if _, err := db.RecordManager.InsertRecord(db.Tables["users"], &storage.Record{Values: row}); err != nil {
+			t.Fatalf("Failed to insert %v: %v", row, err)
+		}
+	}
+
+	dir := t.TempDir()
+	qp := NewQueryProcessor(db)
+
+	t.Run("CSV", func(t *testing.T) {
+		path := filepath.Join(dir, "users.csv")
+		result, err := qp.Execute("COPY users TO '" + path + "' WITH (HEADER)")
+		if err != nil {
+			t.Fatalf("COPY TO failed: %v", err)
+		}
+		if result.Message != "COPY 3" {
+			t.Errorf("Unexpected result %q", result.Message)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+		want := "id,name,active,score\n" +
+			"1,Alice,true,1.5\n" +
+			"2,\"Bob, \"\"the builder\"\"\nJr.\",false,\n" +
+			"3,,,+Inf\n"
+		if string(data) != want {
+			t.Errorf("Exported\n%s\nwant\n%s", data, want)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		path := filepath.Join(dir, "users.ndjson")
+		if _, err := qp.Execute("COPY (SELECT name, id, active FROM users) TO '" + path + "' WITH (FORMAT NDJSON)"); err != nil {
+			t.Fatalf("COPY TO failed: %v", err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open export: %v", err)
+		}
+		defer file.Close()
+
+		var lines []string
+		var got []map[string]interface{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+			var object map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+				t.Fatalf("Line %q is not JSON: %v", scanner.Text(), err)
+			}
+			got = append(got, object)
+		}
+		want := []map[string]interface{}{
+			{"name": "Alice", "id": 1.0, "active": true},
+			{"name": "Bob, \"the builder\"\nJr.", "id": 2.0, "active": false},
+			{"name": "", "id": 3.0, "active": nil},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Exported %v, want %v", got, want)
+		}
+		if len(lines) > 0 && !strings.HasPrefix(lines[0], `{"name":"Alice","id":1,`) {
+			t.Errorf("Keys are not in column order: %s", lines[0])
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		var out strings.Builder
+		source := &Query{Type: QuerySelect, Fields: []string{"id", "name", "active"}, Table: "users"}
+		if _, err := Export(db, source, &out, CopyOptions{Header: true, Delimiter: ';'}); err != nil {
+			t.Fatalf("Export failed: %v", err)
+		}
+		if err := db.CreateTable("copy", db.Tables["users"].Columns[:3]); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		report, err := ImportCSV(db, "copy", strings.NewReader(out.String()), CopyOptions{Header: true, Delimiter: ';'})
+		if err != nil || report.Loaded != 3 {
+			t.Fatalf("Import loaded %+v, %v", report, err)
+		}
+		result, err := qp.Execute("SELECT * FROM copy")
+		if err != nil {
+			t.Fatalf("SELECT failed: %v", err)
+		}
+		// The empty name comes back as NULL, CSV cannot tell them apart
+		want := [][]interface{}{{1, "Alice", true}, {2, "Bob, \"the builder\"\nJr.", false}, {3, nil, nil}}
+		if !reflect.DeepEqual(result.Rows, want) {
+			t.Errorf("Round trip gave %v, want %v", result.Rows, want)
+		}
+	})
+
+	if _, err := qp.Execute("COPY (SELECT nickname FROM users) TO '" + filepath.Join(dir, "bad.csv") + "'"); err == nil {
+		t.Error("Expected exporting an unknown column to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.csv")); !os.IsNotExist(err) {
+		t.Error("Expected the failed export to leave no file")
+	}
+
+	// An existing file is neither overwritten nor removed
+	existing := filepath.Join(dir, "existing.csv")
+	if err := os.WriteFile(existing, []byte("keep me\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qp.Execute("COPY users TO '" + existing + "'"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected the export to refuse an existing file, got %v", err)
+	}
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "keep me\n" {
+		t.Errorf("Expected the existing file to be kept, got %q %v", data, err)
+	}
+}
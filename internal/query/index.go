@@ -0,0 +1,69 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"godb/internal/storage"
+)
+
+// CREATE [UNIQUE] INDEX adds an index over columns of a table, built from the
+// rows it already has. From then on every INSERT into the table adds the row
+// to the index, and a unique index refuses rows whose key it holds, like the
+// index every table gets on its primary key. The index is a B-tree unless
+// USING HASH asks for a hash index, which has no order. DROP INDEX removes an
+// index other than the primary key's.
+
+func (e *Executor) executeCreateIndex(plan *Query) (Result, error) {
+	table, ok := e.db.Tables[plan.Table]
+	if !ok {
+		return Result{}, fmt.Errorf("table %s does not exist", plan.Table)
+	}
+	opts := storage.IndexOptions{Order: plan.KeyColumns, Unique: plan.Unique, Hash: plan.Hash}
+	for _, name := range plan.Fields {
+		i := columnIndex(table, name)
+		if i < 0 {
+			return Result{}, fmt.Errorf("column %s does not exist in table %s", name, table.Name)
+		}
+		opts.Columns = append(opts.Columns, i)
+	}
+	if _, err := e.db.CreateIndex(plan.Index, table.Name, opts); err != nil {
+		return Result{}, fmt.Errorf("creating index %s: %w", plan.Index, err)
+	}
+	return Result{Message: "CREATE INDEX"}, nil
+}
+
+func (e *Executor) executeDropIndex(plan *Query) (Result, error) {
+	if err := e.db.DropIndex(plan.Index); err != nil {
+		return Result{}, fmt.Errorf("dropping index %s: %w", plan.Index, err)
+	}
+	return Result{Message: "DROP INDEX"}, nil
+}
+
+// createIndexSQL returns the CREATE INDEX statement of an index over table
+func createIndexSQL(index storage.Index, table *storage.Table) string {
+	info := index.Info()
+	columns := make([]string, len(info.TableColumns))
+	for i, column := range info.TableColumns {
+		columns[i] = quoteIdentifier(table.Columns[column].Name)
+		if i < len(info.Columns) {
+			if info.Columns[i].Descending {
+				columns[i] += " DESC"
+			}
+			switch info.Columns[i].Nulls {
+			case storage.NullsFirst:
+				columns[i] += " NULLS FIRST"
+			case storage.NullsLast:
+				columns[i] += " NULLS LAST"
+			}
+		}
+	}
+	unique, using := "", ""
+	if info.Unique {
+		unique = "UNIQUE "
+	}
+	if _, ok := index.(*storage.HashIndex); ok {
+		using = " USING HASH"
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s%s (%s)", unique, quoteIdentifier(info.Name), quoteIdentifier(table.Name), using, strings.Join(columns, ", "))
+}
@@ -0,0 +1,88 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+
+	"godb/internal/storage"
+)
+
+// executeInsert checks every row of an INSERT before storing any of them, so
+// a bad row leaves the table as it was. A row a unique index refuses is only
+// found while storing; the rows stored before it are deleted again.
+func (e *Executor) executeInsert(plan *Query) (Result, error) {
+	table, ok := e.db.Tables[plan.Table]
+	if !ok {
+		return Result{}, fmt.Errorf("table %s does not exist", plan.Table)
+	}
+
+	// columns[i] is the table column filled by value i of each row
+	columns := make([]int, len(table.Columns))
+	for i := range columns {
+		columns[i] = i
+	}
+	if plan.Fields != nil {
+		var err error
+		if columns, err = headerColumns(table, plan.Fields); err != nil {
+			return Result{}, fmt.Errorf("INSERT into %s: %w", table.Name, err)
+		}
+	}
+
+	records := make([]*storage.Record, len(plan.Rows))
+	for i, row := range plan.Rows {
+		row, err := e.evaluateRow(row)
+		if err != nil {
+			return Result{}, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		record, err := insertRecord(e.db, table, columns, row)
+		if err != nil {
+			return Result{}, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		records[i] = record
+	}
+
+	var stored []*storage.RecordID
+	for i, record := range records {
+		rid, err := e.db.RecordManager.InsertRecord(table, record)
+		if err != nil {
+			for _, rid := range stored {
+				if deleteErr := e.db.RecordManager.DeleteRecord(table, rid); deleteErr != nil {
+					return Result{}, errors.Join(fmt.Errorf("row %d: %w", i+1, err), deleteErr)
+				}
+			}
+			return Result{}, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		stored = append(stored, rid)
+	}
+	return Result{Message: fmt.Sprintf("INSERT %d", len(records))}, nil
+}
+
+// insertRecord places the values of one VALUES tuple in their columns. Columns
+// that get no value are NULL, or the next value of an AUTOINCREMENT column.
+func insertRecord(db *storage.Database, table *storage.Table, columns []int, row []interface{}) (*storage.Record, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("expected %d values, got %d", len(columns), len(row))
+	}
+
+	values := make([]interface{}, len(table.Columns))
+	for i, value := range row {
+		values[columns[i]] = value
+	}
+	if err := fillAutoIncrement(db, table, values); err != nil {
+		return nil, err
+	}
+	for i, column := range table.Columns {
+		value := values[i]
+		switch {
+		case value == nil:
+			if column.NotNull {
+				return nil, fmt.Errorf("column %s: NULL in a NOT NULL column", column.Name)
+			}
+		case !column.Accepts(value):
+			return nil, fmt.Errorf("column %s: %T value %v does not fit", column.Name, value, value)
+		case column.DataType == storage.TypeVarchar && column.Length > 0 && len(value.(string)) > column.Length:
+			return nil, fmt.Errorf("column %s: %d bytes is longer than %d", column.Name, len(value.(string)), column.Length)
+		}
+	}
+	return &storage.Record{Values: values}, nil
+}
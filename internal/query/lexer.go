@@ -0,0 +1,103 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// the lexer splits SQL into words, quoted strings, numbers and symbols, so
+// that 'file name.csv' or (a,b) come out as the tokens they are. -- comments
+// are skipped.
+
+type tokenKind int
+
+const (
+	tokenWord   tokenKind = iota // keyword or identifier, "quoted" identifiers included
+	tokenString                  // 'single quoted' literal, quotes removed
+	tokenNumber                  // integer or decimal literal
+	tokenSymbol                  // one of ( ) , ; * = and similar
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the statement, for error messages
+}
+
+// is reports whether the token is the given keyword or symbol, ignoring case
+func (t token) is(text string) bool {
+	return (t.kind == tokenWord || t.kind == tokenSymbol) && strings.EqualFold(t.text, text)
+}
+
+func (t token) String() string {
+	if t.kind == tokenString {
+		return "'" + t.text + "'"
+	}
+	return t.text
+}
+
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			// A comment runs to the end of the line
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			continue
+
+		case r == '\'' || r == '"':
+			// Quotes are escaped by doubling them
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated %c quote at position %d", r, start)
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						text.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			kind := tokenString
+			if r == '"' {
+				kind = tokenWord
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String(), pos: start})
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(runes[start:i]), pos: start})
+
+		default:
+			i++
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(r), pos: start})
+		}
+	}
+	return tokens, nil
+}
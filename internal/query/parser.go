@@ -3,7 +3,11 @@ package query
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"godb/internal/storage"
 )
 
 // tokenization splits sql string into words (tokens) and parsing is the process of converting tokens into a structured query
@@ -13,76 +17,733 @@ type QueryType int
 const (
 	QuerySelect QueryType = iota
 	QueryInsert
+	QueryVacuum
+	QueryCopyFrom
+	QueryCopyTo
+	QueryCreateTable
+	QueryCreateSequence
+	QueryCreateIndex
+	QueryDropIndex
 	// Add more query types as needed
 )
 
+var queryTypeNames = map[QueryType]string{
+	QuerySelect:         "SELECT",
+	QueryInsert:         "INSERT",
+	QueryVacuum:         "VACUUM",
+	QueryCopyFrom:       "COPY FROM",
+	QueryCopyTo:         "COPY TO",
+	QueryCreateTable:    "CREATE TABLE",
+	QueryCreateSequence: "CREATE SEQUENCE",
+	QueryCreateIndex:    "CREATE INDEX",
+	QueryDropIndex:      "DROP INDEX",
+}
+
+func (t QueryType) String() string {
+	if name, ok := queryTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("QueryType(%d)", int(t))
+}
+
 // Query represents a parsed SQL query
 type Query struct {
 	Type   QueryType
 	Table  string
 	Fields []string
-	Values []interface{}
+	Rows   [][]interface{} // one per VALUES tuple of INSERT
+
+	Columns       []storage.Column // columns of CREATE TABLE
+	PrimaryKey    string           // primary key column of CREATE TABLE
+	AutoIncrement string           // AUTOINCREMENT column of CREATE TABLE
+
+	Sequence  string // name of CREATE SEQUENCE
+	Start     int    // START WITH of CREATE SEQUENCE, 1 when not given
+	Increment int    // INCREMENT BY of CREATE SEQUENCE, 1 when not given
+
+	Index      string              // name of CREATE INDEX and DROP INDEX
+	Unique     bool                // CREATE UNIQUE INDEX
+	Hash       bool                // CREATE INDEX ... USING HASH
+	KeyColumns []storage.KeyColumn // ordering of each column of CREATE INDEX, named in Fields
+
+	File   string      // file named by COPY
+	Copy   CopyOptions // WITH options of COPY
+	Source *Query      // rows written by COPY TO
+}
+
+// sequenceCall is nextval('name'), currval('name') or setval('name', n) in
+// place of a value. It is evaluated when the statement runs.
+type sequenceCall struct {
+	function string // lower case
+	sequence string
+	value    int // n of setval
 }
 
+// CopyOptions are the options of a COPY statement, also used by the import command
+type CopyOptions struct {
+	Format    string // FormatCSV when empty, or FormatNDJSON
+	Header    bool   // CSV only: the first line names the columns
+	Delimiter rune   // CSV only: field separator, ',' when zero
+}
+
+// File formats of COPY
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson" // one JSON object per line, export only
+)
+
 // ParseSQL parses a SQL string into a Query struct
 func ParseSQL(sql string) (*Query, error) {
-	tokens := strings.Fields(sql)
-	fmt.Printf("Tokens: %v\n", tokens)
-
-	if len(tokens) < 2 {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	// A trailing semicolon ends the statement
+	if n := len(tokens); n > 0 && tokens[n-1].is(";") {
+		tokens = tokens[:n-1]
+	}
+	if len(tokens) == 0 {
 		return nil, errors.New("invalid SQL query")
 	}
 
-	switch strings.ToUpper(tokens[0]) {
+	p := &parser{tokens: tokens}
+	var query *Query
+	switch strings.ToUpper(tokens[0].text) {
 	case "SELECT":
-		return parseSelect(tokens)
+		query, err = p.parseSelect()
 	case "INSERT":
-		return parseInsert(tokens)
+		query, err = p.parseInsert()
+	case "VACUUM":
+		query, err = p.parseVacuum()
+	case "COPY":
+		query, err = p.parseCopy()
+	case "CREATE":
+		query, err = p.parseCreate()
+	case "DROP":
+		query, err = p.parseDrop()
 	default:
 		return nil, errors.New("unsupported query type")
 	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid %s query: unexpected %s", query.Type, p.peek())
+	}
+	return query, nil
+}
+
+// parser walks the tokens of one statement
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the next token without consuming it; past the end it is empty
+func (p *parser) peek() token {
+	if p.done() {
+		return token{kind: tokenSymbol, text: "end of statement"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept consumes the next token if it is the given keyword or symbol
+func (p *parser) accept(text string) bool {
+	if !p.done() && p.peek().is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %s, got %s", text, p.peek())
+	}
+	return nil
+}
+
+// identifier consumes a table or column name
+func (p *parser) identifier() (string, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return "", fmt.Errorf("expected a name, got %s", t)
+	}
+	return t.text, nil
+}
+
+// identifierList consumes name, name, ...
+func (p *parser) identifierList() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			return names, nil
+		}
+	}
+}
+
+// literal consumes a constant: a quoted string, a number, TRUE, FALSE or NULL
+func (p *parser) literal() (interface{}, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		return t.text, nil
+	case t.kind == tokenNumber:
+		if n, err := strconv.Atoi(t.text); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t.text)
+		}
+		return f, nil
+	case t.is("TRUE"):
+		return true, nil
+	case t.is("FALSE"):
+		return false, nil
+	case t.is("NULL"):
+		return nil, nil
+	}
+	return nil, fmt.Errorf("expected a value, got %s", t)
+}
+
+// sequenceFunctions are the functions that may stand in for a value
+var sequenceFunctions = map[string]bool{"nextval": true, "currval": true, "setval": true}
+
+// startsValue reports whether the next tokens are a value: a literal or a
+// sequence function call
+func (p *parser) startsValue() bool {
+	t := p.peek()
+	switch {
+	case p.done():
+		return false
+	case t.kind == tokenString || t.kind == tokenNumber:
+		return true
+	case t.is("TRUE") || t.is("FALSE") || t.is("NULL"):
+		return true
+	}
+	return t.kind == tokenWord && sequenceFunctions[strings.ToLower(t.text)] &&
+		p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].is("(")
 }
 
-func parseSelect(tokens []string) (*Query, error) {
-	fmt.Printf("Parsing SELECT tokens: %v\n", tokens)
+// value consumes a literal or a sequence function call
+func (p *parser) value() (interface{}, error) {
+	t := p.peek()
+	if t.kind != tokenWord || !sequenceFunctions[strings.ToLower(t.text)] {
+		return p.literal()
+	}
+	p.next()
 
-	if len(tokens) < 4 {
-		return nil, fmt.Errorf("invalid SELECT query: too few tokens")
+	call := sequenceCall{function: strings.ToLower(t.text)}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	name := p.next()
+	if name.kind != tokenString {
+		return nil, fmt.Errorf("%s expects a quoted sequence name, got %s", call.function, name)
 	}
+	call.sequence = name.text
+	if call.function == "setval" {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		n := p.next()
+		value, err := strconv.Atoi(n.text)
+		if n.kind != tokenNumber || err != nil {
+			return nil, fmt.Errorf("setval expects an integer, got %s", n)
+		}
+		call.value = value
+	}
+	return call, p.expect(")")
+}
 
-	if strings.ToUpper(tokens[2]) != "FROM" {
-		return nil, fmt.Errorf("invalid SELECT query: expected FROM, got %s", tokens[2])
+// parseSelect parses SELECT fields FROM table, or SELECT values without FROM,
+// e.g. SELECT nextval('ids')
+func (p *parser) parseSelect() (*Query, error) {
+	p.next() // SELECT
+
+	if p.startsValue() {
+		var values []interface{}
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, fmt.Errorf("invalid SELECT query: %w", err)
+			}
+			values = append(values, value)
+			if !p.accept(",") {
+				return &Query{Type: QuerySelect, Rows: [][]interface{}{values}}, nil
+			}
+		}
 	}
 
 	var fields []string
-	if tokens[1] == "*" {
+	if p.accept("*") {
 		fields = []string{"*"}
 	} else {
-		fields = strings.Split(tokens[1], ",")
+		var err error
+		if fields, err = p.identifierList(); err != nil {
+			return nil, fmt.Errorf("invalid SELECT query: %w", err)
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return nil, fmt.Errorf("invalid SELECT query: %w", err)
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, fmt.Errorf("invalid SELECT query: %w", err)
 	}
 
 	return &Query{
 		Type:   QuerySelect,
 		Fields: fields,
-		Table:  tokens[3],
+		Table:  table,
 	}, nil
 }
 
-func parseInsert(tokens []string) (*Query, error) {
-	fmt.Printf("Parsing INSERT tokens: %v\n", tokens)
+// parseInsert parses INSERT INTO table [(columns)] VALUES (values), ...
+func (p *parser) parseInsert() (*Query, error) {
+	p.next() // INSERT
+	if err := p.expect("INTO"); err != nil {
+		return nil, fmt.Errorf("invalid INSERT query: %w", err)
+	}
+	table, err := p.identifier()
+	if err != nil {
+		return nil, fmt.Errorf("invalid INSERT query: %w", err)
+	}
 
-	if len(tokens) < 4 || strings.ToUpper(tokens[1]) != "INTO" {
-		return nil, errors.New("invalid INSERT query")
+	query := &Query{Type: QueryInsert, Table: table}
+	if p.accept("(") {
+		if query.Fields, err = p.identifierList(); err != nil {
+			return nil, fmt.Errorf("invalid INSERT query: %w", err)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, fmt.Errorf("invalid INSERT query: %w", err)
+		}
 	}
 
-	return &Query{
-		Type:   QueryInsert,
-		Table:  tokens[2],
-		Values: parseValues(tokens[3:]),
-	}, nil
+	if err := p.expect("VALUES"); err != nil {
+		return nil, fmt.Errorf("invalid INSERT query: %w", err)
+	}
+	for {
+		values, err := p.parseValues()
+		if err != nil {
+			return nil, fmt.Errorf("invalid INSERT query: %w", err)
+		}
+		if query.Fields != nil && len(query.Fields) != len(values) {
+			return nil, fmt.Errorf("invalid INSERT query: %d columns but %d values", len(query.Fields), len(values))
+		}
+		query.Rows = append(query.Rows, values)
+		if !p.accept(",") {
+			return query, nil
+		}
+	}
 }
 
-func parseValues(tokens []string) []interface{} {
-	// TODO: Implement proper value parsing
-	return []interface{}{}
+// parseValues parses a parenthesized list of values
+func (p *parser) parseValues() ([]interface{}, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return values, p.expect(")")
+}
+
+// parseVacuum parses VACUUM, which always works on the whole database
+func (p *parser) parseVacuum() (*Query, error) {
+	p.next() // VACUUM
+	return &Query{Type: QueryVacuum}, nil
+}
+
+// parseCopy parses COPY table FROM 'file', COPY table TO 'file' and
+// COPY (SELECT ...) TO 'file', each optionally followed by WITH (options)
+func (p *parser) parseCopy() (*Query, error) {
+	p.next() // COPY
+
+	var source *Query
+	var table string
+	var err error
+	if p.accept("(") {
+		if !p.peek().is("SELECT") {
+			return nil, fmt.Errorf("invalid COPY query: expected SELECT, got %s", p.peek())
+		}
+		if source, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, fmt.Errorf("invalid COPY query: %w", err)
+		}
+	} else if table, err = p.identifier(); err != nil {
+		return nil, fmt.Errorf("invalid COPY query: %w", err)
+	}
+
+	query := &Query{Table: table}
+	switch {
+	case p.accept("TO"):
+		query.Type = QueryCopyTo
+		query.Source = source
+		if source == nil {
+			query.Source = &Query{Type: QuerySelect, Fields: []string{"*"}, Table: table}
+		}
+	case source == nil && p.accept("FROM"):
+		query.Type = QueryCopyFrom
+	default:
+		return nil, fmt.Errorf("invalid COPY query: expected TO or FROM, got %s", p.peek())
+	}
+
+	file := p.next()
+	if file.kind != tokenString {
+		return nil, fmt.Errorf("invalid COPY query: expected a quoted file name, got %s", file)
+	}
+	query.File = file.text
+
+	if p.accept("WITH") {
+		if query.Copy, err = p.parseCopyOptions(); err != nil {
+			return nil, fmt.Errorf("invalid COPY query: %w", err)
+		}
+	}
+	if query.Type == QueryCopyFrom && query.Copy.Format == FormatNDJSON {
+		return nil, errors.New("invalid COPY query: COPY FROM only reads CSV")
+	}
+	return query, nil
+}
+
+// parseCopyOptions parses (option, ...) after WITH: FORMAT, HEADER and DELIMITER
+func (p *parser) parseCopyOptions() (CopyOptions, error) {
+	var opts CopyOptions
+	if err := p.expect("("); err != nil {
+		return opts, err
+	}
+	for {
+		option := p.next()
+		switch {
+		case option.is("HEADER"):
+			opts.Header = true
+			// HEADER TRUE and HEADER FALSE are accepted too
+			if p.accept("FALSE") {
+				opts.Header = false
+			} else {
+				p.accept("TRUE")
+			}
+		case option.is("FORMAT"):
+			format := p.next()
+			switch {
+			case format.is(FormatCSV) || (format.kind == tokenString && strings.EqualFold(format.text, FormatCSV)):
+				opts.Format = FormatCSV
+			case format.is(FormatNDJSON) || (format.kind == tokenString && strings.EqualFold(format.text, FormatNDJSON)):
+				opts.Format = FormatNDJSON
+			default:
+				return opts, fmt.Errorf("unknown FORMAT %s, expected CSV or NDJSON", format)
+			}
+		case option.is("DELIMITER"):
+			delimiter := p.next()
+			if delimiter.kind != tokenString || utf8.RuneCountInString(delimiter.text) != 1 {
+				return opts, fmt.Errorf("DELIMITER must be a single quoted character, got %s", delimiter)
+			}
+			opts.Delimiter, _ = utf8.DecodeRuneInString(delimiter.text)
+		default:
+			return opts, fmt.Errorf("unknown COPY option %s", option)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return opts, p.expect(")")
+}
+
+// columnTypes maps the type names of CREATE TABLE to column types
+var columnTypes = map[string]storage.DataType{
+	"INTEGER":   storage.TypeInteger,
+	"INT":       storage.TypeInteger,
+	"VARCHAR":   storage.TypeVarchar,
+	"TEXT":      storage.TypeVarchar,
+	"BOOLEAN":   storage.TypeBoolean,
+	"BOOL":      storage.TypeBoolean,
+	"TIMESTAMP": storage.TypeTimestamp,
+}
+
+// parseCreate parses CREATE TABLE name (column type [(length)] [NOT NULL]
+// [PRIMARY KEY] [AUTOINCREMENT], ... [, PRIMARY KEY (column)]),
+// CREATE SEQUENCE name [START [WITH] n] [INCREMENT [BY] n] and CREATE
+// [UNIQUE] INDEX, see parseIndexDefinition
+func (p *parser) parseCreate() (*Query, error) {
+	p.next() // CREATE
+	switch {
+	case p.accept("TABLE"):
+		query, err := p.parseTableDefinition()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CREATE TABLE query: %w", err)
+		}
+		return query, nil
+	case p.accept("SEQUENCE"):
+		query, err := p.parseSequenceDefinition()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CREATE SEQUENCE query: %w", err)
+		}
+		return query, nil
+	case p.peek().is("UNIQUE") || p.peek().is("INDEX"):
+		query, err := p.parseIndexDefinition()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CREATE INDEX query: %w", err)
+		}
+		return query, nil
+	}
+	return nil, fmt.Errorf("invalid CREATE query: expected TABLE, SEQUENCE or INDEX, got %s", p.peek())
+}
+
+// parseIndexDefinition parses [UNIQUE] INDEX name ON table [USING BTREE |
+// USING HASH] (column [ASC | DESC] [NULLS FIRST | NULLS LAST], ...)
+func (p *parser) parseIndexDefinition() (*Query, error) {
+	query := &Query{Type: QueryCreateIndex, Unique: p.accept("UNIQUE")}
+	if err := p.expect("INDEX"); err != nil {
+		return nil, err
+	}
+	var err error
+	if query.Index, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("ON"); err != nil {
+		return nil, err
+	}
+	if query.Table, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if p.accept("USING") {
+		switch {
+		case p.accept("HASH"):
+			query.Hash = true
+		case p.accept("BTREE"):
+		default:
+			return nil, fmt.Errorf("expected BTREE or HASH, got %s", p.peek())
+		}
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		var order storage.KeyColumn
+		if p.accept("DESC") {
+			order.Descending = true
+		} else {
+			p.accept("ASC")
+		}
+		if p.accept("NULLS") {
+			switch {
+			case p.accept("FIRST"):
+				order.Nulls = storage.NullsFirst
+			case p.accept("LAST"):
+				order.Nulls = storage.NullsLast
+			default:
+				return nil, fmt.Errorf("expected FIRST or LAST, got %s", p.peek())
+			}
+		}
+		query.Fields = append(query.Fields, column)
+		query.KeyColumns = append(query.KeyColumns, order)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// parseDrop parses DROP INDEX name
+func (p *parser) parseDrop() (*Query, error) {
+	p.next() // DROP
+	if err := p.expect("INDEX"); err != nil {
+		return nil, fmt.Errorf("invalid DROP query: %w", err)
+	}
+	name, err := p.identifier()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DROP INDEX query: %w", err)
+	}
+	return &Query{Type: QueryDropIndex, Index: name}, nil
+}
+
+func (p *parser) parseSequenceDefinition() (*Query, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	query := &Query{Type: QueryCreateSequence, Sequence: name, Start: 1, Increment: 1}
+	integer := func() (int, error) {
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil {
+			return 0, fmt.Errorf("expected an integer, got %s", t)
+		}
+		return n, nil
+	}
+	for !p.done() {
+		switch {
+		case p.accept("START"):
+			p.accept("WITH")
+			if query.Start, err = integer(); err != nil {
+				return nil, err
+			}
+		case p.accept("INCREMENT"):
+			p.accept("BY")
+			if query.Increment, err = integer(); err != nil {
+				return nil, err
+			}
+			if query.Increment <= 0 {
+				return nil, fmt.Errorf("INCREMENT must be positive, got %d", query.Increment)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s", p.peek())
+		}
+	}
+	return query, nil
+}
+
+func (p *parser) parseTableDefinition() (*Query, error) {
+	table, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	query := &Query{Type: QueryCreateTable, Table: table}
+	setPrimaryKey := func(column string) error {
+		if query.PrimaryKey != "" {
+			return errors.New("more than one PRIMARY KEY")
+		}
+		query.PrimaryKey = column
+		return nil
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		// PRIMARY KEY here is the table constraint, a column named primary
+		// is followed by its type
+		if p.peek().is("PRIMARY") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].is("KEY") {
+			p.pos += 2
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			if err := setPrimaryKey(column); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		} else {
+			column, constraints, err := p.parseColumn()
+			if err != nil {
+				return nil, err
+			}
+			query.Columns = append(query.Columns, column)
+			if constraints.primaryKey {
+				if err := setPrimaryKey(column.Name); err != nil {
+					return nil, err
+				}
+			}
+			if constraints.autoIncrement {
+				if query.AutoIncrement != "" {
+					return nil, errors.New("more than one AUTOINCREMENT column")
+				}
+				query.AutoIncrement = column.Name
+			}
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(query.Columns) == 0 {
+		return nil, errors.New("a table needs at least one column")
+	}
+	return query, nil
+}
+
+// columnConstraints are the constraints written after a column's type
+type columnConstraints struct {
+	primaryKey    bool
+	autoIncrement bool
+}
+
+// parseColumn parses one column definition
+func (p *parser) parseColumn() (storage.Column, columnConstraints, error) {
+	var column storage.Column
+	var constraints columnConstraints
+	var err error
+	if column.Name, err = p.identifier(); err != nil {
+		return column, constraints, err
+	}
+
+	typeName := p.next()
+	dataType, ok := columnTypes[strings.ToUpper(typeName.text)]
+	if typeName.kind != tokenWord || !ok {
+		return column, constraints, fmt.Errorf("unknown type %s for column %s", typeName, column.Name)
+	}
+	column.DataType = dataType
+	if dataType == storage.TypeVarchar && p.accept("(") {
+		length := p.next()
+		n, err := strconv.Atoi(length.text)
+		if length.kind != tokenNumber || err != nil || n <= 0 {
+			return column, constraints, fmt.Errorf("invalid length %s for column %s", length, column.Name)
+		}
+		column.Length = n
+		if err := p.expect(")"); err != nil {
+			return column, constraints, err
+		}
+	}
+
+	for {
+		switch {
+		case p.accept("NOT"):
+			if err := p.expect("NULL"); err != nil {
+				return column, constraints, err
+			}
+			column.NotNull = true
+		case p.accept("NULL"):
+			column.NotNull = false
+		case p.accept("PRIMARY"):
+			if err := p.expect("KEY"); err != nil {
+				return column, constraints, err
+			}
+			constraints.primaryKey = true
+		case p.accept("AUTOINCREMENT"):
+			constraints.autoIncrement = true
+		default:
+			return column, constraints, nil
+		}
+	}
 }
@@ -29,6 +29,150 @@ func TestParseSQL(t *testing.T) {
 			wantType: QueryInsert,
 			wantErr:  false,
 		},
+		{
+			name:     "VACUUM",
+			sql:      "VACUUM;",
+			wantType: QueryVacuum,
+			wantErr:  false,
+		},
+		{
+			name:     "COPY FROM with options",
+			sql:      "COPY users FROM 'my users.csv' WITH (HEADER, DELIMITER ';')",
+			wantType: QueryCopyFrom,
+			wantErr:  false,
+		},
+		{
+			name:     "COPY TO",
+			sql:      "COPY users TO 'users.csv' WITH (FORMAT CSV, HEADER FALSE)",
+			wantType: QueryCopyTo,
+			wantErr:  false,
+		},
+		{
+			name:     "COPY SELECT TO NDJSON",
+			sql:      "COPY (SELECT id, name FROM users) TO 'users.ndjson' WITH (FORMAT NDJSON);",
+			wantType: QueryCopyTo,
+			wantErr:  false,
+		},
+		{
+			name:    "COPY SELECT FROM",
+			sql:     "COPY (SELECT * FROM users) FROM 'users.csv'",
+			wantErr: true,
+		},
+		{
+			name:    "COPY FROM NDJSON",
+			sql:     "COPY users FROM 'users.ndjson' WITH (FORMAT NDJSON)",
+			wantErr: true,
+		},
+		{
+			name:     "INSERT several rows",
+			sql:      "INSERT INTO users (id, name) VALUES (1, 'Alice'), (2, NULL)",
+			wantType: QueryInsert,
+			wantErr:  false,
+		},
+		{
+			name:     "CREATE TABLE",
+			sql:      "CREATE TABLE users (id INTEGER NOT NULL, name VARCHAR(20), PRIMARY KEY (id))",
+			wantType: QueryCreateTable,
+			wantErr:  false,
+		},
+		{
+			name:    "CREATE TABLE with two keys",
+			sql:     "CREATE TABLE users (id INT PRIMARY KEY, name TEXT PRIMARY KEY)",
+			wantErr: true,
+		},
+		{
+			name:    "CREATE TABLE with an unknown type",
+			sql:     "CREATE TABLE users (id BLOB)",
+			wantErr: true,
+		},
+		{
+			name:     "CREATE TABLE with AUTOINCREMENT",
+			sql:      "CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)",
+			wantType: QueryCreateTable,
+			wantErr:  false,
+		},
+		{
+			name:     "CREATE SEQUENCE",
+			sql:      "CREATE SEQUENCE ids START WITH 10 INCREMENT BY 5;",
+			wantType: QueryCreateSequence,
+			wantErr:  false,
+		},
+		{
+			name:    "CREATE SEQUENCE counting down",
+			sql:     "CREATE SEQUENCE ids INCREMENT BY -1",
+			wantErr: true,
+		},
+		{
+			name:     "INSERT with nextval",
+			sql:      "INSERT INTO users VALUES (nextval('ids'), 'Alice')",
+			wantType: QueryInsert,
+			wantErr:  false,
+		},
+		{
+			name:     "SELECT setval",
+			sql:      "SELECT setval('ids', 42)",
+			wantType: QuerySelect,
+			wantErr:  false,
+		},
+		{
+			name:    "nextval without quotes",
+			sql:     "SELECT nextval(ids)",
+			wantErr: true,
+		},
+		{
+			name:    "COPY FROM without quotes",
+			sql:     "COPY users FROM users.csv",
+			wantErr: true,
+		},
+		{
+			name:    "VACUUM with a table",
+			sql:     "VACUUM users",
+			wantErr: true,
+		},
+		{
+			name:     "CREATE INDEX",
+			sql:      "CREATE INDEX users_name ON users (name);",
+			wantType: QueryCreateIndex,
+			wantErr:  false,
+		},
+		{
+			name:     "CREATE UNIQUE INDEX with ordering",
+			sql:      `CREATE UNIQUE INDEX "by city" ON users (city, age DESC NULLS LAST)`,
+			wantType: QueryCreateIndex,
+			wantErr:  false,
+		},
+		{
+			name:     "CREATE INDEX USING HASH",
+			sql:      "CREATE UNIQUE INDEX users_token ON users USING HASH (token)",
+			wantType: QueryCreateIndex,
+			wantErr:  false,
+		},
+		{
+			name:    "CREATE INDEX with an unknown method",
+			sql:     "CREATE INDEX users_token ON users USING GIST (token)",
+			wantErr: true,
+		},
+		{
+			name:    "CREATE INDEX without columns",
+			sql:     "CREATE INDEX users_name ON users ()",
+			wantErr: true,
+		},
+		{
+			name:    "CREATE UNIQUE without INDEX",
+			sql:     "CREATE UNIQUE users_name ON users (name)",
+			wantErr: true,
+		},
+		{
+			name:     "DROP INDEX",
+			sql:      "DROP INDEX users_name",
+			wantType: QueryDropIndex,
+			wantErr:  false,
+		},
+		{
+			name:    "DROP TABLE",
+			sql:     "DROP TABLE users",
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
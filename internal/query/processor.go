@@ -1,18 +1,32 @@
 package query
 
+import "godb/internal/storage"
+
 type QueryProcessor struct {
-    parser    *Parser
-    optimizer *Optimizer
-    executor  *Executor
+	parser    *Parser
+	optimizer *Optimizer
+	executor  *Executor
+}
+
+// NewQueryProcessor returns a processor that runs statements against db
+func NewQueryProcessor(db *storage.Database) *QueryProcessor {
+	return &QueryProcessor{
+		parser:    &Parser{},
+		optimizer: &Optimizer{},
+		executor:  &Executor{db: db},
+	}
 }
 
 func (qp *QueryProcessor) Execute(query string) (Result, error) {
-    // 1. Parse the query
-    parsed := qp.parser.Parse(query)
-    
-    // 2. Optimize the query 
-    plan := qp.optimizer.Optimize(parsed)
-    
-    // 3. Execute the plan
-    return qp.executor.Execute(plan)
-} 
\ No newline at end of file
+	// 1. Parse the query
+	parsed, err := qp.parser.Parse(query)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// 2. Optimize the query
+	plan := qp.optimizer.Optimize(parsed)
+
+	// 3. Execute the plan
+	return qp.executor.Execute(plan)
+}
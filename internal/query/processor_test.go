@@ -0,0 +1,203 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"godb/internal/storage"
+)
+
+func TestVacuumStatement(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTable("users", []storage.Column{{Name: "name", DataType: storage.TypeVarchar, Length: 20}}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	for i := 0; i < 500; i++ {
+		rid, err := db.RecordManager.InsertRecord(users, &storage.Record{Values: []interface{}{fmt.Sprintf("User %d", i)}})
+		if err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		if i >= 50 {
+			if err := db.RecordManager.DeleteRecord(users, rid); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+		}
+	}
+
+	qp := NewQueryProcessor(db)
+	result, err := qp.Execute("VACUUM")
+	if err != nil {
+		t.Fatalf("VACUUM failed: %v", err)
+	}
+	if !strings.HasPrefix(result.Message, "VACUUM reclaimed") || strings.HasPrefix(result.Message, "VACUUM reclaimed 0 bytes") {
+		t.Errorf("Unexpected result %q", result.Message)
+	}
+
+	if _, err := (&QueryProcessor{}).Execute("VACUUM"); err == nil {
+		t.Error("Expected a processor without a database to fail")
+	}
+}
+
+func TestSequenceStatements(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	qp := NewQueryProcessor(db)
+	run := func(sql string) Result {
+		t.Helper()
+		result, err := qp.Execute(sql)
+		if err != nil {
+			t.Fatalf("%s: %v", sql, err)
+		}
+		return result
+	}
+
+	run("CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT)")
+	run("INSERT INTO users (name) VALUES ('Alice'), ('Bob')")
+	run("INSERT INTO users VALUES (NULL, 'Carol'), (10, 'Dave')")
+	run("INSERT INTO users (name) VALUES ('Eve')")
+	result := run("SELECT id FROM users")
+	if got := fmt.Sprint(result.Rows); got != "[[1] [2] [3] [10] [11]]" {
+		t.Errorf("Unexpected ids %s", got)
+	}
+
+	if _, err := qp.Execute("SELECT currval('invoices')"); err == nil {
+		t.Error("Expected currval of a missing sequence to fail")
+	}
+	run("CREATE SEQUENCE invoices START WITH 100 INCREMENT BY 2")
+	if _, err := qp.Execute("SELECT currval('invoices')"); err == nil {
+		t.Error("Expected currval before nextval to fail")
+	}
+	result = run("SELECT nextval('invoices'), nextval('invoices'), currval('invoices')")
+	if got := fmt.Sprint(result.Columns, result.Rows); got != "[nextval nextval currval] [[100 102 102]]" {
+		t.Errorf("Unexpected result %s", got)
+	}
+	run("SELECT setval('invoices', 200)")
+	run("INSERT INTO users VALUES (nextval('invoices'), 'Frank')")
+	result = run("SELECT currval('invoices')")
+	if got := fmt.Sprint(result.Rows); got != "[[202]]" {
+		t.Errorf("Expected currval 202 after setval(200) and nextval, got %s", got)
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE bad (id INTEGER, n INTEGER AUTOINCREMENT)",
+		"CREATE TABLE bad (name TEXT PRIMARY KEY AUTOINCREMENT)",
+		"CREATE SEQUENCE invoices",
+	} {
+		if _, err := qp.Execute(sql); err == nil {
+			t.Errorf("Expected %q to fail", sql)
+		}
+	}
+	if _, ok := db.Tables["bad"]; ok {
+		t.Error("A failed CREATE TABLE left its table behind")
+	}
+}
+
+func TestPrimaryKeyStatements(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	qp := NewQueryProcessor(db)
+	if _, err := qp.Execute("CREATE TABLE users (name TEXT, id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("CREATE TABLE failed: %v", err)
+	}
+	if _, err := qp.Execute("INSERT INTO users VALUES ('Alice', 1), ('Bob', 2)"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+
+	// The second row collides, so neither row of the statement is kept
+	_, err = qp.Execute("INSERT INTO users VALUES ('Carol', 3), ('Dave', 1)")
+	if !errors.Is(err, storage.ErrDuplicateKey) || !strings.Contains(err.Error(), "row 2: duplicate key 1 in unique index users_pkey") {
+		t.Errorf("Expected a duplicate key error for row 2, got %v", err)
+	}
+	result, err := qp.Execute("SELECT name FROM users")
+	if err != nil {
+		t.Fatalf("SELECT failed: %v", err)
+	}
+	if got := fmt.Sprint(result.Rows); got != "[[Alice] [Bob]]" {
+		t.Errorf("Expected the failed INSERT to leave no rows, got %s", got)
+	}
+
+	// Without a PRIMARY KEY the first column is the key, and TEXT cannot be one
+	if _, err := qp.Execute("CREATE TABLE notes (body TEXT)"); err == nil || !strings.Contains(err.Error(), "cannot be a primary key") {
+		t.Errorf("Expected a TEXT primary key to be refused, got %v", err)
+	}
+	if _, err := qp.Execute("INSERT INTO users VALUES ('Erin', NULL)"); err == nil {
+		t.Error("Expected a NULL primary key to be refused")
+	}
+}
+
+func TestIndexStatements(t *testing.T) {
+	db, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	qp := NewQueryProcessor(db)
+	if _, err := qp.Execute("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)"); err != nil {
+		t.Fatalf("CREATE TABLE failed: %v", err)
+	}
+	if _, err := qp.Execute("INSERT INTO users VALUES (1, 'a@example.com'), (2, 'b@example.com'), (3, NULL)"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	if _, err := qp.Execute("CREATE UNIQUE INDEX users_email ON users (email DESC)"); err != nil {
+		t.Fatalf("CREATE INDEX failed: %v", err)
+	}
+	if _, err := qp.Execute("CREATE INDEX users_missing ON users (name)"); err == nil {
+		t.Error("Expected an index on a missing column to fail")
+	}
+
+	// The new index refuses a second row with an email, but not a second NULL
+	_, err = qp.Execute("INSERT INTO users VALUES (4, 'b@example.com')")
+	if !errors.Is(err, storage.ErrDuplicateKey) || !strings.Contains(err.Error(), "in unique index users_email") {
+		t.Errorf("Expected a duplicate key error from users_email, got %v", err)
+	}
+	if _, err := qp.Execute("INSERT INTO users VALUES (5, NULL)"); err != nil {
+		t.Errorf("Expected a second NULL email to be allowed, got %v", err)
+	}
+
+	if _, err := qp.Execute("DROP INDEX users_pkey"); err == nil {
+		t.Error("Expected the primary key index to stay")
+	}
+	if _, err := qp.Execute("DROP INDEX users_email"); err != nil {
+		t.Fatalf("DROP INDEX failed: %v", err)
+	}
+	if _, err := qp.Execute("INSERT INTO users VALUES (4, 'b@example.com')"); err != nil {
+		t.Errorf("Expected the duplicate to be allowed once the index is gone, got %v", err)
+	}
+	if _, ok := db.Indexes["users_email"]; ok {
+		t.Error("Expected users_email to be gone")
+	}
+
+	// A hash index enforces uniqueness the same way
+	_, err = qp.Execute("CREATE UNIQUE INDEX users_token ON users USING HASH (email)")
+	if !errors.Is(err, storage.ErrDuplicateKey) {
+		t.Errorf("Expected a unique hash index over duplicate emails to fail, got %v", err)
+	}
+	if _, err := qp.Execute("CREATE INDEX users_token ON users USING HASH (email)"); err != nil {
+		t.Fatalf("CREATE INDEX USING HASH failed: %v", err)
+	}
+	index := db.HashIndexes["users_token"]
+	key, _ := index.Key("b@example.com")
+	if rids, err := index.SearchAll(key); err != nil || len(rids) != 2 {
+		t.Errorf("Expected two rows with b@example.com, got %v %v", rids, err)
+	}
+	if _, err := qp.Execute("DROP INDEX users_token"); err != nil || db.HashIndexes["users_token"] != nil {
+		t.Errorf("Expected DROP INDEX to drop the hash index, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// ExecuteScript runs the statements read from r, separated by semicolons, one
+// after the other. It stops at the first statement that fails and reports the
+// line it starts on. fn, when not nil, sees every statement with its result.
+// It returns the number of statements run.
+func (qp *QueryProcessor) ExecuteScript(r io.Reader, fn func(statement string, result Result) error) (int, error) {
+	statements := &statementReader{r: bufio.NewReader(r), line: 1}
+	n := 0
+	for {
+		statement, line, err := statements.next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		result, err := qp.Execute(statement)
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+		if fn != nil {
+			if err := fn(statement, result); err != nil {
+				return n, err
+			}
+		}
+	}
+}
+
+// statementReader splits a script into statements at the semicolons that are
+// outside quotes and -- comments
+type statementReader struct {
+	r    *bufio.Reader
+	line int // line of the next rune
+}
+
+// next returns the next statement that is not empty and the line it starts
+// on, or io.EOF at the end of the script
+func (s *statementReader) next() (string, int, error) {
+	var text strings.Builder
+	start := 0 // line of the first rune that is not space or comment
+	var quote rune
+	comment := false
+
+	for {
+		r, _, err := s.r.ReadRune()
+		if err == io.EOF {
+			if quote != 0 {
+				return "", 0, fmt.Errorf("line %d: unterminated %c quote", start, quote)
+			}
+			if start == 0 {
+				return "", 0, io.EOF
+			}
+			return text.String(), start, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		line := s.line
+		if r == '\n' {
+			s.line++
+		}
+
+		switch {
+		case comment:
+			comment = r != '\n'
+		case quote != 0:
+			// A doubled quote is an escaped one, and toggles back in right away
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '-' && s.peek() == '-':
+			comment = true
+		case r == ';':
+			if start != 0 {
+				return text.String(), start, nil
+			}
+			continue
+		}
+
+		if start == 0 && !comment && !unicode.IsSpace(r) {
+			start = line
+		}
+		text.WriteRune(r)
+	}
+}
+
+func (s *statementReader) peek() rune {
+	r, _, err := s.r.ReadRune()
+	if err != nil {
+		return 0
+	}
+	s.r.UnreadRune()
+	return r
+}
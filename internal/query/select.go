@@ -0,0 +1,80 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+
+	"godb/internal/storage"
+)
+
+// selection is a SELECT resolved against its table
+type selection struct {
+	table   *storage.Table
+	columns []string // names of the selected columns, as the table spells them
+	indexes []int    // indexes[i] is the table column of columns[i]
+}
+
+// newSelection resolves the fields of a SELECT, * included, to table columns
+func newSelection(db *storage.Database, plan *Query) (*selection, error) {
+	if plan.Table == "" {
+		return nil, errors.New("SELECT without FROM has no table to scan")
+	}
+	table, ok := db.Tables[plan.Table]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", plan.Table)
+	}
+
+	s := &selection{table: table}
+	for _, field := range plan.Fields {
+		if field == "*" {
+			for i, column := range table.Columns {
+				s.indexes = append(s.indexes, i)
+				s.columns = append(s.columns, column.Name)
+			}
+			continue
+		}
+		i := columnIndex(table, field)
+		if i < 0 {
+			return nil, fmt.Errorf("table %s has no column %s", table.Name, field)
+		}
+		s.indexes = append(s.indexes, i)
+		s.columns = append(s.columns, table.Columns[i].Name)
+	}
+	return s, nil
+}
+
+// scan streams the selected rows to fn, a fresh slice for every row
+func (s *selection) scan(db *storage.Database, fn func(row []interface{}) error) error {
+	return db.RecordManager.Scan(s.table, func(rid storage.RecordID, record *storage.Record) error {
+		if len(record.Values) != len(s.table.Columns) {
+			return fmt.Errorf("record %v has %d values, table %s has %d columns", rid, len(record.Values), s.table.Name, len(s.table.Columns))
+		}
+		row := make([]interface{}, len(s.indexes))
+		for i, index := range s.indexes {
+			row[i] = record.Values[index]
+		}
+		return fn(row)
+	})
+}
+
+// executeSelect runs a SELECT and collects its rows
+func (e *Executor) executeSelect(plan *Query) (Result, error) {
+	if plan.Table == "" {
+		return e.selectValues(plan)
+	}
+	s, err := newSelection(e.db, plan)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Columns: s.columns, Rows: [][]interface{}{}}
+	err = s.scan(e.db, func(row []interface{}) error {
+		result.Rows = append(result.Rows, row)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	result.Message = fmt.Sprintf("SELECT %d", len(result.Rows))
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package query
+
+import (
+	"fmt"
+
+	"godb/internal/storage"
+)
+
+// Sequences are used through nextval, currval and setval, which may stand in
+// for any value of an INSERT or of a SELECT without FROM:
+//
+//	nextval('s')     the next value of s
+//	currval('s')     the value nextval last returned for s through this
+//	                 query processor, an error before the first nextval
+//	setval('s', n)   makes the next nextval return n plus the increment
+//
+// An AUTOINCREMENT column takes the next value of its sequence when a row
+// leaves it NULL. A row that gives it a value moves the sequence past that
+// value, so later rows never get it. Sequence values are not given back when
+// a statement fails.
+
+func (e *Executor) executeCreateSequence(plan *Query) (Result, error) {
+	if err := e.db.CreateSequence(plan.Sequence, plan.Start, plan.Increment); err != nil {
+		return Result{}, fmt.Errorf("creating sequence %s: %w", plan.Sequence, err)
+	}
+	return Result{Message: "CREATE SEQUENCE"}, nil
+}
+
+// evaluate returns the value of a literal or of a sequence function call
+func (e *Executor) evaluate(value interface{}) (interface{}, error) {
+	call, ok := value.(sequenceCall)
+	if !ok {
+		return value, nil
+	}
+	s, ok := e.db.Sequences[call.sequence]
+	if !ok {
+		return nil, fmt.Errorf("sequence %s does not exist", call.sequence)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.currval == nil {
+		e.currval = make(map[string]int)
+	}
+	switch call.function {
+	case "nextval":
+		n, err := s.NextVal()
+		if err != nil {
+			return nil, err
+		}
+		e.currval[s.Name] = n
+		return n, nil
+	case "currval":
+		n, ok := e.currval[s.Name]
+		if !ok {
+			return nil, fmt.Errorf("currval of sequence %s is not yet defined, call nextval first", s.Name)
+		}
+		return n, nil
+	case "setval":
+		if err := s.SetVal(call.value); err != nil {
+			return nil, err
+		}
+		return call.value, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", call.function)
+}
+
+// evaluateRow evaluates every value of a VALUES tuple
+func (e *Executor) evaluateRow(row []interface{}) ([]interface{}, error) {
+	values := make([]interface{}, len(row))
+	for i, value := range row {
+		var err error
+		if values[i], err = e.evaluate(value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// selectValues runs a SELECT without FROM, which returns one row
+func (e *Executor) selectValues(plan *Query) (Result, error) {
+	result := Result{Message: "SELECT 1"}
+	for _, value := range plan.Rows[0] {
+		name := "?column?"
+		if call, ok := value.(sequenceCall); ok {
+			name = call.function
+		}
+		result.Columns = append(result.Columns, name)
+	}
+	row, err := e.evaluateRow(plan.Rows[0])
+	if err != nil {
+		return Result{}, err
+	}
+	result.Rows = [][]interface{}{row}
+	return result, nil
+}
+
+// fillAutoIncrement gives the AUTOINCREMENT column of a row the next value of
+// its sequence when the row leaves it NULL
+func fillAutoIncrement(db *storage.Database, table *storage.Table, values []interface{}) error {
+	s := db.AutoIncrement(table.Name)
+	if s == nil {
+		return nil
+	}
+	i := columnIndex(table, s.Column)
+	if i < 0 {
+		return fmt.Errorf("AUTOINCREMENT column %s is missing", s.Column)
+	}
+
+	switch value := values[i].(type) {
+	case nil:
+		n, err := s.NextVal()
+		if err != nil {
+			return err
+		}
+		values[i] = n
+	case int:
+		return s.Observe(value)
+	}
+	return nil
+}
@@ -1,18 +1,137 @@
 package query
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"godb/internal/storage"
+)
+
 type Parser struct{}
 type Optimizer struct{}
-type Executor struct{}
-type Result struct{}
 
-func (p *Parser) Parse(query string) interface{} {
-	return nil
+// Executor runs parsed statements against a database
+type Executor struct {
+	db *storage.Database
+
+	mu      sync.Mutex     // protects currval
+	currval map[string]int // last nextval of each sequence, for currval
+}
+
+// Result is what a statement returns
+type Result struct {
+	Columns []string        // names of the columns in Rows
+	Rows    [][]interface{} // rows returned by SELECT
+	Message string          // summary of what the statement did
+}
+
+func (p *Parser) Parse(query string) (*Query, error) {
+	return ParseSQL(query)
+}
+
+// Optimize returns the plan for a query; queries run as parsed for now
+func (o *Optimizer) Optimize(parsed *Query) *Query {
+	return parsed
 }
 
-func (o *Optimizer) Optimize(parsed interface{}) interface{} {
-	return nil
+func (e *Executor) Execute(plan *Query) (Result, error) {
+	if e == nil || e.db == nil {
+		return Result{}, errors.New("query processor has no database")
+	}
+
+	switch plan.Type {
+	case QueryVacuum:
+		return e.executeVacuum()
+	case QuerySelect:
+		return e.executeSelect(plan)
+	case QueryCopyFrom:
+		return e.executeCopyFrom(plan)
+	case QueryCopyTo:
+		return e.executeCopyTo(plan)
+	case QueryCreateTable:
+		return e.executeCreateTable(plan)
+	case QueryCreateSequence:
+		return e.executeCreateSequence(plan)
+	case QueryCreateIndex:
+		return e.executeCreateIndex(plan)
+	case QueryDropIndex:
+		return e.executeDropIndex(plan)
+	case QueryInsert:
+		return e.executeInsert(plan)
+	default:
+		return Result{}, fmt.Errorf("executing %s is not supported yet", plan.Type)
+	}
+}
+
+func (e *Executor) executeVacuum() (Result, error) {
+	report, err := e.db.Vacuum()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Message: fmt.Sprintf("VACUUM reclaimed %d bytes: %d pages truncated, %d pages freed, %d rows moved",
+		report.BytesReclaimed, report.PagesTruncated, report.PagesFreed, report.RecordsMoved)}, nil
+}
+
+// executeCreateTable adds a table; without a PRIMARY KEY the first column is
+// the key, as with storage.Database.CreateTable
+func (e *Executor) executeCreateTable(plan *Query) (Result, error) {
+	table := &storage.Table{Name: plan.Table, Columns: plan.Columns}
+	for i, column := range plan.Columns {
+		if columnIndex(table, column.Name) != i {
+			return Result{}, fmt.Errorf("column %s is defined twice", column.Name)
+		}
+	}
+	primaryKey := 0
+	if plan.PrimaryKey != "" {
+		if primaryKey = columnIndex(table, plan.PrimaryKey); primaryKey < 0 {
+			return Result{}, fmt.Errorf("primary key %s is not a column", plan.PrimaryKey)
+		}
+	}
+	if plan.AutoIncrement != "" {
+		// Checked here so a bad AUTOINCREMENT leaves no table behind
+		column := plan.Columns[primaryKey]
+		if column.Name != plan.AutoIncrement {
+			return Result{}, fmt.Errorf("AUTOINCREMENT column %s is not the primary key", plan.AutoIncrement)
+		}
+		if column.DataType != storage.TypeInteger {
+			return Result{}, fmt.Errorf("AUTOINCREMENT column %s is not an INTEGER", plan.AutoIncrement)
+		}
+	}
+
+	if err := e.db.CreateTableWithPrimaryKey(plan.Table, plan.Columns, primaryKey); err != nil {
+		return Result{}, fmt.Errorf("creating table %s: %w", plan.Table, err)
+	}
+	if plan.AutoIncrement != "" {
+		if err := e.db.SetAutoIncrement(plan.Table, plan.AutoIncrement); err != nil {
+			return Result{}, fmt.Errorf("creating table %s: %w", plan.Table, err)
+		}
+	}
+	return Result{Message: "CREATE TABLE"}, nil
+}
+
+func (e *Executor) executeCopyFrom(plan *Query) (Result, error) {
+	report, err := ImportCSVFile(e.db, plan.Table, plan.File, plan.Copy)
+	if err != nil {
+		return Result{}, err
+	}
+
+	message := fmt.Sprintf("COPY %d", report.Loaded)
+	if n := len(report.Rejected); n > 0 {
+		lines := make([]string, n)
+		for i, row := range report.Rejected {
+			lines[i] = fmt.Sprintf("line %d: %s", row.Line, row.Error)
+		}
+		message += fmt.Sprintf(", %d rows rejected:\n%s", n, strings.Join(lines, "\n"))
+	}
+	return Result{Message: message}, nil
 }
 
-func (e *Executor) Execute(plan interface{}) (Result, error) {
-	return Result{}, nil
+func (e *Executor) executeCopyTo(plan *Query) (Result, error) {
+	n, err := ExportFile(e.db, plan.Source, plan.File, plan.Copy)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Message: fmt.Sprintf("COPY %d", n)}, nil
 }
@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
+)
+
+// Online backups copy the pages while writers keep going, so the copied pages
+// may come from different moments. The backup therefore also carries the WAL
+// from a checkpoint taken just before the copy up to an end LSN taken just
+// after it. Every page changed in that window logged a full image after the
+// checkpoint, so replaying the log over the copied pages brings all of them
+// to the state as of the end LSN.
+//
+// An incremental backup only copies pages whose header LSN is newer than the
+// end LSN of the previous backup in its chain (its base LSN). Pages at or
+// below the base did not change since, so the previous backups already hold them.
+//
+// Backup file layout (little-endian):
+//
+//	magic      [8]byte "GODBBAK2"
+//	page size  uint16
+//	page count uint64, pages in the database when the copy started
+//	base LSN   uint64, 0 for a full backup
+//	redo LSN   uint64
+//	pages      page ID uint64 + page size bytes, repeated, ended by page ID endOfPages
+//	end LSN    uint64
+//	log size   uint64
+//	log        WAL entries after the redo LSN up to the end LSN
+//	checksum   uint32, CRC-32 of everything before it
+
+var backupMagic = [8]byte{'G', 'O', 'D', 'B', 'B', 'A', 'K', '2'}
+
+// endOfPages marks the end of the page section
+const endOfPages = ^uint64(0)
+
+// ErrCorruptBackup is returned when a backup file fails validation
+var ErrCorruptBackup = errors.New("corrupt backup")
+
+// BackupInfo describes a backup that was taken or restored
+type BackupInfo struct {
+	Pages   uint64  // pages in the database
+	Copied  uint64  // pages stored in the backup, less than Pages for an incremental backup
+	BaseLSN wal.LSN // previous backup's end LSN, 0 for a full backup
+	RedoLSN wal.LSN // log replay starts after this LSN
+	EndLSN  wal.LSN // the backup is consistent as of this LSN
+	Tables  int     // set by restore after checking the copy
+	Records int     // set by restore after checking the copy
+}
+
+// Incremental reports whether the backup only holds pages changed since its base
+func (info *BackupInfo) Incremental() bool {
+	return info.BaseLSN != 0
+}
+
+// backupHeader is the fixed part at the start of a backup
+type backupHeader struct {
+	Magic     [8]byte
+	PageSize  uint16
+	PageCount uint64
+	BaseLSN   uint64
+	RedoLSN   uint64
+}
+
+// Backup writes a consistent full snapshot of the database to w. Writers are
+// not blocked. Without a WAL the pages are copied one at a time and the copy
+// is only consistent if nothing writes to the database meanwhile.
+func (db *Database) Backup(w io.Writer) (*BackupInfo, error) {
+	return db.BackupSince(w, 0)
+}
+
+// BackupSince writes an incremental backup holding only the pages changed
+// after baseLSN, normally the EndLSN of the previous backup. A base of zero
+// takes a full backup. Incremental backups need a WAL, since page LSNs are
+// only stamped when changes are logged.
+func (db *Database) BackupSince(w io.Writer, baseLSN wal.LSN) (*BackupInfo, error) {
+	if baseLSN != 0 {
+		if db.wal == nil {
+			return nil, errors.New("incremental backup needs a write-ahead log")
+		}
+		if db.wal.CurrentLSN() < baseLSN {
+			return nil, fmt.Errorf("log is behind base LSN %d, it was probably reset; take a full backup", baseLSN)
+		}
+	}
+
+	// Keep checkpoints from truncating the log until its entries are copied
+	if db.wal != nil {
+		release := db.wal.Hold()
+		defer release()
+	}
+	redoLSN, err := db.checkpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages allocated after this point are rebuilt from their images in the log
+	info := &BackupInfo{Pages: db.PageCount(), BaseLSN: baseLSN, RedoLSN: redoLSN}
+
+	crc := crc32.NewIEEE()
+	out := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	header := backupHeader{
+		Magic:     backupMagic,
+		PageSize:  db.PageSize,
+		PageCount: info.Pages,
+		BaseLSN:   uint64(baseLSN),
+		RedoLSN:   uint64(redoLSN),
+	}
+	if err := binary.Write(out, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, db.PageSize)
+	for pageID := uint64(0); pageID < info.Pages; pageID++ {
+		if err := db.copyPage(pageID, data); err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageID, err)
+		}
+		if baseLSN != 0 && pageLSN(data) <= baseLSN {
+			continue
+		}
+		if err := binary.Write(out, binary.LittleEndian, pageID); err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(data); err != nil {
+			return nil, err
+		}
+		info.Copied++
+	}
+	if err := binary.Write(out, binary.LittleEndian, endOfPages); err != nil {
+		return nil, err
+	}
+
+	// Everything logged up to now covers the pages copied above
+	var segment bytes.Buffer
+	if db.wal != nil {
+		info.EndLSN = db.wal.CurrentLSN()
+		if _, err := db.wal.CopyEntries(&segment, redoLSN, info.EndLSN); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := binary.Write(out, binary.LittleEndian, uint64(info.EndLSN)); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(out, binary.LittleEndian, uint64(segment.Len())); err != nil {
+		return nil, err
+	}
+	if _, err := segment.WriteTo(out); err != nil {
+		return nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+
+	// The checksum covers everything written so far and is not part of itself
+	if err := binary.Write(w, binary.LittleEndian, crc.Sum32()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// BackupTo writes a full backup to a new file at path
+func (db *Database) BackupTo(path string) (*BackupInfo, error) {
+	return db.backupToFile(path, 0)
+}
+
+// backupToFile writes a backup since baseLSN to a new file at path
+func (db *Database) backupToFile(path string, baseLSN wal.LSN) (*BackupInfo, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := db.BackupSince(file, baseLSN)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return info, nil
+}
+
+// copyPage copies the current contents of a page into data. Cached pages are
+// copied under their latch; pages past the end of the file read as zeros.
+func (db *Database) copyPage(pageID uint64, data []byte) error {
+	if page, found := db.Cache.Get(pageID); found {
+		page.latch.RLock()
+		copy(data, page.Data)
+		page.latch.RUnlock()
+		return nil
+	}
+
+	n, err := db.File.ReadAt(data, int64(pageID)*int64(db.PageSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(data[n:])
+	return nil
+}
+
+// RestoreBackup writes the full backup read from r to a new database at path,
+// replays the log it carries and then checks the restored copy by reading
+// every record of every table. opts selects the file system; the restored
+// database is opened with it and closed again before returning.
+// Incremental backups are restored with RestoreChain.
+func RestoreBackup(r io.Reader, path string, opts Options) (*BackupInfo, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.OS
+	}
+	if _, err := opts.FS.Stat(path); err == nil {
+		return nil, fmt.Errorf("restore target %s already exists", path)
+	}
+
+	info, err := applyBackup(r, path, 0, opts)
+	if err != nil {
+		opts.FS.Remove(path)
+		return nil, err
+	}
+	if err := checkRestored(path, opts, info); err != nil {
+		return nil, fmt.Errorf("checking restored database: %w", err)
+	}
+	return info, nil
+}
+
+// applyBackup writes the pages of one backup into the database at path and
+// replays its log. baseLSN must match the backup's base, so incremental
+// backups are only applied on top of the state they were taken against.
+func applyBackup(r io.Reader, path string, baseLSN wal.LSN, opts Options) (*BackupInfo, error) {
+	walPath := path + ".restore-wal"
+	defer opts.FS.Remove(walPath)
+
+	info, err := restoreFiles(r, path, walPath, baseLSN, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Opening with the log replays it; closing takes a checkpoint so the
+	// log is no longer needed
+	opts.WALPath = walPath
+	db, err := NewDatabaseWithOptions(path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("replaying backup log: %w", err)
+	}
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// restoreFiles writes the pages to path and the log segment to walPath,
+// verifying the backup checksum along the way
+func restoreFiles(r io.Reader, path, walPath string, baseLSN wal.LSN, opts Options) (*BackupInfo, error) {
+	crc := crc32.NewIEEE()
+	in := io.TeeReader(bufio.NewReader(r), crc)
+
+	var header backupHeader
+	if err := binary.Read(in, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	if header.Magic != backupMagic {
+		return nil, fmt.Errorf("%w: not a godb backup", ErrCorruptBackup)
+	}
+	if header.PageSize == 0 {
+		return nil, fmt.Errorf("%w: page size is zero", ErrCorruptBackup)
+	}
+	if wal.LSN(header.BaseLSN) != baseLSN {
+		if baseLSN == 0 {
+			return nil, fmt.Errorf("backup is incremental from LSN %d, restore its chain instead", header.BaseLSN)
+		}
+		return nil, fmt.Errorf("backup starts at LSN %d but the chain is at LSN %d", header.BaseLSN, baseLSN)
+	}
+	info := &BackupInfo{
+		Pages:   header.PageCount,
+		BaseLSN: wal.LSN(header.BaseLSN),
+		RedoLSN: wal.LSN(header.RedoLSN),
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if baseLSN == 0 {
+		flag |= os.O_EXCL
+	}
+	file, err := opts.FS.OpenFile(path, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Size the file to the page count at backup time; pages the backup does
+	// not hold keep the contents of the previous backups in the chain
+	if err := file.Truncate(int64(header.PageCount) * int64(header.PageSize)); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, header.PageSize)
+	for {
+		var pageID uint64
+		if err := binary.Read(in, binary.LittleEndian, &pageID); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+		}
+		if pageID == endOfPages {
+			break
+		}
+		if _, err := io.ReadFull(in, data); err != nil {
+			return nil, fmt.Errorf("%w: page %d: %v", ErrCorruptBackup, pageID, err)
+		}
+		if _, err := file.WriteAt(data, int64(pageID)*int64(header.PageSize)); err != nil {
+			return nil, err
+		}
+		info.Copied++
+	}
+
+	var endLSN, segmentSize uint64
+	if err := binary.Read(in, binary.LittleEndian, &endLSN); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	if err := binary.Read(in, binary.LittleEndian, &segmentSize); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	info.EndLSN = wal.LSN(endLSN)
+	segment := make([]byte, segmentSize)
+	if _, err := io.ReadFull(in, segment); err != nil {
+		return nil, fmt.Errorf("%w: log: %v", ErrCorruptBackup, err)
+	}
+
+	if err := verifyChecksum(in, crc); err != nil {
+		return nil, err
+	}
+
+	walFile, err := opts.FS.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer walFile.Close()
+	if err := wal.WriteHeader(walFile, info.RedoLSN); err != nil {
+		return nil, err
+	}
+	if _, err := walFile.WriteAt(segment, wal.HeaderSize); err != nil {
+		return nil, err
+	}
+	if err := walFile.Sync(); err != nil {
+		return nil, err
+	}
+	return info, file.Sync()
+}
+
+// verifyChecksum reads the trailing checksum, which is not part of the sum
+func verifyChecksum(in io.Reader, crc hash.Hash32) error {
+	want := crc.Sum32()
+	var got uint32
+	if err := binary.Read(in, binary.LittleEndian, &got); err != nil {
+		return fmt.Errorf("%w: missing checksum: %v", ErrCorruptBackup, err)
+	}
+	if got != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptBackup)
+	}
+	return nil
+}
+
+// checkRestored opens the restored database read-only, runs Database.Check
+// over it and counts the records of every table
+func checkRestored(path string, opts Options, info *BackupInfo) error {
+	opts.ReadOnly = true
+	opts.WALPath = ""
+	db, err := NewDatabaseWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if db.PageCount() < info.Pages {
+		return fmt.Errorf("expected at least %d pages, found %d", info.Pages, db.PageCount())
+	}
+
+	report, err := db.Check()
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		problem := report.Problems[0]
+		return fmt.Errorf("%d problems, first on page %d: %s", len(report.Problems), problem.Page, problem.Message)
+	}
+
+	info.Tables = len(db.Tables)
+	for _, table := range db.Tables {
+		err := db.RecordManager.Scan(table, func(rid RecordID, record *Record) error {
+			info.Records++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
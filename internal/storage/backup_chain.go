@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
+)
+
+// A backup chain is a directory holding a full backup followed by incremental
+// backups, each one taken against the end LSN of the one before. The manifest
+// lists them in order. Taking a full backup into a directory that already has
+// a chain starts a new chain; restore uses the last full backup and the
+// incrementals after it.
+
+// chainManifest is the name of the manifest file in a backup chain directory
+const chainManifest = "manifest.json"
+
+// ChainEntry describes one backup file in a chain
+type ChainEntry struct {
+	File        string    `json:"file"`
+	Incremental bool      `json:"incremental"`
+	BaseLSN     wal.LSN   `json:"base_lsn"`
+	EndLSN      wal.LSN   `json:"end_lsn"`
+	Pages       uint64    `json:"pages"`
+	Copied      uint64    `json:"copied"`
+	Created     time.Time `json:"created"`
+}
+
+// BackupChain is the manifest of a backup chain directory
+type BackupChain struct {
+	Backups []ChainEntry `json:"backups"`
+}
+
+// ReadBackupChain reads the manifest of the chain in dir. A directory
+// without a manifest holds an empty chain.
+func ReadBackupChain(dir string) (*BackupChain, error) {
+	data, err := os.ReadFile(filepath.Join(dir, chainManifest))
+	if errors.Is(err, os.ErrNotExist) {
+		return &BackupChain{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var chain BackupChain
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %v", ErrCorruptBackup, err)
+	}
+	return &chain, nil
+}
+
+// write replaces the manifest in dir, so a crash leaves the old or the new one
+func (chain *BackupChain) write(dir string) error {
+	data, err := json.MarshalIndent(chain, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, chainManifest+".tmp")
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, chainManifest))
+}
+
+// current returns the backups a restore needs: the last full backup and
+// the incrementals taken after it
+func (chain *BackupChain) current() []ChainEntry {
+	for i := len(chain.Backups) - 1; i >= 0; i-- {
+		if !chain.Backups[i].Incremental {
+			return chain.Backups[i:]
+		}
+	}
+	return nil
+}
+
+// BackupToChain adds a backup to the chain in dir, creating the directory if
+// needed. An incremental backup only copies pages changed since the previous
+// backup in the chain; a full backup starts a new chain.
+func (db *Database) BackupToChain(dir string, incremental bool) (*BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	chain, err := ReadBackupChain(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseLSN wal.LSN
+	if incremental {
+		backups := chain.current()
+		if len(backups) == 0 {
+			return nil, errors.New("no full backup in chain to base an incremental backup on")
+		}
+		baseLSN = backups[len(backups)-1].EndLSN
+	}
+
+	name := fmt.Sprintf("backup-%04d.bak", len(chain.Backups))
+	info, err := db.backupToFile(filepath.Join(dir, name), baseLSN)
+	if err != nil {
+		return nil, err
+	}
+	chain.Backups = append(chain.Backups, ChainEntry{
+		File:        name,
+		Incremental: incremental,
+		BaseLSN:     info.BaseLSN,
+		EndLSN:      info.EndLSN,
+		Pages:       info.Pages,
+		Copied:      info.Copied,
+		Created:     time.Now().UTC(),
+	})
+	return info, chain.write(dir)
+}
+
+// RestoreChain restores the backup chain in dir to a new database at path:
+// the last full backup first, then each incremental on top of it in order.
+// The restored copy is checked like RestoreBackup does. The returned info
+// describes the state the chain restored to.
+func RestoreChain(dir, path string, opts Options) (*BackupInfo, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.OS
+	}
+	if _, err := opts.FS.Stat(path); err == nil {
+		return nil, fmt.Errorf("restore target %s already exists", path)
+	}
+
+	chain, err := ReadBackupChain(dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := chain.current()
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("no full backup in %s", dir)
+	}
+
+	info, err := restoreChain(dir, path, backups, opts)
+	if err != nil {
+		opts.FS.Remove(path)
+		return nil, err
+	}
+	if err := checkRestored(path, opts, info); err != nil {
+		return nil, fmt.Errorf("checking restored database: %w", err)
+	}
+	return info, nil
+}
+
+// restoreChain applies each backup in turn, checking it continues the chain
+func restoreChain(dir, path string, backups []ChainEntry, opts Options) (*BackupInfo, error) {
+	var info *BackupInfo
+	var baseLSN wal.LSN
+	for _, entry := range backups {
+		file, err := os.Open(filepath.Join(dir, entry.File))
+		if err != nil {
+			return nil, err
+		}
+		info, err = applyBackup(file, path, baseLSN, opts)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.File, err)
+		}
+		baseLSN = info.EndLSN
+	}
+	return info, nil
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db, err := NewDatabaseWithOptions("source.db", Options{FS: fs, WALPath: "source.wal"})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}
+	if err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	for i := 0; i < 500; i++ {
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}}); err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+	}
+
+	// Keep writing while the backup runs
+	stop := make(chan struct{})
+	written := make(chan int)
+	go func() {
+		i := 500
+		for {
+			select {
+			case <-stop:
+				written <- i
+				return
+			default:
+			}
+			if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}}); err != nil {
+				t.Errorf("Failed to insert record %d: %v", i, err)
+			}
+			i++
+		}
+	}()
+
+	var backup bytes.Buffer
+	info, err := db.Backup(&backup)
+	close(stop)
+	total := <-written
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	restored, err := RestoreBackup(bytes.NewReader(backup.Bytes()), "restored.db", Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.Pages != info.Pages || restored.EndLSN != info.EndLSN {
+		t.Errorf("Restored %+v does not match backup %+v", restored, info)
+	}
+	if restored.Tables != 1 || restored.Records < 500 || restored.Records > total {
+		t.Errorf("Expected 1 table with 500 to %d records, got %d tables and %d records", total, restored.Tables, restored.Records)
+	}
+
+	copyDB, err := NewDatabaseWithOptions("restored.db", Options{FS: fs, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer copyDB.Close()
+
+	// The copy holds a prefix of the inserts with no gaps
+	count := 0
+	err = copyDB.RecordManager.Scan(copyDB.Tables["users"], func(rid RecordID, record *Record) error {
+		if record.Values[0] != count || record.Values[1] != fmt.Sprintf("User %d", count) {
+			t.Errorf("Unexpected record %v at position %d", record.Values, count)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to scan restored table: %v", err)
+	}
+	if count != restored.Records {
+		t.Errorf("Expected %d restored records, got %d", restored.Records, count)
+	}
+
+	t.Run("Corrupt Backup", func(t *testing.T) {
+		data := append([]byte(nil), backup.Bytes()...)
+		data[len(data)/2] ^= 0xff
+		_, err := RestoreBackup(bytes.NewReader(data), "corrupt.db", Options{FS: fs})
+		if !errors.Is(err, ErrCorruptBackup) {
+			t.Errorf("Expected ErrCorruptBackup, got %v", err)
+		}
+		if _, err := fs.Stat("corrupt.db"); err == nil {
+			t.Error("Expected corrupt restore to be cleaned up")
+		}
+	})
+}
+
+func TestIncrementalBackupChain(t *testing.T) {
+	fs := vfs.NewMemFS()
+	dir := t.TempDir()
+	db, err := NewDatabaseWithOptions("source.db", Options{FS: fs, WALPath: "source.wal"})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTable("users", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	inserted := 0
+	insert := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{inserted, fmt.Sprintf("User %d", inserted)}}); err != nil {
+				t.Fatalf("Failed to insert record %d: %v", inserted, err)
+			}
+			inserted++
+		}
+	}
+
+	if _, err := db.BackupToChain(dir, true); err == nil {
+		t.Error("Expected incremental backup without a full backup to fail")
+	}
+
+	insert(1000)
+	full, err := db.BackupToChain(dir, false)
+	if err != nil {
+		t.Fatalf("Full backup failed: %v", err)
+	}
+	if full.Copied != full.Pages {
+		t.Errorf("Expected full backup to copy all %d pages, copied %d", full.Pages, full.Copied)
+	}
+
+	for round := 0; round < 2; round++ {
+		insert(20)
+		info, err := db.BackupToChain(dir, true)
+		if err != nil {
+			t.Fatalf("Incremental backup %d failed: %v", round, err)
+		}
+		if info.Copied == 0 || info.Copied >= info.Pages {
+			t.Errorf("Expected incremental backup %d to copy some of %d pages, copied %d", round, info.Pages, info.Copied)
+		}
+	}
+
+	chain, err := ReadBackupChain(dir)
+	if err != nil {
+		t.Fatalf("Failed to read chain: %v", err)
+	}
+	if len(chain.Backups) != 3 {
+		t.Fatalf("Expected 3 backups in chain, got %d", len(chain.Backups))
+	}
+	for i := 1; i < len(chain.Backups); i++ {
+		if chain.Backups[i].BaseLSN != chain.Backups[i-1].EndLSN {
+			t.Errorf("Backup %d starts at LSN %d, previous ends at %d", i, chain.Backups[i].BaseLSN, chain.Backups[i-1].EndLSN)
+		}
+	}
+
+	restored, err := RestoreChain(dir, "restored.db", Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.Records != inserted || restored.EndLSN != chain.Backups[2].EndLSN {
+		t.Errorf("Expected %d records as of LSN %d, got %+v", inserted, chain.Backups[2].EndLSN, restored)
+	}
+
+	t.Run("Incremental Alone", func(t *testing.T) {
+		file, err := os.Open(filepath.Join(dir, chain.Backups[1].File))
+		if err != nil {
+			t.Fatalf("Failed to open backup: %v", err)
+		}
+		defer file.Close()
+		if _, err := RestoreBackup(file, "partial.db", Options{FS: fs}); err == nil {
+			t.Error("Expected restoring an incremental backup on its own to fail")
+		}
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db, err := NewDatabaseWithOptions("source.db", Options{FS: fs, WALPath: "source.wal"})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	if err := db.CreateTable("users", []Column{{Name: "id", DataType: TypeInteger}}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	insert := func(from, to int) {
+		for i := from; i < to; i++ {
+			if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i}}); err != nil {
+				t.Fatalf("Failed to insert record %d: %v", i, err)
+			}
+		}
+	}
+	insert(0, 300)
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	insert(300, 400)
+
+	count := 0
+	err = snapshot.RecordManager.Scan(snapshot.Tables["users"], func(rid RecordID, record *Record) error {
+		if record.Values[0] != count {
+			return fmt.Errorf("record %v holds %v, want %d", rid, record.Values[0], count)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan of snapshot failed: %v", err)
+	}
+	if count != 300 {
+		t.Errorf("Snapshot holds %d records, want 300", count)
+	}
+	if err := snapshot.CreateTable("orders", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected the snapshot to be read-only, got %v", err)
+	}
+	if paths, _ := fs.Glob(db.snapshotPattern()); len(paths) != 1 {
+		t.Errorf("Expected the snapshot in one file next to the database, got %v", paths)
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Fatalf("Failed to close snapshot: %v", err)
+	}
+	if paths, _ := fs.Glob(db.snapshotPattern()); len(paths) != 0 {
+		t.Errorf("Expected closing the snapshot to remove its file, got %v", paths)
+	}
+
+	// A snapshot a crash left behind is removed on the next open
+	if _, err := db.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if db, err = NewDatabaseWithOptions("source.db", Options{FS: fs, WALPath: "source.wal"}); err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	if paths, _ := fs.Glob(db.snapshotPattern()); len(paths) != 0 {
+		t.Errorf("Expected the left over snapshot to be removed, got %v", paths)
+	}
+}
@@ -1,200 +1,748 @@
 package storage
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 )
 
-// BTreeNode represents a node in the B-tree
-type BTreeNode struct {
-	isLeaf   bool         // Whether this is a leaf node
-	keys     []int        // Keys stored in this node
-	values   []RecordID   // Values (RecordIDs) stored in leaf nodes
-	children []*BTreeNode // Child pointers (nil for leaf nodes)
-	parent   *BTreeNode   // Parent pointer (nil for root)
-}
+// A BTree is a B+tree index whose nodes are pages of the database, read
+// through the cache like table pages and logged like them, see btree_page.go.
+// Leaves hold every key with the record ID it points to; internal nodes hold
+// separators and the page IDs of their children.
+//
+// Keys are byte strings in bytes.Compare order; EncodeKey builds them from
+// column values. Each tree has a longest key it accepts, and its degree is
+// chosen so that full nodes of such keys fit in a page.
+//
+// Many records may share a key, as in an index on a status column. An entry
+// is a key and a record ID, and entries are ordered by key and then by record
+// ID, so every entry has a place of its own: the separators of internal nodes
+// are entries too, and a given (key, record ID) is found, inserted or removed
+// with a single descent however many records share the key.
+//
+// A unique tree refuses a second entry with a key it already holds, unless
+// the key has a NULL column: like in SQL, NULLs are never equal to each other.
+// An index over a table names the table and the column behind each key
+// column; the table's primary key has one, see table_index.go.
+//
+// Each tree is a catalog row of kind "index" holding its ID, name, root page,
+// degree, key size, uniqueness, table and key columns. The root page never
+// changes: when the root splits, its content moves to a new page below it, so
+// the catalog row is never rewritten. Like a table's, the tree's page list is
+// rebuilt on open from the page headers, which name the tree's ID as their
+// owner. Nodes are only read when the tree is used.
+//
+// A node holds at most 2*degree-1 keys, and every node but the root at least
+// degree-1. Any number of goroutines may use a tree at once, see
+// btree_latch.go.
 
-// BTree represents a B-tree index structure
+// BTree is a B+tree index stored in database pages
 type BTree struct {
-	root   *BTreeNode // Root node of the tree
-	degree int        // Minimum degree (minimum number of keys per node)
+	IndexInfo
+	Root    uint64   // page of the root node
+	PageIDs []uint64 // pages holding the tree's nodes, in allocation order
+
+	degree   int // minimum degree
+	keySize  int // longest key in bytes
+	db       *Database
+	latches  sync.Map                 // page ID to *nodeLatch
+	keyLocks [keyLockCount]sync.Mutex // held by inserts into a unique tree, see keyLock
+	pagesMu  sync.Mutex               // protects PageIDs while nodes are added and freed
 }
 
-// NewBTree creates a new B-tree with the specified degree
-func NewBTree(degree int) *BTree {
-	if degree < 2 {
-		return nil
+// BTreeOptions controls how a B-tree is created
+type BTreeOptions struct {
+	Degree       int         // minimum degree, 0 for the largest whose nodes fit in a page
+	KeySize      int         // longest key in bytes, 0 for DefaultKeySize
+	Columns      []KeyColumn // ordering of the key's columns
+	Unique       bool        // refuse a second entry with the same key
+	TableID      uint64      // table whose rows the index covers
+	TableColumns []int       // column of the table behind each key column
+}
+
+// ErrDuplicateKey is returned when an insert would give a unique index a key
+// it already holds. The error is a *DuplicateKeyError naming the index and key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// DuplicateKeyError is the ErrDuplicateKey of one index and key
+type DuplicateKeyError struct {
+	Index string // name of the unique index
+	Key   string // the key, formatted with the index's columns
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key %s in unique index %s", e.Key, e.Index)
+}
+
+// Is makes errors.Is(err, ErrDuplicateKey) true
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == ErrDuplicateKey
+}
+
+// DefaultKeySize is the longest key of a B-tree created without a key size
+const DefaultKeySize = 64
+
+// serialize writes the tree as a record: ID, name, root page, degree, key
+// size, unique flag, table ID and column count, then the descending flag and
+// NULL order of each column, then the count and numbers of the table columns
+func (t *BTree) serialize() []byte {
+	values := []interface{}{int(t.ID), t.Name, int(t.Root), t.degree, t.keySize, t.Unique, int(t.TableID), len(t.Columns)}
+	for _, column := range t.Columns {
+		values = append(values, column.Descending, int(column.Nulls))
 	}
-	return &BTree{
-		root:   nil,
-		degree: degree,
+	values = append(values, len(t.TableColumns))
+	for _, column := range t.TableColumns {
+		values = append(values, column)
 	}
+	return mustSerializeRecord(values)
 }
 
-// Search looks up a key in the B-tree and returns the associated RecordID
-func (t *BTree) Search(key int) (*RecordID, bool) {
-	if t.root == nil {
-		return nil, false
+func deserializeBTree(data []byte) (*BTree, error) {
+	record, err := DeserializeRecord(data)
+	if err != nil {
+		return nil, err
 	}
-	return t.searchNode(t.root, key)
+	corrupt := errors.New("corrupt index metadata")
+	values := record.Values
+	if len(values) < 8 {
+		return nil, corrupt
+	}
+	t := &BTree{}
+	var id, root, table, count int
+	var ok [8]bool
+	id, ok[0] = values[0].(int)
+	t.Name, ok[1] = values[1].(string)
+	root, ok[2] = values[2].(int)
+	t.degree, ok[3] = values[3].(int)
+	t.keySize, ok[4] = values[4].(int)
+	t.Unique, ok[5] = values[5].(bool)
+	table, ok[6] = values[6].(int)
+	count, ok[7] = values[7].(int)
+	for _, valid := range ok {
+		if !valid {
+			return nil, corrupt
+		}
+	}
+	if count < 0 || len(values) < 9+2*count {
+		return nil, corrupt
+	}
+	t.ID, t.Root, t.TableID = uint64(id), uint64(root), uint64(table)
+
+	for i := 0; i < count; i++ {
+		descending, descOK := values[8+2*i].(bool)
+		nulls, nullsOK := values[9+2*i].(int)
+		if !descOK || !nullsOK {
+			return nil, corrupt
+		}
+		t.Columns = append(t.Columns, KeyColumn{Descending: descending, Nulls: NullOrder(nulls)})
+	}
+
+	values = values[8+2*count:]
+	count, countOK := values[0].(int)
+	if !countOK || len(values) != 1+count {
+		return nil, corrupt
+	}
+	for _, value := range values[1:] {
+		column, ok := value.(int)
+		if !ok {
+			return nil, corrupt
+		}
+		t.TableColumns = append(t.TableColumns, column)
+	}
+	return t, nil
 }
 
-// searchNode recursively searches for a key in a node and its children
-func (t *BTree) searchNode(node *BTreeNode, key int) (*RecordID, bool) {
-	// Find the first key greater than or equal to target
-	i := 0
-	for i < len(node.keys) && key > node.keys[i] {
-		i++
+// CreateBTree adds an empty B-tree index with keys of up to DefaultKeySize
+// bytes. A degree of 0 picks the largest degree whose nodes fit in a page.
+func (db *Database) CreateBTree(name string, degree int) (*BTree, error) {
+	return db.CreateBTreeWithOptions(name, BTreeOptions{Degree: degree})
+}
+
+// CreateBTreeWithOptions adds an empty B-tree index
+func (db *Database) CreateBTreeWithOptions(name string, opts BTreeOptions) (*BTree, error) {
+	if db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if db.indexExists(name) {
+		return nil, fmt.Errorf("index %s already exists", name)
+	}
+	keySize, degree := opts.KeySize, opts.Degree
+	if keySize == 0 {
+		keySize = DefaultKeySize
+	}
+	maxDegree := (maxNodeKeys(db.PageSize, keySize) + 1) / 2
+	if degree == 0 {
+		degree = maxDegree
+	}
+	if keySize < 0 || keySize > math.MaxUint16 || degree < 2 || degree > maxDegree {
+		return nil, fmt.Errorf("B-tree degree %d with %d byte keys does not fit in a page", degree, keySize)
+	}
+
+	t := &BTree{
+		IndexInfo: IndexInfo{
+			ID:           db.nextTableID,
+			Name:         name,
+			Columns:      opts.Columns,
+			Unique:       opts.Unique,
+			TableID:      opts.TableID,
+			TableColumns: opts.TableColumns,
+		},
+		degree:  degree,
+		keySize: keySize,
+		db:      db,
+	}
+	root, err := t.newNode(true)
+	if err != nil {
+		return nil, err
 	}
+	t.Root = root.pageID
+	if _, err := db.addCatalogEntry(catalogKindIndex, t.serialize()); err != nil {
+		return nil, err
+	}
+	db.nextTableID++
+	db.Indexes[name] = t
+	db.attachIndex(t)
+	return t, nil
+}
+
+// Degree returns the minimum degree of the tree
+func (t *BTree) Degree() int {
+	return t.degree
+}
 
-	// If we found the key in current node
-	if i < len(node.keys) && key == node.keys[i] {
-		return &node.values[i], true
+// keyLimit returns the longest key the tree takes
+func (t *BTree) keyLimit() int {
+	return t.keySize
+}
+
+// maxKeys is how many keys a node holds before it splits
+func (t *BTree) maxKeys() int {
+	return 2*t.degree - 1
+}
+
+// compareEntries orders entries by key and then by record ID
+func compareEntries(key []byte, rid RecordID, otherKey []byte, otherRID RecordID) int {
+	if c := bytes.Compare(key, otherKey); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(rid.PageID, otherRID.PageID); c != 0 {
+		return c
 	}
+	return cmp.Compare(rid.SlotNum, otherRID.SlotNum)
+}
+
+// entryIndex returns the index of the first entry of the node above the entry
+// (key, rid). In an internal node it is the child whose entries may include
+// it: the child after the last separator at or below it.
+func (n *btreeNode) entryIndex(key []byte, rid RecordID) int {
+	return sort.Search(len(n.keys), func(i int) bool { return compareEntries(n.keys[i], n.values[i], key, rid) > 0 })
+}
+
+// childIndex returns the index of the first key above key. In an internal
+// node, the entries with keys above key are in that child and after it.
+func (n *btreeNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 })
+}
 
-	// If we haven't found the key and this is a leaf, key doesn't exist
-	if node.isLeaf {
-		return nil, false
+// lowerBound returns the index of the first key at or above key
+func (n *btreeNode) lowerBound(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+}
+
+// Search looks up a key and returns the record ID stored with it. When
+// several records share the key it returns the smallest record ID; SearchAll
+// returns them all.
+func (t *BTree) Search(key []byte) (RecordID, bool, error) {
+	c, err := t.Seek(key)
+	if err != nil {
+		return RecordID{}, false, err
+	}
+	if c.Valid() && bytes.Equal(c.Key(), key) {
+		return c.Value(), true, nil
 	}
+	return RecordID{}, false, nil
+}
 
-	// Search appropriate child
-	// If i == len(node.keys), search rightmost child
-	// Otherwise, search child i
-	return t.searchNode(node.children[i], key)
+// SearchAll returns the record IDs stored with a key, in ascending order
+func (t *BTree) SearchAll(key []byte) ([]RecordID, error) {
+	var rids []RecordID
+	err := t.Range(KeyRange{Lo: key, Hi: key, LoInclusive: true, HiInclusive: true}, func(key []byte, rid RecordID) error {
+		rids = append(rids, rid)
+		return nil
+	})
+	return rids, err
 }
 
-// Insert adds a new key-value pair to the B-tree
-func (t *BTree) Insert(key int, value RecordID) {
-	// If tree is empty, create root
-	if t.root == nil {
-		t.root = &BTreeNode{
-			isLeaf: true,
-			keys:   make([]int, 0, t.degree),
-			values: make([]RecordID, 0, t.degree),
+// Insert adds a key and the record ID stored with it. A key may be stored
+// with any number of record IDs, but only once with each; a unique tree
+// returns a *DuplicateKeyError for a key it holds with any record ID. Full
+// nodes are split on the way down, so the insert never has to go back up the
+// tree.
+func (t *BTree) Insert(key []byte, value RecordID) error {
+	if t.db.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) > t.keySize {
+		return fmt.Errorf("index %s: key of %d bytes is longer than %d", t.Name, len(key), t.keySize)
+	}
+	key = slices.Clone(key)
+
+	if t.Unique && !t.hasNull(key) {
+		lock := t.keyLock(key)
+		lock.Lock()
+		defer lock.Unlock()
+		if _, found, err := t.Search(key); err != nil {
+			return err
+		} else if found {
+			return &DuplicateKeyError{Index: t.Name, Key: formatKey(t.Columns, key)}
 		}
 	}
 
-	// If root is full, create new root and split
-	if len(t.root.keys) == 2*t.degree-1 {
-		newRoot := &BTreeNode{
-			isLeaf:   false,
-			keys:     make([]int, 0, t.degree),
-			children: make([]*BTreeNode, 1, t.degree+1),
+	held := t.newLatchSet()
+	defer held.release()
+	root, err := held.read(t.Root)
+	if err != nil {
+		return err
+	}
+
+	if len(root.keys) == t.maxKeys() {
+		// The root page stays put: its content moves to a new child, which
+		// is then split below the emptied root
+		child, err := t.newNode(root.leaf)
+		if err != nil {
+			return err
+		}
+		held.lock(child.pageID)
+		child.keys, child.values, child.children = root.keys, root.values, root.children
+		root = &btreeNode{pageID: t.Root, children: []uint64{child.pageID}}
+		if err := t.splitChild(root, 0, child, held); err != nil {
+			return err
 		}
-		newRoot.children[0] = t.root
-		t.root.parent = newRoot
-		t.root = newRoot
-		t.splitChild(newRoot, 0)
 	}
 
-	t.insertNonFull(t.root, key, value)
-}
+	node := root
+	for !node.leaf {
+		i := node.entryIndex(key, value)
+		child, err := held.read(node.children[i])
+		if err != nil {
+			return err
+		}
+		if len(child.keys) == t.maxKeys() {
+			if err := t.splitChild(node, i, child, held); err != nil {
+				return err
+			}
+			if compareEntries(key, value, node.keys[i], node.values[i]) >= 0 {
+				if child, err = held.read(node.children[i+1]); err != nil {
+					return err
+				}
+			}
+		}
+		// The child has room for a key from below, nothing above it changes
+		held.releaseExcept(child.pageID)
+		node = child
+	}
 
-// insertNonFull inserts a key-value pair into a non-full node
-func (t *BTree) insertNonFull(node *BTreeNode, key int, value RecordID) {
-	if node.isLeaf {
-		// Find insertion point
-		i := len(node.keys) - 1
+	i := node.entryIndex(key, value)
+	if i > 0 && compareEntries(node.keys[i-1], node.values[i-1], key, value) == 0 {
+		return fmt.Errorf("index %s: key %s is already stored with record %v", t.Name, formatKey(t.Columns, key), value)
+	}
+	node.keys = slices.Insert(node.keys, i, key)
+	node.values = slices.Insert(node.values, i, value)
+	return t.writeNode(node)
+}
 
-		// Make space for new key/value
-		node.keys = append(node.keys, 0)
-		node.values = append(node.values, RecordID{})
+// splitChild splits the full child at index i of parent into two nodes and
+// adds a separator for the new right node to parent. A leaf keeps its first
+// degree entries and the separator is a copy of the right node's first entry;
+// an internal node gives its middle separator up to parent. The writer holds
+// parent and child; the new node and the leaf after it join its latches.
+func (t *BTree) splitChild(parent *btreeNode, i int, child *btreeNode, held *latchSet) error {
+	right, err := t.newNode(child.leaf)
+	if err != nil {
+		return err
+	}
+	held.lock(right.pageID)
 
-		// Shift elements
-		for i >= 0 && node.keys[i] > key {
-			node.keys[i+1] = node.keys[i]
-			node.values[i+1] = node.values[i]
-			i--
+	var sepKey []byte
+	var sepValue RecordID
+	if child.leaf {
+		mid := t.degree
+		sepKey, sepValue = child.keys[mid], child.values[mid]
+		right.keys = slices.Clone(child.keys[mid:])
+		right.values = slices.Clone(child.values[mid:])
+		child.keys = slices.Clone(child.keys[:mid])
+		child.values = slices.Clone(child.values[:mid])
+		if err := t.linkLeaf(child, right, held); err != nil {
+			return err
 		}
-
-		// Insert new key/value
-		node.keys[i+1] = key
-		node.values[i+1] = value
 	} else {
-		// Find child to recurse to
-		i := len(node.keys) - 1
-		for i >= 0 && key < node.keys[i] {
-			i--
-		}
-		i++
-
-		// If child is full, split it
-		if len(node.children[i].keys) == 2*t.degree-1 {
-			t.splitChild(node, i)
-			if key > node.keys[i] {
-				i++
+		mid := t.degree - 1
+		sepKey, sepValue = child.keys[mid], child.values[mid]
+		right.keys = slices.Clone(child.keys[mid+1:])
+		right.values = slices.Clone(child.values[mid+1:])
+		right.children = slices.Clone(child.children[mid+1:])
+		child.keys = slices.Clone(child.keys[:mid])
+		child.values = slices.Clone(child.values[:mid])
+		child.children = slices.Clone(child.children[:mid+1])
+	}
+	parent.keys = slices.Insert(parent.keys, i, sepKey)
+	parent.values = slices.Insert(parent.values, i, sepValue)
+	parent.children = slices.Insert(parent.children, i+1, right.pageID)
+
+	return t.writeNodes(child, right, parent)
+}
+
+// Delete removes the entry of a key and record ID and reports whether it was
+// there. Other records stored with the key are left alone.
+//
+// Like Insert it works on the way down: before it enters a child that holds
+// only degree-1 keys, the child borrows an entry from a sibling or is merged
+// with one, so the leaf can lose an entry without going back up. When the root
+// is left with a single child, that child's content moves into the root page
+// and the tree gets one level shorter. A separator equal to the removed entry
+// is replaced by its successor, the leaf's new first entry, so separators stay
+// entries of the tree.
+func (t *BTree) Delete(key []byte, value RecordID) (bool, error) {
+	if t.db.ReadOnly {
+		return false, ErrReadOnly
+	}
+	held := t.newLatchSet()
+	defer held.release()
+	node, err := held.read(t.Root)
+	if err != nil {
+		return false, err
+	}
+
+	// Internal node and index of the separator equal to the entry, if any
+	var sepNode *btreeNode
+	sepIndex := -1
+	for !node.leaf {
+		i := node.entryIndex(key, value)
+		child, err := held.read(node.children[i])
+		if err != nil {
+			return false, err
+		}
+		if len(child.keys) < t.degree {
+			if child, err = t.fill(node, i, child, held); err != nil {
+				return false, err
+			}
+			if child.pageID == t.Root {
+				// The root shrank, look at it again
+				node = child
+				continue
 			}
+			i = node.entryIndex(key, value)
+		}
+		if i > 0 && compareEntries(node.keys[i-1], node.values[i-1], key, value) == 0 {
+			sepNode, sepIndex = node, i-1
+		}
+		// The child can lose a key, nothing above it changes but the
+		// separator
+		if sepNode != nil {
+			held.releaseExcept(child.pageID, sepNode.pageID)
+		} else {
+			held.releaseExcept(child.pageID)
 		}
+		node = child
+	}
 
-		t.insertNonFull(node.children[i], key, value)
+	i := node.entryIndex(key, value) - 1
+	if i < 0 || compareEntries(node.keys[i], node.values[i], key, value) != 0 {
+		return false, nil
+	}
+	node.keys = slices.Delete(node.keys, i, i+1)
+	node.values = slices.Delete(node.values, i, i+1)
+	if err := t.writeNode(node); err != nil {
+		return false, err
+	}
+
+	if sepNode != nil && i == 0 && len(node.keys) > 0 {
+		sepNode.keys[sepIndex], sepNode.values[sepIndex] = node.keys[0], node.values[0]
+		if err := t.writeNode(sepNode); err != nil {
+			return false, err
+		}
 	}
+	return true, nil
 }
 
-// splitChild splits a full child node
-func (t *BTree) splitChild(parent *BTreeNode, childIndex int) {
-	child := parent.children[childIndex]
-	medianIdx := t.degree - 1
+// fill gives the child at index i of parent, which holds degree-1 keys, at
+// least one more key: it borrows one from a sibling that can spare it, or
+// else is merged with a sibling. It returns the node that now covers the
+// child's keys, which is the root when the merge left parent, the root, empty.
+// The siblings it looks at join the writer's latches.
+func (t *BTree) fill(parent *btreeNode, i int, child *btreeNode, held *latchSet) (*btreeNode, error) {
+	var left, right *btreeNode
+	var err error
+	if i > 0 {
+		if left, err = held.read(parent.children[i-1]); err != nil {
+			return nil, err
+		}
+		if len(left.keys) >= t.degree {
+			return child, t.borrowFromLeft(parent, i, left, child)
+		}
+	}
+	if i < len(parent.keys) {
+		if right, err = held.read(parent.children[i+1]); err != nil {
+			return nil, err
+		}
+		if len(right.keys) >= t.degree {
+			return child, t.borrowFromRight(parent, i, child, right)
+		}
+	}
 
-	// Create new right node
-	newRight := &BTreeNode{
-		isLeaf:   child.isLeaf,
-		keys:     make([]int, 0, t.degree-1),
-		values:   make([]RecordID, 0, t.degree-1),
-		children: make([]*BTreeNode, 0, t.degree),
-		parent:   parent,
+	if right != nil {
+		return t.merge(parent, i, child, right, held)
 	}
+	return t.merge(parent, i-1, left, child, held)
+}
 
-	// Move median key and value to parent
-	medianKey := child.keys[medianIdx]
-	medianValue := child.values[medianIdx]
+// borrowFromLeft moves the last entry of left into child, its right neighbour
+// at index i of parent
+func (t *BTree) borrowFromLeft(parent *btreeNode, i int, left, child *btreeNode) error {
+	last := len(left.keys) - 1
+	if child.leaf {
+		// The moved entry, the predecessor of child's entries, becomes the
+		// separator
+		child.keys = slices.Insert(child.keys, 0, left.keys[last])
+		child.values = slices.Insert(child.values, 0, left.values[last])
+		parent.keys[i-1], parent.values[i-1] = child.keys[0], child.values[0]
+	} else {
+		// The separator comes down and the moved entry goes up in its place
+		child.keys = slices.Insert(child.keys, 0, parent.keys[i-1])
+		child.values = slices.Insert(child.values, 0, parent.values[i-1])
+		child.children = slices.Insert(child.children, 0, left.children[last+1])
+		left.children = left.children[:last+1]
+		parent.keys[i-1], parent.values[i-1] = left.keys[last], left.values[last]
+	}
+	left.keys, left.values = left.keys[:last], left.values[:last]
+	return t.writeNodes(left, child, parent)
+}
+
+// borrowFromRight moves the first entry of right into child, its left
+// neighbour at index i of parent
+func (t *BTree) borrowFromRight(parent *btreeNode, i int, child, right *btreeNode) error {
+	if child.leaf {
+		child.keys = append(child.keys, right.keys[0])
+		child.values = append(child.values, right.values[0])
+		parent.keys[i], parent.values[i] = right.keys[1], right.values[1]
+	} else {
+		child.keys = append(child.keys, parent.keys[i])
+		child.values = append(child.values, parent.values[i])
+		child.children = append(child.children, right.children[0])
+		parent.keys[i], parent.values[i] = right.keys[0], right.values[0]
+		right.children = slices.Delete(right.children, 0, 1)
+	}
+	right.keys = slices.Delete(right.keys, 0, 1)
+	right.values = slices.Delete(right.values, 0, 1)
+	return t.writeNodes(child, right, parent)
+}
 
-	// Copy right half to new node
-	newRight.keys = append(newRight.keys, child.keys[medianIdx+1:]...)
-	newRight.values = append(newRight.values, child.values[medianIdx+1:]...)
+// merge moves right, the child after separator i of parent, into left and
+// frees its page. It returns left, or the root if parent was the root and
+// is left without keys.
+func (t *BTree) merge(parent *btreeNode, i int, left, right *btreeNode, held *latchSet) (*btreeNode, error) {
+	if left.leaf {
+		left.keys = append(left.keys, right.keys...)
+		left.values = append(left.values, right.values...)
+		if err := t.unlinkLeaf(left, right, held); err != nil {
+			return nil, err
+		}
+	} else {
+		left.keys = append(append(left.keys, parent.keys[i]), right.keys...)
+		left.values = append(append(left.values, parent.values[i]), right.values...)
+		left.children = append(left.children, right.children...)
+	}
+	parent.keys = slices.Delete(parent.keys, i, i+1)
+	parent.values = slices.Delete(parent.values, i, i+1)
+	parent.children = slices.Delete(parent.children, i+1, i+2)
+	if err := t.freeNode(right.pageID); err != nil {
+		return nil, err
+	}
 
-	if !child.isLeaf {
-		newRight.children = append(newRight.children, child.children[medianIdx+1:]...)
-		for _, c := range newRight.children {
-			c.parent = newRight
+	if parent.pageID == t.Root && len(parent.keys) == 0 {
+		// The root page stays put and takes the merged node's content
+		if err := t.freeNode(left.pageID); err != nil {
+			return nil, err
 		}
+		left.pageID = t.Root
+		return left, t.writeNode(left)
 	}
+	return left, t.writeNodes(left, parent)
+}
 
-	// Truncate left child
-	child.keys = child.keys[:medianIdx]
-	child.values = child.values[:medianIdx]
-	if !child.isLeaf {
-		child.children = child.children[:medianIdx+1]
+// linkLeaf puts the new leaf right into the leaf list after left
+func (t *BTree) linkLeaf(left, right *btreeNode, held *latchSet) error {
+	right.prev, right.next = left.pageID, left.next
+	left.next = right.pageID
+	if right.next == 0 {
+		return nil
+	}
+	next, err := held.read(right.next)
+	if err != nil {
+		return err
 	}
+	next.prev = right.pageID
+	return t.writeNode(next)
+}
 
-	// Insert median into parent
-	insertIdx := childIndex
-	parent.keys = append(parent.keys, 0)
-	parent.values = append(parent.values, RecordID{})
-	copy(parent.keys[insertIdx+1:], parent.keys[insertIdx:])
-	copy(parent.values[insertIdx+1:], parent.values[insertIdx:])
-	parent.keys[insertIdx] = medianKey
-	parent.values[insertIdx] = medianValue
+// unlinkLeaf takes right, the leaf after left, out of the leaf list
+func (t *BTree) unlinkLeaf(left, right *btreeNode, held *latchSet) error {
+	left.next = right.next
+	if right.next == 0 {
+		return nil
+	}
+	next, err := held.read(right.next)
+	if err != nil {
+		return err
+	}
+	next.prev = left.pageID
+	return t.writeNode(next)
+}
 
-	// Insert new child into parent
-	parent.children = append(parent.children, nil)
-	copy(parent.children[childIndex+2:], parent.children[childIndex+1:])
-	parent.children[childIndex+1] = newRight
+// writeNodes stores several nodes
+func (t *BTree) writeNodes(nodes ...*btreeNode) error {
+	for _, node := range nodes {
+		if err := t.writeNode(node); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Add this debug helper function
+// String prints the tree one node per line, indented by level, for debugging
 func (t *BTree) String() string {
-	if t.root == nil {
-		return "Empty tree"
+	return t.nodeToString(t.Root, 0, "")
+}
+
+func (t *BTree) nodeToString(pageID uint64, level int, prefix string) string {
+	node, err := t.readNode(pageID)
+	if err != nil {
+		return fmt.Sprintf("%sLevel %d: %v\n", prefix, level, err)
+	}
+	if node.leaf {
+		return fmt.Sprintf("%sLevel %d: page %d Keys: %s Values: %v\n", prefix, level, pageID, t.formatKeys(node.keys), node.values)
+	}
+	result := fmt.Sprintf("%sLevel %d: page %d Keys: %s\n", prefix, level, pageID, t.formatKeys(node.keys))
+	for i, child := range node.children {
+		result += fmt.Sprintf("%sChild %d:\n", prefix, i)
+		result += t.nodeToString(child, level+1, prefix+"  ")
 	}
-	return t.nodeToString(t.root, 0, "")
+	return result
 }
 
-func (t *BTree) nodeToString(node *BTreeNode, level int, prefix string) string {
-	result := fmt.Sprintf("%sLevel %d: Keys: %v Values: %v\n", prefix, level, node.keys, node.values)
-	if !node.isLeaf {
-		for i, child := range node.children {
-			result += fmt.Sprintf("%sChild %d:\n", prefix, i)
-			result += t.nodeToString(child, level+1, prefix+"  ")
+// formatKeys prints the keys of a node
+func (t *BTree) formatKeys(keys [][]byte) string {
+	formatted := make([]string, len(keys))
+	for i, key := range keys {
+		formatted[i] = formatKey(t.Columns, key)
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+// Check verifies the B-tree invariants and returns a description of every
+// violation: nodes that do not decode, key counts within the degree's bounds,
+// keys no longer than the key size, entries in order, each once, and within
+// the range their parent allows, child pages that belong to the tree and are
+// reached once, every leaf at the same depth and the leaf list linking the
+// leaves in key order. A valid tree returns nil.
+func (t *BTree) Check() []string {
+	c := &treeCheck{tree: t, leafDepth: -1, seen: make(map[uint64]bool)}
+	c.node(t.Root, nil, nil, 0)
+
+	for i, leaf := range c.leaves {
+		var prev, next uint64
+		if i > 0 {
+			prev = c.leaves[i-1].pageID
+		}
+		if i+1 < len(c.leaves) {
+			next = c.leaves[i+1].pageID
+		}
+		if leaf.prev != prev || leaf.next != next {
+			c.problems = append(c.problems, fmt.Sprintf("page %d: leaf links to previous %d and next %d, expected %d and %d", leaf.pageID, leaf.prev, leaf.next, prev, next))
 		}
 	}
-	return result
+	return c.problems
+}
+
+// treeCheck holds the state of one Check walk
+type treeCheck struct {
+	tree      *BTree
+	leafDepth int
+	seen      map[uint64]bool
+	leaves    []*btreeNode // in key order
+	problems  []string
+}
+
+// treeEntry is a key and the record ID stored with it
+type treeEntry struct {
+	key []byte
+	rid RecordID
+}
+
+// node checks one subtree whose entries must lie at or above low and below
+// high; nil bounds are open
+func (c *treeCheck) node(pageID uint64, low, high *treeEntry, depth int) {
+	t := c.tree
+	if c.seen[pageID] {
+		c.problems = append(c.problems, fmt.Sprintf("depth %d page %d: page is reached twice", depth, pageID))
+		return
+	}
+	c.seen[pageID] = true
+
+	node, err := t.readNode(pageID)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("depth %d page %d: %v", depth, pageID, err))
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		c.problems = append(c.problems, fmt.Sprintf("depth %d page %d keys %s: ", depth, pageID, t.formatKeys(node.keys))+fmt.Sprintf(format, args...))
+	}
+
+	if len(node.keys) > t.maxKeys() {
+		report("more than %d keys", t.maxKeys())
+	}
+	if pageID != t.Root && len(node.keys) < t.degree-1 {
+		report("fewer than %d keys", t.degree-1)
+	}
+	for i, key := range node.keys {
+		rid := node.values[i]
+		if len(key) > t.keySize {
+			report("key %s is longer than %d bytes", formatKey(t.Columns, key), t.keySize)
+		}
+		if i > 0 && compareEntries(key, rid, node.keys[i-1], node.values[i-1]) <= 0 {
+			report("entry %s %v is out of order or repeated", formatKey(t.Columns, key), rid)
+		}
+		if (low != nil && compareEntries(key, rid, low.key, low.rid) < 0) ||
+			(high != nil && compareEntries(key, rid, high.key, high.rid) >= 0) {
+			report("entry %s %v is outside its parent's range", formatKey(t.Columns, key), rid)
+		}
+	}
+
+	if node.leaf {
+		c.leaves = append(c.leaves, node)
+		if c.leafDepth == -1 {
+			c.leafDepth = depth
+		} else if depth != c.leafDepth {
+			report("leaf at depth %d, expected %d", depth, c.leafDepth)
+		}
+		return
+	}
+
+	for i, child := range node.children {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = &treeEntry{node.keys[i-1], node.values[i-1]}
+		}
+		if i < len(node.keys) {
+			childHigh = &treeEntry{node.keys[i], node.values[i]}
+		}
+		c.node(child, childLow, childHigh, depth+1)
+	}
 }
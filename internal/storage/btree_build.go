@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// BuildFromSorted fills an empty tree from entries that arrive in order,
+// without a descent per entry. Leaves are packed one after another and each
+// finished node hands its first entry and page up to the level above, so the
+// internal levels are built bottom-up alongside the leaves. Each level keeps
+// only the node it is filling and the one before it in memory.
+//
+// Every node but the last of its level gets the same number of keys, set by
+// the fill factor. The last one may come up short; it then takes entries from
+// the node before it, or is merged into it when both fit in one node, so all
+// nodes keep the degree's bounds. Whatever node ends up alone at the top is
+// moved into the root page, which never changes.
+
+// DefaultFillFactor is the share of a node's keys BuildFromSorted fills when
+// given a fill factor of 0. The room left lets later inserts land without a
+// split on nearly every call.
+const DefaultFillFactor = 0.9
+
+// EntryIterator returns the entries for BuildFromSorted one at a time. It
+// returns false after the last entry.
+type EntryIterator func() (key []byte, rid RecordID, ok bool, err error)
+
+// BuildFromSorted fills an empty tree with the entries next returns, which
+// must be in ascending order of key and record ID. Nodes get fillFactor of the
+// keys they hold before splitting, but never less than half; 0 means
+// DefaultFillFactor. A unique tree returns a *DuplicateKeyError when two
+// entries share a key. On an error the tree is left empty.
+func (t *BTree) BuildFromSorted(next EntryIterator, fillFactor float64) error {
+	if t.db.ReadOnly {
+		return ErrReadOnly
+	}
+	if fillFactor == 0 {
+		fillFactor = DefaultFillFactor
+	}
+	if fillFactor < 0 || fillFactor > 1 {
+		return fmt.Errorf("index %s: fill factor %v is not between 0 and 1", t.Name, fillFactor)
+	}
+	// Holding the root keeps everyone out until the tree is built
+	held := t.newLatchSet()
+	defer held.release()
+	root, err := held.read(t.Root)
+	if err != nil {
+		return err
+	}
+	if !root.leaf || len(root.keys) > 0 {
+		return fmt.Errorf("index %s: only an empty tree can be built from sorted entries", t.Name)
+	}
+
+	b := &treeBuilder{tree: t, perNode: int(math.Ceil(fillFactor * float64(t.maxKeys())))}
+	b.perNode = min(max(b.perNode, t.degree), t.maxKeys())
+	if err := b.build(next); err != nil {
+		// The root page was not touched yet, give back every other page
+		for _, pageID := range slices.Clone(t.PageIDs) {
+			if pageID != t.Root {
+				err = errors.Join(err, t.freeNode(pageID))
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// treeBuilder holds the state of one BuildFromSorted
+type treeBuilder struct {
+	tree    *BTree
+	perNode int           // keys of every node but the last of a level
+	levels  []*buildLevel // the leaves first
+}
+
+// buildLevel holds the last two nodes of a level, which are not written yet
+type buildLevel struct {
+	prev, cur       *btreeNode
+	prevLow, curLow treeEntry // first entry below each node
+}
+
+// build adds every entry and finishes the tree
+func (b *treeBuilder) build(next EntryIterator) error {
+	t := b.tree
+	var last *treeEntry
+	for {
+		key, rid, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if len(key) > t.keySize {
+			return fmt.Errorf("index %s: key of %d bytes is longer than %d", t.Name, len(key), t.keySize)
+		}
+		if last != nil {
+			if compareEntries(key, rid, last.key, last.rid) <= 0 {
+				return fmt.Errorf("index %s: entry %s %v is not above the entry before it", t.Name, formatKey(t.Columns, key), rid)
+			}
+			if t.Unique && string(key) == string(last.key) && !t.hasNull(key) {
+				return &DuplicateKeyError{Index: t.Name, Key: formatKey(t.Columns, key)}
+			}
+		}
+		last = &treeEntry{slices.Clone(key), rid}
+		if err := b.addEntry(*last); err != nil {
+			return err
+		}
+	}
+	if len(b.levels) == 0 {
+		return nil
+	}
+	return b.finish()
+}
+
+// addEntry appends an entry to the last leaf, starting a new leaf when the
+// last one has its share of keys
+func (b *treeBuilder) addEntry(entry treeEntry) error {
+	if len(b.levels) == 0 {
+		b.levels = append(b.levels, &buildLevel{})
+	}
+	level := b.levels[0]
+	if level.cur == nil || len(level.cur.keys) == b.perNode {
+		if err := b.startNode(0, entry); err != nil {
+			return err
+		}
+	}
+	level.cur.keys = append(level.cur.keys, entry.key)
+	level.cur.values = append(level.cur.values, entry.rid)
+	return nil
+}
+
+// addChild appends a finished node, whose first entry is low, to the last
+// node of the level above it. A new node starts with the child alone, and low
+// becomes the separator in front of it one more level up.
+func (b *treeBuilder) addChild(depth int, low treeEntry, child uint64) error {
+	if depth == len(b.levels) {
+		b.levels = append(b.levels, &buildLevel{})
+	}
+	level := b.levels[depth]
+	if level.cur == nil || len(level.cur.keys) == b.perNode {
+		if err := b.startNode(depth, low); err != nil {
+			return err
+		}
+		level.cur.children = append(level.cur.children, child)
+		return nil
+	}
+	level.cur.keys = append(level.cur.keys, low.key)
+	level.cur.values = append(level.cur.values, low.rid)
+	level.cur.children = append(level.cur.children, child)
+	return nil
+}
+
+// startNode begins a new last node of a level. The node before the previous
+// one can no longer change, so it is written and handed to the level above.
+func (b *treeBuilder) startNode(depth int, low treeEntry) error {
+	level := b.levels[depth]
+	node, err := b.tree.newNode(depth == 0)
+	if err != nil {
+		return err
+	}
+	if level.prev != nil {
+		if err := b.flush(depth, level.prev, level.prevLow); err != nil {
+			return err
+		}
+	}
+	if level.cur != nil && depth == 0 {
+		level.cur.next, node.prev = node.pageID, level.cur.pageID
+	}
+	level.prev, level.prevLow = level.cur, level.curLow
+	level.cur, level.curLow = node, low
+	return nil
+}
+
+// flush writes a finished node and adds it to the level above
+func (b *treeBuilder) flush(depth int, node *btreeNode, low treeEntry) error {
+	if err := b.tree.writeNode(node); err != nil {
+		return err
+	}
+	return b.addChild(depth+1, low, node.pageID)
+}
+
+// finish evens out the last nodes of each level from the leaves up and moves
+// the single node of the top level into the root page
+func (b *treeBuilder) finish() error {
+	t := b.tree
+	for depth := 0; ; depth++ {
+		level := b.levels[depth]
+		if level.prev != nil && len(level.cur.keys) < t.degree-1 {
+			if err := b.rebalance(level); err != nil {
+				return err
+			}
+		}
+
+		if depth == len(b.levels)-1 && (level.prev == nil || level.cur == nil) {
+			top := level.cur
+			if top == nil {
+				top = level.prev
+			}
+			moved := top.pageID
+			top.pageID = t.Root
+			if err := t.writeNode(top); err != nil {
+				return err
+			}
+			return t.freeNode(moved)
+		}
+
+		if err := b.flush(depth, level.prev, level.prevLow); err != nil {
+			return err
+		}
+		if level.cur != nil {
+			if err := b.flush(depth, level.cur, level.curLow); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// rebalance gives the short last node of a level entries from the node
+// before it, which is full to its share, or merges the two when they fit in
+// one node. A merged level has no cur left.
+func (b *treeBuilder) rebalance(level *buildLevel) error {
+	t := b.tree
+	prev, cur := level.prev, level.cur
+
+	keys := slices.Concat(prev.keys, cur.keys)
+	values := slices.Concat(prev.values, cur.values)
+	if !prev.leaf {
+		// The entry below cur separates the two nodes' children
+		keys = slices.Concat(prev.keys, [][]byte{level.curLow.key}, cur.keys)
+		values = slices.Concat(prev.values, []RecordID{level.curLow.rid}, cur.values)
+	}
+
+	if len(keys) <= t.maxKeys() {
+		prev.keys, prev.values = keys, values
+		if prev.leaf {
+			prev.next = 0
+		} else {
+			prev.children = slices.Concat(prev.children, cur.children)
+		}
+		level.cur = nil
+		return t.freeNode(cur.pageID)
+	}
+
+	mid := len(keys) / 2
+	if prev.leaf {
+		prev.keys, cur.keys = keys[:mid], keys[mid:]
+		prev.values, cur.values = values[:mid], values[mid:]
+	} else {
+		children := slices.Concat(prev.children, cur.children)
+		prev.keys, cur.keys = keys[:mid], keys[mid+1:]
+		prev.values, cur.values = values[:mid], values[mid+1:]
+		prev.children, cur.children = children[:mid+1], children[mid+1:]
+	}
+	level.curLow = treeEntry{keys[mid], values[mid]}
+	return nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// A cursor walks the entries of a B-tree in key order. It finds its first
+// leaf by descending from the root and then follows the leaf list, so a scan
+// of k entries reads O(log n + k/degree) pages. Entries with equal keys come
+// in record ID order.
+//
+// A cursor holds a decoded copy of its current leaf. It does not see changes
+// writers make to that leaf afterwards, but when it moves on to another leaf
+// it finds its place again if they did, see btree_latch.go: it returns each
+// entry that stays in the tree throughout once, in order, and may or may not
+// return entries added or removed meanwhile.
+
+// Cursor is a position among the entries of a B-tree. Between the entries it
+// may stand before the first one or after the last one, where it is not Valid.
+type Cursor struct {
+	tree    *BTree
+	leaf    *btreeNode
+	version uint64 // version of the leaf's latch when it was copied
+	pos     int    // index in leaf.keys, -1 before it or len(leaf.keys) after it
+}
+
+// Seek returns a cursor at the first entry whose key is at or above key, or
+// after the last entry if there is none
+func (t *BTree) Seek(key []byte) (*Cursor, error) {
+	return t.seek(key, false)
+}
+
+// seek returns a cursor at the first entry whose key is at or above key, or
+// above it when after is set
+func (t *BTree) seek(key []byte, after bool) (*Cursor, error) {
+	// Leaves hold entries at or above the separator before them and at or
+	// below the one after them
+	bound := func(node *btreeNode) int {
+		if after {
+			return node.childIndex(key)
+		}
+		return node.lowerBound(key)
+	}
+
+	c, err := t.descend(bound)
+	if err != nil {
+		return nil, err
+	}
+	c.pos = bound(c.leaf)
+	if err := c.skipForward(); err != nil {
+		return nil, err
+	}
+	// If the leaf changed before the cursor left it, the cursor lands on the
+	// entry after the leaf's last one, which may still be below key
+	for c.Valid() {
+		if cmp := bytes.Compare(c.Key(), key); cmp > 0 || (cmp == 0 && !after) {
+			break
+		}
+		if err := c.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// seekEntry returns a cursor at the first entry at or above the entry (key,
+// rid), or above it when after is set
+func (t *BTree) seekEntry(key []byte, rid RecordID, after bool) (*Cursor, error) {
+	c, err := t.descend(func(node *btreeNode) int { return node.entryIndex(key, rid) })
+	if err != nil {
+		return nil, err
+	}
+	c.pos = sort.Search(len(c.leaf.keys), func(i int) bool {
+		cmp := compareEntries(c.leaf.keys[i], c.leaf.values[i], key, rid)
+		return cmp > 0 || (cmp == 0 && !after)
+	})
+	return c, nil
+}
+
+// descend returns a cursor on the leaf reached from the root by taking child
+// route(node) of every internal node, holding shared latches hand over hand
+func (t *BTree) descend(route func(node *btreeNode) int) (*Cursor, error) {
+	latch := t.latch(t.Root)
+	latch.RLock()
+	defer func() { latch.RUnlock() }()
+
+	node, err := t.readNode(t.Root)
+	if err != nil {
+		return nil, err
+	}
+	for !node.leaf {
+		child := node.children[route(node)]
+		childLatch := t.latch(child)
+		childLatch.RLock()
+		latch.RUnlock()
+		latch = childLatch
+		if node, err = t.readNode(child); err != nil {
+			return nil, err
+		}
+	}
+	return &Cursor{tree: t, leaf: node, version: latch.version.Load()}, nil
+}
+
+// First returns a cursor at the entry with the smallest key
+func (t *BTree) First() (*Cursor, error) {
+	return t.edge(false)
+}
+
+// Last returns a cursor at the entry with the largest key
+func (t *BTree) Last() (*Cursor, error) {
+	return t.edge(true)
+}
+
+// edge descends to the first or last leaf
+func (t *BTree) edge(last bool) (*Cursor, error) {
+	c, err := t.descend(func(node *btreeNode) int {
+		if last {
+			return len(node.children) - 1
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last {
+		c.pos = len(c.leaf.keys) - 1
+		return c, c.skipBackward()
+	}
+	return c, c.skipForward()
+}
+
+// Valid reports whether the cursor is at an entry
+func (c *Cursor) Valid() bool {
+	return c.pos >= 0 && c.pos < len(c.leaf.keys)
+}
+
+// Key returns the key of the current entry. The cursor must be Valid.
+func (c *Cursor) Key() []byte {
+	return c.leaf.keys[c.pos]
+}
+
+// Value returns the record ID of the current entry. The cursor must be Valid.
+func (c *Cursor) Value() RecordID {
+	return c.leaf.values[c.pos]
+}
+
+// Next moves to the next entry. After the last entry the cursor is no longer
+// Valid; Prev brings it back.
+func (c *Cursor) Next() error {
+	if c.pos < len(c.leaf.keys) {
+		c.pos++
+	}
+	return c.skipForward()
+}
+
+// Prev moves to the previous entry. Before the first entry the cursor is no
+// longer Valid; Next brings it back.
+func (c *Cursor) Prev() error {
+	if c.pos >= 0 {
+		c.pos--
+	}
+	return c.skipBackward()
+}
+
+// skipForward moves past the end of the leaf to the start of the next one
+// with entries, if there is one
+func (c *Cursor) skipForward() error {
+	for c.pos >= len(c.leaf.keys) && c.leaf.next != 0 {
+		next, version, err := c.neighbour(c.leaf.next)
+		if c.changed() {
+			// Find the entry after the leaf's last one from the root
+			last := len(c.leaf.keys) - 1
+			moved, err := c.tree.seekEntry(c.leaf.keys[last], c.leaf.values[last], true)
+			if err != nil {
+				return err
+			}
+			*c = *moved
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.leaf, c.version, c.pos = next, version, 0
+	}
+	return nil
+}
+
+// skipBackward moves before the start of the leaf to the end of the previous
+// one with entries, if there is one
+func (c *Cursor) skipBackward() error {
+	for c.pos < 0 && c.leaf.prev != 0 {
+		prev, version, err := c.neighbour(c.leaf.prev)
+		if c.changed() {
+			// Find the entry before the leaf's first one from the root
+			moved, err := c.tree.seekEntry(c.leaf.keys[0], c.leaf.values[0], false)
+			if err != nil {
+				return err
+			}
+			*c = *moved
+			c.pos--
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.leaf, c.version, c.pos = prev, version, len(prev.keys)-1
+	}
+	return nil
+}
+
+// neighbour reads the leaf before or after the cursor's leaf under a shared
+// latch and returns it with its latch's version
+func (c *Cursor) neighbour(pageID uint64) (*btreeNode, uint64, error) {
+	latch := c.tree.latch(pageID)
+	latch.RLock()
+	defer latch.RUnlock()
+	node, err := c.tree.readNode(pageID)
+	return node, latch.version.Load(), err
+}
+
+// changed reports whether a writer latched the cursor's leaf since it was
+// copied
+func (c *Cursor) changed() bool {
+	return c.tree.latch(c.leaf.pageID).version.Load() != c.version
+}
+
+// KeyRange selects the entries of a B-tree between two keys for Range. A nil
+// bound leaves the range open at that end.
+type KeyRange struct {
+	Lo, Hi      []byte
+	LoInclusive bool // include entries whose key is Lo
+	HiInclusive bool // include entries whose key is Hi
+	Descending  bool // visit entries from Hi down to Lo
+}
+
+// Range calls fn for every entry within r, in ascending key order or in
+// descending order with r.Descending. An error from fn stops the scan and is
+// returned. fn must not change the tree.
+func (t *BTree) Range(r KeyRange, fn func(key []byte, rid RecordID) error) error {
+	inRange := func(key []byte) bool {
+		if r.Lo != nil {
+			if cmp := bytes.Compare(key, r.Lo); cmp < 0 || (cmp == 0 && !r.LoInclusive) {
+				return false
+			}
+		}
+		if r.Hi != nil {
+			if cmp := bytes.Compare(key, r.Hi); cmp > 0 || (cmp == 0 && !r.HiInclusive) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !r.Descending {
+		c, err := t.First()
+		if r.Lo != nil {
+			c, err = t.seek(r.Lo, !r.LoInclusive)
+		}
+		if err != nil {
+			return err
+		}
+		return walk(c, c.Next, inRange, fn)
+	}
+
+	c, err := t.Last()
+	if r.Hi != nil {
+		// Start after the last entry in range and step back onto it, and
+		// past entries above Hi that writers added meanwhile
+		if c, err = t.seek(r.Hi, r.HiInclusive); err == nil {
+			err = c.Prev()
+		}
+		for err == nil && c.Valid() {
+			if cmp := bytes.Compare(c.Key(), r.Hi); cmp < 0 || (cmp == 0 && r.HiInclusive) {
+				break
+			}
+			err = c.Prev()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return walk(c, c.Prev, inRange, fn)
+}
+
+// walk calls fn for the entries from the cursor's on, moving with step, while
+// they are in range
+func walk(c *Cursor, step func() error, inRange func(key []byte) bool, fn func(key []byte, rid RecordID) error) error {
+	for c.Valid() && inRange(c.Key()) {
+		if err := fn(c.Key(), c.Value()); err != nil {
+			return err
+		}
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count returns how many entries the tree holds
+func (t *BTree) count() (int, error) {
+	n := 0
+	err := t.Range(KeyRange{}, func([]byte, RecordID) error {
+		n++
+		return nil
+	})
+	return n, err
+}
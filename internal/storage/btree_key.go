@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// B-tree keys are byte strings compared with bytes.Compare. EncodeKey turns
+// the values of one or more columns into such a string so that comparing the
+// strings orders the rows by the first column, then the second, and so on,
+// each ascending or descending. Each value is a type tag and its data:
+//
+//	NULL    0x00 when NULLs come first, 0xff when they come last, no data
+//	bool    0x10, then 0 or 1
+//	int     0x20, then 8 bytes big-endian with the sign bit flipped
+//	float   0x30, then the 8 IEEE 754 bytes big-endian, with the sign bit
+//	        flipped for positive numbers and every bit flipped for negative
+//	        ones
+//	string  0x40, then the bytes with each 0x00 written as 0x00 0xff, ended
+//	        by 0x00 0x01
+//
+// Values of different types order by their tag. A descending column has the
+// tag and data of its non-NULL values inverted bit by bit; the escaping above
+// keeps a string from comparing past its end, so the inversion reverses the
+// order of strings too. NULL tags are never inverted and land where the
+// column's NullOrder puts them.
+
+const (
+	keyTagNullFirst = 0x00
+	keyTagBool      = 0x10
+	keyTagInt       = 0x20
+	keyTagFloat     = 0x30
+	keyTagString    = 0x40
+	keyTagNullLast  = 0xff
+)
+
+// NullOrder says where a key column puts NULLs
+type NullOrder uint8
+
+const (
+	NullsDefault NullOrder = iota // NULL is the smallest value: first ascending, last descending
+	NullsFirst
+	NullsLast
+)
+
+// KeyColumn is how one column of an index key is ordered
+type KeyColumn struct {
+	Descending bool
+	Nulls      NullOrder
+}
+
+// nullTag returns the tag a NULL of the column is encoded with
+func (c KeyColumn) nullTag() byte {
+	if c.Nulls == NullsLast || (c.Nulls == NullsDefault && c.Descending) {
+		return keyTagNullLast
+	}
+	return keyTagNullFirst
+}
+
+// EncodeKey encodes values as a key ordered by columns. Values beyond the
+// columns given are ordered ascending with NULLs first.
+func EncodeKey(columns []KeyColumn, values ...interface{}) ([]byte, error) {
+	var key []byte
+	for i, value := range values {
+		var column KeyColumn
+		if i < len(columns) {
+			column = columns[i]
+		}
+		if value == nil {
+			key = append(key, column.nullTag())
+			continue
+		}
+
+		start := len(key)
+		switch v := value.(type) {
+		case bool:
+			b := byte(0)
+			if v {
+				b = 1
+			}
+			key = append(key, keyTagBool, b)
+		case int:
+			key = append(key, keyTagInt)
+			key = binary.BigEndian.AppendUint64(key, uint64(v)^1<<63)
+		case float64:
+			bits := math.Float64bits(v)
+			if bits&(1<<63) != 0 {
+				bits = ^bits
+			} else {
+				bits |= 1 << 63
+			}
+			key = append(key, keyTagFloat)
+			key = binary.BigEndian.AppendUint64(key, bits)
+		case string:
+			key = append(key, keyTagString)
+			for i := 0; i < len(v); i++ {
+				key = append(key, v[i])
+				if v[i] == 0 {
+					key = append(key, 0xff)
+				}
+			}
+			key = append(key, 0x00, 0x01)
+		default:
+			return nil, fmt.Errorf("unsupported type for key value: %v", value)
+		}
+		if column.Descending {
+			for i := start; i < len(key); i++ {
+				key[i] = ^key[i]
+			}
+		}
+	}
+	return key, nil
+}
+
+// DecodeKey returns the values EncodeKey encoded with the same columns
+func DecodeKey(columns []KeyColumn, key []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(key) > 0 {
+		var column KeyColumn
+		if i := len(values); i < len(columns) {
+			column = columns[i]
+		}
+		tag := key[0]
+		if tag == keyTagNullFirst || tag == keyTagNullLast {
+			values = append(values, nil)
+			key = key[1:]
+			continue
+		}
+
+		// Undo a descending column's inversion on what is read
+		b := func(i int) byte {
+			if column.Descending {
+				return ^key[i]
+			}
+			return key[i]
+		}
+		fixed := func(n int) ([]byte, error) {
+			if len(key) < 1+n {
+				return nil, errors.New("truncated key")
+			}
+			data := make([]byte, n)
+			for i := range data {
+				data[i] = b(1 + i)
+			}
+			key = key[1+n:]
+			return data, nil
+		}
+
+		switch b(0) {
+		case keyTagBool:
+			data, err := fixed(1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, data[0] == 1)
+		case keyTagInt:
+			data, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, int(binary.BigEndian.Uint64(data)^1<<63))
+		case keyTagFloat:
+			data, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			bits := binary.BigEndian.Uint64(data)
+			if bits&(1<<63) != 0 {
+				bits &^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			values = append(values, math.Float64frombits(bits))
+		case keyTagString:
+			var s []byte
+			i := 1
+			for ; i+1 < len(key); i++ {
+				if b(i) != 0 {
+					s = append(s, b(i))
+					continue
+				}
+				if b(i+1) == 0x01 {
+					break
+				}
+				s = append(s, 0)
+				i++
+			}
+			if i+1 >= len(key) {
+				return nil, errors.New("unterminated string in key")
+			}
+			values = append(values, string(s))
+			key = key[i+2:]
+		default:
+			return nil, fmt.Errorf("unknown key tag %#x", b(0))
+		}
+	}
+	return values, nil
+}
+
+// formatKey prints a key as its decoded value, a parenthesized list of values
+// for a composite key, or in hex if it does not decode with columns
+func formatKey(columns []KeyColumn, key []byte) string {
+	values, err := DecodeKey(columns, key)
+	if err != nil {
+		return fmt.Sprintf("x'%x'", key)
+	}
+	parts := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			parts[i] = "NULL"
+		case string:
+			parts[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
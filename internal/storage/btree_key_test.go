@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestEncodeKey(t *testing.T) {
+	t.Run("Order", func(t *testing.T) {
+		for _, values := range [][]interface{}{
+			{math.MinInt64, -1000, -1, 0, 1, 255, 256, math.MaxInt64},
+			{math.Inf(-1), -1e300, -2.5, -0.5, 0.0, 1e-300, 0.5, 2.5, math.Inf(1)},
+			{"", "\x00", "\x00\x00", "\x00a", "a", "a\x00", "a\x00b", "a\x01", "ab", "b"},
+			{false, true},
+			{nil, false, 0, 0.0, ""},
+		} {
+			for _, column := range []KeyColumn{{}, {Descending: true}} {
+				keys := make([][]byte, len(values))
+				for i, value := range values {
+					key, err := EncodeKey([]KeyColumn{column}, value)
+					if err != nil {
+						t.Fatalf("Failed to encode %v: %v", value, err)
+					}
+					keys[i] = key
+				}
+				for i := 1; i < len(keys); i++ {
+					cmp := bytes.Compare(keys[i-1], keys[i])
+					if column.Descending {
+						cmp = -cmp
+					}
+					if cmp >= 0 {
+						t.Errorf("%+v: expected %q to order before %q", column, values[i-1], values[i])
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Nulls", func(t *testing.T) {
+		for _, tc := range []struct {
+			column    KeyColumn
+			nullFirst bool
+		}{
+			{KeyColumn{}, true},
+			{KeyColumn{Descending: true}, false},
+			{KeyColumn{Nulls: NullsLast}, false},
+			{KeyColumn{Descending: true, Nulls: NullsFirst}, true},
+		} {
+			null, _ := EncodeKey([]KeyColumn{tc.column}, nil)
+			for _, value := range []interface{}{math.MinInt64, math.MaxInt64, "", "\xff\xff", false, true, math.Inf(1)} {
+				key, _ := EncodeKey([]KeyColumn{tc.column}, value)
+				if (bytes.Compare(null, key) < 0) != tc.nullFirst {
+					t.Errorf("%+v: NULL is on the wrong side of %v", tc.column, value)
+				}
+			}
+		}
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		columns := []KeyColumn{{}, {Descending: true}}
+		var rows [][]interface{}
+		for _, name := range []interface{}{"b", "a", "ab", nil, "a\x00"} {
+			for _, n := range []interface{}{3, nil, -7, 12} {
+				rows = append(rows, []interface{}{name, n})
+			}
+		}
+
+		keys := make([][]byte, len(rows))
+		for i, row := range rows {
+			key, err := EncodeKey(columns, row...)
+			if err != nil {
+				t.Fatalf("Failed to encode %v: %v", row, err)
+			}
+			keys[i] = key
+			decoded, err := DecodeKey(columns, key)
+			if err != nil || !reflect.DeepEqual(decoded, row) {
+				t.Errorf("Decoding %v returned %v %v", row, decoded, err)
+			}
+		}
+
+		// Names ascend with NULL first, then numbers descend with NULL last
+		order := func(v interface{}) string {
+			if v == nil {
+				return ""
+			}
+			return "~" + v.(string)
+		}
+		want := slices.Clone(rows)
+		slices.SortStableFunc(want, func(a, b []interface{}) int {
+			if cmp := strings.Compare(order(a[0]), order(b[0])); cmp != 0 {
+				return cmp
+			}
+			switch {
+			case a[1] == nil && b[1] == nil:
+				return 0
+			case a[1] == nil:
+				return 1
+			case b[1] == nil:
+				return -1
+			}
+			return b[1].(int) - a[1].(int)
+		})
+		slices.SortFunc(keys, bytes.Compare)
+		for i, key := range keys {
+			if got, _ := DecodeKey(columns, key); !reflect.DeepEqual(got, want[i]) {
+				t.Fatalf("Position %d: got %v, want %v", i, got, want[i])
+			}
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		values := []interface{}{nil, true, -42, 3.25, "it's\x00", math.MinInt64}
+		for _, desc := range []bool{false, true} {
+			columns := make([]KeyColumn, len(values))
+			for i := range columns {
+				columns[i].Descending = desc
+			}
+			key, err := EncodeKey(columns, values...)
+			if err != nil {
+				t.Fatalf("Failed to encode: %v", err)
+			}
+			got, err := DecodeKey(columns, key)
+			if err != nil || !reflect.DeepEqual(got, values) {
+				t.Errorf("Descending %v: decoded %v %v", desc, got, err)
+			}
+			if s := formatKey(columns, key); s != "(NULL, true, -42, 3.25, 'it''s\x00', -9223372036854775808)" {
+				t.Errorf("Unexpected formatted key %q", s)
+			}
+		}
+
+		if _, err := EncodeKey(nil, []byte("raw")); err == nil {
+			t.Error("Expected an error encoding an unsupported type")
+		}
+		if _, err := DecodeKey(nil, []byte{keyTagInt, 1, 2}); err == nil {
+			t.Error("Expected an error decoding a truncated int")
+		}
+		if _, err := DecodeKey(nil, []byte{keyTagString, 'a', 'b'}); err == nil {
+			t.Error("Expected an error decoding an unterminated string")
+		}
+		if s := formatKey(nil, []byte{0x99}); s != "x'99'" {
+			t.Errorf("Expected an undecodable key in hex, got %s", s)
+		}
+	})
+
+	t.Run("Tree", func(t *testing.T) {
+		fs := vfs.NewMemFS()
+		db, err := NewDatabaseWithOptions("test.db", Options{FS: fs})
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		columns := []KeyColumn{{}, {Descending: true, Nulls: NullsFirst}}
+		btree, err := db.CreateBTreeWithOptions("by_name_score", BTreeOptions{Degree: 3, KeySize: 32, Columns: columns})
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		if _, err := db.CreateBTreeWithOptions("huge", BTreeOptions{Degree: 200, KeySize: 64}); err == nil {
+			t.Error("Expected an error for nodes that do not fit in a page")
+		}
+
+		for i := 0; i < 100; i++ {
+			var score interface{} = float64(i%10) / 2
+			if i%10 == 0 {
+				score = nil
+			}
+			key, err := btree.Key(fmt.Sprintf("name%02d", i/10), score)
+			if err != nil {
+				t.Fatalf("Failed to encode key: %v", err)
+			}
+			if err := btree.Insert(key, RecordID{uint64(i), 0}); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		long, _ := btree.Key(strings.Repeat("x", 40))
+		if err := btree.Insert(long, RecordID{}); err == nil {
+			t.Error("Expected an error for a key longer than the key size")
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+
+		db, err = NewDatabaseWithOptions("test.db", Options{FS: fs})
+		if err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+		defer db.Close()
+		btree = db.Indexes["by_name_score"]
+		if btree == nil || !reflect.DeepEqual(btree.Columns, columns) {
+			t.Fatalf("Index columns were not persisted: %+v", btree)
+		}
+
+		lo, _ := btree.Key("name03")
+		hi, _ := btree.Key("name04")
+		var got []uint64
+		err = btree.Range(KeyRange{Lo: lo, Hi: hi}, func(key []byte, rid RecordID) error {
+			got = append(got, rid.PageID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Range failed: %v", err)
+		}
+		// NULL scores first, then the rest highest first
+		want := []uint64{30, 39, 38, 37, 36, 35, 34, 33, 32, 31}
+		if !slices.Equal(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+		if problems := btree.Check(); problems != nil {
+			t.Errorf("Expected a valid tree, got %v", problems)
+		}
+	})
+}
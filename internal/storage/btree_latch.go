@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// Many goroutines may search, scan, insert and delete in a tree at once.
+// Each node has a latch, a reader-writer lock kept by the tree apart from the
+// page cache, and operations take them by latch crabbing: a descent latches
+// the child before it lets go of the parent, so it never sees a node halfway
+// through a change made above it.
+//
+// Readers hold shared latches, at most two at a time. Writers hold exclusive
+// ones. Because Insert splits full nodes and Delete fills thin ones on the
+// way down, the child a writer moves into can absorb whatever happens below
+// it, and the writer lets go of every node above the child. So the root is
+// held only for the first step, and writers in different subtrees proceed in
+// parallel. A writer may also latch the neighbours of a node it splits,
+// merges or borrows from; leaves outside its subtree it latches only to the
+// right, and readers only move down, so latches are always taken in one
+// order and never deadlock.
+//
+// A cursor holds no latch between calls, only a copy of its leaf. Stepping to
+// the next or previous leaf, it compares its leaf's version with the one it
+// copied: every exclusive latch moves the version on, so if it is unchanged
+// no writer has touched the leaf or moved entries across its edge, and the
+// neighbour it read is the right one. Otherwise the cursor descends again to
+// the entry after the last one it returned, or before the first.
+//
+// A unique tree also serializes inserts of equal keys, so that checking for
+// the key and adding it is one step. Keys hash to one of a fixed set of
+// locks, so inserts of different keys rarely wait for each other.
+
+// nodeLatch guards one node of a tree
+type nodeLatch struct {
+	sync.RWMutex
+	version atomic.Uint64 // moved on when a writer takes and releases the latch
+}
+
+// lock takes the latch exclusively
+func (l *nodeLatch) lock() {
+	l.Lock()
+	l.version.Add(1)
+}
+
+// unlock releases an exclusive latch
+func (l *nodeLatch) unlock() {
+	l.version.Add(1)
+	l.Unlock()
+}
+
+// latch returns the latch of a node, creating it on first use. Latches stay
+// with their page ID for the life of the tree, so a latch is never replaced
+// while someone holds or waits for it.
+func (t *BTree) latch(pageID uint64) *nodeLatch {
+	if l, ok := t.latches.Load(pageID); ok {
+		return l.(*nodeLatch)
+	}
+	l, _ := t.latches.LoadOrStore(pageID, &nodeLatch{})
+	return l.(*nodeLatch)
+}
+
+// latchSet is the exclusive latches a writer holds
+type latchSet struct {
+	tree    *BTree
+	latched map[uint64]*nodeLatch
+}
+
+func (t *BTree) newLatchSet() *latchSet {
+	return &latchSet{tree: t, latched: make(map[uint64]*nodeLatch)}
+}
+
+// read latches a node exclusively, unless the set holds it already, and
+// reads it
+func (s *latchSet) read(pageID uint64) (*btreeNode, error) {
+	s.lock(pageID)
+	return s.tree.readNode(pageID)
+}
+
+// lock latches a node exclusively, unless the set holds it already
+func (s *latchSet) lock(pageID uint64) {
+	if _, held := s.latched[pageID]; held {
+		return
+	}
+	l := s.tree.latch(pageID)
+	l.lock()
+	s.latched[pageID] = l
+}
+
+// releaseExcept releases every latch but those of the given nodes
+func (s *latchSet) releaseExcept(keep ...uint64) {
+	for pageID, l := range s.latched {
+		kept := false
+		for _, id := range keep {
+			kept = kept || id == pageID
+		}
+		if !kept {
+			l.unlock()
+			delete(s.latched, pageID)
+		}
+	}
+}
+
+// release releases every latch of the set
+func (s *latchSet) release() {
+	s.releaseExcept()
+}
+
+// keyLockCount is how many locks a unique tree spreads its keys over
+const keyLockCount = 64
+
+var keyLockSeed = maphash.MakeSeed()
+
+// keyLock returns the lock that inserts of key into a unique tree hold
+func (t *BTree) keyLock(key []byte) *sync.Mutex {
+	return &t.keyLocks[maphash.Bytes(keyLockSeed, key)%keyLockCount]
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"godb/internal/wal"
+)
+
+// A B-tree node fills one page. The page header has type PageTypeBTree and
+// names the index as its owner; the node follows it:
+//
+//	offset 32  1 byte   node kind, 1 leaf or 2 internal
+//	offset 33  3 bytes  reserved
+//	offset 36  4 bytes  key count
+//	offset 40  8 bytes  next leaf, 0 for none or in an internal node
+//	offset 48  8 bytes  previous leaf, 0 for none or in an internal node
+//	offset 56           entries
+//
+// The leaves form a doubly linked list in key order, so a scan moves from
+// leaf to leaf without going back up the tree. Page 0 is the header page and
+// never a node, so it stands for no leaf.
+//
+// Keys are byte strings, see btree_key.go, stored as a 2 byte length and the
+// bytes. A leaf entry is a key and the record ID it points to, an 8 byte page
+// ID and a 2 byte slot. Entries are ordered by key and then by record ID, so
+// equal keys are kept apart by their record IDs. An internal node starts with
+// the page of its first child, followed by one separator and 8 byte child page
+// per key. A separator is a key and record ID like a leaf entry; the child
+// after it holds the entries at or above it. Entries are packed back to back.
+//
+// Nodes split and merge by key count, not by bytes. A tree's degree is chosen
+// so that a full node of its longest keys fits in a page.
+
+const (
+	nodeOffsetKind  = PageHeaderSize     // leaf or internal
+	nodeOffsetCount = PageHeaderSize + 4 // number of keys
+	nodeOffsetNext  = PageHeaderSize + 8 // next leaf
+	nodeOffsetPrev  = PageHeaderSize + 16
+	nodeHeaderSize  = PageHeaderSize + 24
+
+	leafEntrySize     = 12 // key length, page ID and slot, without the key
+	internalEntrySize = 20 // key length, page ID, slot and child page, without the key
+
+	nodeKindLeaf     = 1
+	nodeKindInternal = 2
+)
+
+// btreeNode is a decoded node page
+type btreeNode struct {
+	pageID   uint64
+	leaf     bool
+	keys     [][]byte
+	values   []RecordID // one per key, the separators' in an internal node
+	children []uint64   // internal only, one more than keys
+	next     uint64     // leaf only, the next leaf in key order or 0
+	prev     uint64     // leaf only, the previous leaf in key order or 0
+}
+
+// maxNodeKeys is how many keys of keySize bytes fit in both a leaf and an
+// internal node
+func maxNodeKeys(pageSize uint16, keySize int) int {
+	leaf := (int(pageSize) - nodeHeaderSize) / (leafEntrySize + keySize)
+	internal := (int(pageSize) - nodeHeaderSize - 8) / (internalEntrySize + keySize)
+	return min(leaf, internal)
+}
+
+// size returns the bytes the node takes in its page
+func (n *btreeNode) size() int {
+	size := nodeHeaderSize + len(n.keys)*leafEntrySize
+	if !n.leaf {
+		size = nodeHeaderSize + 8 + len(n.keys)*internalEntrySize
+	}
+	for _, key := range n.keys {
+		size += len(key)
+	}
+	return size
+}
+
+// encode writes the node over data, a page owned by the index treeID. The
+// page LSN is left alone for logPageChange to stamp.
+func (n *btreeNode) encode(data []byte, treeID uint64) {
+	clear(data[:OffsetPageLSN])
+	clear(data[nodeOffsetKind:])
+	data[OffsetPageType] = byte(PageTypeBTree)
+	binary.LittleEndian.PutUint64(data[OffsetTableID:], treeID)
+
+	data[nodeOffsetKind] = nodeKindInternal
+	if n.leaf {
+		data[nodeOffsetKind] = nodeKindLeaf
+	}
+	binary.LittleEndian.PutUint32(data[nodeOffsetCount:], uint32(len(n.keys)))
+
+	offset := nodeHeaderSize
+	if n.leaf {
+		binary.LittleEndian.PutUint64(data[nodeOffsetNext:], n.next)
+		binary.LittleEndian.PutUint64(data[nodeOffsetPrev:], n.prev)
+		for i, key := range n.keys {
+			offset = putNodeEntry(data, offset, key, n.values[i])
+		}
+		return
+	}
+	if len(n.children) == 0 {
+		// A new internal node before its children are set
+		return
+	}
+	binary.LittleEndian.PutUint64(data[offset:], n.children[0])
+	offset += 8
+	for i, key := range n.keys {
+		offset = putNodeEntry(data, offset, key, n.values[i])
+		binary.LittleEndian.PutUint64(data[offset:], n.children[i+1])
+		offset += 8
+	}
+}
+
+// putNodeEntry writes a key, its length and a record ID at offset and returns
+// the offset after them
+func putNodeEntry(data []byte, offset int, key []byte, rid RecordID) int {
+	binary.LittleEndian.PutUint16(data[offset:], uint16(len(key)))
+	offset += 2 + copy(data[offset+2:], key)
+	binary.LittleEndian.PutUint64(data[offset:], rid.PageID)
+	binary.LittleEndian.PutUint16(data[offset+8:], rid.SlotNum)
+	return offset + 10
+}
+
+// nodeEntry reads an entry written by putNodeEntry and returns the offset
+// after it. It returns -1 if the entry runs past the end of data or leaves
+// fewer than rest bytes after it.
+func nodeEntry(data []byte, offset, rest int) ([]byte, RecordID, int) {
+	if offset+2 > len(data) {
+		return nil, RecordID{}, -1
+	}
+	end := offset + 2 + int(binary.LittleEndian.Uint16(data[offset:]))
+	if end+10+rest > len(data) {
+		return nil, RecordID{}, -1
+	}
+	rid := RecordID{
+		PageID:  binary.LittleEndian.Uint64(data[end:]),
+		SlotNum: binary.LittleEndian.Uint16(data[end+8:]),
+	}
+	return append([]byte(nil), data[offset+2:end]...), rid, end + 10
+}
+
+// decodeNode reads the node stored in a page
+func decodeNode(pageID uint64, data []byte) (*btreeNode, error) {
+	if PageType(data[OffsetPageType]) != PageTypeBTree {
+		return nil, fmt.Errorf("page %d is a %s page, not a B-tree node", pageID, PageType(data[OffsetPageType]))
+	}
+	node := &btreeNode{pageID: pageID}
+	switch data[nodeOffsetKind] {
+	case nodeKindLeaf:
+		node.leaf = true
+	case nodeKindInternal:
+	default:
+		return nil, fmt.Errorf("page %d has unknown node kind %d", pageID, data[nodeOffsetKind])
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[nodeOffsetCount:]))
+	if count > maxNodeKeys(uint16(len(data)), 0) {
+		return nil, fmt.Errorf("page %d: %d keys do not fit in a node", pageID, count)
+	}
+	node.keys = make([][]byte, count)
+	node.values = make([]RecordID, count)
+	overflow := func() error {
+		return fmt.Errorf("page %d: node entries run past the end of the page", pageID)
+	}
+
+	offset := nodeHeaderSize
+	if node.leaf {
+		node.next = binary.LittleEndian.Uint64(data[nodeOffsetNext:])
+		node.prev = binary.LittleEndian.Uint64(data[nodeOffsetPrev:])
+		for i := range node.keys {
+			if node.keys[i], node.values[i], offset = nodeEntry(data, offset, 0); offset < 0 {
+				return nil, overflow()
+			}
+		}
+		return node, nil
+	}
+	node.children = make([]uint64, count+1)
+	node.children[0] = binary.LittleEndian.Uint64(data[offset:])
+	offset += 8
+	for i := range node.keys {
+		if node.keys[i], node.values[i], offset = nodeEntry(data, offset, 8); offset < 0 {
+			return nil, overflow()
+		}
+		node.children[i+1] = binary.LittleEndian.Uint64(data[offset:])
+		offset += 8
+	}
+	return node, nil
+}
+
+// readNode fetches and decodes a node of the tree
+func (t *BTree) readNode(pageID uint64) (*btreeNode, error) {
+	page, err := t.db.GetPage(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", t.Name, err)
+	}
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+	if owner := binary.LittleEndian.Uint64(page.Data[OffsetTableID:]); owner != t.ID {
+		return nil, fmt.Errorf("index %s: page %d belongs to owner %d", t.Name, pageID, owner)
+	}
+	return decodeNode(pageID, page.Data)
+}
+
+// writeNode stores a node in its page and logs the change
+func (t *BTree) writeNode(node *btreeNode) error {
+	page, err := t.db.GetPage(node.pageID)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", t.Name, err)
+	}
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	before := append([]byte(nil), page.Data...)
+	node.encode(page.Data, t.ID)
+	page.IsDirty = true
+	return t.db.logPageChange(page, before, wal.LogTypeUpdate)
+}
+
+// newNode allocates a page for an empty node and adds it to the tree's pages
+func (t *BTree) newNode(leaf bool) (*btreeNode, error) {
+	page, err := t.db.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	node := &btreeNode{pageID: page.ID, leaf: leaf}
+	if !leaf {
+		node.children = []uint64{}
+	}
+	node.encode(page.Data, t.ID)
+	page.IsDirty = true
+	if err := t.db.logPageChange(page, nil, wal.LogTypeInsert); err != nil {
+		return nil, err
+	}
+
+	t.pagesMu.Lock()
+	t.PageIDs = append(t.PageIDs, page.ID)
+	t.pagesMu.Unlock()
+	if err := t.db.cachePage(page); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// freeNode returns the page of a node that is no longer part of the tree to
+// the free list
+func (t *BTree) freeNode(pageID uint64) error {
+	if err := t.db.freePage(pageID, nil); err != nil {
+		return err
+	}
+	t.pagesMu.Lock()
+	defer t.pagesMu.Unlock()
+	t.PageIDs = slices.DeleteFunc(t.PageIDs, func(id uint64) bool { return id == pageID })
+	return nil
+}
+
+// pages returns a copy of the tree's page list
+func (t *BTree) pages() []uint64 {
+	t.pagesMu.Lock()
+	defer t.pagesMu.Unlock()
+	return slices.Clone(t.PageIDs)
+}
+
+// decodePage reports whether a page of the tree holds a node that decodes
+func (t *BTree) decodePage(pageID uint64, data []byte) error {
+	_, err := decodeNode(pageID, data)
+	return err
+}
+
+// freePages frees every node of a dropped tree
+func (t *BTree) freePages() error {
+	for _, pageID := range t.pages() {
+		if err := t.freeNode(pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
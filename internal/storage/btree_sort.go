@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"godb/internal/vfs"
+)
+
+// An entrySorter puts index entries in the order BuildFromSorted needs. It
+// collects entries in memory; once they take more than its budget, it sorts
+// them and writes them out as a run, a temporary file next to the database
+// on the database's file system. When all entries are in, the runs and the
+// entries still in memory are merged, reading each run from start to end.
+//
+// A run holds its entries back to back in their page form: a 2 byte key
+// length, the key, an 8 byte page ID and a 2 byte slot. Runs are named after
+// the database and the index being built, <database>.sort<index>.<n>, so two
+// builds never share a file. Runs left by a crash are removed the next time
+// the database is opened for writing.
+
+// indexSortMemory is how many bytes of entries an index build sorts in memory
+// before it writes a run
+const indexSortMemory = 16 << 20
+
+// entrySorter collects entries and returns them in order
+type entrySorter struct {
+	db      *Database
+	indexID uint64 // index the entries are for, part of the run names
+	memory  int    // bytes of entries kept in memory before a run is written
+	size    int    // bytes of entries in memory
+	entries []treeEntry
+	runs    []*sortRun
+}
+
+// sortRun is a sorted run written to a temporary file
+type sortRun struct {
+	path   string
+	file   vfs.File
+	reader *bufio.Reader
+	head   treeEntry // entry read last, the run's smallest left while merging
+}
+
+// newEntrySorter returns a sorter for the entries of an index that keeps up
+// to memory bytes of entries in memory. It must be closed to remove its runs.
+func (db *Database) newEntrySorter(indexID uint64, memory int) *entrySorter {
+	return &entrySorter{db: db, indexID: indexID, memory: memory}
+}
+
+// sortRunPattern matches the names of every sort run of the database
+func (db *Database) sortRunPattern() string {
+	return escapeGlob(db.Path) + ".sort*"
+}
+
+// escapeGlob quotes the characters a glob pattern gives a meaning
+func escapeGlob(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// removeSortRuns removes runs left behind by index builds that never
+// finished. The caller holds the database's exclusive lock, so no build is
+// running.
+func (db *Database) removeSortRuns() error {
+	paths, err := db.FS.Glob(db.sortRunPattern())
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := db.FS.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing sort run %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// add collects one entry; the sorter keeps its own copy of the key
+func (s *entrySorter) add(key []byte, rid RecordID) error {
+	s.entries = append(s.entries, treeEntry{slices.Clone(key), rid})
+	s.size += leafEntrySize + len(key)
+	if s.size > s.memory {
+		return s.writeRun()
+	}
+	return nil
+}
+
+// sortEntries sorts the entries in memory
+func (s *entrySorter) sortEntries() {
+	slices.SortFunc(s.entries, func(a, b treeEntry) int {
+		return compareEntries(a.key, a.rid, b.key, b.rid)
+	})
+}
+
+// writeRun sorts the entries in memory and moves them to a new run
+func (s *entrySorter) writeRun() error {
+	s.sortEntries()
+	path := fmt.Sprintf("%s.sort%d.%d", s.db.Path, s.indexID, len(s.runs)+1)
+	file, err := s.db.FS.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	run := &sortRun{path: path, file: file}
+	s.runs = append(s.runs, run)
+
+	w := bufio.NewWriter(io.NewOffsetWriter(file, 0))
+	buf := make([]byte, leafEntrySize+math.MaxUint16)
+	var written int64
+	for _, entry := range s.entries {
+		n := putNodeEntry(buf, 0, entry.key, entry.rid)
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		written += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	run.reader = bufio.NewReader(io.NewSectionReader(file, 0, written))
+	s.entries, s.size = s.entries[:0], 0
+	return nil
+}
+
+// next reads the run's next entry into head, and returns false at its end
+func (r *sortRun) next() (bool, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r.reader, length[:]); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("sort run %s: %w", r.path, err)
+	}
+	entry := make([]byte, int(binary.LittleEndian.Uint16(length[:]))+10)
+	if _, err := io.ReadFull(r.reader, entry); err != nil {
+		return false, fmt.Errorf("sort run %s: %w", r.path, err)
+	}
+	key := entry[:len(entry)-10]
+	rid := entry[len(key):]
+	r.head = treeEntry{key, RecordID{PageID: binary.LittleEndian.Uint64(rid), SlotNum: binary.LittleEndian.Uint16(rid[8:])}}
+	return true, nil
+}
+
+// sorted returns an iterator over every entry added, in ascending order. No
+// entries may be added after it.
+func (s *entrySorter) sorted() (EntryIterator, error) {
+	s.sortEntries()
+	memory := s.entries
+	if len(s.runs) == 0 {
+		return func() ([]byte, RecordID, bool, error) {
+			if len(memory) == 0 {
+				return nil, RecordID{}, false, nil
+			}
+			entry := memory[0]
+			memory = memory[1:]
+			return entry.key, entry.rid, true, nil
+		}, nil
+	}
+
+	// Merge the runs, and the entries in memory as one more source, by
+	// keeping the source with the smallest next entry on top of a heap
+	merge := &runHeap{memory: memory}
+	for _, run := range s.runs {
+		if ok, err := run.next(); err != nil {
+			return nil, err
+		} else if ok {
+			merge.runs = append(merge.runs, run)
+		}
+	}
+	heap.Init(merge)
+	return func() ([]byte, RecordID, bool, error) {
+		if len(merge.runs) == 0 && len(merge.memory) == 0 {
+			return nil, RecordID{}, false, nil
+		}
+		if len(merge.runs) == 0 || (len(merge.memory) > 0 && merge.memoryFirst()) {
+			entry := merge.memory[0]
+			merge.memory = merge.memory[1:]
+			return entry.key, entry.rid, true, nil
+		}
+		run := merge.runs[0]
+		entry := run.head
+		ok, err := run.next()
+		if err != nil {
+			return nil, RecordID{}, false, err
+		}
+		if ok {
+			heap.Fix(merge, 0)
+		} else {
+			heap.Pop(merge)
+		}
+		return entry.key, entry.rid, true, nil
+	}, nil
+}
+
+// close removes the sorter's runs
+func (s *entrySorter) close() error {
+	var errs []error
+	for _, run := range s.runs {
+		errs = append(errs, run.file.Close(), s.db.FS.Remove(run.path))
+	}
+	s.runs, s.entries = nil, nil
+	return errors.Join(errs...)
+}
+
+// runHeap orders the runs being merged by their heads, for container/heap.
+// The entries left in memory are compared with the smallest head apart from
+// the heap.
+type runHeap struct {
+	runs   []*sortRun
+	memory []treeEntry
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.runs[i].head, h.runs[j].head
+	return compareEntries(a.key, a.rid, b.key, b.rid) < 0
+}
+
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runHeap) Push(x any) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *runHeap) Pop() any {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
+
+// memoryFirst reports whether the next entry in memory comes before the
+// smallest run head
+func (h *runHeap) memoryFirst() bool {
+	a, b := h.memory[0], h.runs[0].head
+	return compareEntries(a.key, a.rid, b.key, b.rid) < 0
+}
@@ -1,39 +1,68 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
 	"testing"
+
+	"godb/internal/vfs"
 )
 
+// intKey is the key of a single integer
+func intKey(i int) []byte {
+	key, _ := EncodeKey(nil, i)
+	return key
+}
+
+// keyInt decodes a key made by intKey
+func keyInt(key []byte) int {
+	values, _ := DecodeKey(nil, key)
+	return values[0].(int)
+}
+
 func TestBTree(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
 	t.Run("Node Splitting", func(t *testing.T) {
-		btree := NewBTree(3)
-		if btree == nil {
-			t.Fatal("Failed to create B-tree")
+		btree, err := db.CreateBTree("splitting", 3)
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
 		}
 
 		// Insert keys in ascending order to force splits
 		for i := 1; i <= 10; i++ {
 			t.Logf("Inserting key %d", i)
-			btree.Insert(i, RecordID{uint64(i), 1})
+			if err := btree.Insert(intKey(i), RecordID{uint64(i), 1}); err != nil {
+				t.Fatalf("Failed to insert key %d: %v", i, err)
+			}
 			t.Logf("Tree after insertion:\n%s", btree.String())
 
 			// Verify all previously inserted keys are still findable
 			for j := 1; j <= i; j++ {
-				_, found := btree.Search(j)
-				if !found {
-					t.Errorf("Key %d not found after inserting key %d\nTree state:\n%s",
-						j, i, btree.String())
-				} else {
-					t.Logf("Successfully found key %d", j)
+				_, found, err := btree.Search(intKey(j))
+				if err != nil || !found {
+					t.Errorf("Key %d not found after inserting key %d: %v\nTree state:\n%s",
+						j, i, err, btree.String())
 				}
 			}
 		}
+		if len(btree.PageIDs) < 3 {
+			t.Errorf("Expected the splits to use at least 3 pages, got %v", btree.PageIDs)
+		}
 	})
 
 	t.Run("Basic Insert and Search", func(t *testing.T) {
-		btree := NewBTree(3) // Degree 3
-		if btree == nil {
-			t.Fatal("Failed to create B-tree")
+		btree, err := db.CreateBTree("basic", 3) // Degree 3
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
 		}
 
 		// Insert some test records
@@ -45,26 +74,759 @@ func TestBTree(t *testing.T) {
 			{3, RecordID{1, 2}},
 			{7, RecordID{1, 3}},
 			{1, RecordID{1, 4}},
-			{9, RecordID{1, 5}},
+			{-9, RecordID{1, 5}},
 		}
 
 		// Insert records
 		for _, r := range records {
-			btree.Insert(r.key, r.rid)
+			if err := btree.Insert(intKey(r.key), r.rid); err != nil {
+				t.Fatalf("Failed to insert key %d: %v", r.key, err)
+			}
 			// Verify immediate insertion
-			rid, found := btree.Search(r.key)
-			if !found {
-				t.Errorf("Key %d not found immediately after insertion", r.key)
+			rid, found, err := btree.Search(intKey(r.key))
+			if err != nil || !found {
+				t.Errorf("Key %d not found immediately after insertion: %v", r.key, err)
 			}
-			if rid != nil && *rid != r.rid {
+			if found && rid != r.rid {
 				t.Errorf("For key %d, got RecordID %v, want %v", r.key, rid, r.rid)
 			}
 		}
 
 		// Search for non-existent key
-		_, found := btree.Search(100)
-		if found {
+		if _, found, _ := btree.Search(intKey(100)); found {
 			t.Error("Found non-existent key 100")
 		}
+		if _, err := db.CreateBTree("basic", 3); err == nil {
+			t.Error("Expected a second index with the same name to fail")
+		}
+		if _, err := db.CreateBTree("huge", 1000); err == nil {
+			t.Error("Expected a degree whose nodes do not fit in a page to fail")
+		}
+	})
+
+	t.Run("Check Invariants", func(t *testing.T) {
+		btree, err := db.CreateBTree("invariants", 2)
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := btree.Insert(intKey((i*37)%200), RecordID{uint64(i), 1}); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		if problems := btree.Check(); problems != nil {
+			t.Fatalf("Expected a valid tree, got %v", problems)
+		}
+
+		// Move a key of the leftmost child above its separator in the root
+		root, err := btree.readNode(btree.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := btree.readNode(root.children[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := child.keys[0]
+		child.keys[0] = append(slices.Clone(root.keys[0]), 0)
+		if err := btree.writeNode(child); err != nil {
+			t.Fatal(err)
+		}
+		if problems := btree.Check(); len(problems) == 0 {
+			t.Error("Expected Check to report a key outside its range")
+		}
+		report, err := db.Check()
+		if err != nil || report.OK() {
+			t.Errorf("Expected Database.Check to report the index, got %v %+v", err, report)
+		}
+
+		child.keys[0] = saved
+		if err := btree.writeNode(child); err != nil {
+			t.Fatal(err)
+		}
+		if report, err := db.Check(); err != nil || !report.OK() {
+			t.Errorf("Expected a clean report after undoing the damage, got %v %+v", err, report)
+		}
+	})
+}
+
+func TestBTreeDelete(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	for _, degree := range []int{2, 3, 8} {
+		btree, err := db.CreateBTree(fmt.Sprintf("random_%d", degree), degree)
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		rng := rand.New(rand.NewSource(int64(degree)))
+		want := make(map[int]RecordID)
+
+		for op := 0; op < 5000; op++ {
+			key := rng.Intn(500)
+			if _, exists := want[key]; exists || rng.Intn(3) == 0 {
+				found, err := btree.Delete(intKey(key), want[key])
+				if err != nil {
+					t.Fatalf("Degree %d: failed to delete %d: %v", degree, key, err)
+				}
+				if _, exists := want[key]; found != exists {
+					t.Fatalf("Degree %d: Delete(%d) reported %v, expected %v", degree, key, found, exists)
+				}
+				delete(want, key)
+			} else {
+				rid := RecordID{uint64(op), uint16(key)}
+				if err := btree.Insert(intKey(key), rid); err != nil {
+					t.Fatalf("Degree %d: failed to insert %d: %v", degree, key, err)
+				}
+				want[key] = rid
+			}
+
+			if op%250 == 0 {
+				if problems := btree.Check(); problems != nil {
+					t.Fatalf("Degree %d after %d operations: %v\n%s", degree, op, problems, btree.String())
+				}
+			}
+		}
+
+		for key := 0; key < 500; key++ {
+			rid, found, err := btree.Search(intKey(key))
+			if expected, exists := want[key]; err != nil || found != exists || (found && rid != expected) {
+				t.Fatalf("Degree %d: Search(%d) = %v %v %v, expected %v %v", degree, key, rid, found, err, expected, exists)
+			}
+		}
+
+		// Emptying the tree shrinks it back to the root page
+		for key := range want {
+			if found, err := btree.Delete(intKey(key), want[key]); err != nil || !found {
+				t.Fatalf("Degree %d: failed to delete %d: %v %v", degree, key, found, err)
+			}
+		}
+		if problems := btree.Check(); problems != nil {
+			t.Fatalf("Degree %d: expected a valid empty tree, got %v", degree, problems)
+		}
+		if len(btree.PageIDs) != 1 || btree.PageIDs[0] != btree.Root {
+			t.Errorf("Degree %d: expected only the root page to be left, got %v", degree, btree.PageIDs)
+		}
+	}
+
+	report, err := db.Check()
+	if err != nil || !report.OK() {
+		t.Errorf("Expected a clean report with the freed pages, got %v %+v", err, report)
+	}
+}
+
+func TestBTreeDuplicates(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	btree, err := db.CreateBTree("status", 3)
+	if err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+	// 2000 records share four keys, inserted in random order
+	const n = 2000
+	status := func(i int) []byte {
+		key, _ := btree.Key([]string{"active", "closed", "new", "pending"}[i%4])
+		return key
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, i := range rng.Perm(n) {
+		if err := btree.Insert(status(i), RecordID{uint64(i / 10), uint16(i % 10)}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if err := btree.Insert(status(7), RecordID{0, 7}); err == nil {
+		t.Error("Expected an error inserting the same key and record twice")
+	}
+
+	// Every fourth record goes, leaving the others with its key alone
+	for i := 0; i < n; i += 4 {
+		found, err := btree.Delete(status(i), RecordID{uint64(i / 10), uint16(i % 10)})
+		if err != nil || !found {
+			t.Fatalf("Failed to delete record %d: %v %v", i, found, err)
+		}
+	}
+	if found, err := btree.Delete(status(0), RecordID{0, 0}); err != nil || found {
+		t.Errorf("Expected a deleted entry to be gone, got %v %v", found, err)
+	}
+	if found, err := btree.Delete(status(1), RecordID{0, 0}); err != nil || found {
+		t.Errorf("Expected no entry for a record stored under another key, got %v %v", found, err)
+	}
+	if problems := btree.Check(); problems != nil {
+		t.Fatalf("Expected a valid tree, got %v", problems)
+	}
+
+	for k := 0; k < 4; k++ {
+		rids, err := btree.SearchAll(status(k))
+		if err != nil {
+			t.Fatalf("SearchAll failed: %v", err)
+		}
+		var want []RecordID
+		for i := k; i < n; i += 4 {
+			if k != 0 {
+				want = append(want, RecordID{uint64(i / 10), uint16(i % 10)})
+			}
+		}
+		if !slices.Equal(rids, want) {
+			t.Errorf("Key %d: expected %d record IDs in order, got %d: %v", k, len(want), len(rids), rids)
+		}
+		rid, found, err := btree.Search(status(k))
+		if err != nil || found != (k != 0) || (found && rid != want[0]) {
+			t.Errorf("Key %d: Search returned %v %v %v", k, rid, found, err)
+		}
+	}
+	if rids, err := btree.SearchAll(intKey(1)); err != nil || rids != nil {
+		t.Errorf("Expected no record IDs for a missing key, got %v %v", rids, err)
+	}
+}
+
+func TestBTreeRestart(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	btree, err := db.CreateBTree("ids", 0)
+	if err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+	root := btree.Root
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if err := btree.Insert(intKey((i*7919)%n), RecordID{uint64(i), uint16(i % 100)}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if btree.Root != root {
+		t.Errorf("Root moved from page %d to %d", root, btree.Root)
+	}
+
+	verify := func(t *testing.T, db *Database) {
+		t.Helper()
+		btree := db.Indexes["ids"]
+		if btree == nil {
+			t.Fatal("Index is missing after reopening")
+		}
+		if btree.Root != root || btree.Degree() != (maxNodeKeys(db.PageSize, DefaultKeySize)+1)/2 {
+			t.Errorf("Unexpected root %d and degree %d", btree.Root, btree.Degree())
+		}
+		for i := 0; i < n; i++ {
+			rid, found, err := btree.Search(intKey((i * 7919) % n))
+			if err != nil || !found || rid != (RecordID{uint64(i), uint16(i % 100)}) {
+				t.Fatalf("Key %d: got %v %v %v", (i*7919)%n, rid, found, err)
+			}
+		}
+		if problems := btree.Check(); problems != nil {
+			t.Errorf("Expected a valid tree, got %v", problems)
+		}
+		report, err := db.Check()
+		if err != nil || !report.OK() || report.Indexes != 1 {
+			t.Errorf("Expected a clean report with one index, got %v %+v", err, report)
+		}
+	}
+
+	t.Run("Crash", func(t *testing.T) {
+		// Drop the handles without a checkpoint, the log rebuilds the nodes
+		db.wal.Close()
+		db.File.Close()
+		db, err = NewDatabaseWithOptions("test.db", opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen database: %v", err)
+		}
+		verify(t, db)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		if err := db.Close(); err != nil {
+			t.Fatalf("Failed to close database: %v", err)
+		}
+		db, err = NewDatabaseWithOptions("test.db", opts)
+		if err != nil {
+			t.Fatalf("Failed to reopen database: %v", err)
+		}
+		defer db.Close()
+		verify(t, db)
+
+		pages, err := db.InspectTable("ids")
+		if err != nil || len(pages) != len(db.Indexes["ids"].PageIDs) {
+			t.Fatalf("Expected a summary of every node, got %v %v", pages, err)
+		}
+		info, err := db.InspectPage(root)
+		if err != nil || info.Node == nil || info.Node.Leaf || info.Table != "ids" {
+			t.Errorf("Expected the root to inspect as an internal node of ids, got %+v %v", info, err)
+		}
+	})
+}
+
+func TestBTreeCursor(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	btree, err := db.CreateBTree("even", 3)
+	if err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+	// Even keys from 0 to 998, then 100 to 198 deleted again
+	rng := rand.New(rand.NewSource(1))
+	for _, i := range rng.Perm(500) {
+		if err := btree.Insert(intKey(2*i), RecordID{uint64(i), 1}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	var keys []int
+	for key := 0; key < 1000; key += 2 {
+		if key >= 100 && key < 200 {
+			if _, err := btree.Delete(intKey(key), RecordID{uint64(key / 2), 1}); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if problems := btree.Check(); problems != nil {
+		t.Fatalf("Expected a valid tree, got %v", problems)
+	}
+
+	t.Run("Walk", func(t *testing.T) {
+		var forward, backward []int
+		c, err := btree.First()
+		for ; err == nil && c.Valid(); err = c.Next() {
+			forward = append(forward, keyInt(c.Key()))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c, err = btree.Last(); err == nil && c.Valid(); err = c.Prev() {
+			backward = append([]int{keyInt(c.Key())}, backward...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(forward) != fmt.Sprint(keys) || fmt.Sprint(backward) != fmt.Sprint(keys) {
+			t.Errorf("Expected %v both ways, got %v and %v", keys, forward, backward)
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		for _, tc := range []struct{ seek, want int }{{-5, 0}, {0, 0}, {51, 52}, {100, 200}, {150, 200}, {998, 998}} {
+			c, err := btree.Seek(intKey(tc.seek))
+			if err != nil || !c.Valid() || keyInt(c.Key()) != tc.want || c.Value().PageID != uint64(tc.want/2) {
+				t.Errorf("Seek(%d): expected %d, got %+v %v", tc.seek, tc.want, c, err)
+			}
+		}
+
+		c, err := btree.Seek(intKey(999))
+		if err != nil || c.Valid() {
+			t.Fatalf("Expected Seek past the last key to be invalid, got %v", err)
+		}
+		if err := c.Prev(); err != nil || !c.Valid() || keyInt(c.Key()) != 998 {
+			t.Errorf("Expected Prev to step back onto 998, got %v", err)
+		}
+		c, _ = btree.Seek(intKey(200))
+		c.Prev()
+		if !c.Valid() || keyInt(c.Key()) != 98 {
+			t.Errorf("Expected Prev from 200 to reach 98 across the gap")
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		// Bounds of nil are open
+		bound := func(key *int) []byte {
+			if key == nil {
+				return nil
+			}
+			return intKey(*key)
+		}
+		n := func(i int) *int { return &i }
+		for _, r := range []struct {
+			lo, hi               *int
+			loIncl, hiIncl, desc bool
+		}{
+			{n(10), n(20), true, true, false},
+			{n(10), n(20), false, false, false},
+			{n(11), n(19), true, true, false},
+			{n(90), n(210), false, true, false},
+			{n(10), n(20), true, true, true},
+			{n(10), n(20), false, false, true},
+			{n(90), n(210), true, false, true},
+			{n(-100), n(2000), false, false, true},
+			{n(20), n(10), true, true, false},
+			{n(120), n(180), true, true, false},
+			{nil, n(10), false, true, false},
+			{n(990), nil, false, false, false},
+			{nil, n(10), false, false, true},
+			{n(990), nil, true, false, true},
+			{nil, nil, false, false, true},
+		} {
+			var want []int
+			for _, key := range keys {
+				if (r.lo == nil || key > *r.lo || (key == *r.lo && r.loIncl)) &&
+					(r.hi == nil || key < *r.hi || (key == *r.hi && r.hiIncl)) {
+					want = append(want, key)
+				}
+			}
+			if r.desc {
+				slices.Reverse(want)
+			}
+
+			var got []int
+			kr := KeyRange{Lo: bound(r.lo), Hi: bound(r.hi), LoInclusive: r.loIncl, HiInclusive: r.hiIncl, Descending: r.desc}
+			err := btree.Range(kr, func(key []byte, rid RecordID) error {
+				got = append(got, keyInt(key))
+				return nil
+			})
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("Range(%+v): expected %v, got %v %v", r, want, got, err)
+			}
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err := btree.Range(KeyRange{Lo: intKey(0), Hi: intKey(1000)}, func(key []byte, rid RecordID) error {
+			if calls++; calls == 3 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || calls != 3 {
+			t.Errorf("Expected the scan to stop after 3 entries with the callback's error, got %d %v", calls, err)
+		}
+	})
+
+	t.Run("Equal Keys", func(t *testing.T) {
+		dups, err := db.CreateBTree("dups", 2)
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := dups.Insert(intKey(i%2), RecordID{uint64(i), 1}); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		var got []uint64
+		dups.Range(KeyRange{Lo: intKey(1), Hi: intKey(1), LoInclusive: true, HiInclusive: true}, func(key []byte, rid RecordID) error {
+			got = append(got, rid.PageID)
+			return nil
+		})
+		if fmt.Sprint(got) != "[1 3 5 7 9 11 13 15 17 19]" {
+			t.Errorf("Expected every entry of key 1 in record ID order, got %v", got)
+		}
+	})
+}
+
+// sliceEntries iterates over entries held in a slice
+func sliceEntries(entries []treeEntry) EntryIterator {
+	return func() ([]byte, RecordID, bool, error) {
+		if len(entries) == 0 {
+			return nil, RecordID{}, false, nil
+		}
+		entry := entries[0]
+		entries = entries[1:]
+		return entry.key, entry.rid, true, nil
+	}
+}
+
+// treeNodes returns the nodes reachable from the root, level by level
+func treeNodes(t *testing.T, btree *BTree) [][]*btreeNode {
+	t.Helper()
+	var levels [][]*btreeNode
+	pages := []uint64{btree.Root}
+	for len(pages) > 0 {
+		var level []*btreeNode
+		var children []uint64
+		for _, pageID := range pages {
+			node, err := btree.readNode(pageID)
+			if err != nil {
+				t.Fatalf("Failed to read node: %v", err)
+			}
+			level = append(level, node)
+			children = append(children, node.children...)
+		}
+		levels = append(levels, level)
+		pages = children
+	}
+	return levels
+}
+
+func TestBuildFromSorted(t *testing.T) {
+	for _, degree := range []int{2, 3, 24} {
+		for _, fill := range []float64{0, 0.5, 1} {
+			for _, n := range []int{0, 1, 2, 3, 4, 5, 6, 7, 10, 23, 50, 500} {
+				name := fmt.Sprintf("degree %d fill %v n %d", degree, fill, n)
+				db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+				if err != nil {
+					t.Fatalf("Failed to create database: %v", err)
+				}
+				btree, err := db.CreateBTree("built", degree)
+				if err != nil {
+					t.Fatalf("Failed to create B-tree: %v", err)
+				}
+				// Pairs of entries share a key
+				entries := make([]treeEntry, n)
+				for i := range entries {
+					entries[i] = treeEntry{intKey(i / 2), RecordID{uint64(i), 1}}
+				}
+				if err := btree.BuildFromSorted(sliceEntries(entries), fill); err != nil {
+					t.Fatalf("%s: BuildFromSorted failed: %v", name, err)
+				}
+				if problems := btree.Check(); problems != nil {
+					t.Fatalf("%s: expected a valid tree, got %v\n%s", name, problems, btree)
+				}
+
+				var got []treeEntry
+				btree.Range(KeyRange{}, func(key []byte, rid RecordID) error {
+					got = append(got, treeEntry{key, rid})
+					return nil
+				})
+				if fmt.Sprint(got) != fmt.Sprint(entries) {
+					t.Errorf("%s: expected every entry in order, got %d of %d", name, len(got), n)
+				}
+
+				levels := treeNodes(t, btree)
+				pages := 0
+				for _, level := range levels {
+					pages += len(level)
+				}
+				if pages != len(btree.PageIDs) {
+					t.Errorf("%s: the tree reaches %d of its %d pages", name, pages, len(btree.PageIDs))
+				}
+				// All leaves but the last two hold the fill factor's share
+				perNode := btree.maxKeys()
+				if fill == 0.5 {
+					perNode = max((btree.maxKeys()+1)/2, degree)
+				} else if fill == 0 {
+					perNode = max(int(math.Ceil(DefaultFillFactor*float64(btree.maxKeys()))), degree)
+				}
+				leaves := levels[len(levels)-1]
+				for i, leaf := range leaves[:max(len(leaves)-2, 0)] {
+					if len(leaf.keys) != perNode {
+						t.Errorf("%s: leaf %d holds %d keys, expected %d", name, i, len(leaf.keys), perNode)
+					}
+				}
+
+				// The tree goes on to take inserts and deletes
+				for i := 0; i < n; i += 3 {
+					if found, err := btree.Delete(entries[i].key, entries[i].rid); err != nil || !found {
+						t.Fatalf("%s: failed to delete entry %d: %v %v", name, i, found, err)
+					}
+					if err := btree.Insert(intKey(n+i), RecordID{uint64(i), 2}); err != nil {
+						t.Fatalf("%s: failed to insert: %v", name, err)
+					}
+				}
+				if problems := btree.Check(); problems != nil {
+					t.Errorf("%s: expected a valid tree after changes, got %v", name, problems)
+				}
+				db.Close()
+			}
+		}
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		defer db.Close()
+		btree, err := db.CreateBTreeWithOptions("unique", BTreeOptions{Degree: 2, Unique: true})
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		ordered := func(n int) []treeEntry {
+			entries := make([]treeEntry, n)
+			for i := range entries {
+				entries[i] = treeEntry{intKey(i), RecordID{uint64(i), 1}}
+			}
+			return entries
+		}
+
+		outOfOrder := ordered(100)
+		outOfOrder[60], outOfOrder[61] = outOfOrder[61], outOfOrder[60]
+		if err := btree.BuildFromSorted(sliceEntries(outOfOrder), 1); err == nil {
+			t.Error("Expected entries out of order to fail")
+		}
+		duplicate := ordered(100)
+		duplicate[70].key = duplicate[69].key
+		if err := btree.BuildFromSorted(sliceEntries(duplicate), 1); !errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("Expected a duplicate key, got %v", err)
+		}
+		if err := btree.BuildFromSorted(sliceEntries(ordered(10)), 1.5); err == nil {
+			t.Error("Expected a fill factor above 1 to fail")
+		}
+		failing := errors.New("read failed")
+		calls := 0
+		err = btree.BuildFromSorted(func() ([]byte, RecordID, bool, error) {
+			if calls++; calls == 50 {
+				return nil, RecordID{}, false, failing
+			}
+			return intKey(calls), RecordID{1, 1}, true, nil
+		}, 1)
+		if !errors.Is(err, failing) {
+			t.Errorf("Expected the iterator's error, got %v", err)
+		}
+		if len(btree.PageIDs) != 1 {
+			t.Errorf("Expected failed builds to free their pages, got %v", btree.PageIDs)
+		}
+
+		// NULLs never collide
+		null, _ := btree.Key(nil)
+		nulls := []treeEntry{{null, RecordID{1, 1}}, {null, RecordID{1, 2}}}
+		if err := btree.BuildFromSorted(sliceEntries(append(nulls, ordered(10)...)), 1); err != nil {
+			t.Fatalf("Expected NULL keys to be allowed, got %v", err)
+		}
+		if err := btree.BuildFromSorted(sliceEntries(ordered(10)), 1); err == nil {
+			t.Error("Expected a tree that is not empty to fail")
+		}
+	})
+}
+
+func TestBTreeConcurrent(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	btree, err := db.CreateBTree("shared", 4)
+	if err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+
+	// Every tenth key stays in the tree throughout; the writers share out
+	// the others and insert, delete and insert them again while readers
+	// look up and scan the stable ones
+	const n, writers, readers = 2000, 8, 4
+	rid := func(key int) RecordID { return RecordID{uint64(key), uint16(key % 7)} }
+	stable := 0
+	for key := 0; key < n; key += 10 {
+		if err := btree.Insert(intKey(key), rid(key)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		stable++
+	}
+
+	var writing, reading sync.WaitGroup
+	done := make(chan struct{})
+	final := make([]map[int]bool, writers)
+	for g := 0; g < writers; g++ {
+		writing.Add(1)
+		go func() {
+			defer writing.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			var keys []int
+			for key := g; key < n; key += writers {
+				if key%10 != 0 {
+					keys = append(keys, key)
+				}
+			}
+			present := make(map[int]bool)
+			for round := 0; round < 3; round++ {
+				for _, i := range rng.Perm(len(keys)) {
+					key := keys[i]
+					if present[key] {
+						if found, err := btree.Delete(intKey(key), rid(key)); err != nil || !found {
+							t.Errorf("Failed to delete key %d: %v %v", key, found, err)
+							return
+						}
+					} else if err := btree.Insert(intKey(key), rid(key)); err != nil {
+						t.Errorf("Failed to insert key %d: %v", key, err)
+						return
+					}
+					present[key] = !present[key]
+				}
+				// Keep about half of the keys for the next round
+				for _, key := range keys[:len(keys)/2] {
+					if !present[key] {
+						if err := btree.Insert(intKey(key), rid(key)); err != nil {
+							t.Errorf("Failed to insert key %d: %v", key, err)
+							return
+						}
+						present[key] = true
+					}
+				}
+			}
+			final[g] = present
+		}()
+	}
+
+	for r := 0; r < readers; r++ {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			rng := rand.New(rand.NewSource(int64(100 + r)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := rng.Intn(n/10) * 10
+				if got, found, err := btree.Search(intKey(key)); err != nil || !found || got != rid(key) {
+					t.Errorf("Search(%d) returned %v %v %v", key, got, found, err)
+					return
+				}
+
+				// Scan a window of keys
+				lo, hi := key, min(key+300, n)
+				seen, last := 0, lo-1
+				kr := KeyRange{Lo: intKey(lo), Hi: intKey(hi), LoInclusive: true, Descending: r%2 == 1}
+				if kr.Descending {
+					last = hi
+				}
+				err := btree.Range(kr, func(key []byte, _ RecordID) error {
+					k := keyInt(key)
+					if (!kr.Descending && k <= last) || (kr.Descending && k >= last) {
+						return fmt.Errorf("key %d after %d", k, last)
+					}
+					last = k
+					if k%10 == 0 {
+						seen++
+					}
+					return nil
+				})
+				if err != nil || seen != (hi-lo+9)/10 {
+					t.Errorf("Scan of keys %d to %d saw %d stable keys: %v", lo, hi, seen, err)
+					return
+				}
+			}
+		}()
+	}
+
+	writing.Wait()
+	close(done)
+	reading.Wait()
+
+	if problems := btree.Check(); problems != nil {
+		t.Fatalf("Expected a valid tree, got %v", problems)
+	}
+	var want []int
+	for key := 0; key < n; key++ {
+		if key%10 == 0 || final[key%writers][key] {
+			want = append(want, key)
+		}
+	}
+	var got []int
+	btree.Range(KeyRange{}, func(key []byte, _ RecordID) error {
+		got = append(got, keyInt(key))
+		return nil
 	})
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %d keys after the writers finished, got %d", len(want), len(got))
+	}
+	pages := 0
+	for _, level := range treeNodes(t, btree) {
+		pages += len(level)
+	}
+	if pages != len(btree.PageIDs) {
+		t.Errorf("The tree reaches %d of its %d pages", pages, len(btree.PageIDs))
+	}
 }
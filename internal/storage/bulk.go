@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// BulkLoader appends many records to one table. It keeps filling the page it
+// is on and starts a new page when that one is full, instead of searching the
+// table for space before every record like InsertRecord does.
+//
+// The table's indexes are filled at the end rather than a row at a time:
+// Insert collects each row's keys in a sorter, like an index build does, and
+// Finish loads them in order. An index that was empty is built bottom-up with
+// BuildFromSorted, any other gets the sorted keys inserted one after another.
+// Finish is also where a key a unique index already holds, or that two loaded
+// rows share, is found; such rows are taken back out of the table. Until
+// Finish the loaded rows are in the table but not in its indexes. The load is
+// logged as record units, see record_log.go, so a crash before Finish is done
+// takes its rows back out. Like other inserts a load must not run
+// concurrently with other changes to the table.
+type BulkLoader struct {
+	rm      *RecordManager
+	table   *Table
+	page    *Page          // page being filled, nil before the first insert into an empty table
+	empty   bool           // the table had no pages, so its indexes hold no entries
+	sorters []*entrySorter // keys of the loaded rows, one sorter per index
+	rids    []RecordID     // every row loaded, taken back out should Finish fail
+	units   []*recordUnit  // a load unit for every page rows were appended to
+	err     error          // a failure that leaves the sorters behind the table
+	loaded  int
+	done    bool
+}
+
+// BulkRejected is a loaded row that Finish took back out of the table
+type BulkRejected struct {
+	RID RecordID
+	Err error
+}
+
+// NewBulkLoader starts a bulk load into table, continuing on its last page.
+// Finish must be called to fill the indexes and remove temporary files.
+func (rm *RecordManager) NewBulkLoader(table *Table) (*BulkLoader, error) {
+	if rm.db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := rm.db.checkDuplicateKeys(table); err != nil {
+		return nil, err
+	}
+
+	loader := &BulkLoader{rm: rm, table: table}
+	if pages := table.pages(); len(pages) > 0 {
+		page, err := rm.db.GetPage(pages[len(pages)-1])
+		if err != nil {
+			return nil, err
+		}
+		loader.page = page
+	} else {
+		loader.empty = true
+	}
+	for _, index := range table.Indexes {
+		loader.sorters = append(loader.sorters, rm.db.newEntrySorter(index.Info().ID, indexSortMemory))
+	}
+	return loader, nil
+}
+
+// Insert appends one record and keeps its keys for Finish. A record that
+// cannot have a key in every index is refused here; one whose key a unique
+// index refuses is only found by Finish.
+func (l *BulkLoader) Insert(record *Record) (*RecordID, error) {
+	if l.done {
+		return nil, errors.New("bulk load is finished")
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	if err := l.table.checkPrimaryKey(record); err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(l.table.Indexes))
+	for i, index := range l.table.Indexes {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return nil, err
+		}
+		if limit := index.keyLimit(); len(key) > limit {
+			return nil, fmt.Errorf("index %s: key of %d bytes is longer than %d", index.Info().Name, len(key), limit)
+		}
+		keys[i] = key
+	}
+	data, err := SerializeRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if limit := int(l.rm.db.PageSize) - PageHeaderSize - SlotEntrySize; len(data) > limit {
+		return nil, fmt.Errorf("record of %d bytes does not fit in a page, the limit is %d", len(data), limit)
+	}
+
+	rid, err := l.append(data)
+	if err != nil {
+		return nil, err
+	}
+	l.rids = append(l.rids, rid)
+	for i, key := range keys {
+		if err := l.sorters[i].add(key, rid); err != nil {
+			// Some sorters have the row and some not, only Finish can undo it
+			l.err = err
+			return nil, err
+		}
+	}
+	l.loaded++
+	return &rid, nil
+}
+
+// append writes a serialized record to the page being filled, or to a new
+// one when it is full
+func (l *BulkLoader) append(data []byte) (RecordID, error) {
+	if l.page != nil {
+		slotNum, err := l.rm.insertIntoPage(l.page, data, l.begin)
+		if err == nil {
+			return RecordID{PageID: l.page.ID, SlotNum: slotNum}, nil
+		}
+		if !errors.Is(err, errPageFull) {
+			return RecordID{}, err
+		}
+	}
+
+	page, err := l.rm.newTablePage(l.table)
+	if err != nil {
+		return RecordID{}, err
+	}
+	l.page = page
+	slotNum, err := l.rm.insertIntoPage(page, data, l.begin)
+	if err != nil {
+		return RecordID{}, err
+	}
+	return RecordID{PageID: page.ID, SlotNum: slotNum}, nil
+}
+
+// begin opens a load unit the first time a row is appended to a page
+func (l *BulkLoader) begin(rid RecordID) error {
+	if len(l.rids) > 0 && l.rids[len(l.rids)-1].PageID == rid.PageID {
+		return nil
+	}
+	unit, err := l.rm.db.beginLoadUnit(l.table, rid)
+	// A unit whose begin may have reached the log is closed with the others
+	l.units = append(l.units, unit)
+	return err
+}
+
+// Finish adds the keys of the loaded rows to the table's indexes and returns
+// the rows it took back out since a unique index refused their key. Of loaded
+// rows sharing a key the one with the lowest record ID stays. Any other
+// failure takes every loaded row back out, leaving the table as it was. No
+// records may be inserted after it.
+func (l *BulkLoader) Finish() (rejected []BulkRejected, err error) {
+	if l.done {
+		return nil, errors.New("bulk load is finished")
+	}
+	l.done = true
+	defer func() {
+		for _, sorter := range l.sorters {
+			err = errors.Join(err, sorter.close())
+		}
+	}()
+	if l.err != nil {
+		return nil, errors.Join(l.err, l.abort(0))
+	}
+
+	// refused holds the position of the index that refused each rejected
+	// row; the indexes before it have its entries
+	refused := make(map[RecordID]int)
+	for i, index := range l.table.Indexes {
+		if err := l.fillIndex(i, index, refused, &rejected); err != nil {
+			return nil, errors.Join(err, l.abort(i+1))
+		}
+	}
+	for _, r := range rejected {
+		record, err := l.rm.deleteFromPage(l.table, &r.RID)
+		if err == nil {
+			err = l.rm.deleteIndexEntries(l.table.Indexes[:refused[r.RID]], record, r.RID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.loaded -= len(rejected)
+
+	// Recovery takes back a load whose commits are lost, so they are synced
+	for _, unit := range l.units {
+		if err := unit.commit(); err != nil {
+			return nil, err
+		}
+	}
+	if l.rm.db.wal != nil && len(l.units) > 0 {
+		return rejected, l.rm.db.wal.Sync()
+	}
+	return rejected, nil
+}
+
+// fillIndex adds the sorted keys of the loaded rows to the i-th index of the
+// table. It skips the rows an index before refused, and refuses those whose
+// key the index is unique on and holds already.
+func (l *BulkLoader) fillIndex(i int, index Index, refused map[RecordID]int, rejected *[]BulkRejected) error {
+	info := index.Info()
+	sorted, err := l.sorters[i].sorted()
+	if err != nil {
+		return err
+	}
+	reject := func(key []byte, rid RecordID, err error) {
+		refused[rid] = i
+		*rejected = append(*rejected, BulkRejected{RID: rid, Err: err})
+	}
+
+	// next returns the entries to add. Equal keys are next to each other,
+	// so a unique index refuses every one after the first here.
+	var last []byte
+	next := func() ([]byte, RecordID, bool, error) {
+		for {
+			key, rid, ok, err := sorted()
+			if !ok || err != nil {
+				return key, rid, ok, err
+			}
+			if _, ok := refused[rid]; ok {
+				continue
+			}
+			if info.Unique && last != nil && bytes.Equal(key, last) && !info.hasNull(key) {
+				reject(key, rid, &DuplicateKeyError{Index: info.Name, Key: formatKey(info.Columns, key)})
+				continue
+			}
+			last = key
+			return key, rid, true, nil
+		}
+	}
+
+	if tree, ok := index.(*BTree); ok && l.empty {
+		return tree.BuildFromSorted(next, DefaultFillFactor)
+	}
+	for {
+		key, rid, ok, err := next()
+		if err != nil || !ok {
+			return err
+		}
+		if err := index.Insert(key, rid); errors.Is(err, ErrDuplicateKey) {
+			reject(key, rid, err)
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// abort takes every loaded row back out of the table and out of the first
+// filled indexes, which hold the entries of some of them
+func (l *BulkLoader) abort(filled int) error {
+	var errs []error
+	for _, rid := range l.rids {
+		record, err := l.rm.deleteFromPage(l.table, &rid)
+		if err == nil {
+			err = l.rm.deleteIndexEntries(l.table.Indexes[:filled], record, rid)
+		}
+		errs = append(errs, err)
+	}
+	l.loaded = 0
+	if err := errors.Join(errs...); err != nil {
+		// The units stay open, so the rows are taken back out on the next open
+		return err
+	}
+	for _, unit := range l.units {
+		if err := unit.undo(func() error { return nil }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loaded returns how many records have been inserted, less those Finish took
+// back out
+func (l *BulkLoader) Loaded() int {
+	return l.loaded
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestBulkLoader(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTable("users", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{0, "User 0"}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	loader, err := db.RecordManager.NewBulkLoader(users)
+	if err != nil {
+		t.Fatalf("Failed to start bulk load: %v", err)
+	}
+	for i := 1; i < 3000; i++ {
+		if _, err := loader.Insert(&Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}}); err != nil {
+			t.Fatalf("Failed to load record %d: %v", i, err)
+		}
+	}
+	if _, err := loader.Insert(&Record{Values: []interface{}{-1, strings.Repeat("x", 5000)}}); err == nil {
+		t.Error("Expected a record larger than a page to be refused")
+	}
+	if rejected, err := loader.Finish(); err != nil || rejected != nil {
+		t.Fatalf("Failed to finish: %v %v", rejected, err)
+	}
+	if loader.Loaded() != 2999 {
+		t.Errorf("Expected 2999 loaded records, got %d", loader.Loaded())
+	}
+	if _, err := loader.Insert(&Record{Values: []interface{}{3000, "late"}}); err == nil {
+		t.Error("Expected an insert after Finish to be refused")
+	}
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+	key, _ := users.PrimaryIndex().Key(2500)
+	if rids, err := users.PrimaryIndex().SearchAll(key); err != nil || len(rids) != 1 {
+		t.Errorf("Expected a loaded row in the primary key index, got %v %v", rids, err)
+	}
+
+	// Rows come back in load order and every page but the last is full
+	next := 0
+	err = db.RecordManager.Scan(users, func(rid RecordID, record *Record) error {
+		if record.Values[0] != next {
+			t.Fatalf("Expected record %d, got %v", next, record.Values)
+		}
+		next++
+		return nil
+	})
+	if err != nil || next != 3000 {
+		t.Fatalf("Expected 3000 records, got %d (%v)", next, err)
+	}
+	pages, err := db.InspectTable("users")
+	if err != nil {
+		t.Fatalf("Failed to inspect table: %v", err)
+	}
+	for _, page := range pages[:len(pages)-1] {
+		if page.FreeBytes > 64 {
+			t.Errorf("Page %d was left with %d free bytes", page.ID, page.FreeBytes)
+		}
+	}
+}
+
+func TestBulkLoaderDuplicates(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTable("codes", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "code", DataType: TypeVarchar, Length: 8},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	codes := db.Tables["codes"]
+	byCode, err := db.CreateIndex("by_code", "codes", IndexOptions{Columns: []int{1}, Unique: true, Hash: true})
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+
+	// Into an empty table the primary key index is built from the sorted
+	// keys, the hash index takes them one at a time. Rows 90 to 99 repeat
+	// the codes of rows 0 to 9 and the last row repeats id 5.
+	loader, err := db.RecordManager.NewBulkLoader(codes)
+	if err != nil {
+		t.Fatalf("Failed to start bulk load: %v", err)
+	}
+	var rids []RecordID
+	for i := 0; i < 100; i++ {
+		rid, err := loader.Insert(&Record{Values: []interface{}{i, fmt.Sprintf("c%d", i%90)}})
+		if err != nil {
+			t.Fatalf("Failed to load record %d: %v", i, err)
+		}
+		rids = append(rids, *rid)
+	}
+	last, err := loader.Insert(&Record{Values: []interface{}{5, "last"}})
+	if err != nil {
+		t.Fatalf("Failed to load the last record: %v", err)
+	}
+	rejected, err := loader.Finish()
+	if err != nil {
+		t.Fatalf("Failed to finish: %v", err)
+	}
+	refused := make(map[RecordID]string)
+	for _, r := range rejected {
+		if !errors.Is(r.Err, ErrDuplicateKey) {
+			t.Errorf("Expected a duplicate key error for %v, got %v", r.RID, r.Err)
+		}
+		refused[r.RID] = r.Err.(*DuplicateKeyError).Index
+	}
+	if len(refused) != 11 || refused[*last] != "codes_pkey" || loader.Loaded() != 90 {
+		t.Fatalf("Expected 11 rows rejected and 90 loaded, got %d and %d", len(refused), loader.Loaded())
+	}
+	for i := 90; i < 100; i++ {
+		if refused[rids[i]] != "by_code" {
+			t.Errorf("Expected row %d to be refused by by_code, got %q", i, refused[rids[i]])
+		}
+	}
+
+	// The refused rows are gone from the table and from both indexes
+	count := 0
+	err = db.RecordManager.Scan(codes, func(rid RecordID, record *Record) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 90 {
+		t.Errorf("Expected 90 rows, got %d: %v", count, err)
+	}
+	key, _ := codes.PrimaryIndex().Key(95)
+	if rids, err := codes.PrimaryIndex().SearchAll(key); err != nil || len(rids) != 0 {
+		t.Errorf("Expected the refused row 95 to be gone from the primary key, got %v %v", rids, err)
+	}
+	key, _ = byCode.Key("c3")
+	if rid, found, err := byCode.Search(key); err != nil || !found || rid != rids[3] {
+		t.Errorf("Expected code c3 to stay with row 3, got %v %v %v", rid, found, err)
+	}
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+}
+
+func TestBulkLoaderRecovery(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.CreateTable("t", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "data", DataType: TypeVarchar, Length: 1500},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("by_data", "t", IndexOptions{Columns: []int{1}, Hash: true}); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+
+	reopen := func(t *testing.T, crash bool) {
+		t.Helper()
+		if crash {
+			db.wal.Close()
+			db.File.Close()
+		} else if err := db.Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		if db, err = NewDatabaseWithOptions("test.db", opts); err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+		// A small cache makes every load dirty far more pages than it holds
+		db.Cache.Capacity = 16
+	}
+	load := func(t *testing.T, from, to int) *BulkLoader {
+		t.Helper()
+		loader, err := db.RecordManager.NewBulkLoader(db.Tables["t"])
+		if err != nil {
+			t.Fatalf("Failed to start bulk load: %v", err)
+		}
+		for i := from; i < to; i++ {
+			if _, err := loader.Insert(&Record{Values: []interface{}{i, fmt.Sprintf("%d %s", i, strings.Repeat("x", 1000))}}); err != nil {
+				t.Fatalf("Failed to load record %d: %v", i, err)
+			}
+		}
+		return loader
+	}
+	expectRows := func(t *testing.T, want int) {
+		t.Helper()
+		if report, err := db.Check(); err != nil || !report.OK() {
+			t.Errorf("Check failed: %v %+v", err, report)
+		}
+		count := 0
+		if err := db.RecordManager.Scan(db.Tables["t"], func(RecordID, *Record) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if count != want {
+			t.Errorf("Expected %d rows, got %d", want, count)
+		}
+	}
+	reopen(t, false)
+
+	t.Run("Larger Than The Cache", func(t *testing.T) {
+		if _, err := load(t, 0, 1000).Finish(); err != nil {
+			t.Fatalf("Failed to finish: %v", err)
+		}
+		reopen(t, false)
+		expectRows(t, 1000)
+	})
+
+	t.Run("Crash Before Finish", func(t *testing.T) {
+		load(t, 1000, 1500)
+		reopen(t, true)
+		expectRows(t, 1000)
+	})
+
+	t.Run("Crash During Finish", func(t *testing.T) {
+		// Only the primary key index gets the new rows before the crash
+		loader := load(t, 1000, 1500)
+		var rejected []BulkRejected
+		if err := loader.fillIndex(0, db.Tables["t"].Indexes[0], make(map[RecordID]int), &rejected); err != nil {
+			t.Fatalf("Failed to fill the index: %v", err)
+		}
+		reopen(t, true)
+		expectRows(t, 1000)
+
+		// The keys are free for the next load
+		if _, err := load(t, 1000, 1500).Finish(); err != nil {
+			t.Fatalf("Failed to finish: %v", err)
+		}
+		reopen(t, true)
+		expectRows(t, 1500)
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
@@ -2,10 +2,12 @@ package storage
 
 import (
 	"container/list"
+	"sync"
 )
 
 type Cache struct {
 	Capacity int
+	mu       sync.Mutex // cache is shared with the background page writer
 	pages    map[uint64]*list.Element
 	lru      *list.List
 }
@@ -27,6 +29,9 @@ func NewCache(capacity int) *Cache {
 // If the page is found, it moves the page to the front of the LRU list and returns the page.
 // If the page is not found, it returns nil and false.
 func (c *Cache) Get(pageID uint64) (*Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if element, found := c.pages[pageID]; found {
 		c.lru.MoveToFront(element)
 		return element.Value.(*cacheEntry).page, true
@@ -38,6 +43,9 @@ func (c *Cache) Get(pageID uint64) (*Page, bool) {
 // If the page is already in the cache, it updates the page in the cache.
 // If the page is not in the cache, it adds the page to the cache and evicts the oldest page if the cache is full.
 func (c *Cache) Put(page *Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if element, found := c.pages[page.ID]; found {
 		c.lru.MoveToFront(element)
 		element.Value.(*cacheEntry).page = page
@@ -53,13 +61,106 @@ func (c *Cache) Put(page *Page) {
 	c.pages[page.ID] = element
 }
 
-// evictOldest removes the oldest page from the cache.
-func (c *Cache) evictOldest() {
-	element := c.lru.Back()
-	if element != nil {
+// PutIfAbsent adds a page to the cache unless it already holds a page with
+// the same ID, and returns the page the cache holds. Two goroutines that read
+// the same page from disk at once end up sharing the first copy cached.
+func (c *Cache) PutIfAbsent(page *Page) *Page {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.pages[page.ID]; found {
+		c.lru.MoveToFront(element)
+		return element.Value.(*cacheEntry).page
+	}
+
+	if c.lru.Len() >= c.Capacity {
+		c.evictOldest()
+	}
+
+	entry := &cacheEntry{pageID: page.ID, page: page}
+	element := c.lru.PushFront(entry)
+	c.pages[page.ID] = element
+	return page
+}
+
+// DirtyPages returns up to limit dirty pages, least recently used first,
+// so the pages that have waited the longest are written out first.
+// A limit of zero or less returns every dirty page.
+func (c *Cache) DirtyPages(limit int) []*Page {
+	// Latches are taken outside the cache's lock, see evictOldest
+	var dirty []*Page
+	for _, page := range c.oldest() {
+		if !page.isDirty() {
+			continue
+		}
+		dirty = append(dirty, page)
+		if limit > 0 && len(dirty) >= limit {
+			break
+		}
+	}
+	return dirty
+}
+
+// oldest returns every cached page, least recently used first
+func (c *Cache) oldest() []*Page {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pages := make([]*Page, 0, c.lru.Len())
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		pages = append(pages, element.Value.(*cacheEntry).page)
+	}
+	return pages
+}
+
+// overflow returns how many pages the cache holds beyond its capacity
+func (c *Cache) overflow() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len() - c.Capacity
+}
+
+// Remove drops a page from the cache without writing it
+func (c *Cache) Remove(pageID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.pages[pageID]; found {
 		c.lru.Remove(element)
-		entry := element.Value.(*cacheEntry)
-		delete(c.pages, entry.pageID)
+		delete(c.pages, pageID)
+	}
+}
+
+// evict drops page from the cache, unless the cache holds another page
+// under its ID
+func (c *Cache) evict(page *Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.pages[page.ID]; found && element.Value.(*cacheEntry).page == page {
+		c.lru.Remove(element)
+		delete(c.pages, page.ID)
+	}
+}
+
+// evictOldest removes the least recently used page that is clean and not
+// latched. A dirty page is never dropped, its changes would be lost; when
+// every page is dirty or in use the cache grows past its capacity until
+// Database.writeBack writes the oldest ones out. Latches are only tried here,
+// since their holders may be waiting for the cache's lock.
+func (c *Cache) evictOldest() {
+	for element := c.lru.Back(); element != nil; element = element.Prev() {
+		page := element.Value.(*cacheEntry).page
+		if !page.latch.TryLock() {
+			continue
+		}
+		dirty := page.IsDirty
+		page.latch.Unlock()
+		if !dirty {
+			c.lru.Remove(element)
+			delete(c.pages, page.ID)
+			return
+		}
 	}
 }
 
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"godb/internal/wal"
+)
+
+// The catalog is the database's own table of tables. It is stored like any
+// other table, in slotted pages owned by a reserved table ID, and its first
+// page is always page 1, right after the header page, so it can be found when
+// the file is opened.
+// Each row is a kind tag followed by the serialized object, a table, a
+// sequence, an index or a hash index.
+//
+// Table and index page lists are not stored in the catalog. Every page header
+// records the table or index that owns it, and the lists are rebuilt when the
+// file is opened.
+// Pages owned by no table make up the free list.
+
+const (
+	catalogTableID = 1 // reserved ID of the catalog itself
+	firstTableID   = 2 // first ID handed out to user tables
+
+	catalogKindTable     = "table"
+	catalogKindSequence  = "sequence"
+	catalogKindIndex     = "index"
+	catalogKindHashIndex = "hash index"
+)
+
+func newCatalogTable() *Table {
+	catalog := NewTable("godb_catalog", []Column{
+		{Name: "kind", DataType: TypeVarchar},
+		{Name: "definition", DataType: TypeVarchar},
+	})
+	catalog.ID = catalogTableID
+	return catalog
+}
+
+// initCatalog formats page 1 as the first catalog page of a new database
+func (db *Database) initCatalog() error {
+	page, err := db.allocatePage()
+	if err != nil {
+		return err
+	}
+	if page.ID != catalogRootPage {
+		return fmt.Errorf("catalog must start at page %d, got page %d", catalogRootPage, page.ID)
+	}
+
+	layout := NewPageLayout(db.PageSize)
+	layout.header.Type = PageTypeCatalog
+	layout.header.TableID = catalogTableID
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	if err := db.logPageChange(page, nil, wal.LogTypeInsert); err != nil {
+		return err
+	}
+
+	db.catalog.AddPage(page.ID)
+	return db.cachePage(page)
+}
+
+// loadCatalog reads the catalog and hands every page to the table that owns it
+func (db *Database) loadCatalog() error {
+	owners, err := db.scanPageOwners()
+	if err != nil {
+		return err
+	}
+	db.catalog.PageIDs = owners[catalogTableID]
+	db.freePages = owners[0]
+	if err := db.finishCatalogMoves(); err != nil {
+		return err
+	}
+
+	err = db.RecordManager.Scan(db.catalog, func(rid RecordID, record *Record) error {
+		kind, definition, err := catalogEntry(record)
+		if err != nil {
+			return fmt.Errorf("catalog row %v: %w", rid, err)
+		}
+
+		switch kind {
+		case catalogKindTable:
+			table, err := DeserializeTable(definition)
+			if err != nil {
+				return fmt.Errorf("catalog row %v: %w", rid, err)
+			}
+			table.PageIDs = owners[table.ID]
+			db.Tables[table.Name] = table
+			if table.ID >= db.nextTableID {
+				db.nextTableID = table.ID + 1
+			}
+		case catalogKindSequence:
+			s, err := deserializeSequence(definition)
+			if err != nil {
+				return fmt.Errorf("catalog row %v: %w", rid, err)
+			}
+			s.db, s.rid = db, rid
+			db.Sequences[s.Name] = s
+		case catalogKindIndex:
+			t, err := deserializeBTree(definition)
+			if err != nil {
+				return fmt.Errorf("catalog row %v: %w", rid, err)
+			}
+			t.db = db
+			t.PageIDs = owners[t.ID]
+			db.Indexes[t.Name] = t
+			if t.ID >= db.nextTableID {
+				db.nextTableID = t.ID + 1
+			}
+		case catalogKindHashIndex:
+			h, err := deserializeHashIndex(definition)
+			if err != nil {
+				return fmt.Errorf("catalog row %v: %w", rid, err)
+			}
+			h.db = db
+			h.PageIDs = owners[h.ID]
+			db.HashIndexes[h.Name] = h
+			if h.ID >= db.nextTableID {
+				db.nextTableID = h.ID + 1
+			}
+		default:
+			return fmt.Errorf("catalog row %v: unknown kind %q", rid, kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Indexes join their tables once all are loaded: VACUUM may have moved an
+	// index's row before its table's
+	for _, index := range db.checkedIndexes() {
+		info := index.Info()
+		if info.TableID != 0 && db.tableByID(info.TableID) == nil {
+			return fmt.Errorf("index %s is on missing table %d", info.Name, info.TableID)
+		}
+		db.attachIndex(index)
+	}
+	return nil
+}
+
+// catalogEntry splits a catalog row into its kind and serialized definition
+func catalogEntry(record *Record) (string, []byte, error) {
+	if len(record.Values) != 2 {
+		return "", nil, errors.New("malformed catalog row")
+	}
+	kind, kindOK := record.Values[0].(string)
+	definition, defOK := record.Values[1].(string)
+	if !kindOK || !defOK {
+		return "", nil, errors.New("malformed catalog row")
+	}
+	return kind, []byte(definition), nil
+}
+
+// addCatalogEntry stores a new object in the catalog and returns its row
+func (db *Database) addCatalogEntry(kind string, definition []byte) (*RecordID, error) {
+	return db.RecordManager.InsertRecord(db.catalog, &Record{
+		Values: []interface{}{kind, string(definition)},
+	})
+}
+
+// scanPageOwners reads the header of every page after the header page and
+// groups page IDs by the table that owns them, in page order. Free pages are
+// listed under owner 0.
+func (db *Database) scanPageOwners() (map[uint64][]uint64, error) {
+	owners := make(map[uint64][]uint64)
+	header := make([]byte, PageHeaderSize)
+
+	for pageID := uint64(headerPageID + 1); pageID < db.nextPageID; pageID++ {
+		_, err := db.File.ReadAt(header, int64(pageID)*int64(db.PageSize))
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tableID := binary.LittleEndian.Uint64(header[OffsetTableID:])
+		owners[tableID] = append(owners[tableID], pageID)
+	}
+	return owners, nil
+}
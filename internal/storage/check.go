@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+// check walks every page of the database and reports what is wrong with it
+// instead of stopping at the first problem. It reads pages through the cache,
+// so it sees changes that are not on disk yet and can run on a database that
+// is in use. Each page is checked under its latch and each table's page list
+// is copied under the table's mutex; index page lists are copied the same
+// way, so the check sees the pages each owner had when it started.
+//
+// Every live row is also looked up in each index of its table, which must
+// hold an entry with the row's key and record ID, and each index must hold
+// as many entries as its table has rows. Rows that change while the check
+// runs may show up as false alarms there.
+
+// Kinds of problems in a CheckReport
+const (
+	CheckPage      = "page"      // page header or slot directory is invalid
+	CheckRecord    = "record"    // a record does not decode or does not match its table
+	CheckOwnership = "ownership" // page headers and table page lists disagree
+	CheckIndex     = "index"     // a B-tree node does not decode or breaks the tree's invariants
+	CheckPrimary   = "primary"   // a table has no primary key index
+	CheckEntries   = "entries"   // an index and the rows of its table disagree
+)
+
+// CheckReport is the result of Database.Check. It is meant to be encoded as JSON.
+type CheckReport struct {
+	Pages    uint64         `json:"pages"`
+	Tables   int            `json:"tables"`
+	Indexes  int            `json:"indexes"`
+	Records  int            `json:"records"`
+	Problems []CheckProblem `json:"problems"`
+}
+
+// CheckProblem is one inconsistency found by Database.Check
+type CheckProblem struct {
+	Kind    string `json:"kind"`
+	Table   string `json:"table,omitempty"`
+	Page    uint64 `json:"page"`
+	Slot    int    `json:"slot,omitempty"` // 1-based like RecordID.SlotNum
+	Message string `json:"message"`
+}
+
+// OK reports whether the check found no problems
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// problem records a problem with a page; tableName and slot may be empty
+func (r *CheckReport) problem(kind, tableName string, pageID uint64, slot int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, CheckProblem{
+		Kind:    kind,
+		Table:   tableName,
+		Page:    pageID,
+		Slot:    slot,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Check verifies the slot directory of every page, decodes every record
+// against its table's columns, walks every index, matches every index with
+// the rows of its table, checks that every page is owned by exactly one table
+// or index and reports tables without a primary key index. Problems go into
+// the report; the error is only set when pages cannot be read at all.
+func (db *Database) Check() (*CheckReport, error) {
+	report := &CheckReport{
+		Pages:    db.PageCount(),
+		Tables:   len(db.Tables),
+		Indexes:  len(db.Indexes) + len(db.HashIndexes),
+		Problems: []CheckProblem{},
+	}
+
+	tables := db.checkedTables()
+	indexes := db.checkedIndexes()
+	byID := make(map[uint64]*Table, len(tables))
+	var lists []pageOwner
+	for _, table := range tables {
+		byID[table.ID] = table
+		lists = append(lists, pageOwner{table.ID, table.Name, table.pages()})
+	}
+	indexByID := make(map[uint64]Index, len(indexes))
+	for _, index := range indexes {
+		info := index.Info()
+		indexByID[info.ID] = index
+		lists = append(lists, pageOwner{info.ID, info.Name, index.pages()})
+	}
+
+	rows := make(map[uint64]int) // live rows of each table
+	owners, err := db.checkPages(report, byID, indexByID, rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		for _, problem := range index.Check() {
+			report.problem(CheckIndex, index.Info().Name, indexRoot(index), 0, "%s", problem)
+		}
+	}
+	for _, table := range tables {
+		checkEntryCounts(report, table, rows[table.ID])
+	}
+	checkOwnership(report, lists, owners)
+	for _, table := range tables {
+		if table != db.catalog && table.PrimaryIndex() == nil {
+			reason := db.noPrimaryIndex[table.Name]
+			if reason == nil {
+				reason = errors.New("it is built the next time the database is opened for writing")
+			}
+			report.problem(CheckPrimary, table.Name, 0, 0, "no primary key index: %v", reason)
+		}
+	}
+	return report, nil
+}
+
+// pageOwner is a table or index and the pages it lists as its own
+type pageOwner struct {
+	id      uint64
+	name    string
+	pageIDs []uint64
+}
+
+// checkedTables returns the catalog and every user table, ordered by ID
+func (db *Database) checkedTables() []*Table {
+	tables := []*Table{db.catalog}
+	for _, table := range db.Tables {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].ID < tables[j].ID })
+	return tables
+}
+
+// checkedIndexes returns every index of either kind, ordered by ID
+func (db *Database) checkedIndexes() []Index {
+	indexes := make([]Index, 0, len(db.Indexes)+len(db.HashIndexes))
+	for _, tree := range db.Indexes {
+		indexes = append(indexes, tree)
+	}
+	for _, hash := range db.HashIndexes {
+		indexes = append(indexes, hash)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Info().ID < indexes[j].Info().ID })
+	return indexes
+}
+
+// indexRoot returns the page an index is found from: a tree's root or a hash
+// index's directory
+func indexRoot(index Index) uint64 {
+	switch index := index.(type) {
+	case *BTree:
+		return index.Root
+	case *HashIndex:
+		return index.Directory
+	}
+	return 0
+}
+
+// checkPages checks the layout and records of every page, counts the rows of
+// each table into rows and returns the owner each page header names
+func (db *Database) checkPages(report *CheckReport, byID map[uint64]*Table, indexByID map[uint64]Index, rows map[uint64]int) (map[uint64]uint64, error) {
+	owners := make(map[uint64]uint64)
+	data := make([]byte, db.PageSize)
+
+	for pageID := uint64(0); pageID < report.Pages; pageID++ {
+		page, err := db.GetPage(pageID)
+		if errors.Is(err, io.EOF) {
+			// Allocated but never written, nothing to check
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageID, err)
+		}
+		page.latch.RLock()
+		copy(data, page.Data)
+		page.latch.RUnlock()
+
+		if pageID == headerPageID {
+			checkHeaderPage(report, data)
+			continue
+		}
+
+		if index := indexByID[binary.LittleEndian.Uint64(data[OffsetTableID:])]; index != nil {
+			// Index pages hold a node or bucket instead of a slot directory
+			info := index.Info()
+			owners[pageID] = info.ID
+			if err := index.decodePage(pageID, data); err != nil {
+				report.problem(CheckIndex, info.Name, pageID, 0, "%v", err)
+			}
+			continue
+		}
+
+		layout, ok := checkLayout(report, pageID, data)
+		if !ok {
+			continue
+		}
+		if want := ownerPageType(layout.header.TableID); layout.header.Type != want &&
+			!(want == PageTypeFree && layout.header.Type == PageTypeUnknown) {
+			report.problem(CheckPage, "", pageID, 0, "page of table %d has type %s, expected %s", layout.header.TableID, layout.header.Type, want)
+		}
+		if layout.header.TableID == 0 {
+			continue
+		}
+		owners[pageID] = layout.header.TableID
+
+		table := byID[layout.header.TableID]
+		if table == nil {
+			report.problem(CheckOwnership, "", pageID, 0, "header names unknown table %d", layout.header.TableID)
+			continue
+		}
+		decoded := checkRecords(report, table, pageID, layout)
+		report.Records += decoded
+		rows[table.ID] += decoded
+	}
+	return owners, nil
+}
+
+// checkHeaderPage validates page 0, the file header
+func checkHeaderPage(report *CheckReport, data []byte) {
+	layout := DeserializePageLayout(data)
+	if layout.header.Type != PageTypeHeader || layout.header.TableID != 0 {
+		report.problem(CheckPage, "", headerPageID, 0, "header page has type %s and owner %d", layout.header.Type, layout.header.TableID)
+	}
+	if header := decodeFileHeader(data); header.Version != FormatVersion || int(header.PageSize) != len(data) {
+		report.problem(CheckPage, "", headerPageID, 0, "file header names version %d with %d byte pages", header.Version, header.PageSize)
+	}
+}
+
+// ownerPageType returns the type of the slotted pages owned by a table;
+// pages without an owner are free. Zeroed pages that were never written are
+// free too and may still have no type.
+func ownerPageType(tableID uint64) PageType {
+	switch tableID {
+	case 0:
+		return PageTypeFree
+	case catalogTableID:
+		return PageTypeCatalog
+	}
+	return PageTypeHeap
+}
+
+// checkLayout validates the header and slot directory of a page. It returns
+// false when the slot directory is too broken to look at the records.
+func checkLayout(report *CheckReport, pageID uint64, data []byte) (*PageLayout, bool) {
+	layout := DeserializePageLayout(data)
+	header := layout.header
+	pageSize := uint32(len(data))
+
+	if int(header.SlotCount) != len(layout.slots) {
+		report.problem(CheckPage, "", pageID, 0, "slot count %d does not fit in the page", header.SlotCount)
+		return nil, false
+	}
+	if header.LastSlotID != header.SlotCount {
+		report.problem(CheckPage, "", pageID, 0, "last slot ID %d does not match slot count %d", header.LastSlotID, header.SlotCount)
+	}
+
+	directoryEnd := uint32(PageHeaderSize + len(layout.slots)*SlotEntrySize)
+	if header.FreeSpace < directoryEnd || header.FreeSpace > pageSize {
+		report.problem(CheckPage, "", pageID, 0, "free space pointer %d outside [%d, %d]", header.FreeSpace, directoryEnd, pageSize)
+	}
+
+	// Records live between the free space pointer and the end of the page
+	var inBounds []int
+	for i, slot := range layout.slots {
+		end := slot.Offset + uint32(slot.Length)
+		switch {
+		case slot.Offset < directoryEnd || end > pageSize:
+			report.problem(CheckPage, "", pageID, i+1, "record [%d, %d) outside the page's record area [%d, %d)", slot.Offset, end, directoryEnd, pageSize)
+			continue
+		case slot.Offset < header.FreeSpace:
+			report.problem(CheckPage, "", pageID, i+1, "record at %d is below the free space pointer %d", slot.Offset, header.FreeSpace)
+		}
+		if slot.Flags&^SlotFlagDeleted != 0 {
+			report.problem(CheckPage, "", pageID, i+1, "unknown slot flags %#x", slot.Flags)
+		}
+		inBounds = append(inBounds, i)
+	}
+
+	sort.Slice(inBounds, func(a, b int) bool {
+		return layout.slots[inBounds[a]].Offset < layout.slots[inBounds[b]].Offset
+	})
+	for n := 1; n < len(inBounds); n++ {
+		prev, cur := layout.slots[inBounds[n-1]], layout.slots[inBounds[n]]
+		if prev.Offset+uint32(prev.Length) > cur.Offset {
+			report.problem(CheckPage, "", pageID, inBounds[n]+1, "record overlaps slot %d", inBounds[n-1]+1)
+		}
+	}
+	return layout, true
+}
+
+// checkRecords decodes every live record of a page that passed checkLayout,
+// looks each up in its table's indexes and returns how many decoded
+func checkRecords(report *CheckReport, table *Table, pageID uint64, layout *PageLayout) int {
+	decoded := 0
+	pageSize := uint32(len(layout.data))
+	for i, slot := range layout.slots {
+		end := slot.Offset + uint32(slot.Length)
+		if slot.Flags&SlotFlagDeleted != 0 || end > pageSize {
+			continue
+		}
+
+		record, err := DeserializeRecord(layout.data[slot.Offset:end])
+		if err != nil {
+			report.problem(CheckRecord, table.Name, pageID, i+1, "%v", err)
+			continue
+		}
+		decoded++
+
+		if len(record.Values) != len(table.Columns) {
+			report.problem(CheckRecord, table.Name, pageID, i+1, "record has %d values, table has %d columns", len(record.Values), len(table.Columns))
+			continue
+		}
+		for c, column := range table.Columns {
+			value := record.Values[c]
+			if value == nil {
+				if column.NotNull {
+					report.problem(CheckRecord, table.Name, pageID, i+1, "column %s is null", column.Name)
+				}
+				continue
+			}
+			if !column.Accepts(value) {
+				report.problem(CheckRecord, table.Name, pageID, i+1, "column %s holds %T", column.Name, value)
+			}
+		}
+		checkEntries(report, table, RecordID{PageID: pageID, SlotNum: uint16(i + 1)}, record)
+	}
+	return decoded
+}
+
+// checkEntries reports the indexes of a table that hold no entry for a row
+func checkEntries(report *CheckReport, table *Table, rid RecordID, record *Record) {
+	for _, index := range table.Indexes {
+		name := index.Info().Name
+		key, err := index.recordKey(record)
+		if err != nil {
+			report.problem(CheckEntries, table.Name, rid.PageID, int(rid.SlotNum), "index %s: %v", name, err)
+			continue
+		}
+		rids, err := index.SearchAll(key)
+		if err != nil {
+			report.problem(CheckEntries, table.Name, rid.PageID, int(rid.SlotNum), "index %s: %v", name, err)
+			continue
+		}
+		if !slices.Contains(rids, rid) {
+			report.problem(CheckEntries, table.Name, rid.PageID, int(rid.SlotNum), "index %s has no entry %s for the row", name, formatKey(index.Info().Columns, key))
+		}
+	}
+}
+
+// checkEntryCounts reports the indexes of a table that do not hold one entry
+// for each of its rows
+func checkEntryCounts(report *CheckReport, table *Table, rows int) {
+	for _, index := range table.Indexes {
+		name := index.Info().Name
+		entries, err := index.count()
+		if err != nil {
+			report.problem(CheckEntries, table.Name, indexRoot(index), 0, "index %s: %v", name, err)
+		} else if entries != rows {
+			report.problem(CheckEntries, table.Name, indexRoot(index), 0, "index %s holds %d entries for %d rows", name, entries, rows)
+		}
+	}
+}
+
+// checkOwnership compares the owner in every page header with the page lists
+// of the tables and indexes
+func checkOwnership(report *CheckReport, lists []pageOwner, owners map[uint64]uint64) {
+	byID := make(map[uint64]*pageOwner, len(lists))
+	listedBy := make(map[uint64]*pageOwner)
+	for i := range lists {
+		list := &lists[i]
+		byID[list.id] = list
+		for _, pageID := range list.pageIDs {
+			if other := listedBy[pageID]; other != nil {
+				report.problem(CheckOwnership, list.name, pageID, 0, "page is also listed by table %s", other.name)
+				continue
+			}
+			listedBy[pageID] = list
+
+			switch owner, ok := owners[pageID]; {
+			case pageID >= report.Pages:
+				report.problem(CheckOwnership, list.name, pageID, 0, "listed page is past the last page %d", report.Pages)
+			case !ok:
+				report.problem(CheckOwnership, list.name, pageID, 0, "listed page has no owner in its header")
+			case owner != list.id:
+				report.problem(CheckOwnership, list.name, pageID, 0, "listed page is owned by table %d", owner)
+			}
+		}
+	}
+
+	pageIDs := make([]uint64, 0, len(owners))
+	for pageID := range owners {
+		pageIDs = append(pageIDs, pageID)
+	}
+	sort.Slice(pageIDs, func(i, j int) bool { return pageIDs[i] < pageIDs[j] })
+	for _, pageID := range pageIDs {
+		owner := byID[owners[pageID]]
+		if owner != nil && listedBy[pageID] == nil {
+			report.problem(CheckOwnership, owner.name, pageID, 0, "page is missing from the table's page list")
+		}
+	}
+}
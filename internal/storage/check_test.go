@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}
+	for _, name := range []string{"users", "orders"} {
+		if err := db.CreateTable(name, columns); err != nil {
+			t.Fatalf("Failed to create table %s: %v", name, err)
+		}
+		for i := 0; i < 300; i++ {
+			if _, err := db.RecordManager.InsertRecord(db.Tables[name], &Record{Values: []interface{}{i, fmt.Sprintf("%s %d", name, i)}}); err != nil {
+				t.Fatalf("Failed to insert record %d: %v", i, err)
+			}
+		}
+	}
+
+	report, err := db.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("Expected a clean report, got %+v", report.Problems)
+	}
+	if report.Tables != 2 || report.Indexes != 2 || report.Records != 604 {
+		t.Errorf("Expected 2 tables, 2 indexes and 604 records including the catalog, got %d, %d and %d", report.Tables, report.Indexes, report.Records)
+	}
+
+	users, orders := db.Tables["users"], db.Tables["orders"]
+	expect := func(t *testing.T, kind, message string) {
+		t.Helper()
+		report, err := db.Check()
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		for _, problem := range report.Problems {
+			if problem.Kind == kind && strings.Contains(problem.Message, message) {
+				return
+			}
+		}
+		t.Errorf("Expected a %s problem containing %q, got %+v", kind, message, report.Problems)
+	}
+	// corrupt changes a page in the cache and returns a function undoing it
+	corrupt := func(pageID uint64, change func(data []byte)) func() {
+		page, err := db.GetPage(pageID)
+		if err != nil {
+			t.Fatalf("Failed to get page %d: %v", pageID, err)
+		}
+		saved := append([]byte(nil), page.Data...)
+		change(page.Data)
+		return func() { copy(page.Data, saved) }
+	}
+	slot := func(n int) int { return PageHeaderSize + (n-1)*SlotEntrySize }
+
+	t.Run("Slot Out Of Bounds", func(t *testing.T) {
+		defer corrupt(users.PageIDs[0], func(data []byte) {
+			binary.LittleEndian.PutUint32(data[slot(3):], uint32(len(data)-2))
+		})()
+		expect(t, CheckPage, "outside the page's record area")
+	})
+
+	t.Run("Overlapping Slots", func(t *testing.T) {
+		defer corrupt(users.PageIDs[0], func(data []byte) {
+			offset := binary.LittleEndian.Uint32(data[slot(2):])
+			binary.LittleEndian.PutUint32(data[slot(3):], offset+1)
+		})()
+		expect(t, CheckPage, "overlaps slot")
+	})
+
+	t.Run("Huge Slot Count", func(t *testing.T) {
+		defer corrupt(users.PageIDs[0], func(data []byte) {
+			binary.LittleEndian.PutUint32(data[OffsetSlotCount:], 1<<30)
+		})()
+		expect(t, CheckPage, "does not fit in the page")
+	})
+
+	t.Run("Undecodable Record", func(t *testing.T) {
+		defer corrupt(orders.PageIDs[0], func(data []byte) {
+			offset := binary.LittleEndian.Uint32(data[slot(1):])
+			data[offset+4] = 0xee // type of the first value
+		})()
+		expect(t, CheckRecord, "unknown value type")
+	})
+
+	t.Run("Truncated Bool", func(t *testing.T) {
+		if _, err := DeserializeRecord([]byte{1, 0, 0, 0, byte(TypeBool)}); err == nil {
+			t.Error("Expected a bool without its byte to be refused")
+		}
+		defer corrupt(orders.PageIDs[0], func(data []byte) {
+			offset := binary.LittleEndian.Uint32(data[slot(1):])
+			binary.LittleEndian.PutUint32(data[offset:], 1)
+			data[offset+4] = byte(TypeBool)
+			binary.LittleEndian.PutUint16(data[slot(1)+4:], 5)
+		})()
+		expect(t, CheckRecord, "corrupt record data")
+	})
+
+	t.Run("Wrong Column Count", func(t *testing.T) {
+		defer corrupt(orders.PageIDs[0], func(data []byte) {
+			offset := binary.LittleEndian.Uint32(data[slot(1):])
+			binary.LittleEndian.PutUint32(data[offset:], 1)
+		})()
+		expect(t, CheckRecord, "record has 1 values, table has 2 columns")
+	})
+
+	t.Run("Wrong Page Type", func(t *testing.T) {
+		defer corrupt(users.PageIDs[0], func(data []byte) {
+			data[OffsetPageType] = byte(PageTypeFree)
+		})()
+		expect(t, CheckPage, "has type free, expected heap")
+	})
+
+	t.Run("Damaged File Header", func(t *testing.T) {
+		defer corrupt(headerPageID, func(data []byte) {
+			binary.LittleEndian.PutUint32(data[offsetFileVersion:], 7)
+		})()
+		expect(t, CheckPage, "file header names version 7")
+	})
+
+	t.Run("Page In Two Tables", func(t *testing.T) {
+		orders.PageIDs = append(orders.PageIDs, users.PageIDs[0])
+		defer func() { orders.PageIDs = orders.PageIDs[:len(orders.PageIDs)-1] }()
+		expect(t, CheckOwnership, "also listed by table users")
+	})
+
+	t.Run("Page Missing From List", func(t *testing.T) {
+		saved := users.PageIDs
+		users.PageIDs = users.PageIDs[1:]
+		defer func() { users.PageIDs = saved }()
+		expect(t, CheckOwnership, "missing from the table's page list")
+	})
+
+	t.Run("Row Missing From Index", func(t *testing.T) {
+		index := users.PrimaryIndex()
+		key, _ := index.Key(7)
+		rid, found, err := index.Search(key)
+		if err != nil || !found {
+			t.Fatalf("Search failed: %v %v", found, err)
+		}
+		if _, err := index.Delete(key, rid); err != nil {
+			t.Fatalf("Failed to delete the entry: %v", err)
+		}
+		defer func() {
+			if err := index.Insert(key, rid); err != nil {
+				t.Fatalf("Failed to put the entry back: %v", err)
+			}
+		}()
+		expect(t, CheckEntries, "index users_pkey has no entry 7 for the row")
+		expect(t, CheckEntries, "index users_pkey holds 299 entries for 300 rows")
+	})
+
+	t.Run("Entry Without Row", func(t *testing.T) {
+		index := orders.PrimaryIndex()
+		key, _ := index.Key(1000)
+		rid := RecordID{PageID: orders.PageIDs[0], SlotNum: 1}
+		if err := index.Insert(key, rid); err != nil {
+			t.Fatalf("Failed to insert the entry: %v", err)
+		}
+		defer index.Delete(key, rid)
+		expect(t, CheckEntries, "index orders_pkey holds 301 entries for 300 rows")
+	})
+
+	report, err = db.Check()
+	if err != nil || !report.OK() {
+		t.Errorf("Expected a clean report after undoing the damage, got %v %+v", err, report)
+	}
+}
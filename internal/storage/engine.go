@@ -2,16 +2,49 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
 )
 
 type Database struct {
 	Path          string
-	File          *os.File
+	InMemory      bool // no backing file, contents are dropped on Close
+	ReadOnly      bool // opened with a shared lock, every change is refused
+	FS            vfs.FS
+	File          vfs.File
 	PageSize      uint16 // 4kb
 	Cache         *Cache
 	Tables        map[string]*Table
+	Sequences     map[string]*Sequence
+	Indexes       map[string]*BTree
+	HashIndexes   map[string]*HashIndex
 	RecordManager *RecordManager
+
+	writerMu   sync.Mutex
+	pageWriter *PageWriter // background writer, nil when not running
+
+	wal          *wal.WAL            // write-ahead log, nil when changes are not logged
+	logMu        sync.RWMutex        // held exclusively while a checkpoint starts a new image epoch
+	imagedMu     sync.Mutex          // protects imaged
+	imaged       map[uint64]struct{} // pages that logged a full image since the last checkpoint
+	checkpointMu sync.Mutex          // one checkpoint at a time
+	units        atomic.Uint64       // ID of the last record unit begun, see record_log.go
+	unfinished   []wal.LogEntry      // begin entries of the units a crash cut short
+	snapshots    atomic.Uint64       // number of the last snapshot taken, part of its file name
+	temporary    bool                // a snapshot, whose file is removed on Close
+
+	catalog     *Table     // system table holding every table definition
+	nextTableID uint64     // ID given to the next table or index
+	allocMu     sync.Mutex // protects nextPageID and freePages
+	nextPageID  uint64     // ID given to the next allocated page when no page is free
+	freePages   []uint64   // pages owned by no table, in ascending order, reused first
+
+	noPrimaryIndex map[string]error // tables whose primary key index could not be built, and why
 }
 
 // Page is the smallest unit of storage in the database. Data is stored in pages (fixed size blocks) rather than one continuous block.
@@ -21,27 +54,197 @@ type Page struct {
 	ID      uint64 // page id for unique identifications
 	Data    []byte // actual data stored in the page
 	IsDirty bool   // bool to indicate if page needs to be written to disk
+
+	// latch protects Data and IsDirty while the page is shared between
+	// record operations and the background page writer
+	latch sync.RWMutex
+}
+
+// isDirty reports whether the page has changes that are not on disk yet
+func (p *Page) isDirty() bool {
+	p.latch.RLock()
+	defer p.latch.RUnlock()
+	return p.IsDirty
 }
 
+// MemoryPath opens a database that lives only in memory, like SQLite's ":memory:"
+const MemoryPath = ":memory:"
+
+var (
+	// ErrDatabaseLocked is returned when another process already has the database open
+	ErrDatabaseLocked = errors.New("database is locked")
+	// ErrReadOnly is returned when a read-only database is asked to change
+	ErrReadOnly = errors.New("database is opened read-only")
+)
+
+// Options controls how a database is opened
+type Options struct {
+	FS       vfs.FS // file system holding the database file, vfs.OS when nil
+	InMemory bool   // keep everything in a private in-memory file system, dropped on Close
+
+	// ReadOnly opens the file without write access and takes a shared lock,
+	// so several read-only openers can coexist. A read-write open takes an
+	// exclusive lock and fails with ErrDatabaseLocked while anyone else has
+	// the file open.
+	ReadOnly bool
+
+	// WALPath is the write-ahead log for page changes, opened on the same
+	// file system. The log is replayed on open. Empty means no logging.
+	// Read-only databases never open the log.
+	WALPath string
+}
+
+// DefaultPageSize is the size of every page, 4kb
+const DefaultPageSize = 4096
+
 func NewDatabase(path string) (*Database, error) {
+	return NewDatabaseWithOptions(path, Options{})
+}
+
+func NewDatabaseWithOptions(path string, opts Options) (*Database, error) {
+	if path == MemoryPath {
+		opts.InMemory = true
+	}
+	if opts.InMemory {
+		// Every in-memory database gets its own file system so two of them
+		// never see each other's pages
+		opts.FS = vfs.NewMemFS()
+	}
+	if opts.FS == nil {
+		opts.FS = vfs.OS
+	}
+
 	db := &Database{
-		Path:     path,
-		PageSize: 4096,           // Standard page size 4kb
-		Cache:    NewCache(1000), // LRU cache with 1000 pages
-		Tables:   make(map[string]*Table),
+		Path:        path,
+		InMemory:    opts.InMemory,
+		ReadOnly:    opts.ReadOnly,
+		FS:          opts.FS,
+		PageSize:    DefaultPageSize,
+		Cache:       NewCache(1000), // LRU cache with 1000 pages
+		Tables:      make(map[string]*Table),
+		Sequences:   make(map[string]*Sequence),
+		Indexes:     make(map[string]*BTree),
+		HashIndexes: make(map[string]*HashIndex),
+	}
+
+	flag, lockType := os.O_RDWR|os.O_CREATE, vfs.LockExclusive
+	if db.ReadOnly {
+		flag, lockType = os.O_RDONLY, vfs.LockShared
 	}
 
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	file, err := db.FS.OpenFile(path, flag, 0666)
 	if err != nil {
 		return nil, err
 	}
+	if err := file.Lock(lockType); err != nil {
+		file.Close()
+		if errors.Is(err, vfs.ErrLocked) {
+			return nil, fmt.Errorf("%w: %s", ErrDatabaseLocked, path)
+		}
+		return nil, err
+	}
 	db.File = file
+	if !db.ReadOnly && !db.InMemory {
+		if err := db.removeSortRuns(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := db.removeSnapshots(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
 	db.RecordManager = NewRecordManager(db)
+	db.catalog = newCatalogTable()
+	db.nextTableID = firstTableID
+
+	if opts.WALPath != "" && !db.ReadOnly {
+		if err := db.openWAL(opts.WALPath); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if err := db.openCatalog(); err != nil {
+		db.closeFiles()
+		return nil, err
+	}
 	return db, nil
 }
 
+// openCatalog formats a new file or checks the header of an existing one and
+// loads its catalog
+func (db *Database) openCatalog() error {
+	db.nextPageID = db.getNextPageID()
+	if db.nextPageID > 0 {
+		if err := db.checkFileHeader(); err != nil {
+			return err
+		}
+		if err := db.loadCatalog(); err != nil {
+			return err
+		}
+		if db.ReadOnly {
+			return nil
+		}
+		if err := db.finishUnits(); err != nil {
+			return err
+		}
+		return db.createMissingPrimaryIndexes()
+	}
+	if db.ReadOnly {
+		// An empty file opened read-only is an empty database
+		return nil
+	}
+	if err := db.initHeaderPage(); err != nil {
+		return err
+	}
+	return db.initCatalog()
+}
+
+// closeFiles releases the WAL and the database file without flushing
+func (db *Database) closeFiles() {
+	if db.wal != nil {
+		db.wal.Close()
+	}
+	db.File.Close()
+}
+
+// openWAL attaches the write-ahead log and replays it onto the file
+func (db *Database) openWAL(path string) error {
+	log, err := wal.NewWALWithFS(db.FS, path)
+	if errors.Is(err, wal.ErrUnsupportedVersion) {
+		if version, _ := wal.Version(db.FS, path); version < FormatVersion {
+			return fmt.Errorf("%w: %v; run godb upgrade", ErrUnsupportedVersion, err)
+		}
+		return fmt.Errorf("%w: %v", ErrUnsupportedVersion, err)
+	}
+	if err != nil {
+		return err
+	}
+	db.wal = log
+	db.imaged = make(map[uint64]struct{})
+
+	if err := db.recover(); err != nil {
+		log.Close()
+		return err
+	}
+	return nil
+}
+
+// allocatePage returns a zeroed page, reusing the lowest free page if any.
+// The caller formats it and puts it in the cache.
 func (db *Database) allocatePage() (*Page, error) {
-	pageID := db.getNextPageID()
+	db.allocMu.Lock()
+	var pageID uint64
+	if len(db.freePages) > 0 {
+		pageID = db.freePages[0]
+		db.freePages = db.freePages[1:]
+	} else {
+		pageID = db.nextPageID
+		db.nextPageID++
+	}
+	db.allocMu.Unlock()
+
 	page := &Page{
 		ID:      pageID,
 		Data:    make([]byte, db.PageSize),
@@ -64,6 +267,9 @@ func (db *Database) writePage(page *Page) error {
 	if !page.IsDirty {
 		return nil
 	}
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
 	offset := int64(page.ID) * int64(db.PageSize)
 	_, err := db.File.WriteAt(page.Data, offset)
 	if err != nil {
@@ -73,6 +279,132 @@ func (db *Database) writePage(page *Page) error {
 	return nil
 }
 
+// flushPage writes a single page to disk while holding its latch. The log
+// entries describing the page are synced first, so a page never reaches disk
+// ahead of the log that can repair it.
+func (db *Database) flushPage(page *Page) error {
+	page.latch.Lock()
+	defer page.latch.Unlock()
+	return db.flushLatched(page)
+}
+
+// flushLatched is flushPage for a caller holding the page's latch
+func (db *Database) flushLatched(page *Page) error {
+	if db.wal != nil && page.IsDirty {
+		if err := db.wal.SyncTo(pageLSN(page.Data)); err != nil {
+			return err
+		}
+	}
+	return db.writePage(page)
+}
+
+// writeBack brings a cache that grew past its capacity, since its oldest
+// pages were all dirty, back to size by writing those pages out and evicting
+// them. Pages whose latch is held are in use and stay.
+func (db *Database) writeBack() error {
+	over := db.Cache.overflow()
+	if over <= 0 || db.ReadOnly {
+		return nil
+	}
+	for _, page := range db.Cache.oldest() {
+		if over == 0 {
+			break
+		}
+		if !page.latch.TryLock() {
+			continue
+		}
+		err := db.flushLatched(page)
+		if err == nil {
+			db.Cache.evict(page)
+			over--
+		}
+		page.latch.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachePage adds a new page to the cache, writing older pages out should the
+// cache be full of dirty ones
+func (db *Database) cachePage(page *Page) error {
+	db.Cache.Put(page)
+	return db.writeBack()
+}
+
+// FlushPages writes up to limit dirty pages from the cache to disk, oldest
+// first, and returns how many pages were written. A limit of zero or less
+// writes every dirty page.
+func (db *Database) FlushPages(limit int) (int, error) {
+	written := 0
+	for _, page := range db.Cache.DirtyPages(limit) {
+		if err := db.flushPage(page); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Flush writes every dirty page to disk and syncs the file
+func (db *Database) Flush() error {
+	if db.ReadOnly {
+		return nil
+	}
+	if _, err := db.FlushPages(0); err != nil {
+		return err
+	}
+	return db.File.Sync()
+}
+
+// Close stops the background page writer, flushes all dirty pages and closes the file.
+// With a WAL the flush is a checkpoint, so the next open has nothing to replay.
+// An in-memory database is dropped instead of flushed.
+func (db *Database) Close() error {
+	db.StopPageWriter()
+	if db.InMemory {
+		db.Cache = NewCache(db.Cache.Capacity)
+		db.Tables = make(map[string]*Table)
+		db.Sequences = make(map[string]*Sequence)
+		db.Indexes = make(map[string]*BTree)
+		db.HashIndexes = make(map[string]*HashIndex)
+		if db.wal != nil {
+			db.wal.Close()
+		}
+		if err := db.File.Close(); err != nil {
+			return err
+		}
+		return db.FS.Remove(db.Path)
+	}
+
+	err := db.Checkpoint()
+	if db.wal != nil {
+		if walErr := db.wal.Close(); err == nil {
+			err = walErr
+		}
+	}
+	if err != nil {
+		db.File.Close()
+		return err
+	}
+	if err := db.File.Close(); err != nil {
+		return err
+	}
+	if db.temporary {
+		return db.FS.Remove(db.Path)
+	}
+	return nil
+}
+
+// PageCount returns the number of pages allocated so far, including pages
+// that are still only in the cache
+func (db *Database) PageCount() uint64 {
+	db.allocMu.Lock()
+	defer db.allocMu.Unlock()
+	return db.nextPageID
+}
+
 func (db *Database) getNextPageID() uint64 {
 	// Simplified example: calculate next page ID based on file size
 	fileInfo, err := db.File.Stat()
@@ -82,14 +414,40 @@ func (db *Database) getNextPageID() uint64 {
 	return uint64(fileInfo.Size() / int64(db.PageSize))
 }
 
+// CreateTable adds a table whose primary key is its first column
 func (db *Database) CreateTable(name string, columns []Column) error {
+	return db.CreateTableWithPrimaryKey(name, columns, 0)
+}
+
+// CreateTableWithPrimaryKey adds a table whose primary key is the column at
+// index primaryKey
+func (db *Database) CreateTableWithPrimaryKey(name string, columns []Column, primaryKey int) error {
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
 	if _, exists := db.Tables[name]; exists {
 		return errors.New("table already exists")
 	}
+	if db.indexExists(PrimaryKeyIndexName(name)) {
+		return fmt.Errorf("index %s already exists", PrimaryKeyIndexName(name))
+	}
+
+	if primaryKey < 0 || primaryKey >= len(columns) {
+		return fmt.Errorf("primary key column %d is out of range", primaryKey)
+	}
+	if err := primaryKeyError(db.PageSize, columns[primaryKey]); err != nil {
+		return err
+	}
 
 	table := NewTable(name, columns)
+	table.ID = db.nextTableID
+	table.PrimaryKey = primaryKey
+	if _, err := db.addCatalogEntry(catalogKindTable, table.Serialize()); err != nil {
+		return err
+	}
+	db.nextTableID++
 	db.Tables[name] = table
-	return nil
+	return db.createPrimaryIndex(table)
 }
 
 func (db *Database) GetPage(pageID uint64) (*Page, error) {
@@ -104,8 +462,7 @@ func (db *Database) GetPage(pageID uint64) (*Page, error) {
 		return nil, err
 	}
 
-	// Add to cache for future use
-	db.Cache.Put(page)
-
-	return page, nil
+	// Add to cache for future use, unless another reader got there first
+	page = db.Cache.PutIfAbsent(page)
+	return page, db.writeBack()
 }
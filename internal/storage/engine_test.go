@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestInMemoryDatabase(t *testing.T) {
+	db, err := NewDatabase(MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create in-memory database: %v", err)
+	}
+	if !db.InMemory {
+		t.Fatal("Expected database to be in memory")
+	}
+
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}
+	if err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	table := db.Tables["users"]
+
+	rid, err := db.RecordManager.InsertRecord(table, &Record{Values: []interface{}{1, "Alice"}})
+	if err != nil {
+		t.Fatalf("Failed to insert record: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	record, err := db.RecordManager.GetRecord(table, rid)
+	if err != nil {
+		t.Fatalf("Failed to retrieve record: %v", err)
+	}
+	if record.Values[1] != "Alice" {
+		t.Errorf("Expected Alice, got %v", record.Values[1])
+	}
+
+	if _, err := os.Stat(MemoryPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no file on disk, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	// A second in-memory database starts empty
+	other, err := NewDatabase(MemoryPath)
+	if err != nil {
+		t.Fatalf("Failed to create second in-memory database: %v", err)
+	}
+	defer other.Close()
+	if len(other.Tables) != 0 {
+		t.Errorf("Expected no tables, got %d", len(other.Tables))
+	}
+	if _, err := other.RecordManager.GetRecord(table, rid); err == nil {
+		t.Error("Expected record to be gone from a new in-memory database")
+	}
+}
+
+func TestDatabaseLocking(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "locked.db")
+
+	writer, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	if _, err := NewDatabase(dbPath); !errors.Is(err, ErrDatabaseLocked) {
+		t.Errorf("Expected ErrDatabaseLocked for second writer, got %v", err)
+	}
+	if _, err := NewDatabaseWithOptions(dbPath, Options{ReadOnly: true}); !errors.Is(err, ErrDatabaseLocked) {
+		t.Errorf("Expected ErrDatabaseLocked for reader while writer is open, got %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	// Several read-only openers may share the file
+	reader1, err := NewDatabaseWithOptions(dbPath, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open first reader: %v", err)
+	}
+	defer reader1.Close()
+	reader2, err := NewDatabaseWithOptions(dbPath, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open second reader: %v", err)
+	}
+	defer reader2.Close()
+
+	if err := reader1.CreateTable("users", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly when changing a read-only database, got %v", err)
+	}
+	if _, err := NewDatabase(dbPath); !errors.Is(err, ErrDatabaseLocked) {
+		t.Errorf("Expected ErrDatabaseLocked for writer while readers are open, got %v", err)
+	}
+}
+
+func TestCacheWriteBack(t *testing.T) {
+	for _, walPath := range []string{"", "test.wal"} {
+		t.Run("WAL "+walPath, func(t *testing.T) {
+			fs := vfs.NewMemFS()
+			opts := Options{FS: fs, WALPath: walPath}
+			db, err := NewDatabaseWithOptions("test.db", opts)
+			if err != nil {
+				t.Fatalf("Failed to create database: %v", err)
+			}
+			db.Cache.Capacity = 16
+
+			if err := db.CreateTable("t", []Column{
+				{Name: "id", DataType: TypeInteger},
+				{Name: "data", DataType: TypeVarchar, Length: 1500},
+			}); err != nil {
+				t.Fatalf("Failed to create table: %v", err)
+			}
+			// Two rows a page, so far more pages are dirtied than the cache holds
+			data := strings.Repeat("x", 1500)
+			for i := 0; i < 300; i++ {
+				if _, err := db.RecordManager.InsertRecord(db.Tables["t"], &Record{Values: []interface{}{i, data}}); err != nil {
+					t.Fatalf("Failed to insert record %d: %v", i, err)
+				}
+			}
+			if over := db.Cache.overflow(); over > 0 {
+				t.Errorf("Expected the cache to stay within its capacity, it holds %d pages more", over)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+
+			if db, err = NewDatabaseWithOptions("test.db", opts); err != nil {
+				t.Fatalf("Failed to reopen: %v", err)
+			}
+			defer db.Close()
+			if report, err := db.Check(); err != nil || !report.OK() {
+				t.Errorf("Check failed: %v %+v", err, report)
+			}
+			count := 0
+			if err := db.RecordManager.Scan(db.Tables["t"], func(RecordID, *Record) error {
+				count++
+				return nil
+			}); err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if count != 300 {
+				t.Errorf("Expected 300 rows after reopening, got %d", count)
+			}
+		})
+	}
+}
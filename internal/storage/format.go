@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
+)
+
+// The on-disk format is specified in FORMAT.md. The database file and its
+// write-ahead log carry the same format version: the file in its header page,
+// the log in its header. Files in another version are refused on open, and
+// `godb upgrade` rewrites older ones, see Upgrade.
+//
+// Page 0 is the header page and page 1 the first catalog page. Every page
+// starts with the page header of page_layout.go, whose type byte says what
+// the page holds.
+
+// FormatVersion is the version of the file format written by this build
+const FormatVersion = wal.FormatVersion
+
+const (
+	headerPageID    = 0 // page holding the file header
+	catalogRootPage = 1 // first page of the catalog
+)
+
+// ErrUnsupportedVersion is returned when a database file was written in a
+// format version this build does not read
+var ErrUnsupportedVersion = errors.New("unsupported file format version")
+
+// PageType says what a page holds. It is stored in byte OffsetPageType of
+// every page header.
+type PageType uint8
+
+const (
+	PageTypeUnknown  PageType = iota // zeroed page that was never written
+	PageTypeHeader                   // page 0, the file header
+	PageTypeCatalog                  // slotted page of the catalog
+	PageTypeHeap                     // slotted page of a table
+	PageTypeBTree                    // node of a B-tree index
+	PageTypeOverflow                 // reserved for values too large for one page, never written yet
+	PageTypeFree                     // page owned by no table, on the free list
+	PageTypeHash                     // directory or bucket of a hash index
+)
+
+var pageTypeNames = map[PageType]string{
+	PageTypeUnknown:  "unknown",
+	PageTypeHeader:   "header",
+	PageTypeCatalog:  "catalog",
+	PageTypeHeap:     "heap",
+	PageTypeBTree:    "btree",
+	PageTypeOverflow: "overflow",
+	PageTypeFree:     "free",
+	PageTypeHash:     "hash",
+}
+
+func (t PageType) String() string {
+	if name, ok := pageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("PageType(%d)", uint8(t))
+}
+
+// MarshalText writes page types by name in JSON output
+func (t PageType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// FileHeader is the content of the header page after its page header
+type FileHeader struct {
+	Version  uint32 `json:"version"`   // format version of the file
+	PageSize uint16 `json:"page_size"` // size of every page in bytes
+}
+
+// Layout of the file header, little-endian like the rest of the page
+const (
+	offsetFileMagic    = PageHeaderSize
+	offsetFileVersion  = PageHeaderSize + 8
+	offsetFilePageSize = PageHeaderSize + 12
+)
+
+var fileMagic = [8]byte{'G', 'O', 'D', 'B', 'F', 'I', 'L', 'E'}
+
+// encodeHeaderPage returns the header page of a new file
+func encodeHeaderPage(pageSize uint16) []byte {
+	layout := NewPageLayout(pageSize)
+	layout.header.Type = PageTypeHeader
+	data := layout.Serialize()
+	copy(data[offsetFileMagic:], fileMagic[:])
+	binary.LittleEndian.PutUint32(data[offsetFileVersion:], FormatVersion)
+	binary.LittleEndian.PutUint16(data[offsetFilePageSize:], pageSize)
+	return data
+}
+
+// decodeFileHeader reads the file header from page 0. Files without one were
+// written before the header existed and are version 1.
+func decodeFileHeader(data []byte) FileHeader {
+	if len(data) < offsetFilePageSize+2 || !bytes.Equal(data[offsetFileMagic:offsetFileVersion], fileMagic[:]) {
+		return FileHeader{Version: 1, PageSize: uint16(len(data))}
+	}
+	return FileHeader{
+		Version:  binary.LittleEndian.Uint32(data[offsetFileVersion:]),
+		PageSize: binary.LittleEndian.Uint16(data[offsetFilePageSize:]),
+	}
+}
+
+// initHeaderPage writes page 0 of a new database
+func (db *Database) initHeaderPage() error {
+	page, err := db.allocatePage()
+	if err != nil {
+		return err
+	}
+	if page.ID != headerPageID {
+		return fmt.Errorf("header must be page %d, got page %d", headerPageID, page.ID)
+	}
+
+	page.Data = encodeHeaderPage(db.PageSize)
+	page.IsDirty = true
+	if err := db.logPageChange(page, nil, wal.LogTypeInsert); err != nil {
+		return err
+	}
+	return db.cachePage(page)
+}
+
+// checkFileHeader refuses files in a format version this build does not read
+func (db *Database) checkFileHeader() error {
+	page, err := db.GetPage(headerPageID)
+	if err != nil {
+		return fmt.Errorf("reading file header: %w", err)
+	}
+	page.latch.RLock()
+	header := decodeFileHeader(page.Data)
+	page.latch.RUnlock()
+
+	switch {
+	case header.Version < FormatVersion:
+		return fmt.Errorf("%w: %s is version %d, this build reads version %d; run godb upgrade",
+			ErrUnsupportedVersion, db.Path, header.Version, FormatVersion)
+	case header.Version > FormatVersion:
+		return fmt.Errorf("%w: %s is version %d, written by a newer build than this one (version %d)",
+			ErrUnsupportedVersion, db.Path, header.Version, FormatVersion)
+	case header.PageSize != db.PageSize:
+		return fmt.Errorf("%s has %d byte pages, expected %d", db.Path, header.PageSize, db.PageSize)
+	}
+	return nil
+}
+
+// FileVersion returns the format version of the database file at path, or
+// FormatVersion for a new, empty file
+func FileVersion(path string, opts Options) (uint32, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.OS
+	}
+	file, err := opts.FS.OpenFile(path, os.O_RDONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	data := make([]byte, offsetFilePageSize+2)
+	n, err := file.ReadAt(data, 0)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return FormatVersion, nil
+	}
+	if n < len(data) && err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	return decodeFileHeader(data[:n]).Version, nil
+}
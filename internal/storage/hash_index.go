@@ -0,0 +1,805 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sync"
+)
+
+// A HashIndex is an extendible hash index whose pages are pages of the
+// database, read through the cache and logged like B-tree nodes, see
+// hash_page.go. It answers only "which records have this key", but it does so
+// with a fixed number of page reads however large it grows.
+//
+// Keys are the same byte strings a B-tree uses, and are hashed to 64 bits.
+// The directory has 2^depth slots, where depth is the global depth, and the
+// low depth bits of a key's hash pick its slot. Each slot names a bucket. A
+// bucket has a local depth of its own, at most the global depth: all its keys
+// agree in their low local depth bits, and it is named by each of the
+// 2^(global-local) slots ending in those bits.
+//
+// A full bucket splits in two by the next bit of its keys' hashes, and only
+// the slots that named it change. When its local depth already equals the
+// global depth, the directory doubles first, each new slot naming the same
+// bucket as the slot it copies. A bucket whose keys all share those bits, such
+// as many records with one key, cannot be split; it grows a chain of overflow
+// pages instead. The directory stops doubling at maxHashDepth, after which
+// every full bucket overflows.
+//
+// Deletes give back what inserts took. An overflow page left empty is dropped
+// from its chain, an empty bucket is merged with its buddy, the bucket whose
+// slots differ from its own only in the highest bit, when the two have the
+// same depth, and merging goes on while the bucket left has an empty buddy.
+// The directory then halves while every bucket is named by both halves.
+//
+// The directory root is a page that never moves, like a B-tree's root, so the
+// catalog row is never rewritten. It holds the global depth and lists the
+// directory pages, which hold the slots. A lookup reads the root, one
+// directory page and the bucket.
+//
+// Each index is a catalog row of kind "hash index" holding its ID, name,
+// directory root, key size, uniqueness, table and key columns. A hash index
+// keeps no order, so its key columns are all ascending. Readers share the
+// index and writers hold it alone.
+
+// maxHashDepth is the largest global depth of a hash index, a directory of
+// 65536 slots in 130 pages of 4096 bytes
+const maxHashDepth = 16
+
+// HashIndex is an extendible hash index stored in database pages
+type HashIndex struct {
+	IndexInfo
+	Directory uint64   // page of the directory root
+	PageIDs   []uint64 // root, directory and bucket pages, in allocation order
+
+	keySize int // longest key in bytes
+	db      *Database
+	mu      sync.RWMutex // held shared by lookups and exclusively by changes
+	pagesMu sync.Mutex   // protects PageIDs while pages are added and freed
+}
+
+// HashIndexOptions controls how a hash index is created
+type HashIndexOptions struct {
+	KeySize      int         // longest key in bytes, 0 for DefaultKeySize
+	Columns      []KeyColumn // key columns, which must not be descending or order NULLs
+	Unique       bool        // refuse a second entry with the same key
+	TableID      uint64      // table whose rows the index covers
+	TableColumns []int       // column of the table behind each key column
+}
+
+// serialize writes the index as a record: ID, name, directory root, key size,
+// unique flag, table ID and column count, then the descending flag and NULL
+// order of each column, then the count and numbers of the table columns
+func (h *HashIndex) serialize() []byte {
+	values := []interface{}{int(h.ID), h.Name, int(h.Directory), h.keySize, h.Unique, int(h.TableID), len(h.Columns)}
+	for _, column := range h.Columns {
+		values = append(values, column.Descending, int(column.Nulls))
+	}
+	values = append(values, len(h.TableColumns))
+	for _, column := range h.TableColumns {
+		values = append(values, column)
+	}
+	return mustSerializeRecord(values)
+}
+
+func deserializeHashIndex(data []byte) (*HashIndex, error) {
+	record, err := DeserializeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	corrupt := errors.New("corrupt hash index metadata")
+	values := record.Values
+	if len(values) < 7 {
+		return nil, corrupt
+	}
+	h := &HashIndex{}
+	var id, directory, table, count int
+	var ok [7]bool
+	id, ok[0] = values[0].(int)
+	h.Name, ok[1] = values[1].(string)
+	directory, ok[2] = values[2].(int)
+	h.keySize, ok[3] = values[3].(int)
+	h.Unique, ok[4] = values[4].(bool)
+	table, ok[5] = values[5].(int)
+	count, ok[6] = values[6].(int)
+	for _, valid := range ok {
+		if !valid {
+			return nil, corrupt
+		}
+	}
+	if count < 0 || len(values) < 8+2*count {
+		return nil, corrupt
+	}
+	h.ID, h.Directory, h.TableID = uint64(id), uint64(directory), uint64(table)
+
+	for i := 0; i < count; i++ {
+		descending, descOK := values[7+2*i].(bool)
+		nulls, nullsOK := values[8+2*i].(int)
+		if !descOK || !nullsOK {
+			return nil, corrupt
+		}
+		h.Columns = append(h.Columns, KeyColumn{Descending: descending, Nulls: NullOrder(nulls)})
+	}
+
+	values = values[7+2*count:]
+	count, countOK := values[0].(int)
+	if !countOK || len(values) != 1+count {
+		return nil, corrupt
+	}
+	for _, value := range values[1:] {
+		column, ok := value.(int)
+		if !ok {
+			return nil, corrupt
+		}
+		h.TableColumns = append(h.TableColumns, column)
+	}
+	return h, nil
+}
+
+// CreateHashIndex adds an empty hash index: a root, one directory page and
+// one bucket, at global depth 0
+func (db *Database) CreateHashIndex(name string, opts HashIndexOptions) (*HashIndex, error) {
+	if db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if db.indexExists(name) {
+		return nil, fmt.Errorf("index %s already exists", name)
+	}
+	keySize := opts.KeySize
+	if keySize == 0 {
+		keySize = DefaultKeySize
+	}
+	if keySize < 0 || keySize > math.MaxUint16 || hashHeaderSize+2*(leafEntrySize+keySize) > int(db.PageSize) {
+		return nil, fmt.Errorf("hash index buckets of %d byte keys do not fit in a page", keySize)
+	}
+	for _, column := range opts.Columns {
+		if column != (KeyColumn{}) {
+			return nil, fmt.Errorf("hash index %s keeps no order, its columns cannot be descending or order NULLs", name)
+		}
+	}
+
+	h := &HashIndex{
+		IndexInfo: IndexInfo{
+			ID:           db.nextTableID,
+			Name:         name,
+			Columns:      opts.Columns,
+			Unique:       opts.Unique,
+			TableID:      opts.TableID,
+			TableColumns: opts.TableColumns,
+		},
+		keySize: keySize,
+		db:      db,
+	}
+	root, err := h.newHashPage(hashKindRoot)
+	if err != nil {
+		return nil, err
+	}
+	directory, err := h.newHashPage(hashKindDirectory)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := h.newHashPage(hashKindBucket)
+	if err != nil {
+		return nil, err
+	}
+	root.ids = []uint64{directory.pageID}
+	directory.ids = []uint64{bucket.pageID}
+	if err := h.writeHashPage(root); err != nil {
+		return nil, err
+	}
+	if err := h.writeHashPage(directory); err != nil {
+		return nil, err
+	}
+	h.Directory = root.pageID
+
+	if _, err := db.addCatalogEntry(catalogKindHashIndex, h.serialize()); err != nil {
+		return nil, err
+	}
+	db.nextTableID++
+	db.HashIndexes[name] = h
+	db.attachIndex(h)
+	return h, nil
+}
+
+// buildHashIndex fills a new, empty hash index with the rows of its table
+func (db *Database) buildHashIndex(table *Table, index *HashIndex) error {
+	return db.RecordManager.Scan(table, func(rid RecordID, record *Record) error {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return err
+		}
+		return index.Insert(key, rid)
+	})
+}
+
+// hashKey hashes a key with FNV-1a, then mixes the result so that its low
+// bits, which pick the directory slot, depend on every bit of the key
+func hashKey(key []byte) uint64 {
+	f := fnv.New64a()
+	f.Write(key)
+	h := f.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// depthMask returns the low depth bits of a hash
+func depthMask(depth int) uint64 {
+	return 1<<depth - 1
+}
+
+// directory reads the root and returns it with the bucket of every slot
+func (h *HashIndex) directory() (*hashPage, []uint64, error) {
+	root, err := h.readHashPage(h.Directory)
+	if err != nil {
+		return nil, nil, err
+	}
+	slots := make([]uint64, 0, 1<<root.depth)
+	for _, pageID := range root.ids {
+		page, err := h.readHashPage(pageID)
+		if err != nil {
+			return nil, nil, err
+		}
+		slots = append(slots, page.ids...)
+	}
+	if root.kind != hashKindRoot || len(slots) != 1<<root.depth {
+		return nil, nil, fmt.Errorf("index %s: directory has %d slots at depth %d", h.Name, len(slots), root.depth)
+	}
+	return root, slots, nil
+}
+
+// storeDirectory writes the slots back, given the slots before the change.
+// Only the directory pages whose slots changed are written; directory pages
+// are added or freed as the directory grows or shrinks.
+func (h *HashIndex) storeDirectory(root *hashPage, before, slots []uint64) error {
+	perPage := hashIDsPerPage(h.db.PageSize)
+	pages := (len(slots) + perPage - 1) / perPage
+	for len(root.ids) < pages {
+		page, err := h.newHashPage(hashKindDirectory)
+		if err != nil {
+			return err
+		}
+		root.ids = append(root.ids, page.pageID)
+	}
+
+	for n, pageID := range root.ids[:pages] {
+		lo, hi := n*perPage, min((n+1)*perPage, len(slots))
+		oldLo, oldHi := min(lo, len(before)), min((n+1)*perPage, len(before))
+		if slices.Equal(slots[lo:hi], before[oldLo:oldHi]) {
+			continue
+		}
+		page := &hashPage{pageID: pageID, kind: hashKindDirectory, ids: slots[lo:hi]}
+		if err := h.writeHashPage(page); err != nil {
+			return err
+		}
+	}
+
+	surplus := root.ids[pages:]
+	root.ids = root.ids[:pages]
+	if len(slots) != len(before) {
+		if err := h.writeHashPage(root); err != nil {
+			return err
+		}
+	}
+	for _, pageID := range surplus {
+		if err := h.freeHashPage(pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucket returns the pages of the bucket whose slot a hash picks, the bucket
+// first and then its overflow pages
+func (h *HashIndex) bucket(hash uint64) ([]*hashPage, error) {
+	root, err := h.readHashPage(h.Directory)
+	if err != nil {
+		return nil, err
+	}
+	slot := int(hash & depthMask(root.depth))
+	perPage := hashIDsPerPage(h.db.PageSize)
+	if root.kind != hashKindRoot || slot/perPage >= len(root.ids) {
+		return nil, fmt.Errorf("index %s: directory root does not list slot %d", h.Name, slot)
+	}
+	directory, err := h.readHashPage(root.ids[slot/perPage])
+	if err != nil {
+		return nil, err
+	}
+	if slot%perPage >= len(directory.ids) {
+		return nil, fmt.Errorf("index %s: directory page %d does not list slot %d", h.Name, directory.pageID, slot)
+	}
+	return h.chain(directory.ids[slot%perPage])
+}
+
+// chain reads a bucket and its overflow pages
+func (h *HashIndex) chain(pageID uint64) ([]*hashPage, error) {
+	var pages []*hashPage
+	for pageID != 0 {
+		page, err := h.readHashPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		if page.kind != hashKindBucket {
+			return nil, fmt.Errorf("index %s: page %d is not a bucket", h.Name, pageID)
+		}
+		if len(pages) > len(h.pages()) {
+			return nil, fmt.Errorf("index %s: overflow pages of bucket %d form a loop", h.Name, pages[0].pageID)
+		}
+		pages = append(pages, page)
+		pageID = page.next
+	}
+	return pages, nil
+}
+
+// entries returns every entry of a bucket's pages
+func entries(chain []*hashPage) ([][]byte, []RecordID) {
+	var keys [][]byte
+	var values []RecordID
+	for _, page := range chain {
+		keys = append(keys, page.keys...)
+		values = append(values, page.values...)
+	}
+	return keys, values
+}
+
+// writeChain packs entries into a bucket's pages, all of the given depth,
+// adding overflow pages as needed and freeing those left over. The bucket
+// itself always stays, even when it is left empty.
+func (h *HashIndex) writeChain(chain []*hashPage, depth int, keys [][]byte, values []RecordID) error {
+	fill := []*hashPage{{kind: hashKindBucket, depth: depth}}
+	for i, key := range keys {
+		last := fill[len(fill)-1]
+		if last.size()+leafEntrySize+len(key) > int(h.db.PageSize) {
+			last = &hashPage{kind: hashKindBucket, depth: depth}
+			fill = append(fill, last)
+		}
+		last.keys = append(last.keys, key)
+		last.values = append(last.values, values[i])
+	}
+	for len(chain) < len(fill) {
+		page, err := h.newHashPage(hashKindBucket)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, page)
+	}
+
+	for i, page := range fill {
+		page.pageID = chain[i].pageID
+		if i+1 < len(fill) {
+			page.next = chain[i+1].pageID
+		}
+		if err := h.writeHashPage(page); err != nil {
+			return err
+		}
+	}
+	for _, page := range chain[len(fill):] {
+		if err := h.freeHashPage(page.pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search looks up a key and returns the record ID stored with it. When
+// several records share the key it returns the smallest record ID; SearchAll
+// returns them all.
+func (h *HashIndex) Search(key []byte) (RecordID, bool, error) {
+	rids, err := h.SearchAll(key)
+	if err != nil || len(rids) == 0 {
+		return RecordID{}, false, err
+	}
+	return rids[0], true, nil
+}
+
+// SearchAll returns the record IDs stored with a key, in ascending order
+func (h *HashIndex) SearchAll(key []byte) ([]RecordID, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	chain, err := h.bucket(hashKey(key))
+	if err != nil {
+		return nil, err
+	}
+	var rids []RecordID
+	for _, page := range chain {
+		for i, other := range page.keys {
+			if bytes.Equal(other, key) {
+				rids = append(rids, page.values[i])
+			}
+		}
+	}
+	slices.SortFunc(rids, func(a, b RecordID) int { return compareEntries(nil, a, nil, b) })
+	return rids, nil
+}
+
+// Insert adds a key and the record ID stored with it. A key may be stored
+// with any number of record IDs, but only once with each; a unique index
+// returns a *DuplicateKeyError for a key it holds with any record ID.
+func (h *HashIndex) Insert(key []byte, value RecordID) error {
+	if h.db.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) > h.keySize {
+		return fmt.Errorf("index %s: key of %d bytes is longer than %d", h.Name, len(key), h.keySize)
+	}
+	key = slices.Clone(key)
+	hash := hashKey(key)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	chain, err := h.bucket(hash)
+	if err != nil {
+		return err
+	}
+	for _, page := range chain {
+		for i, other := range page.keys {
+			if !bytes.Equal(other, key) {
+				continue
+			}
+			if page.values[i] == value {
+				return fmt.Errorf("index %s: key %s is already stored with record %v", h.Name, formatKey(h.Columns, key), value)
+			}
+			if h.Unique && !h.hasNull(key) {
+				return &DuplicateKeyError{Index: h.Name, Key: formatKey(h.Columns, key)}
+			}
+		}
+	}
+
+	for {
+		for _, page := range chain {
+			if page.size()+leafEntrySize+len(key) <= int(h.db.PageSize) {
+				page.keys = append(page.keys, key)
+				page.values = append(page.values, value)
+				return h.writeHashPage(page)
+			}
+		}
+		if chain[0].depth == maxHashDepth || !splits(chain, hash) {
+			break
+		}
+		if err := h.split(chain); err != nil {
+			return err
+		}
+		if chain, err = h.bucket(hash); err != nil {
+			return err
+		}
+	}
+
+	// Splitting cannot make room, so the entry goes to a new overflow page
+	last := chain[len(chain)-1]
+	page, err := h.newHashPage(hashKindBucket)
+	if err != nil {
+		return err
+	}
+	page.depth = last.depth
+	page.keys, page.values = [][]byte{key}, []RecordID{value}
+	if err := h.writeHashPage(page); err != nil {
+		return err
+	}
+	last.next = page.pageID
+	return h.writeHashPage(last)
+}
+
+// splits reports whether splitting a bucket down to maxHashDepth would part
+// any of its keys from a new key's hash
+func splits(chain []*hashPage, hash uint64) bool {
+	mask := depthMask(maxHashDepth)
+	for _, page := range chain {
+		for _, key := range page.keys {
+			if hashKey(key)&mask != hash&mask {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// split moves the entries of a bucket whose next hash bit is set to a new
+// bucket, doubling the directory first if the bucket's depth is the global
+// depth
+func (h *HashIndex) split(chain []*hashPage) error {
+	root, slots, err := h.directory()
+	if err != nil {
+		return err
+	}
+	before := slices.Clone(slots)
+	depth := chain[0].depth
+	if depth == root.depth {
+		slots = append(slots, slots...)
+		root.depth++
+	}
+
+	bit := uint64(1) << depth
+	keys, values := entries(chain)
+	var stayKeys, movedKeys [][]byte
+	var stayValues, movedValues []RecordID
+	for i, key := range keys {
+		if hashKey(key)&bit != 0 {
+			movedKeys, movedValues = append(movedKeys, key), append(movedValues, values[i])
+		} else {
+			stayKeys, stayValues = append(stayKeys, key), append(stayValues, values[i])
+		}
+	}
+	sibling, err := h.newHashPage(hashKindBucket)
+	if err != nil {
+		return err
+	}
+	if err := h.writeChain([]*hashPage{sibling}, depth+1, movedKeys, movedValues); err != nil {
+		return err
+	}
+	if err := h.writeChain(chain, depth+1, stayKeys, stayValues); err != nil {
+		return err
+	}
+
+	for slot, pageID := range slots {
+		if pageID == chain[0].pageID && uint64(slot)&bit != 0 {
+			slots[slot] = sibling.pageID
+		}
+	}
+	return h.storeDirectory(root, before, slots)
+}
+
+// Delete removes the entry of a key and record ID and reports whether it was
+// there
+func (h *HashIndex) Delete(key []byte, value RecordID) (bool, error) {
+	if h.db.ReadOnly {
+		return false, ErrReadOnly
+	}
+	hash := hashKey(key)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	chain, err := h.bucket(hash)
+	if err != nil {
+		return false, err
+	}
+	keys, values := entries(chain)
+	i := slices.IndexFunc(keys, func(other []byte) bool { return bytes.Equal(other, key) })
+	for i >= 0 && values[i] != value {
+		next := slices.IndexFunc(keys[i+1:], func(other []byte) bool { return bytes.Equal(other, key) })
+		if next < 0 {
+			i = -1
+		} else {
+			i += 1 + next
+		}
+	}
+	if i < 0 {
+		return false, nil
+	}
+
+	keys, values = slices.Delete(keys, i, i+1), slices.Delete(values, i, i+1)
+	if err := h.writeChain(chain, chain[0].depth, keys, values); err != nil {
+		return true, err
+	}
+	if len(keys) == 0 {
+		return true, h.merge(hash)
+	}
+	return true, nil
+}
+
+// merge folds the bucket holding a hash into its buddy while one of the two
+// is empty and both have the same depth, and then halves the directory as
+// far as it can. Merging repeats on the bucket that is left, so emptying an
+// index brings it back to a single bucket.
+func (h *HashIndex) merge(hash uint64) error {
+	root, slots, err := h.directory()
+	if err != nil {
+		return err
+	}
+	before := slices.Clone(slots)
+	for {
+		chain, err := h.chain(slots[hash&depthMask(root.depth)])
+		if err != nil {
+			return err
+		}
+		depth := chain[0].depth
+		if depth == 0 {
+			break
+		}
+		buddy, err := h.chain(slots[(hash&depthMask(depth))^(1<<(depth-1))])
+		if err != nil {
+			return err
+		}
+		if buddy[0].depth != depth {
+			break
+		}
+		// An empty bucket is a single page, writeChain drops its overflow
+		kept, dropped := buddy, chain
+		if len(chain) > 1 || len(chain[0].keys) > 0 {
+			kept, dropped = chain, buddy
+		}
+		if len(dropped) > 1 || len(dropped[0].keys) > 0 {
+			break
+		}
+
+		for slot, pageID := range slots {
+			if pageID == dropped[0].pageID {
+				slots[slot] = kept[0].pageID
+			}
+		}
+		for _, page := range kept {
+			page.depth = depth - 1
+			if err := h.writeHashPage(page); err != nil {
+				return err
+			}
+		}
+		if err := h.freeHashPage(dropped[0].pageID); err != nil {
+			return err
+		}
+	}
+
+	for root.depth > 0 {
+		half := len(slots) / 2
+		if !slices.Equal(slots[:half], slots[half:]) {
+			break
+		}
+		slots = slots[:half]
+		root.depth--
+	}
+	return h.storeDirectory(root, before, slots)
+}
+
+// Depth returns the global depth of the directory
+func (h *HashIndex) Depth() (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	root, err := h.readHashPage(h.Directory)
+	if err != nil {
+		return 0, err
+	}
+	return root.depth, nil
+}
+
+// count returns how many entries the index holds
+func (h *HashIndex) count() (int, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, slots, err := h.directory()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	counted := make(map[uint64]bool)
+	for _, pageID := range slots {
+		if counted[pageID] {
+			continue
+		}
+		counted[pageID] = true
+		chain, err := h.chain(pageID)
+		if err != nil {
+			return 0, err
+		}
+		for _, page := range chain {
+			n += len(page.keys)
+		}
+	}
+	return n, nil
+}
+
+// keyLimit returns the longest key the index takes
+func (h *HashIndex) keyLimit() int {
+	return h.keySize
+}
+
+// Check walks the whole index and returns every violation of its invariants:
+// the directory's size, the slots naming each bucket, the hashes of each
+// bucket's keys, duplicate entries, and pages that are reached twice or not
+// at all. An empty result means the index is valid.
+func (h *HashIndex) Check() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	seen := map[uint64]bool{h.Directory: true}
+	root, err := h.readHashPage(h.Directory)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if root.kind != hashKindRoot {
+		problem("page %d: directory root has kind %d", root.pageID, root.kind)
+	}
+	if root.depth > maxHashDepth {
+		problem("page %d: global depth %d is above %d", root.pageID, root.depth, maxHashDepth)
+		return problems
+	}
+	var slots []uint64
+	for _, pageID := range root.ids {
+		if seen[pageID] {
+			problem("page %d: directory page is listed twice", pageID)
+			continue
+		}
+		seen[pageID] = true
+		page, err := h.readHashPage(pageID)
+		if err != nil {
+			problem("%v", err)
+			return problems
+		}
+		if page.kind != hashKindDirectory {
+			problem("page %d: directory page has kind %d", pageID, page.kind)
+		}
+		slots = append(slots, page.ids...)
+	}
+	if len(slots) != 1<<root.depth {
+		problem("page %d: directory has %d slots, expected %d at depth %d", root.pageID, len(slots), 1<<root.depth, root.depth)
+		return problems
+	}
+
+	named := make(map[uint64][]int) // slots naming each bucket
+	var buckets []uint64
+	for slot, pageID := range slots {
+		if named[pageID] == nil {
+			buckets = append(buckets, pageID)
+		}
+		named[pageID] = append(named[pageID], slot)
+	}
+	for _, pageID := range buckets {
+		if seen[pageID] {
+			problem("page %d: bucket is also a directory page", pageID)
+			continue
+		}
+		chain, err := h.chain(pageID)
+		if err != nil {
+			problem("%v", err)
+			continue
+		}
+		depth := chain[0].depth
+		mask := depthMask(depth)
+		low := uint64(named[pageID][0]) & mask
+		if depth > root.depth {
+			problem("page %d: local depth %d is above the global depth %d", pageID, depth, root.depth)
+		} else if len(named[pageID]) != 1<<(root.depth-depth) {
+			problem("page %d: bucket of depth %d is named by %d slots, expected %d", pageID, depth, len(named[pageID]), 1<<(root.depth-depth))
+		}
+		for _, slot := range named[pageID] {
+			if uint64(slot)&mask != low {
+				problem("page %d: bucket of depth %d is named by slots %d and %d", pageID, depth, named[pageID][0], slot)
+				break
+			}
+		}
+
+		held := make(map[string]bool)   // keys of the bucket
+		stored := make(map[string]bool) // keys of the bucket with their record IDs
+		for _, page := range chain {
+			if seen[page.pageID] {
+				problem("page %d: bucket page is reached twice", page.pageID)
+				continue
+			}
+			seen[page.pageID] = true
+			if page.depth != depth {
+				problem("page %d: overflow page has depth %d, its bucket %d", page.pageID, page.depth, depth)
+			}
+			for i, key := range page.keys {
+				if len(key) > h.keySize {
+					problem("page %d: key of %d bytes is longer than %d", page.pageID, len(key), h.keySize)
+				}
+				if hashKey(key)&mask != low {
+					problem("page %d: key %s does not hash to the bucket", page.pageID, formatKey(h.Columns, key))
+				}
+				entry := fmt.Sprintf("%x %v", key, page.values[i])
+				if stored[entry] {
+					problem("page %d: key %s is stored twice with record %v", page.pageID, formatKey(h.Columns, key), page.values[i])
+				}
+				if h.Unique && !h.hasNull(key) && held[string(key)] {
+					problem("page %d: unique key %s is stored more than once", page.pageID, formatKey(h.Columns, key))
+				}
+				stored[entry], held[string(key)] = true, true
+			}
+		}
+	}
+
+	for _, pageID := range h.pages() {
+		if !seen[pageID] {
+			problem("page %d: listed page is not reachable from the directory", pageID)
+		}
+	}
+	return problems
+}
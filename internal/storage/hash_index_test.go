@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestHashIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	index, err := db.CreateHashIndex("sessions", HashIndexOptions{KeySize: 16})
+	if err != nil {
+		t.Fatalf("Failed to create hash index: %v", err)
+	}
+	if _, err := db.CreateBTree("sessions", 0); err == nil {
+		t.Error("Expected a B-tree with the name of a hash index to be refused")
+	}
+	if _, err := db.CreateHashIndex("ordered", HashIndexOptions{Columns: []KeyColumn{{Descending: true}}}); err == nil {
+		t.Error("Expected a descending hash index to be refused")
+	}
+
+	// expectValid fails the test unless Check finds the index valid
+	expectValid := func(t *testing.T) {
+		t.Helper()
+		if problems := index.Check(); problems != nil {
+			t.Fatalf("Expected a valid index, got %v", problems)
+		}
+	}
+	depth := func() int {
+		d, err := index.Depth()
+		if err != nil {
+			t.Fatalf("Failed to read the depth: %v", err)
+		}
+		return d
+	}
+
+	const n = 3000
+	for i := 0; i < n; i++ {
+		if err := index.Insert(intKey(i), RecordID{uint64(i), 1}); err != nil {
+			t.Fatalf("Failed to insert %d: %v", i, err)
+		}
+	}
+	expectValid(t)
+	if d := depth(); d < 4 {
+		t.Errorf("Expected %d keys to split the directory, got depth %d", n, d)
+	}
+	for i := 0; i < n; i++ {
+		rid, found, err := index.Search(intKey(i))
+		if err != nil || !found || rid != (RecordID{uint64(i), 1}) {
+			t.Fatalf("Search %d: got %v %v %v", i, rid, found, err)
+		}
+	}
+	if _, found, _ := index.Search(intKey(n)); found {
+		t.Error("Expected a missing key not to be found")
+	}
+	if err := index.Insert(intKey(7), RecordID{7, 1}); err == nil {
+		t.Error("Expected an entry stored twice to be refused")
+	}
+
+	t.Run("Overflow", func(t *testing.T) {
+		// One key with more records than a bucket holds cannot be split
+		const shared = 600
+		key := intKey(-1)
+		for i := shared - 1; i >= 0; i-- {
+			if err := index.Insert(key, RecordID{uint64(i), 2}); err != nil {
+				t.Fatalf("Failed to insert record %d: %v", i, err)
+			}
+		}
+		expectValid(t)
+		rids, err := index.SearchAll(key)
+		if err != nil || len(rids) != shared || rids[0] != (RecordID{0, 2}) || rids[shared-1] != (RecordID{shared - 1, 2}) {
+			t.Fatalf("Expected %d record IDs in order, got %d: %v", shared, len(rids), err)
+		}
+		chain, err := index.bucket(hashKey(key))
+		if err != nil || len(chain) < 3 {
+			t.Errorf("Expected an overflow chain, got %d pages: %v", len(chain), err)
+		}
+
+		pages := len(index.PageIDs)
+		for i := 0; i < shared; i++ {
+			if found, err := index.Delete(key, RecordID{uint64(i), 2}); !found || err != nil {
+				t.Fatalf("Failed to delete record %d: %v %v", i, found, err)
+			}
+		}
+		expectValid(t)
+		if len(index.PageIDs) >= pages-1 {
+			t.Errorf("Expected the overflow pages to be freed, %d pages left of %d", len(index.PageIDs), pages)
+		}
+	})
+
+	t.Run("Unique", func(t *testing.T) {
+		unique, err := db.CreateHashIndex("tokens", HashIndexOptions{Unique: true})
+		if err != nil {
+			t.Fatalf("Failed to create hash index: %v", err)
+		}
+		key, _ := unique.Key("abc")
+		if err := unique.Insert(key, RecordID{1, 1}); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		var dup *DuplicateKeyError
+		if err := unique.Insert(key, RecordID{2, 1}); !errors.As(err, &dup) || dup.Index != "tokens" || dup.Key != "'abc'" {
+			t.Errorf("Expected a duplicate key 'abc' in tokens, got %v", err)
+		}
+		null, _ := unique.Key(nil)
+		for i := 0; i < 3; i++ {
+			if err := unique.Insert(null, RecordID{uint64(i), 1}); err != nil {
+				t.Errorf("Expected NULL keys to be allowed, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := n + g; i < n+1000; i += 4 {
+					if err := index.Insert(intKey(i), RecordID{uint64(i), 1}); err != nil {
+						t.Errorf("Insert failed: %v", err)
+						return
+					}
+					if _, found, err := index.Search(intKey(i - 4)); i-4 >= n && (!found || err != nil) {
+						t.Errorf("Search %d failed: %v %v", i-4, found, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		expectValid(t)
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer db.Close()
+	index = db.HashIndexes["sessions"]
+	if index == nil {
+		t.Fatal("Expected the hash index after reopening")
+	}
+	expectValid(t)
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+
+	// Deleting every key merges the buckets and halves the directory back
+	for i := 0; i < n+1000; i++ {
+		if found, err := index.Delete(intKey(i), RecordID{uint64(i), 1}); !found || err != nil {
+			t.Fatalf("Failed to delete %d: %v %v", i, found, err)
+		}
+	}
+	if found, err := index.Delete(intKey(0), RecordID{0, 1}); found || err != nil {
+		t.Errorf("Expected a deleted key to be gone, got %v %v", found, err)
+	}
+	expectValid(t)
+	if d := depth(); d != 0 || len(index.PageIDs) != 3 {
+		t.Errorf("Expected depth 0 in 3 pages, got depth %d in %d pages", d, len(index.PageIDs))
+	}
+}
+
+func TestCreateHashIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "session", DataType: TypeVarchar, Length: 32},
+	}
+	if err := db.CreateTable("sessions", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	sessions := db.Tables["sessions"]
+	rids := make(map[int]*RecordID)
+	for i := 0; i < 500; i++ {
+		rid, err := db.RecordManager.InsertRecord(sessions, &Record{Values: []interface{}{i, fmt.Sprintf("session-%d", i)}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids[i] = rid
+	}
+
+	if _, err := db.CreateIndex("by_session", "sessions", IndexOptions{Columns: []int{1}, Order: []KeyColumn{{Descending: true}}, Hash: true}); err == nil {
+		t.Error("Expected a descending hash index to be refused")
+	}
+	if db.HashIndexes["by_session"] != nil || len(sessions.Indexes) != 1 {
+		t.Fatal("Expected the refused index to leave nothing behind")
+	}
+	index, err := db.CreateIndex("by_session", "sessions", IndexOptions{Columns: []int{1}, Unique: true, Hash: true})
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if _, ok := index.(*HashIndex); !ok || len(sessions.Indexes) != 2 {
+		t.Fatalf("Expected a hash index on the table, got %T", index)
+	}
+
+	// lookup returns the ID of the row with a session, or -1
+	lookup := func(t *testing.T, session string) int {
+		t.Helper()
+		index := db.HashIndexes["by_session"]
+		key, _ := index.Key(session)
+		rid, found, err := index.Search(key)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if !found {
+			return -1
+		}
+		record, err := db.RecordManager.GetRecord(sessions, &rid)
+		if err != nil {
+			t.Fatalf("Search of %s returned %v: %v", session, rid, err)
+		}
+		return record.Values[0].(int)
+	}
+	if id := lookup(t, "session-42"); id != 42 {
+		t.Errorf("Expected row 42, got %d", id)
+	}
+
+	_, err = db.RecordManager.InsertRecord(sessions, &Record{Values: []interface{}{500, "session-42"}})
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("Expected a duplicate key error, got %v", err)
+	}
+	if err := db.RecordManager.UpdateRecord(sessions, rids[1], &Record{Values: []interface{}{1, "renewed"}}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if err := db.RecordManager.DeleteRecord(sessions, rids[2]); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if lookup(t, "session-1") != -1 || lookup(t, "renewed") != 1 || lookup(t, "session-2") != -1 {
+		t.Error("Expected the index to follow the update and delete")
+	}
+
+	for i := 10; i < 500; i++ {
+		if i%25 != 0 {
+			if err := db.RecordManager.DeleteRecord(sessions, rids[i]); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+		}
+	}
+	if report, err := db.Vacuum(); err != nil || report.RecordsMoved == 0 {
+		t.Fatalf("Expected VACUUM to move rows, got %+v %v", report, err)
+	}
+	if id := lookup(t, "session-475"); id != 475 {
+		t.Errorf("Expected row 475 after VACUUM, got %d", id)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer db.Close()
+	sessions = db.Tables["sessions"]
+	if len(sessions.Indexes) != 2 || lookup(t, "session-450") != 450 {
+		t.Fatalf("Expected the hash index on its table after reopening, got %v", sessions.Indexes)
+	}
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+
+	pages := db.PageCount()
+	if err := db.DropIndex("by_session"); err != nil {
+		t.Fatalf("Failed to drop: %v", err)
+	}
+	if db.HashIndexes["by_session"] != nil || len(sessions.Indexes) != 1 {
+		t.Error("Expected the hash index to be gone")
+	}
+	if _, err := db.CreateIndex("by_session", "sessions", IndexOptions{Columns: []int{1}, Hash: true}); err != nil {
+		t.Fatalf("Failed to create the index again: %v", err)
+	}
+	if db.PageCount() != pages {
+		t.Errorf("Expected the dropped pages to be reused, %d pages grew to %d", pages, db.PageCount())
+	}
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+}
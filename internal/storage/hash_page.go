@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"godb/internal/wal"
+)
+
+// A hash index page has type PageTypeHash and names the index as its owner.
+// What follows the page header depends on the page's kind:
+//
+//	offset 32  1 byte   page kind, 1 root, 2 directory or 3 bucket
+//	offset 33  3 bytes  reserved
+//	offset 36  4 bytes  global depth in the root, local depth in a bucket
+//	offset 40  4 bytes  count of page IDs or entries
+//	offset 44  4 bytes  reserved
+//	offset 48  8 bytes  next overflow page of a bucket, 0 for none
+//	offset 56           page IDs or entries
+//
+// The root lists the directory pages in order, and the directory pages list
+// the bucket of every directory slot, 8 bytes per page ID. A bucket holds
+// entries laid out like those of a B-tree leaf, a key and a record ID, in no
+// particular order. When a bucket cannot be split, more entries go to
+// overflow pages chained after it, which are buckets of the same depth.
+
+const (
+	hashOffsetKind  = PageHeaderSize      // root, directory or bucket
+	hashOffsetDepth = PageHeaderSize + 4  // global or local depth
+	hashOffsetCount = PageHeaderSize + 8  // number of page IDs or entries
+	hashOffsetNext  = PageHeaderSize + 16 // next overflow page
+	hashHeaderSize  = PageHeaderSize + 24
+
+	hashKindRoot      = 1
+	hashKindDirectory = 2
+	hashKindBucket    = 3
+)
+
+// hashPage is a decoded hash index page
+type hashPage struct {
+	pageID uint64
+	kind   byte
+	depth  int        // global depth of the root, local depth of a bucket
+	ids    []uint64   // the root's directory pages or a directory page's buckets
+	keys   [][]byte   // bucket only
+	values []RecordID // bucket only, one per key
+	next   uint64     // bucket only, the next overflow page or 0
+}
+
+// hashIDsPerPage is how many page IDs fit in a root or directory page
+func hashIDsPerPage(pageSize uint16) int {
+	return (int(pageSize) - hashHeaderSize) / 8
+}
+
+// size returns the bytes the page's content takes
+func (p *hashPage) size() int {
+	if p.kind != hashKindBucket {
+		return hashHeaderSize + 8*len(p.ids)
+	}
+	size := hashHeaderSize + len(p.keys)*leafEntrySize
+	for _, key := range p.keys {
+		size += len(key)
+	}
+	return size
+}
+
+// encode writes the page over data, a page owned by the index indexID. The
+// page LSN is left alone for logPageChange to stamp.
+func (p *hashPage) encode(data []byte, indexID uint64) {
+	clear(data[:OffsetPageLSN])
+	clear(data[hashOffsetKind:])
+	data[OffsetPageType] = byte(PageTypeHash)
+	binary.LittleEndian.PutUint64(data[OffsetTableID:], indexID)
+
+	data[hashOffsetKind] = p.kind
+	binary.LittleEndian.PutUint32(data[hashOffsetDepth:], uint32(p.depth))
+	offset := hashHeaderSize
+	if p.kind != hashKindBucket {
+		binary.LittleEndian.PutUint32(data[hashOffsetCount:], uint32(len(p.ids)))
+		for _, id := range p.ids {
+			binary.LittleEndian.PutUint64(data[offset:], id)
+			offset += 8
+		}
+		return
+	}
+	binary.LittleEndian.PutUint32(data[hashOffsetCount:], uint32(len(p.keys)))
+	binary.LittleEndian.PutUint64(data[hashOffsetNext:], p.next)
+	for i, key := range p.keys {
+		offset = putNodeEntry(data, offset, key, p.values[i])
+	}
+}
+
+// decodeHashPage reads the hash index page stored in data
+func decodeHashPage(pageID uint64, data []byte) (*hashPage, error) {
+	if PageType(data[OffsetPageType]) != PageTypeHash {
+		return nil, fmt.Errorf("page %d is a %s page, not a hash index page", pageID, PageType(data[OffsetPageType]))
+	}
+	p := &hashPage{
+		pageID: pageID,
+		kind:   data[hashOffsetKind],
+		depth:  int(binary.LittleEndian.Uint32(data[hashOffsetDepth:])),
+	}
+	count := int(binary.LittleEndian.Uint32(data[hashOffsetCount:]))
+	offset := hashHeaderSize
+
+	switch p.kind {
+	case hashKindRoot, hashKindDirectory:
+		if count > hashIDsPerPage(uint16(len(data))) {
+			return nil, fmt.Errorf("page %d: %d page IDs do not fit in a hash index page", pageID, count)
+		}
+		p.ids = make([]uint64, count)
+		for i := range p.ids {
+			p.ids[i] = binary.LittleEndian.Uint64(data[offset:])
+			offset += 8
+		}
+	case hashKindBucket:
+		if count > (len(data)-hashHeaderSize)/leafEntrySize {
+			return nil, fmt.Errorf("page %d: %d entries do not fit in a bucket", pageID, count)
+		}
+		p.next = binary.LittleEndian.Uint64(data[hashOffsetNext:])
+		p.keys = make([][]byte, count)
+		p.values = make([]RecordID, count)
+		for i := range p.keys {
+			if p.keys[i], p.values[i], offset = nodeEntry(data, offset, 0); offset < 0 {
+				return nil, fmt.Errorf("page %d: bucket entries run past the end of the page", pageID)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("page %d has unknown hash page kind %d", pageID, p.kind)
+	}
+	return p, nil
+}
+
+// readHashPage fetches and decodes a page of the index
+func (h *HashIndex) readHashPage(pageID uint64) (*hashPage, error) {
+	page, err := h.db.GetPage(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("index %s: %w", h.Name, err)
+	}
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+	if owner := binary.LittleEndian.Uint64(page.Data[OffsetTableID:]); owner != h.ID {
+		return nil, fmt.Errorf("index %s: page %d belongs to owner %d", h.Name, pageID, owner)
+	}
+	return decodeHashPage(pageID, page.Data)
+}
+
+// writeHashPage stores a page of the index and logs the change
+func (h *HashIndex) writeHashPage(p *hashPage) error {
+	page, err := h.db.GetPage(p.pageID)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", h.Name, err)
+	}
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	before := append([]byte(nil), page.Data...)
+	p.encode(page.Data, h.ID)
+	page.IsDirty = true
+	return h.db.logPageChange(page, before, wal.LogTypeUpdate)
+}
+
+// newHashPage allocates an empty page of the given kind and adds it to the
+// index's pages
+func (h *HashIndex) newHashPage(kind byte) (*hashPage, error) {
+	page, err := h.db.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	p := &hashPage{pageID: page.ID, kind: kind}
+	p.encode(page.Data, h.ID)
+	page.IsDirty = true
+	if err := h.db.logPageChange(page, nil, wal.LogTypeInsert); err != nil {
+		return nil, err
+	}
+
+	h.pagesMu.Lock()
+	h.PageIDs = append(h.PageIDs, page.ID)
+	h.pagesMu.Unlock()
+	if err := h.db.cachePage(page); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// freeHashPage returns a page the index no longer uses to the free list
+func (h *HashIndex) freeHashPage(pageID uint64) error {
+	if err := h.db.freePage(pageID, nil); err != nil {
+		return err
+	}
+	h.pagesMu.Lock()
+	defer h.pagesMu.Unlock()
+	h.PageIDs = slices.DeleteFunc(h.PageIDs, func(id uint64) bool { return id == pageID })
+	return nil
+}
+
+// pages returns a copy of the index's page list
+func (h *HashIndex) pages() []uint64 {
+	h.pagesMu.Lock()
+	defer h.pagesMu.Unlock()
+	return slices.Clone(h.PageIDs)
+}
+
+// decodePage reports whether a page of the index decodes
+func (h *HashIndex) decodePage(pageID uint64, data []byte) error {
+	_, err := decodeHashPage(pageID, data)
+	return err
+}
+
+// freePages frees every page of a dropped index
+func (h *HashIndex) freePages() error {
+	for _, pageID := range h.pages() {
+		if err := h.freeHashPage(pageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
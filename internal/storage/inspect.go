@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// inspect decodes pages for people looking at a database file. Unlike the
+// record manager it never fails on a damaged page: whatever does not decode is
+// reported next to the raw bytes.
+
+// PageInfo is a decoded page
+type PageInfo struct {
+	ID        uint64      `json:"id"`
+	Table     string      `json:"table,omitempty"` // name of the owning table or index, if known
+	Header    PageHeader  `json:"header"`
+	File      *FileHeader `json:"file,omitempty"` // only on the header page
+	Node      *NodeInfo   `json:"node,omitempty"` // only on B-tree pages
+	Hash      *HashInfo   `json:"hash,omitempty"` // only on hash index pages
+	FreeBytes uint32      `json:"free_bytes"`     // room left for records and slots, or node or bucket entries
+	Slots     []SlotInfo  `json:"slots"`
+	Data      []byte      `json:"-"` // copy of the raw page
+}
+
+// NodeInfo is a decoded B-tree node
+type NodeInfo struct {
+	Leaf     bool       `json:"leaf"`
+	Keys     []string   `json:"keys"`               // decoded with the index's columns, in hex if they do not decode
+	Values   []RecordID `json:"values,omitempty"`   // one per key, the separators' in an internal node
+	Children []uint64   `json:"children,omitempty"` // internal only
+	Next     uint64     `json:"next,omitempty"`     // leaf only, the next leaf in key order
+	Prev     uint64     `json:"prev,omitempty"`     // leaf only, the previous leaf in key order
+	Error    string     `json:"error,omitempty"`    // why the node did not decode
+}
+
+// HashInfo is a decoded hash index page
+type HashInfo struct {
+	Kind   string     `json:"kind"`             // root, directory or bucket
+	Depth  int        `json:"depth"`            // global depth of the root, local depth of a bucket
+	Pages  []uint64   `json:"pages,omitempty"`  // directory pages of the root, or the bucket of each slot
+	Keys   []string   `json:"keys,omitempty"`   // bucket only, decoded with the index's columns
+	Values []RecordID `json:"values,omitempty"` // bucket only, one per key
+	Next   uint64     `json:"next,omitempty"`   // bucket only, the next overflow page
+	Error  string     `json:"error,omitempty"`  // why the page did not decode
+}
+
+// SlotInfo is a decoded slot directory entry and the record it points to
+type SlotInfo struct {
+	Slot int `json:"slot"` // 1-based like RecordID.SlotNum
+	SlotEntry
+	Deleted bool          `json:"deleted"`
+	Values  []interface{} `json:"values,omitempty"`
+	Error   string        `json:"error,omitempty"` // why the record did not decode
+}
+
+// Raw returns the bytes the slot points to, clipped to the page
+func (s *SlotInfo) Raw(page *PageInfo) []byte {
+	start := min(int(s.Offset), len(page.Data))
+	end := min(start+int(s.Length), len(page.Data))
+	return page.Data[start:end]
+}
+
+// PageSummary describes one page in a table's page list. For an index page,
+// Slots and Live are both its number of keys, 0 on hash directory pages.
+type PageSummary struct {
+	ID        uint64 `json:"id"`
+	Slots     int    `json:"slots"`
+	Live      int    `json:"live"` // slots not marked deleted
+	FreeBytes uint32 `json:"free_bytes"`
+	LSN       uint64 `json:"lsn"`
+}
+
+// InspectPage decodes the header, slot directory and records of a page
+func (db *Database) InspectPage(pageID uint64) (*PageInfo, error) {
+	if pageID >= db.PageCount() {
+		return nil, fmt.Errorf("page %d does not exist, the database has %d pages", pageID, db.PageCount())
+	}
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PageInfo{ID: pageID, Data: make([]byte, db.PageSize)}
+	page.latch.RLock()
+	copy(info.Data, page.Data)
+	page.latch.RUnlock()
+
+	layout := DeserializePageLayout(info.Data)
+	info.Header = layout.header
+	if pageID == headerPageID {
+		header := decodeFileHeader(info.Data)
+		info.File = &header
+		return info, nil
+	}
+	if table := db.tableByID(layout.header.TableID); table != nil {
+		info.Table = table.Name
+	}
+	if index := db.indexByID(layout.header.TableID); index != nil {
+		info.Table = index.Info().Name
+	}
+	switch layout.header.Type {
+	case PageTypeBTree:
+		info.Node, info.FreeBytes = db.inspectNode(pageID, info.Data)
+		info.Slots = []SlotInfo{}
+		return info, nil
+	case PageTypeHash:
+		info.Hash, info.FreeBytes = db.inspectHashPage(pageID, info.Data)
+		info.Slots = []SlotInfo{}
+		return info, nil
+	}
+	info.FreeBytes = layout.getFreeSpace()
+
+	info.Slots = make([]SlotInfo, len(layout.slots))
+	for i, slot := range layout.slots {
+		s := &info.Slots[i]
+		s.Slot = i + 1
+		s.SlotEntry = slot
+		s.Deleted = slot.Flags&SlotFlagDeleted != 0
+		if s.Deleted && slot.Length == 0 {
+			// Compacted away by VACUUM
+			continue
+		}
+
+		raw := s.Raw(info)
+		if len(raw) != int(slot.Length) {
+			s.Error = "record runs past the end of the page"
+			continue
+		}
+		record, err := DeserializeRecord(raw)
+		if err != nil {
+			s.Error = err.Error()
+			continue
+		}
+		s.Values = record.Values
+	}
+	return info, nil
+}
+
+// inspectNode decodes a B-tree page and returns the node and its free bytes.
+// Keys are printed with the columns of the index owning the page, if known.
+func (db *Database) inspectNode(pageID uint64, data []byte) (*NodeInfo, uint32) {
+	node, err := decodeNode(pageID, data)
+	if err != nil {
+		return &NodeInfo{Error: err.Error()}, 0
+	}
+	info := &NodeInfo{
+		Leaf:     node.leaf,
+		Keys:     db.inspectKeys(data, node.keys),
+		Values:   node.values,
+		Children: node.children,
+		Next:     node.next,
+		Prev:     node.prev,
+	}
+	return info, uint32(len(data) - node.size())
+}
+
+// inspectHashPage decodes a hash index page and returns it and its free bytes
+func (db *Database) inspectHashPage(pageID uint64, data []byte) (*HashInfo, uint32) {
+	page, err := decodeHashPage(pageID, data)
+	if err != nil {
+		return &HashInfo{Error: err.Error()}, 0
+	}
+	info := &HashInfo{Kind: "bucket", Depth: page.depth, Pages: page.ids, Next: page.next}
+	switch page.kind {
+	case hashKindRoot:
+		info.Kind = "root"
+	case hashKindDirectory:
+		info.Kind = "directory"
+	default:
+		info.Keys, info.Values = db.inspectKeys(data, page.keys), page.values
+	}
+	return info, uint32(len(data) - page.size())
+}
+
+// inspectKeys formats the keys of an index page with the columns of the index
+// owning it, if known
+func (db *Database) inspectKeys(data []byte, keys [][]byte) []string {
+	var columns []KeyColumn
+	if index := db.indexByID(binary.LittleEndian.Uint64(data[OffsetTableID:])); index != nil {
+		columns = index.Info().Columns
+	}
+	formatted := make([]string, len(keys))
+	for i, key := range keys {
+		formatted[i] = formatKey(columns, key)
+	}
+	return formatted
+}
+
+// InspectTable summarizes every page of a table or index, in page list order.
+// The catalog can be inspected under its own name, godb_catalog.
+func (db *Database) InspectTable(name string) ([]PageSummary, error) {
+	table := db.Tables[name]
+	if name == db.catalog.Name {
+		table = db.catalog
+	}
+	if index, ok := db.index(name); table == nil && ok {
+		return db.inspectIndex(index)
+	}
+	if table == nil {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+
+	pages := table.pages()
+	summaries := make([]PageSummary, 0, len(pages))
+	for _, pageID := range pages {
+		page, err := db.GetPage(pageID)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageID, err)
+		}
+
+		page.latch.RLock()
+		layout := DeserializePageLayout(page.Data)
+		page.latch.RUnlock()
+
+		summary := PageSummary{
+			ID:        pageID,
+			Slots:     len(layout.slots),
+			FreeBytes: layout.getFreeSpace(),
+			LSN:       layout.header.LSN,
+		}
+		for _, slot := range layout.slots {
+			if slot.Flags&SlotFlagDeleted == 0 {
+				summary.Live++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// inspectIndex summarizes every node or hash page of an index
+func (db *Database) inspectIndex(index Index) ([]PageSummary, error) {
+	pageIDs := index.pages()
+	summaries := make([]PageSummary, 0, len(pageIDs))
+	for _, pageID := range pageIDs {
+		page, err := db.GetPage(pageID)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageID, err)
+		}
+
+		page.latch.RLock()
+		var keys int
+		var free uint32
+		if PageType(page.Data[OffsetPageType]) == PageTypeHash {
+			var hash *HashInfo
+			hash, free = db.inspectHashPage(pageID, page.Data)
+			keys = len(hash.Keys)
+		} else {
+			var node *NodeInfo
+			node, free = db.inspectNode(pageID, page.Data)
+			keys = len(node.Keys)
+		}
+		lsn := uint64(pageLSN(page.Data))
+		page.latch.RUnlock()
+
+		summaries = append(summaries, PageSummary{
+			ID:        pageID,
+			Slots:     keys,
+			Live:      keys,
+			FreeBytes: free,
+			LSN:       lsn,
+		})
+	}
+	return summaries, nil
+}
+
+// indexByID finds an index of either kind by its ID
+func (db *Database) indexByID(id uint64) Index {
+	for _, index := range db.checkedIndexes() {
+		if index.Info().ID == id {
+			return index
+		}
+	}
+	return nil
+}
+
+// tableByID finds a table, including the catalog, by its ID
+func (db *Database) tableByID(id uint64) *Table {
+	if id == db.catalog.ID {
+		return db.catalog
+	}
+	for _, table := range db.Tables {
+		if table.ID == id {
+			return table
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.CreateTable("users", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	for i := 0; i < 200; i++ {
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}}); err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+	}
+
+	summaries, err := db.InspectTable("users")
+	if err != nil {
+		t.Fatalf("InspectTable failed: %v", err)
+	}
+	if len(summaries) != len(users.PageIDs) {
+		t.Fatalf("Expected %d pages, got %d", len(users.PageIDs), len(summaries))
+	}
+	live := 0
+	for _, summary := range summaries {
+		live += summary.Live
+	}
+	if live != 200 {
+		t.Errorf("Expected 200 live slots, got %d", live)
+	}
+	if _, err := db.InspectTable(db.catalog.Name); err != nil {
+		t.Errorf("Failed to inspect the catalog: %v", err)
+	}
+
+	pageID := users.PageIDs[0]
+	info, err := db.InspectPage(pageID)
+	if err != nil {
+		t.Fatalf("InspectPage failed: %v", err)
+	}
+	if info.Table != "users" || info.Header.TableID != users.ID || len(info.Slots) != int(info.Header.SlotCount) {
+		t.Errorf("Unexpected page info %+v", info)
+	}
+	if info.Header.Type != PageTypeHeap || info.File != nil {
+		t.Errorf("Expected a heap page, got type %s", info.Header.Type)
+	}
+	first := info.Slots[0]
+	if first.Slot != 1 || first.Values[0] != 0 || first.Values[1] != "User 0" {
+		t.Errorf("Unexpected first slot %+v", first)
+	}
+
+	// A damaged record is reported, not fatal
+	page, _ := db.GetPage(pageID)
+	binary.LittleEndian.PutUint32(page.Data[PageHeaderSize:], uint32(db.PageSize)-2)
+	info, err = db.InspectPage(pageID)
+	if err != nil {
+		t.Fatalf("InspectPage failed on a damaged page: %v", err)
+	}
+	if info.Slots[0].Error == "" {
+		t.Error("Expected an error for a slot past the end of the page")
+	}
+
+	header, err := db.InspectPage(headerPageID)
+	if err != nil || header.Header.Type != PageTypeHeader || header.File == nil || header.File.Version != FormatVersion {
+		t.Errorf("Unexpected header page %+v, %v", header, err)
+	}
+
+	if _, err := db.InspectPage(db.PageCount()); err == nil {
+		t.Error("Expected inspecting a page past the end to fail")
+	}
+}
@@ -8,7 +8,7 @@ import (
 // manages how data is stored in a page. Contains header (metadata) and slots (data)
 const (
 	// Page layout constants
-	PageHeaderSize = 16 // Size of page header in bytes
+	PageHeaderSize = 32 // Size of page header in bytes
 	SlotEntrySize  = 8  // Size of each slot entry
 	MinRecordSize  = 4  // Minimum size of a record
 
@@ -16,22 +16,32 @@ const (
 	OffsetSlotCount  = 0  // Number of slots
 	OffsetFreeSpace  = 4  // Free space pointer
 	OffsetLastSlotID = 8  // Last used slot ID
-	OffsetFlags      = 12 // Flags/Reserved
+	OffsetPageType   = 12 // Page type, see PageType; bytes 13 to 15 are reserved
+	OffsetTableID    = 16 // Table that owns the page, 0 if none
+	OffsetPageLSN    = 24 // LSN of the last logged change to the page
+
+	// Slot flags
+	SlotFlagDeleted = 1 // record was deleted, its bytes are reclaimed by VACUUM
 )
 
+// errPageFull is returned when a record does not fit in the page's free space
+var errPageFull = errors.New("insufficient space in page")
+
 // SlotEntry represents an entry in the slot directory
 type SlotEntry struct {
-	Offset uint32 // Offset from start of page
-	Length uint16 // Length of record
-	Flags  uint16 // Record flags (e.g., deleted, overflow)
+	Offset uint32 `json:"offset"` // Offset from start of page
+	Length uint16 `json:"length"` // Length of record
+	Flags  uint16 `json:"flags"`  // Record flags (e.g., deleted, overflow)
 }
 
 // PageHeader represents the header section of a page
 type PageHeader struct {
-	SlotCount  uint32 // Number of slots in use
-	FreeSpace  uint32 // Pointer to start of free space
-	LastSlotID uint32 // ID of last used slot
-	Flags      uint32 // Page flags
+	SlotCount  uint32   `json:"slot_count"`   // Number of slots in use
+	FreeSpace  uint32   `json:"free_space"`   // Pointer to start of free space
+	LastSlotID uint32   `json:"last_slot_id"` // ID of last used slot
+	Type       PageType `json:"type"`         // What the page holds
+	TableID    uint64   `json:"table_id"`     // Owning table, lets the catalog find a table's pages on open
+	LSN        uint64   `json:"lsn"`          // Last WAL entry that changed the page, 0 without a WAL
 }
 
 // PageLayout manages the internal layout of a page
@@ -48,23 +58,26 @@ func NewPageLayout(pageSize uint16) *PageLayout {
 			SlotCount:  0,
 			FreeSpace:  uint32(pageSize - PageHeaderSize),
 			LastSlotID: 0,
-			Flags:      0,
+			Type:       PageTypeHeap,
 		},
 		slots: make([]SlotEntry, 0),
 		data:  make([]byte, pageSize),
 	}
 }
 
-// getFreeSpace calculates available free space
+// getFreeSpace calculates available free space between the slot directory and the records
 func (pl *PageLayout) getFreeSpace() uint32 {
-	usedBySlots := uint32(len(pl.slots) * SlotEntrySize)
+	usedBySlots := uint32(PageHeaderSize + len(pl.slots)*SlotEntrySize)
+	if usedBySlots > pl.header.FreeSpace {
+		return 0
+	}
 	return pl.header.FreeSpace - usedBySlots
 }
 
 // findFreeSlot finds space for a new record
 func (pl *PageLayout) findFreeSlot(recordSize uint16) (uint16, error) {
 	if pl.getFreeSpace() < uint32(recordSize+SlotEntrySize) {
-		return 0, errors.New("insufficient space in page")
+		return 0, errPageFull
 	}
 
 	// Find a suitable location in the page
@@ -85,13 +98,55 @@ func (pl *PageLayout) findFreeSlot(recordSize uint16) (uint16, error) {
 	return slotID, nil
 }
 
+// liveBytes returns the bytes used by live records and their slots
+func (pl *PageLayout) liveBytes() uint32 {
+	used := uint32(0)
+	for _, slot := range pl.slots {
+		if slot.Flags&SlotFlagDeleted == 0 {
+			used += uint32(slot.Length) + SlotEntrySize
+		}
+	}
+	return used
+}
+
+// compact moves the live records to the end of the page with no gaps between
+// them and drops deleted slots at the end of the directory. Live records keep
+// their slot numbers; deleted slots left in the middle point at the end of
+// the page with length zero.
+func (pl *PageLayout) compact() {
+	for n := len(pl.slots); n > 0 && pl.slots[n-1].Flags&SlotFlagDeleted != 0; n-- {
+		pl.slots = pl.slots[:n-1]
+	}
+
+	records := make([]byte, len(pl.data))
+	end := uint32(len(pl.data))
+	for i := range pl.slots {
+		slot := &pl.slots[i]
+		if slot.Flags&SlotFlagDeleted != 0 {
+			slot.Offset, slot.Length = uint32(len(pl.data)), 0
+			continue
+		}
+		end -= uint32(slot.Length)
+		copy(records[end:], pl.data[slot.Offset:slot.Offset+uint32(slot.Length)])
+		slot.Offset = end
+	}
+
+	clear(pl.data[PageHeaderSize:])
+	copy(pl.data[end:], records[end:])
+	pl.header.SlotCount = uint32(len(pl.slots))
+	pl.header.LastSlotID = uint32(len(pl.slots))
+	pl.header.FreeSpace = end
+}
+
 // Serialize converts the page layout to bytes
 func (pl *PageLayout) Serialize() []byte {
 	// Write header
 	binary.LittleEndian.PutUint32(pl.data[OffsetSlotCount:], pl.header.SlotCount)
 	binary.LittleEndian.PutUint32(pl.data[OffsetFreeSpace:], pl.header.FreeSpace)
 	binary.LittleEndian.PutUint32(pl.data[OffsetLastSlotID:], pl.header.LastSlotID)
-	binary.LittleEndian.PutUint32(pl.data[OffsetFlags:], pl.header.Flags)
+	pl.data[OffsetPageType] = byte(pl.header.Type)
+	binary.LittleEndian.PutUint64(pl.data[OffsetTableID:], pl.header.TableID)
+	binary.LittleEndian.PutUint64(pl.data[OffsetPageLSN:], pl.header.LSN)
 
 	// Write slot directory
 	slotOffset := PageHeaderSize
@@ -115,12 +170,16 @@ func DeserializePageLayout(data []byte) *PageLayout {
 	pl.header.SlotCount = binary.LittleEndian.Uint32(data[OffsetSlotCount:])
 	pl.header.FreeSpace = binary.LittleEndian.Uint32(data[OffsetFreeSpace:])
 	pl.header.LastSlotID = binary.LittleEndian.Uint32(data[OffsetLastSlotID:])
-	pl.header.Flags = binary.LittleEndian.Uint32(data[OffsetFlags:])
-
-	// Read slot directory
-	pl.slots = make([]SlotEntry, pl.header.SlotCount)
+	pl.header.Type = PageType(data[OffsetPageType])
+	pl.header.TableID = binary.LittleEndian.Uint64(data[OffsetTableID:])
+	pl.header.LSN = binary.LittleEndian.Uint64(data[OffsetPageLSN:])
+
+	// Read slot directory. A corrupt slot count cannot make us read past the
+	// page; Database.Check reports such pages.
+	slotCount := min(pl.header.SlotCount, uint32((len(data)-PageHeaderSize)/SlotEntrySize))
+	pl.slots = make([]SlotEntry, slotCount)
 	slotOffset := PageHeaderSize
-	for i := uint32(0); i < pl.header.SlotCount; i++ {
+	for i := uint32(0); i < slotCount; i++ {
 		pl.slots[i].Offset = binary.LittleEndian.Uint32(data[slotOffset:])
 		pl.slots[i].Length = binary.LittleEndian.Uint16(data[slotOffset+4:])
 		pl.slots[i].Flags = binary.LittleEndian.Uint16(data[slotOffset+6:])
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"godb/internal/wal"
+)
+
+// page_log connects page changes to the write-ahead log.
+//
+// A crash in the middle of writePage can leave a page half old and half new
+// (a torn page). Byte-range redo records cannot repair that, because they
+// assume the rest of the page is intact. So the first change to a page after
+// each checkpoint logs a full page image instead, and later changes log only
+// the bytes that moved. Recovery restores the image first and then replays the
+// small changes on top, which rebuilds the page no matter how it was torn.
+//
+// Images are restored unconditionally. Page checksums, once pages carry them,
+// only decide which pages are reported as torn; they do not change replay.
+
+// diffMergeGap joins changed byte ranges that are closer than this, so an
+// insert logs one record for the header and slot and one for the row data
+// instead of one per changed byte
+const diffMergeGap = 16
+
+// pageLSN reads the LSN of the last logged change from a page header
+func pageLSN(data []byte) wal.LSN {
+	return wal.LSN(binary.LittleEndian.Uint64(data[OffsetPageLSN:]))
+}
+
+// setPageLSN stamps a page header with the LSN of the change just logged.
+// The stamp is not part of the logged bytes; redo stamps the page the same way.
+func setPageLSN(data []byte, lsn wal.LSN) {
+	binary.LittleEndian.PutUint64(data[OffsetPageLSN:], uint64(lsn))
+}
+
+// byteRange is a half-open range of page offsets
+type byteRange struct {
+	start, end int
+}
+
+// logPageChange writes the change from before to page.Data to the WAL.
+// before is nil for a freshly allocated page. The caller must hold the page latch.
+func (db *Database) logPageChange(page *Page, before []byte, logType wal.LogType) error {
+	if db.wal == nil {
+		return nil
+	}
+
+	// Hold off a checkpoint from starting a new image epoch while we decide
+	// whether this page still needs its image
+	db.logMu.RLock()
+	defer db.logMu.RUnlock()
+
+	db.imagedMu.Lock()
+	_, imaged := db.imaged[page.ID]
+	db.imaged[page.ID] = struct{}{}
+	db.imagedMu.Unlock()
+
+	if !imaged || before == nil {
+		entry := &wal.LogEntry{
+			Type:   wal.LogTypeFullPage,
+			PageID: page.ID,
+			Record: wal.LogRecord{After: append([]byte(nil), page.Data...)},
+		}
+		if err := db.wal.Write(entry); err != nil {
+			return err
+		}
+		setPageLSN(page.Data, entry.LSN)
+		return nil
+	}
+
+	for _, r := range diffRanges(before, page.Data) {
+		entry := &wal.LogEntry{
+			Type:   logType,
+			PageID: page.ID,
+			Record: wal.LogRecord{
+				Offset: uint32(r.start),
+				Before: append([]byte(nil), before[r.start:r.end]...),
+				After:  append([]byte(nil), page.Data[r.start:r.end]...),
+			},
+		}
+		if err := db.wal.Write(entry); err != nil {
+			return err
+		}
+		setPageLSN(page.Data, entry.LSN)
+	}
+	return nil
+}
+
+// diffRanges returns the ranges where before and after differ
+func diffRanges(before, after []byte) []byteRange {
+	var ranges []byteRange
+	for i := 0; i < len(after); i++ {
+		if i < len(before) && before[i] == after[i] {
+			continue
+		}
+		if n := len(ranges); n > 0 && i-ranges[n-1].end < diffMergeGap {
+			ranges[n-1].end = i + 1
+		} else {
+			ranges = append(ranges, byteRange{start: i, end: i + 1})
+		}
+	}
+	return ranges
+}
+
+// Checkpoint flushes every dirty page and records a checkpoint in the WAL,
+// so recovery only has to replay what happens afterwards
+func (db *Database) Checkpoint() error {
+	_, err := db.checkpoint()
+	return err
+}
+
+// checkpoint takes a checkpoint and returns its redo point
+func (db *Database) checkpoint() (wal.LSN, error) {
+	if db.wal == nil {
+		return 0, db.Flush()
+	}
+
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+
+	// Start a new image epoch: changes logged from here on may reach pages
+	// after the flush below, so each page logs a fresh image first
+	db.logMu.Lock()
+	redoLSN := db.wal.CurrentLSN()
+	db.imagedMu.Lock()
+	db.imaged = make(map[uint64]struct{})
+	db.imagedMu.Unlock()
+	db.logMu.Unlock()
+
+	if err := db.Flush(); err != nil {
+		return 0, err
+	}
+	return redoLSN, db.wal.CreateCheckpointFrom(redoLSN)
+}
+
+// StartCheckpointScheduler takes checkpoints in the background when the WAL
+// grows by maxBytes or every interval, see wal.CheckpointPolicy
+func (db *Database) StartCheckpointScheduler(maxBytes int64, interval time.Duration, onError func(error)) error {
+	if db.wal == nil {
+		return errors.New("database has no write-ahead log")
+	}
+	db.wal.StartCheckpointScheduler(wal.CheckpointPolicy{
+		MaxBytes:   maxBytes,
+		Interval:   interval,
+		Checkpoint: db.Checkpoint,
+		OnError:    onError,
+	})
+	return nil
+}
+
+// recover replays the WAL after the last checkpoint onto the database file
+// and takes a new checkpoint so the replayed log is not needed again. Record
+// units the crash cut short are kept for finishUnits, which needs the catalog.
+func (db *Database) recover() error {
+	r := db.wal.StartRecovery()
+	if err := r.Recover(); err != nil {
+		return err
+	}
+	db.unfinished = r.Incomplete()
+
+	entries := r.RedoLog()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		page, err := db.pageForRedo(entry.PageID)
+		if err != nil {
+			return err
+		}
+
+		change := entry.Record
+		if entry.Type == wal.LogTypeFullPage {
+			copy(page.Data, change.After)
+		} else {
+			if int(change.Offset)+len(change.After) > len(page.Data) {
+				return errors.New("log record does not fit in page")
+			}
+			copy(page.Data[change.Offset:], change.After)
+		}
+		page.IsDirty = true
+		setPageLSN(page.Data, entry.LSN)
+	}
+
+	return db.Checkpoint()
+}
+
+// pageForRedo returns the page to replay changes onto. Pages past the end of
+// the file were never written before the crash and start out zeroed.
+func (db *Database) pageForRedo(pageID uint64) (*Page, error) {
+	page, err := db.GetPage(pageID)
+	if err == nil {
+		return page, nil
+	}
+	if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	page = &Page{ID: pageID, Data: make([]byte, db.PageSize)}
+	return page, db.cachePage(page)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestTornPageRecovery(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	table := NewTable("test_table", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	})
+	insert := func(from, to int) []*RecordID {
+		var rids []*RecordID
+		for i := from; i < to; i++ {
+			rid, err := db.RecordManager.InsertRecord(table, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}})
+			if err != nil {
+				t.Fatalf("Failed to insert record %d: %v", i, err)
+			}
+			rids = append(rids, rid)
+		}
+		return rids
+	}
+
+	rids := insert(0, 50)
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	rids = append(rids, insert(50, 100)...)
+
+	// Write the pages and then tear page 0: its second half goes back to zeros,
+	// as if the crash hit in the middle of the write
+	if _, err := db.FlushPages(0); err != nil {
+		t.Fatalf("Failed to flush pages: %v", err)
+	}
+	if _, err := db.File.WriteAt(make([]byte, db.PageSize/2), int64(db.PageSize/2)); err != nil {
+		t.Fatalf("Failed to tear page: %v", err)
+	}
+
+	// Crash: drop the handles without a checkpoint
+	db.wal.Close()
+	db.File.Close()
+
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	for i, rid := range rids {
+		record, err := db.RecordManager.GetRecord(table, rid)
+		if err != nil {
+			t.Fatalf("Failed to retrieve record %d after recovery: %v", i, err)
+		}
+		if record.Values[0] != i || record.Values[1] != fmt.Sprintf("User %d", i) {
+			t.Errorf("Record %d: unexpected values after recovery %v", i, record.Values)
+		}
+	}
+
+	if _, err := os.Stat("test.wal"); !os.IsNotExist(err) {
+		t.Errorf("Expected the WAL to stay on the in-memory file system, got %v", err)
+	}
+}
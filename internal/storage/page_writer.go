@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// PageWriter trickles dirty pages from the cache to disk in the background.
+// Every interval it writes at most pagesPerRound of the oldest dirty pages,
+// which spreads the I/O out instead of paying for it all at checkpoint or close.
+type PageWriter struct {
+	db            *Database
+	interval      time.Duration
+	pagesPerRound int
+	onError       func(error)
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// StartPageWriter starts the background writer. Starting a new writer stops
+// the one already running. onError may be nil.
+func (db *Database) StartPageWriter(interval time.Duration, pagesPerRound int, onError func(error)) *PageWriter {
+	db.StopPageWriter()
+
+	pw := &PageWriter{
+		db:            db,
+		interval:      interval,
+		pagesPerRound: pagesPerRound,
+		onError:       onError,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	db.writerMu.Lock()
+	db.pageWriter = pw
+	db.writerMu.Unlock()
+
+	go pw.run()
+	return pw
+}
+
+// StopPageWriter stops the background writer, if any, and waits for it to exit
+func (db *Database) StopPageWriter() {
+	db.writerMu.Lock()
+	pw := db.pageWriter
+	db.pageWriter = nil
+	db.writerMu.Unlock()
+
+	if pw != nil {
+		pw.Close()
+	}
+}
+
+func (pw *PageWriter) run() {
+	defer close(pw.done)
+
+	ticker := time.NewTicker(pw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.stop:
+			return
+		case <-ticker.C:
+			if _, err := pw.db.FlushPages(pw.pagesPerRound); err != nil && pw.onError != nil {
+				pw.onError(err)
+			}
+		}
+	}
+}
+
+// Close stops the writer and waits for the current round to finish
+func (pw *PageWriter) Close() {
+	pw.stopOnce.Do(func() { close(pw.stop) })
+	<-pw.done
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPageWriter(t *testing.T) {
+	dbPath := "test_writer.db"
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer os.Remove(dbPath)
+
+	table := NewTable("test_table", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	})
+	for i := 0; i < 200; i++ {
+		if _, err := db.RecordManager.InsertRecord(table, &Record{Values: []interface{}{i, "writer test"}}); err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+	}
+
+	db.StartPageWriter(10*time.Millisecond, 1, func(err error) {
+		t.Errorf("Background write failed: %v", err)
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(db.Cache.DirtyPages(0)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Background writer did not flush dirty pages")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if want := int64(len(table.PageIDs)) * int64(db.PageSize); info.Size() < want {
+		t.Errorf("Expected at least %d bytes on disk, got %d", want, info.Size())
+	}
+}
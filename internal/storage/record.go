@@ -1,6 +1,11 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"godb/internal/wal"
+)
 
 // manages how records are inserted, retrieved, and deleted from the database
 
@@ -27,35 +32,77 @@ func NewRecordManager(db *Database) *RecordManager {
 	}
 }
 
+// InsertRecord adds a record to the table and its indexes. A record whose key
+// a unique index already holds is not added, and the error wraps
+// ErrDuplicateKey. The unique indexes are searched before the row is written,
+// so a refused row leaves no dead slot behind. The primary key may not be
+// NULL. Should an index fail, the entries already added and the row are taken
+// back. The change is logged as one unit, see record_log.go.
 func (rm *RecordManager) InsertRecord(table *Table, record *Record) (*RecordID, error) {
+	if rm.db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := rm.db.checkDuplicateKeys(table); err != nil {
+		return nil, err
+	}
+	if err := table.checkPrimaryKey(record); err != nil {
+		return nil, err
+	}
+
 	// Serialize the record
 	recordData, err := SerializeRecord(record)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find a page with enough space
-	pageID, err := rm.findPageWithSpace(table, len(recordData))
-	if err != nil {
-		return nil, err
+	if limit := int(rm.db.PageSize) - PageHeaderSize - SlotEntrySize; len(recordData) > limit {
+		return nil, fmt.Errorf("record of %d bytes does not fit in a page, the limit is %d", len(recordData), limit)
 	}
 
-	// Get the page from cache or disk
-	page, err := rm.db.GetPage(pageID)
+	keys, err := uniqueKeys(table.Indexes, record)
 	if err != nil {
 		return nil, err
 	}
-
-	// Insert record into page
-	slotNum, err := rm.insertIntoPage(page, recordData)
-	if err != nil {
+	unlock := table.lockKeys(keys)
+	defer unlock()
+	if err := checkUniqueKeys(keys, RecordID{}); err != nil {
 		return nil, err
 	}
 
-	return &RecordID{
-		PageID:  pageID,
-		SlotNum: slotNum,
-	}, nil
+	// Find a page with enough space. Another insert may fill it before it is
+	// latched, and then the search starts again.
+	var rid RecordID
+	var unit *recordUnit
+	begin := func(rid RecordID) (err error) {
+		unit, err = rm.db.beginUnit(table, rid, nil, recordData)
+		return err
+	}
+	for {
+		page, err := rm.findPageWithSpace(table, len(recordData))
+		if err != nil {
+			return nil, err
+		}
+		slotNum, err := rm.insertIntoPage(page, recordData, begin)
+		if errors.Is(err, errPageFull) {
+			continue
+		}
+		if err != nil {
+			if unit != nil {
+				err = errors.Join(err, unit.undo(func() error { return nil }))
+			}
+			return nil, err
+		}
+		rid = RecordID{PageID: page.ID, SlotNum: slotNum}
+		break
+	}
+
+	if err := rm.insertIndexEntries(table.Indexes, record, rid); err != nil {
+		return nil, errors.Join(err, unit.undo(func() error {
+			_, err := rm.deleteFromPage(table, &rid)
+			return err
+		}))
+	}
+	return &rid, unit.commit()
 }
 
 func (rm *RecordManager) GetRecord(table *Table, rid *RecordID) (*Record, error) {
@@ -74,8 +121,286 @@ func (rm *RecordManager) GetRecord(table *Table, rid *RecordID) (*Record, error)
 	return record, nil
 }
 
+// DeleteRecord marks a record deleted and removes it from the table's
+// indexes. Its bytes stay in the page until VACUUM compacts it; the slot
+// number is not reused before then. Should an index fail, the entries already
+// removed and the record are put back. The change is logged as one unit, see
+// record_log.go.
+func (rm *RecordManager) DeleteRecord(table *Table, rid *RecordID) error {
+	if rm.db.ReadOnly {
+		return ErrReadOnly
+	}
+	data, err := rm.recordData(table, rid)
+	if err != nil {
+		return err
+	}
+	old, err := DeserializeRecord(data)
+	if err != nil {
+		return err
+	}
+	// Hold the unique keys so nobody takes them while they may be put back
+	keys, err := uniqueKeys(table.Indexes, old)
+	if err != nil {
+		return err
+	}
+	unlock := table.lockKeys(keys)
+	defer unlock()
+
+	unit, err := rm.db.beginUnit(table, *rid, data, nil)
+	if err != nil {
+		return err
+	}
+	record, err := rm.deleteFromPage(table, rid)
+	if err != nil {
+		return errors.Join(err, unit.undo(func() error { return nil }))
+	}
+	for i, index := range table.Indexes {
+		key, err := index.recordKey(record)
+		if err == nil {
+			_, err = index.Delete(key, *rid)
+		}
+		if err != nil {
+			return errors.Join(err, unit.undo(func() error {
+				if err := rm.insertIndexEntries(table.Indexes[:i], record, *rid); err != nil {
+					return err
+				}
+				return rm.restoreInPage(table, rid)
+			}))
+		}
+	}
+	return unit.commit()
+}
+
+// recordData returns a copy of the bytes of a live record
+func (rm *RecordManager) recordData(table *Table, rid *RecordID) ([]byte, error) {
+	page, err := rm.db.GetPage(rid.PageID)
+	if err != nil {
+		return nil, err
+	}
+
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+
+	layout := DeserializePageLayout(page.Data)
+	if layout.header.TableID != table.ID {
+		return nil, fmt.Errorf("page %d does not belong to table %s", rid.PageID, table.Name)
+	}
+	if rid.SlotNum == 0 || int(rid.SlotNum) > len(layout.slots) {
+		return nil, errors.New("invalid slot number")
+	}
+	slot := layout.slots[rid.SlotNum-1]
+	if slot.Flags&SlotFlagDeleted != 0 {
+		return nil, errors.New("record deleted")
+	}
+	return append([]byte(nil), page.Data[slot.Offset:slot.Offset+uint32(slot.Length)]...), nil
+}
+
+// deleteFromPage marks a record deleted in its page, leaving the indexes
+// alone, and returns it
+func (rm *RecordManager) deleteFromPage(table *Table, rid *RecordID) (*Record, error) {
+	page, err := rm.db.GetPage(rid.PageID)
+	if err != nil {
+		return nil, err
+	}
+
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	layout := DeserializePageLayout(page.Data)
+	if layout.header.TableID != table.ID {
+		return nil, fmt.Errorf("page %d does not belong to table %s", rid.PageID, table.Name)
+	}
+	if rid.SlotNum == 0 || int(rid.SlotNum) > len(layout.slots) {
+		return nil, errors.New("invalid slot number")
+	}
+	slot := &layout.slots[rid.SlotNum-1]
+	if slot.Flags&SlotFlagDeleted != 0 {
+		return nil, errors.New("record deleted")
+	}
+	record, err := DeserializeRecord(page.Data[slot.Offset : slot.Offset+uint32(slot.Length)])
+	if err != nil {
+		return nil, err
+	}
+
+	before := append([]byte(nil), page.Data...)
+	slot.Flags |= SlotFlagDeleted
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	return record, rm.db.logPageChange(page, before, wal.LogTypeDelete)
+}
+
+// restoreInPage takes back deleteFromPage, making a deleted record live again
+func (rm *RecordManager) restoreInPage(table *Table, rid *RecordID) error {
+	page, err := rm.db.GetPage(rid.PageID)
+	if err != nil {
+		return err
+	}
+
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	layout := DeserializePageLayout(page.Data)
+	if layout.header.TableID != table.ID {
+		return fmt.Errorf("page %d does not belong to table %s", rid.PageID, table.Name)
+	}
+	if rid.SlotNum == 0 || int(rid.SlotNum) > len(layout.slots) {
+		return errors.New("invalid slot number")
+	}
+	slot := &layout.slots[rid.SlotNum-1]
+	if slot.Flags&SlotFlagDeleted == 0 {
+		return nil
+	}
+
+	before := append([]byte(nil), page.Data...)
+	slot.Flags &^= SlotFlagDeleted
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	return rm.db.logPageChange(page, before, wal.LogTypeUpdate)
+}
+
+// UpdateRecord overwrites a record in place and updates the index entries
+// whose key changed. The new record must not be longer than the old one, and
+// a key a unique index already holds for another record leaves the record as
+// it was with an error wrapping ErrDuplicateKey. Should an index fail, the
+// entries already changed are put back. The change is logged as one unit, see
+// record_log.go.
+func (rm *RecordManager) UpdateRecord(table *Table, rid *RecordID, record *Record) error {
+	if rm.db.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := rm.db.checkDuplicateKeys(table); err != nil {
+		return err
+	}
+	if err := table.checkPrimaryKey(record); err != nil {
+		return err
+	}
+	recordData, err := SerializeRecord(record)
+	if err != nil {
+		return err
+	}
+	// Hold the new unique keys like an insert does, before the page latch
+	keys, err := uniqueKeys(table.Indexes, record)
+	if err != nil {
+		return err
+	}
+	unlock := table.lockKeys(keys)
+	defer unlock()
+	if err := checkUniqueKeys(keys, *rid); err != nil {
+		return err
+	}
+
+	page, err := rm.db.GetPage(rid.PageID)
+	if err != nil {
+		return err
+	}
+
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	layout := DeserializePageLayout(page.Data)
+	if layout.header.TableID != table.ID {
+		return fmt.Errorf("page %d does not belong to table %s", rid.PageID, table.Name)
+	}
+	if rid.SlotNum == 0 || int(rid.SlotNum) > len(layout.slots) {
+		return errors.New("invalid slot number")
+	}
+	slot := &layout.slots[rid.SlotNum-1]
+	if slot.Flags&SlotFlagDeleted != 0 {
+		return errors.New("record deleted")
+	}
+	if len(recordData) > int(slot.Length) {
+		return fmt.Errorf("record grew from %d to %d bytes", slot.Length, len(recordData))
+	}
+	oldData := append([]byte(nil), page.Data[slot.Offset:slot.Offset+uint32(slot.Length)]...)
+	old, err := DeserializeRecord(oldData)
+	if err != nil {
+		return err
+	}
+
+	unit, err := rm.db.beginUnit(table, *rid, oldData, recordData)
+	if err != nil {
+		return err
+	}
+	undoIndexes := func() error { return rm.setIndexEntries(table.Indexes, record, old, *rid) }
+	if err := rm.setIndexEntries(table.Indexes, old, record, *rid); err != nil {
+		return errors.Join(err, unit.undo(undoIndexes))
+	}
+	if err := rm.writeSlot(page, layout, slot, recordData); err != nil {
+		return errors.Join(err, unit.undo(func() error {
+			layout := DeserializePageLayout(page.Data)
+			if err := rm.writeSlot(page, layout, &layout.slots[rid.SlotNum-1], oldData); err != nil {
+				return err
+			}
+			return undoIndexes()
+		}))
+	}
+	return unit.commit()
+}
+
+// writeSlot overwrites the record in a slot of a page with data, which must
+// not be longer than the slot. The caller holds the page latch.
+func (rm *RecordManager) writeSlot(page *Page, layout *PageLayout, slot *SlotEntry, data []byte) error {
+	before := append([]byte(nil), page.Data...)
+	copy(page.Data[slot.Offset:], data)
+	slot.Length = uint16(len(data))
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	return rm.db.logPageChange(page, before, wal.LogTypeUpdate)
+}
+
+// Scan calls fn for every live record of the table, in page and slot order.
+// Records are read a page at a time, so fn may modify the table.
+func (rm *RecordManager) Scan(table *Table, fn func(rid RecordID, record *Record) error) error {
+	for _, pageID := range table.pages() {
+		page, err := rm.db.GetPage(pageID)
+		if err != nil {
+			return err
+		}
+
+		rids, records, err := rm.pageRecords(page)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", pageID, err)
+		}
+		for i := range records {
+			if err := fn(rids[i], records[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pageRecords decodes every live record stored in a page
+func (rm *RecordManager) pageRecords(page *Page) ([]RecordID, []*Record, error) {
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+
+	layout := DeserializePageLayout(page.Data)
+	var rids []RecordID
+	var records []*Record
+	for i, slot := range layout.slots {
+		if slot.Flags&SlotFlagDeleted != 0 {
+			continue
+		}
+		record, err := DeserializeRecord(page.Data[slot.Offset : slot.Offset+uint32(slot.Length)])
+		if err != nil {
+			return nil, nil, fmt.Errorf("slot %d: %w", i+1, err)
+		}
+		rids = append(rids, RecordID{PageID: page.ID, SlotNum: uint16(i + 1)})
+		records = append(records, record)
+	}
+	return rids, records, nil
+}
+
 // Helper methods
-func (rm *RecordManager) findPageWithSpace(table *Table, recordSize int) (uint64, error) {
+
+// findPageWithSpace returns a page of the table with room for a record, adding
+// one when none has. The table's mutex is held throughout, so concurrent
+// inserts that find the table full add a single page between them.
+func (rm *RecordManager) findPageWithSpace(table *Table, recordSize int) (*Page, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
 	// Check existing pages
 	for _, pageID := range table.PageIDs {
 		page, err := rm.db.GetPage(pageID)
@@ -83,33 +408,70 @@ func (rm *RecordManager) findPageWithSpace(table *Table, recordSize int) (uint64
 			continue
 		}
 
+		page.latch.RLock()
 		layout := DeserializePageLayout(page.Data)
+		page.latch.RUnlock()
 		if layout.getFreeSpace() >= uint32(recordSize+SlotEntrySize) {
-			return pageID, nil
+			return page, nil
 		}
 	}
 
 	// No existing page has enough space, create new page
-	newPage := &Page{
-		ID:      uint64(len(table.PageIDs)),
-		Data:    make([]byte, rm.db.PageSize),
-		IsDirty: true,
+	newPage, err := rm.formatTablePage(table)
+	if err != nil {
+		return nil, err
+	}
+	table.PageIDs = append(table.PageIDs, newPage.ID)
+	return newPage, nil
+}
+
+// newTablePage allocates an empty page, formats it for the table and adds it
+// to the table's page list
+func (rm *RecordManager) newTablePage(table *Table) (*Page, error) {
+	newPage, err := rm.formatTablePage(table)
+	if err != nil {
+		return nil, err
 	}
+	table.AddPage(newPage.ID)
+	return newPage, nil
+}
+
+// formatTablePage allocates an empty page and formats it for the table,
+// leaving the table's page list alone
+func (rm *RecordManager) formatTablePage(table *Table) (*Page, error) {
+	newPage, err := rm.db.allocatePage()
+	if err != nil {
+		return nil, err
+	}
+	newPage.IsDirty = true
 
 	// Initialize new page layout
 	layout := NewPageLayout(rm.db.PageSize)
+	layout.header.TableID = table.ID
+	if table.ID == catalogTableID {
+		layout.header.Type = PageTypeCatalog
+	}
 	newPage.Data = layout.Serialize()
-
-	// Add page to table
-	table.AddPage(newPage.ID)
+	if err := rm.db.logPageChange(newPage, nil, wal.LogTypeInsert); err != nil {
+		return nil, err
+	}
 
 	// Add page to cache
-	rm.db.Cache.Put(newPage)
-
-	return newPage.ID, nil
+	if err := rm.db.cachePage(newPage); err != nil {
+		return nil, err
+	}
+	return newPage, nil
 }
 
-func (rm *RecordManager) insertIntoPage(page *Page, recordData []byte) (uint16, error) {
+// insertIntoPage writes a record into a free slot of page. When begin is not
+// nil it is called with the record's ID once the slot is found, before the
+// page changes, to open the unit logging the insert.
+func (rm *RecordManager) insertIntoPage(page *Page, recordData []byte, begin func(RecordID) error) (uint16, error) {
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	before := append([]byte(nil), page.Data...)
+
 	// Get or create page layout
 	layout := DeserializePageLayout(page.Data)
 
@@ -118,6 +480,11 @@ func (rm *RecordManager) insertIntoPage(page *Page, recordData []byte) (uint16,
 	if err != nil {
 		return 0, err
 	}
+	if begin != nil {
+		if err := begin(RecordID{PageID: page.ID, SlotNum: slotNum}); err != nil {
+			return 0, err
+		}
+	}
 
 	// Write record data to page
 	slot := layout.slots[slotNum-1]
@@ -127,15 +494,21 @@ func (rm *RecordManager) insertIntoPage(page *Page, recordData []byte) (uint16,
 	page.Data = layout.Serialize()
 	page.IsDirty = true
 
+	if err := rm.db.logPageChange(page, before, wal.LogTypeInsert); err != nil {
+		return 0, err
+	}
 	return slotNum, nil
 }
 
 func (rm *RecordManager) extractRecord(page *Page, slotNum uint16, table *Table) (*Record, error) {
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+
 	// Get page layout
 	layout := DeserializePageLayout(page.Data)
 
 	// Validate slot number
-	if int(slotNum) > len(layout.slots) {
+	if slotNum == 0 || int(slotNum) > len(layout.slots) {
 		return nil, errors.New("invalid slot number")
 	}
 
@@ -143,7 +516,7 @@ func (rm *RecordManager) extractRecord(page *Page, slotNum uint16, table *Table)
 	slot := layout.slots[slotNum-1]
 
 	// Check if record is deleted
-	if slot.Flags&1 != 0 {
+	if slot.Flags&SlotFlagDeleted != 0 {
 		return nil, errors.New("record deleted")
 	}
 
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"godb/internal/wal"
+)
+
+// record_log makes an insert, a delete or an update, which changes a row in
+// its heap page and the pages of every index of its table, one unit in the
+// write-ahead log. A begin entry opens the unit: its page and offset name the
+// row, its before image holds the row's bytes, empty for an insert, and its
+// after image the new ones, empty for a delete. The page changes are logged as
+// usual after it, and a commit entry closes the unit once they are all made.
+// When an index fails part way, the changes made so far are taken back and an
+// abort entry closes the unit instead.
+//
+// A crash can cut a unit short between its pages, leaving a row changed in
+// the heap but not in an index or the other way round. Recovery replays every
+// page change as usual, and once the catalog is loaded finishes each unit
+// that has neither a commit nor an abort: it makes the heap and every index
+// match the row after the change and writes the commit. Finishing only looks
+// at the row and its keys, so running it again after another crash changes
+// nothing. A unit whose row holds neither the bytes before nor after the
+// change, like an insert whose row never reached its page, is not the row's
+// last change; it is closed with an abort and left alone.
+//
+// A bulk load opens a load unit for every page it appends rows to, naming the
+// page and the first slot it fills, and commits them all once its indexes are
+// filled. Recovery takes the rows of a load unit left open back out of the
+// table and its indexes instead of finishing it, so a load is all or nothing.
+//
+// VACUUM moves a row to another page as a move unit, which names the slot the
+// row leaves, holds its bytes as the before image and the record ID it moves
+// to as the after image. The row is written at its new place first, so
+// recovery finishes a move whose new slot holds the row: it deletes the old
+// one and points the index entries at the new one. Moves of catalog rows are
+// finished before the catalog is read, which would otherwise find them twice.
+//
+// Commits are not synced; should one be lost, recovery finishes its unit
+// again. Aborts are, or recovery would finish a change that was taken back,
+// and so are the commits of a load, which recovery would take back.
+
+// unitKind tells what a unit changes. It is kept in the high 16 bits of the
+// begin entry's offset, above the slot number.
+type unitKind uint32
+
+const (
+	unitRow  unitKind = iota // an insert, delete or update of one row
+	unitLoad                 // the rows a bulk load appends to one page
+	unitMove                 // VACUUM moving a row to another page
+)
+
+// recordUnit is a change being logged as one unit. It does nothing on a
+// database without a log, or for a table without indexes, whose rows change
+// in one page unless they move.
+type recordUnit struct {
+	db   *Database
+	txID uint64
+}
+
+// beginUnit logs the start of a change to the row of table at rid whose bytes
+// are old, nil for an insert. record holds the row's new bytes and is nil for
+// a delete.
+func (db *Database) beginUnit(table *Table, rid RecordID, old, record []byte) (*recordUnit, error) {
+	return db.openUnit(table, unitRow, rid, old, record)
+}
+
+// beginLoadUnit logs that a bulk load starts appending rows to the page of
+// rid, at its slot
+func (db *Database) beginLoadUnit(table *Table, rid RecordID) (*recordUnit, error) {
+	return db.openUnit(table, unitLoad, rid, nil, nil)
+}
+
+// beginMoveUnit logs that the row of table at from, whose bytes are record,
+// is moving to to
+func (db *Database) beginMoveUnit(table *Table, from, to RecordID, record []byte) (*recordUnit, error) {
+	target := make([]byte, 10)
+	binary.LittleEndian.PutUint64(target, to.PageID)
+	binary.LittleEndian.PutUint16(target[8:], to.SlotNum)
+	return db.openUnit(table, unitMove, from, record, target)
+}
+
+// openUnit writes the begin entry of a unit of the given kind
+func (db *Database) openUnit(table *Table, kind unitKind, rid RecordID, before, after []byte) (*recordUnit, error) {
+	if db.wal == nil || (len(table.Indexes) == 0 && kind != unitMove) {
+		return &recordUnit{}, nil
+	}
+	unit := &recordUnit{db: db, txID: db.units.Add(1)}
+	return unit, db.wal.Write(&wal.LogEntry{
+		TxID:   unit.txID,
+		Type:   wal.LogTypeBeginTx,
+		PageID: rid.PageID,
+		Record: wal.LogRecord{Offset: uint32(kind)<<16 | uint32(rid.SlotNum), Before: before, After: after},
+	})
+}
+
+// commit closes a unit whose changes are all made
+func (u *recordUnit) commit() error {
+	if u.db == nil {
+		return nil
+	}
+	return u.db.wal.WriteNoSync(&wal.LogEntry{TxID: u.txID, Type: wal.LogTypeCommitTx})
+}
+
+// undo takes the unit's changes back with fn and closes the unit with an
+// abort. Should fn fail, the unit stays open and the change is finished the
+// next time the database is opened.
+func (u *recordUnit) undo(fn func() error) error {
+	if err := fn(); err != nil {
+		return fmt.Errorf("taking the change back: %w", err)
+	}
+	if u.db == nil {
+		return nil
+	}
+	if err := u.db.wal.Write(&wal.LogEntry{TxID: u.txID, Type: wal.LogTypeAbortTx}); err != nil {
+		return err
+	}
+	return u.db.wal.Sync()
+}
+
+// finishUnits finishes the units recovery found cut short
+func (db *Database) finishUnits() error {
+	if len(db.unfinished) == 0 {
+		return nil
+	}
+	for _, begin := range db.unfinished {
+		if err := db.closeUnit(begin); err != nil {
+			return err
+		}
+	}
+	db.unfinished = nil
+	return db.wal.Sync()
+}
+
+// finishCatalogMoves finishes the moves of catalog rows recovery found cut
+// short. The catalog's pages are known, its rows not read yet.
+func (db *Database) finishCatalogMoves() error {
+	var rest []wal.LogEntry
+	for _, begin := range db.unfinished {
+		if unitKind(begin.Record.Offset>>16) != unitMove || !slices.Contains(db.catalog.PageIDs, begin.PageID) {
+			rest = append(rest, begin)
+			continue
+		}
+		if err := db.closeUnit(begin); err != nil {
+			return err
+		}
+	}
+	if len(rest) == len(db.unfinished) {
+		return nil
+	}
+	db.unfinished = rest
+	return db.wal.Sync()
+}
+
+// closeUnit finishes or takes back the unit begin opened and writes its
+// commit or abort
+func (db *Database) closeUnit(begin wal.LogEntry) error {
+	rid := RecordID{PageID: begin.PageID, SlotNum: uint16(begin.Record.Offset)}
+	var finished bool
+	var err error
+	switch kind := unitKind(begin.Record.Offset >> 16); kind {
+	case unitRow:
+		finished, err = db.RecordManager.finishUnit(rid, begin.Record.Before, begin.Record.After)
+	case unitLoad:
+		err = db.RecordManager.undoLoad(rid)
+	case unitMove:
+		if len(begin.Record.After) != 10 {
+			err = fmt.Errorf("move target of %d bytes", len(begin.Record.After))
+			break
+		}
+		to := RecordID{
+			PageID:  binary.LittleEndian.Uint64(begin.Record.After),
+			SlotNum: binary.LittleEndian.Uint16(begin.Record.After[8:]),
+		}
+		finished, err = db.RecordManager.finishMove(rid, to, begin.Record.Before)
+	default:
+		err = fmt.Errorf("unknown unit kind %d", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("finishing the change to record %v: %w", rid, err)
+	}
+	end := wal.LogTypeCommitTx
+	if !finished {
+		end = wal.LogTypeAbortTx
+	}
+	return db.wal.WriteNoSync(&wal.LogEntry{TxID: begin.TxID, Type: end})
+}
+
+// finishUnit makes the row at rid and its index entries match the end of an
+// insert of record, when old is empty, a delete, when record is empty, or an
+// update from old to record. It returns false, changing nothing, when the row
+// holds neither old nor record. An insert whose key a unique index holds for
+// another row is taken back instead, and false returned.
+func (rm *RecordManager) finishUnit(rid RecordID, old, record []byte) (bool, error) {
+	page, table, current, deleted, err := rm.slotContents(rid)
+	if err != nil || current == nil {
+		return false, err
+	}
+	if len(old) == 0 {
+		newRecord, err := DeserializeRecord(record)
+		if deleted || err != nil || !bytes.Equal(current, record) {
+			return false, nil
+		}
+		err = rm.addIndexEntries(table.Indexes, newRecord, rid)
+		if errors.Is(err, ErrDuplicateKey) {
+			// Another row holds the key; the insert cannot be finished
+			if _, err := rm.deleteFromPage(table, &rid); err != nil {
+				return false, err
+			}
+			return false, rm.deleteIndexEntries(table.Indexes, newRecord, rid)
+		}
+		return err == nil, err
+	}
+	oldRecord, err := DeserializeRecord(old)
+	if err != nil {
+		return false, nil
+	}
+	if len(record) == 0 {
+		if !bytes.Equal(current, old) {
+			return false, nil
+		}
+		if !deleted {
+			if _, err := rm.deleteFromPage(table, &rid); err != nil {
+				return false, err
+			}
+		}
+		return true, rm.deleteIndexEntries(table.Indexes, oldRecord, rid)
+	}
+
+	newRecord, err := DeserializeRecord(record)
+	if deleted || err != nil || len(record) > len(old) || (!bytes.Equal(current, old) && !bytes.Equal(current, record)) {
+		return false, nil
+	}
+	if err := rm.setIndexEntries(table.Indexes, oldRecord, newRecord, rid); err != nil {
+		return false, err
+	}
+	if bytes.Equal(current, record) {
+		return true, nil
+	}
+	page.latch.Lock()
+	defer page.latch.Unlock()
+	layout := DeserializePageLayout(page.Data)
+	return true, rm.writeSlot(page, layout, &layout.slots[rid.SlotNum-1], record)
+}
+
+// finishMove makes the row whose bytes are record live only at to, where it
+// moved from from, with its index entries pointing there. It returns false,
+// changing nothing, when to does not hold the row: the move never started.
+func (rm *RecordManager) finishMove(from, to RecordID, record []byte) (bool, error) {
+	_, table, current, deleted, err := rm.slotContents(to)
+	if err != nil || deleted || !bytes.Equal(current, record) {
+		return false, err
+	}
+	moved, err := DeserializeRecord(record)
+	if err != nil {
+		return false, nil
+	}
+	_, _, current, deleted, err = rm.slotContents(from)
+	if err != nil {
+		return false, err
+	}
+	if !deleted && bytes.Equal(current, record) {
+		if _, err := rm.deleteFromPage(table, &from); err != nil {
+			return false, err
+		}
+	}
+	if err := rm.deleteIndexEntries(table.Indexes, moved, from); err != nil {
+		return false, err
+	}
+	return true, rm.addIndexEntries(table.Indexes, moved, to)
+}
+
+// slotContents returns the page of rid, the table that owns it, a copy of the
+// bytes in the slot of rid and whether the slot is deleted. The bytes are nil
+// when there is no such page or slot, or no loaded table owns the page.
+func (rm *RecordManager) slotContents(rid RecordID) (*Page, *Table, []byte, bool, error) {
+	page, err := rm.db.GetPage(rid.PageID)
+	if errors.Is(err, io.EOF) {
+		return nil, nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+	layout := DeserializePageLayout(page.Data)
+	table := rm.db.tableByID(layout.header.TableID)
+	if table == nil || rid.SlotNum == 0 || int(rid.SlotNum) > len(layout.slots) {
+		return page, table, nil, false, nil
+	}
+	slot := layout.slots[rid.SlotNum-1]
+	end := slot.Offset + uint32(slot.Length)
+	if end > uint32(len(page.Data)) {
+		return page, table, nil, false, nil
+	}
+	return page, table, bytes.Clone(page.Data[slot.Offset:end]), slot.Flags&SlotFlagDeleted != 0, nil
+}
+
+// undoLoad takes the rows a bulk load appended to the page of rid, from its
+// slot on, back out of the table and its indexes. The entries go first, so
+// running it again after stopping part way finds the rows still there.
+func (rm *RecordManager) undoLoad(rid RecordID) error {
+	page, err := rm.db.GetPage(rid.PageID)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	page.latch.RLock()
+	layout := DeserializePageLayout(page.Data)
+	table := rm.db.tableByID(layout.header.TableID)
+	var rids []RecordID
+	var records []*Record
+	for i := int(rid.SlotNum); table != nil && i > 0 && i <= len(layout.slots); i++ {
+		slot := layout.slots[i-1]
+		end := slot.Offset + uint32(slot.Length)
+		if slot.Flags&SlotFlagDeleted != 0 || end > uint32(len(page.Data)) {
+			continue
+		}
+		record, err := DeserializeRecord(page.Data[slot.Offset:end])
+		if err != nil {
+			continue
+		}
+		rids = append(rids, RecordID{PageID: rid.PageID, SlotNum: uint16(i)})
+		records = append(records, record)
+	}
+	page.latch.RUnlock()
+
+	for i, loaded := range rids {
+		if err := rm.deleteIndexEntries(table.Indexes, records[i], loaded); err != nil {
+			return err
+		}
+		if _, err := rm.deleteFromPage(table, &loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
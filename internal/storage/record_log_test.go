@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+// failingIndex is a B-tree whose next Insert or Delete fails
+type failingIndex struct {
+	*BTree
+	failInsert, failDelete bool
+}
+
+func (f *failingIndex) Insert(key []byte, rid RecordID) error {
+	if f.failInsert {
+		f.failInsert = false
+		return errors.New("insert failed")
+	}
+	return f.BTree.Insert(key, rid)
+}
+
+func (f *failingIndex) Delete(key []byte, rid RecordID) (bool, error) {
+	if f.failDelete {
+		f.failDelete = false
+		return false, errors.New("delete failed")
+	}
+	return f.BTree.Delete(key, rid)
+}
+
+func TestRecordUnits(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.CreateTable("users", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 20},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("by_name", "users", IndexOptions{Columns: []int{1}}); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	var rids []RecordID
+	for i := 0; i < 20; i++ {
+		rid, err := db.RecordManager.InsertRecord(db.Tables["users"], &Record{Values: []interface{}{i, fmt.Sprintf("user %d", i)}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids = append(rids, *rid)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+
+	expectValid := func(t *testing.T) {
+		t.Helper()
+		if report, err := db.Check(); err != nil || !report.OK() {
+			t.Errorf("Check failed: %v %+v", err, report)
+		}
+	}
+	// crash drops the handles without flushing and opens the database again
+	crash := func(t *testing.T) {
+		t.Helper()
+		db.wal.Close()
+		db.File.Close()
+		if db, err = NewDatabaseWithOptions("test.db", opts); err != nil {
+			t.Fatalf("Failed to reopen: %v", err)
+		}
+	}
+	name := func(t *testing.T, i int) interface{} {
+		t.Helper()
+		record, err := db.RecordManager.GetRecord(db.Tables["users"], &rids[i])
+		if err != nil {
+			return err
+		}
+		return record.Values[1]
+	}
+
+	t.Run("Undo", func(t *testing.T) {
+		users := db.Tables["users"]
+		failing := &failingIndex{BTree: db.Indexes["by_name"], failDelete: true}
+		users.Indexes[1] = failing
+		defer func() { users.Indexes[1] = failing.BTree }()
+
+		if err := db.RecordManager.DeleteRecord(users, &rids[1]); err == nil {
+			t.Error("Expected the delete to fail")
+		}
+		failing.failInsert = true
+		if err := db.RecordManager.UpdateRecord(users, &rids[2], &Record{Values: []interface{}{2, "renamed"}}); err == nil {
+			t.Error("Expected the update to fail")
+		}
+		if name(t, 1) != "user 1" || name(t, 2) != "user 2" {
+			t.Errorf("Expected the rows to be put back, got %v and %v", name(t, 1), name(t, 2))
+		}
+		failing.failInsert = true
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{50, "user 50"}}); err == nil {
+			t.Error("Expected the insert to fail")
+		}
+		expectValid(t)
+
+		// The aborted changes stay taken back after a crash
+		crash(t)
+		if name(t, 1) != "user 1" || name(t, 2) != "user 2" {
+			t.Errorf("Expected the rows to stay after recovery, got %v and %v", name(t, 1), name(t, 2))
+		}
+		expectValid(t)
+	})
+
+	t.Run("Crash Mid Delete", func(t *testing.T) {
+		// Only the heap page changes before the crash
+		users := db.Tables["users"]
+		data, err := db.RecordManager.recordData(users, &rids[3])
+		if err != nil {
+			t.Fatalf("Failed to read the row: %v", err)
+		}
+		if _, err := db.beginUnit(users, rids[3], data, nil); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+		if _, err := db.RecordManager.deleteFromPage(users, &rids[3]); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		crash(t)
+		if _, ok := name(t, 3).(error); !ok {
+			t.Errorf("Expected row 3 to be deleted, got %v", name(t, 3))
+		}
+		expectValid(t)
+	})
+
+	t.Run("Crash Mid Update", func(t *testing.T) {
+		// Only the indexes change before the crash
+		users := db.Tables["users"]
+		data, err := db.RecordManager.recordData(users, &rids[4])
+		if err != nil {
+			t.Fatalf("Failed to read the row: %v", err)
+		}
+		old, _ := DeserializeRecord(data)
+		record := &Record{Values: []interface{}{40, "new 4"}}
+		newData, _ := SerializeRecord(record)
+		if _, err := db.beginUnit(users, rids[4], data, newData); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+		if err := db.RecordManager.setIndexEntries(users.Indexes, old, record, rids[4]); err != nil {
+			t.Fatalf("Failed to update the indexes: %v", err)
+		}
+
+		crash(t)
+		if name(t, 4) != "new 4" {
+			t.Errorf("Expected the update to be finished, got %v", name(t, 4))
+		}
+		expectValid(t)
+
+		// Finished units are closed and not finished again
+		crash(t)
+		if len(db.unfinished) != 0 || name(t, 4) != "new 4" {
+			t.Errorf("Expected no unit left open, got %v", db.unfinished)
+		}
+		expectValid(t)
+	})
+
+	t.Run("Crash Mid Insert", func(t *testing.T) {
+		// Only the heap page changes before the crash
+		users := db.Tables["users"]
+		data, _ := SerializeRecord(&Record{Values: []interface{}{41, "user 41"}})
+		page, err := db.RecordManager.findPageWithSpace(users, len(data))
+		if err != nil {
+			t.Fatalf("Failed to find a page: %v", err)
+		}
+		slotNum, err := db.RecordManager.insertIntoPage(page, data, func(rid RecordID) error {
+			_, err := db.beginUnit(users, rid, nil, data)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		rid := RecordID{PageID: page.ID, SlotNum: slotNum}
+
+		// A unit whose row never reached its page is left alone
+		if _, err := db.beginUnit(users, RecordID{PageID: page.ID, SlotNum: slotNum + 1}, nil, data); err != nil {
+			t.Fatalf("Failed to begin: %v", err)
+		}
+
+		crash(t)
+		users = db.Tables["users"]
+		if record, err := db.RecordManager.GetRecord(users, &rid); err != nil || record.Values[1] != "user 41" {
+			t.Errorf("Expected the row to stay, got %v %v", record, err)
+		}
+		expectValid(t)
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{41, "again"}}); !errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("Expected the key to be taken, got %v", err)
+		}
+		if _, err := db.RecordManager.GetRecord(users, &RecordID{PageID: page.ID, SlotNum: slotNum + 1}); err == nil {
+			t.Error("Expected no row in the slot after the inserted one")
+		}
+	})
+
+	// move writes the row at from to a new page of table, as VACUUM does,
+	// and stops there
+	move := func(t *testing.T, table *Table, from RecordID) RecordID {
+		t.Helper()
+		data, err := db.RecordManager.recordData(table, &from)
+		if err != nil {
+			t.Fatalf("Failed to read the row: %v", err)
+		}
+		page, err := db.RecordManager.newTablePage(table)
+		if err != nil {
+			t.Fatalf("Failed to add a page: %v", err)
+		}
+		slotNum, err := db.RecordManager.insertIntoPage(page, data, func(to RecordID) error {
+			_, err := db.beginMoveUnit(table, from, to, data)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		return RecordID{PageID: page.ID, SlotNum: slotNum}
+	}
+
+	t.Run("Crash Mid Move", func(t *testing.T) {
+		// The row is on both pages before the crash
+		to := move(t, db.Tables["users"], rids[5])
+
+		crash(t)
+		if _, ok := name(t, 5).(error); !ok {
+			t.Errorf("Expected the row to be gone from its old slot, got %v", name(t, 5))
+		}
+		rids[5] = to
+		if name(t, 5) != "user 5" {
+			t.Errorf("Expected the row at its new slot, got %v", name(t, 5))
+		}
+		expectValid(t)
+	})
+
+	t.Run("Crash Mid Catalog Move", func(t *testing.T) {
+		var from RecordID
+		rows := 0
+		err := db.RecordManager.Scan(db.catalog, func(rid RecordID, record *Record) error {
+			if kind, _, _ := catalogEntry(record); kind == catalogKindTable {
+				from = rid
+			}
+			rows++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to scan the catalog: %v", err)
+		}
+		move(t, db.catalog, from)
+
+		// The catalog is read with the move finished, so the row is there once
+		crash(t)
+		after := 0
+		if err := db.RecordManager.Scan(db.catalog, func(RecordID, *Record) error {
+			after++
+			return nil
+		}); err != nil || after != rows {
+			t.Errorf("Expected %d catalog rows, got %d: %v", rows, after, err)
+		}
+		if name(t, 5) != "user 5" {
+			t.Errorf("Expected the table to be readable, got %v", name(t, 5))
+		}
+		expectValid(t)
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
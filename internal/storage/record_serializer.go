@@ -113,6 +113,17 @@ func SerializeRecord(record *Record) ([]byte, error) {
 	return buffer, nil
 }
 
+// mustSerializeRecord serializes the values of a catalog definition. They
+// are built from ints, strings and bools only, which always serialize, so an
+// error is a bug and panics.
+func mustSerializeRecord(values []interface{}) []byte {
+	data, err := SerializeRecord(&Record{Values: values})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
 // DeserializeRecord converts bytes back to a record
 func DeserializeRecord(data []byte) (*Record, error) {
 	if len(data) < 4 {
@@ -122,6 +133,10 @@ func DeserializeRecord(data []byte) (*Record, error) {
 	// Read number of values
 	numValues := binary.LittleEndian.Uint32(data)
 	offset := 4
+	if int64(numValues) > int64(len(data)-offset) {
+		// Every value takes at least its type byte
+		return nil, errors.New("corrupt record data")
+	}
 
 	// Read values
 	values := make([]interface{}, numValues)
@@ -156,6 +171,9 @@ func DeserializeRecord(data []byte) (*Record, error) {
 			values[i] = string(data[offset : offset+int(length)])
 			offset += int(length)
 		case TypeBool:
+			if offset >= len(data) {
+				return nil, errors.New("corrupt record data")
+			}
 			values[i] = data[offset] != 0
 			offset++
 		case TypeFloat:
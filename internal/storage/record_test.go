@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"testing"
+
+	"godb/internal/vfs"
 )
 
 func TestRecordOperations(t *testing.T) {
@@ -145,3 +147,44 @@ func TestRecordOperations(t *testing.T) {
 		}
 	})
 }
+
+func TestRecordOperationsMemFS(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db, err := NewDatabaseWithOptions("test_db.db", Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	table := NewTable("test_table", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	})
+
+	var rids []*RecordID
+	for i := 0; i < 300; i++ {
+		rid, err := db.RecordManager.InsertRecord(table, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if _, err := os.Stat("test_db.db"); !os.IsNotExist(err) {
+		t.Errorf("Expected no file on disk, got %v", err)
+	}
+
+	// Read back through a fresh cache so every page comes from the MemFS
+	db.Cache = NewCache(1000)
+	for i, rid := range rids {
+		record, err := db.RecordManager.GetRecord(table, rid)
+		if err != nil {
+			t.Fatalf("Failed to retrieve record %d: %v", i, err)
+		}
+		if record.Values[0] != i {
+			t.Errorf("Record %d: expected id %d, got %v", i, i, record.Values[0])
+		}
+	}
+}
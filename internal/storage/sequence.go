@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// A sequence hands out increasing integers. It is a catalog row of kind
+// "sequence" holding its definition and the highest value it may hand out,
+// its reservation. NextVal counts in memory until the reservation runs out,
+// then reserves the next sequenceCache values by rewriting the row in place
+// and makes that durable before returning any of them. After a crash or a
+// restart the sequence continues after the stored reservation, so a value is
+// never handed out twice; the unused rest of a batch is skipped.
+//
+// NextVal takes a value from the reserved batch with a compare-and-swap and
+// no lock. Only the caller that finds the batch used up takes the sequence's
+// mutex to reserve the next one, and callers arriving meanwhile wait for it,
+// once every sequenceCache values.
+//
+// A sequence with Table and Column set generates the values of that column,
+// an INTEGER PRIMARY KEY AUTOINCREMENT, and is named table_column_seq.
+
+// sequenceCache is how many values one reservation covers
+const sequenceCache = 32
+
+// Sequence is a named generator of increasing integers
+type Sequence struct {
+	Name      string
+	Start     int    // first value handed out
+	Increment int    // step between values, positive
+	Table     string // table whose column the sequence fills, empty for CREATE SEQUENCE
+	Column    string // column of Table
+
+	mu       sync.Mutex
+	db       *Database
+	rid      RecordID     // catalog row
+	reserved int          // highest value covered by the stored reservation, under mu
+	limit    atomic.Int64 // reserved once it is durable
+	next     atomic.Int64 // value the next NextVal returns
+}
+
+// serialize writes the sequence as a record: name, start, increment,
+// reservation, table and column. Its length does not depend on the
+// reservation, so the catalog row is rewritten in place.
+func (s *Sequence) serialize() []byte {
+	return mustSerializeRecord([]interface{}{
+		s.Name, s.Start, s.Increment, s.reserved, s.Table, s.Column,
+	})
+}
+
+func deserializeSequence(data []byte) (*Sequence, error) {
+	record, err := DeserializeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(record.Values) != 6 {
+		return nil, errors.New("corrupt sequence metadata")
+	}
+	s := &Sequence{}
+	var ok [6]bool
+	s.Name, ok[0] = record.Values[0].(string)
+	s.Start, ok[1] = record.Values[1].(int)
+	s.Increment, ok[2] = record.Values[2].(int)
+	s.reserved, ok[3] = record.Values[3].(int)
+	s.Table, ok[4] = record.Values[4].(string)
+	s.Column, ok[5] = record.Values[5].(string)
+	for _, valid := range ok {
+		if !valid {
+			return nil, errors.New("corrupt sequence metadata")
+		}
+	}
+	if s.Increment <= 0 {
+		return nil, fmt.Errorf("sequence %s has increment %d", s.Name, s.Increment)
+	}
+	s.limit.Store(int64(s.reserved))
+	s.next.Store(int64(s.reserved + s.Increment))
+	return s, nil
+}
+
+// SequenceName is the name of the sequence behind an AUTOINCREMENT column
+func SequenceName(table, column string) string {
+	return table + "_" + column + "_seq"
+}
+
+// CreateSequence adds a sequence whose first value is start
+func (db *Database) CreateSequence(name string, start, increment int) error {
+	_, err := db.createSequence(&Sequence{Name: name, Start: start, Increment: increment})
+	return err
+}
+
+// SetAutoIncrement makes the table's column take its values from a new
+// sequence when a row leaves it NULL. The column must be the table's INTEGER
+// primary key.
+func (db *Database) SetAutoIncrement(tableName, columnName string) error {
+	table, ok := db.Tables[tableName]
+	if !ok {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	column := table.Columns[table.PrimaryKey]
+	if column.Name != columnName {
+		return fmt.Errorf("AUTOINCREMENT column %s is not the primary key of %s", columnName, tableName)
+	}
+	if column.DataType != TypeInteger {
+		return fmt.Errorf("AUTOINCREMENT column %s is not an INTEGER", columnName)
+	}
+	if db.AutoIncrement(tableName) != nil {
+		return fmt.Errorf("table %s already has an AUTOINCREMENT column", tableName)
+	}
+
+	_, err := db.createSequence(&Sequence{
+		Name:      SequenceName(tableName, columnName),
+		Start:     1,
+		Increment: 1,
+		Table:     tableName,
+		Column:    columnName,
+	})
+	return err
+}
+
+// AutoIncrement returns the sequence that fills a column of the table, or nil
+func (db *Database) AutoIncrement(tableName string) *Sequence {
+	for _, s := range db.Sequences {
+		if s.Table == tableName {
+			return s
+		}
+	}
+	return nil
+}
+
+func (db *Database) createSequence(s *Sequence) (*Sequence, error) {
+	if db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if _, exists := db.Sequences[s.Name]; exists {
+		return nil, fmt.Errorf("sequence %s already exists", s.Name)
+	}
+	if s.Increment <= 0 {
+		return nil, fmt.Errorf("sequence %s needs a positive increment, got %d", s.Name, s.Increment)
+	}
+
+	// Nothing is handed out yet: the reservation ends just before Start
+	s.reserved = s.Start - s.Increment
+	s.limit.Store(int64(s.reserved))
+	s.next.Store(int64(s.Start))
+	s.db = db
+	rid, err := db.addCatalogEntry(catalogKindSequence, s.serialize())
+	if err != nil {
+		return nil, err
+	}
+	s.rid = *rid
+	if err := db.syncPage(rid.PageID); err != nil {
+		return nil, err
+	}
+	db.Sequences[s.Name] = s
+	return s, nil
+}
+
+// NextVal returns the next value of the sequence
+func (s *Sequence) NextVal() (int, error) {
+	increment := int64(s.Increment)
+	for {
+		next := s.next.Load()
+		if next > s.limit.Load() {
+			break
+		}
+		if s.next.CompareAndSwap(next, next+increment) {
+			return int(next), nil
+		}
+	}
+
+	// The batch is used up: reserve the next one
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		next := s.next.Load()
+		if next > s.limit.Load() {
+			if err := s.reserve(int(next) + (sequenceCache-1)*s.Increment); err != nil {
+				return 0, err
+			}
+		}
+		if s.next.CompareAndSwap(next, next+increment) {
+			return int(next), nil
+		}
+	}
+}
+
+// SetVal makes the next NextVal return value plus the increment. Values at or
+// below value may be handed out again if they were before.
+func (s *Sequence) SetVal(value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reserve(value); err != nil {
+		return err
+	}
+	s.next.Store(int64(value + s.Increment))
+	return nil
+}
+
+// Observe makes sure the sequence never hands out value or anything below
+// it, for rows that were given an explicit value in an AUTOINCREMENT column
+func (s *Sequence) Observe(value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int64(value) < s.next.Load() {
+		return nil
+	}
+	if value > s.reserved {
+		if err := s.reserve(value + (sequenceCache-1)*s.Increment); err != nil {
+			return err
+		}
+	}
+	for {
+		next := s.next.Load()
+		if int64(value) < next || s.next.CompareAndSwap(next, int64(value+s.Increment)) {
+			return nil
+		}
+	}
+}
+
+// LastValue returns the highest value the sequence may have handed out, and
+// false if it never handed out any. It is what a dump restores with setval.
+func (s *Sequence) LastValue() (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := int(s.next.Load()) - s.Increment
+	return last, last >= s.Start
+}
+
+// reserve stores a new reservation and makes it durable. The caller holds s.mu.
+func (s *Sequence) reserve(reserved int) error {
+	db := s.db
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
+	previous := s.reserved
+	s.reserved = reserved
+	err := db.RecordManager.UpdateRecord(db.catalog, &s.rid, &Record{
+		Values: []interface{}{catalogKindSequence, string(s.serialize())},
+	})
+	if err == nil {
+		err = db.syncPage(s.rid.PageID)
+	}
+	if err != nil {
+		s.reserved = previous
+		return fmt.Errorf("sequence %s: %w", s.Name, err)
+	}
+	// Only now may NextVal hand out values up to it without the mutex
+	s.limit.Store(int64(reserved))
+	return nil
+}
+
+// syncPage makes the current content of a page durable: through the log when
+// there is one, or else by writing the page and syncing the file
+func (db *Database) syncPage(pageID uint64) error {
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return err
+	}
+	if db.wal != nil {
+		page.latch.RLock()
+		lsn := pageLSN(page.Data)
+		page.latch.RUnlock()
+		return db.wal.SyncTo(lsn)
+	}
+	if db.InMemory {
+		return nil
+	}
+	if err := db.flushPage(page); err != nil {
+		return err
+	}
+	return db.File.Sync()
+}
+
+// sequenceMoved points a sequence at its catalog row after VACUUM moved it
+func (db *Database) sequenceMoved(from, to RecordID) {
+	for _, s := range db.Sequences {
+		s.mu.Lock()
+		if s.rid == from {
+			s.rid = to
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestSequence(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	if err := db.CreateSequence("ids", 10, 5); err != nil {
+		t.Fatalf("Failed to create sequence: %v", err)
+	}
+	if err := db.CreateSequence("ids", 1, 1); err == nil {
+		t.Error("Expected a second sequence with the same name to fail")
+	}
+	ids := db.Sequences["ids"]
+	for _, want := range []int{10, 15, 20} {
+		if got, err := ids.NextVal(); err != nil || got != want {
+			t.Fatalf("Expected %d, got %d, %v", want, got, err)
+		}
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		seen := make(map[int]bool)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					n, err := ids.NextVal()
+					if err != nil {
+						t.Errorf("NextVal failed: %v", err)
+						return
+					}
+					mu.Lock()
+					if seen[n] {
+						t.Errorf("Value %d handed out twice", n)
+					}
+					seen[n] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if len(seen) != 1600 {
+			t.Errorf("Expected 1600 values, got %d", len(seen))
+		}
+	})
+
+	last, _ := ids.LastValue()
+
+	// Crash: drop the handles without a checkpoint
+	db.wal.Close()
+	db.File.Close()
+
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	ids = db.Sequences["ids"]
+	if ids == nil {
+		t.Fatal("Sequence is missing after recovery")
+	}
+	if n, err := ids.NextVal(); err != nil || n <= last || (n-10)%5 != 0 {
+		t.Errorf("Expected a value above %d after the crash, got %d, %v", last, n, err)
+	}
+
+	if err := ids.SetVal(100); err != nil {
+		t.Fatalf("SetVal failed: %v", err)
+	}
+	if n, _ := ids.NextVal(); n != 105 {
+		t.Errorf("Expected 105 after setval(100), got %d", n)
+	}
+	if err := ids.Observe(300); err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+	if n, _ := ids.NextVal(); n != 305 {
+		t.Errorf("Expected 305 after a row took 300, got %d", n)
+	}
+
+	t.Run("AutoIncrement", func(t *testing.T) {
+		columns := []Column{
+			{Name: "name", DataType: TypeVarchar},
+			{Name: "id", DataType: TypeInteger},
+		}
+		if err := db.CreateTableWithPrimaryKey("users", columns, 1); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		if err := db.SetAutoIncrement("users", "name"); err == nil {
+			t.Error("Expected AUTOINCREMENT on a column that is not the key to fail")
+		}
+		if err := db.SetAutoIncrement("users", "id"); err != nil {
+			t.Fatalf("SetAutoIncrement failed: %v", err)
+		}
+		s := db.AutoIncrement("users")
+		if s == nil || s.Name != "users_id_seq" || s.Column != "id" {
+			t.Fatalf("Unexpected sequence %+v", s)
+		}
+		if n, _ := s.NextVal(); n != 1 {
+			t.Errorf("Expected the first id to be 1, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// snapshotPattern matches the names of every snapshot of the database
+func (db *Database) snapshotPattern() string {
+	return escapeGlob(db.Path) + ".snapshot*"
+}
+
+// Snapshot returns a read-only copy of the database as it is now. It is taken
+// like a full backup, so writers are not blocked and the copy is consistent
+// when the database has a write-ahead log. The copy is streamed into a
+// temporary file next to the database on its file system, so it costs disk
+// space rather than memory. The caller closes the snapshot, which removes the
+// file.
+func (db *Database) Snapshot() (*Database, error) {
+	path := fmt.Sprintf("%s.snapshot%d.%d", db.Path, os.Getpid(), db.snapshots.Add(1))
+	r, w := io.Pipe()
+	go func() {
+		_, err := db.Backup(w)
+		w.CloseWithError(err)
+	}()
+
+	opts := Options{FS: db.FS}
+	if _, err := applyBackup(r, path, 0, opts); err != nil {
+		// Unblocks the backup if it is still writing
+		r.CloseWithError(err)
+		if !errors.Is(err, os.ErrExist) {
+			db.FS.Remove(path)
+		}
+		return nil, err
+	}
+	opts.ReadOnly = true
+	snapshot, err := NewDatabaseWithOptions(path, opts)
+	if err != nil {
+		db.FS.Remove(path)
+		return nil, err
+	}
+	snapshot.temporary = true
+	return snapshot, nil
+}
+
+// removeSnapshots removes snapshots left behind by processes that ended
+// without closing them. The caller holds the database's exclusive lock, so no
+// snapshot is open.
+func (db *Database) removeSnapshots() error {
+	paths, err := db.FS.Glob(db.snapshotPattern())
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := db.FS.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing snapshot %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -1,5 +1,12 @@
 package storage
 
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
 // Think of tables like excel spreadsheet with different columns
 // Column represents a table column definition
 type Column struct {
@@ -11,10 +18,15 @@ type Column struct {
 
 // Table represents a database table structure
 type Table struct {
+	ID         uint64 // Catalog ID, stored in the header of every page the table owns
 	Name       string
 	Columns    []Column
 	PrimaryKey int      // Index of primary key column
 	PageIDs    []uint64 // Pages containing table data
+	Indexes    []Index  // Indexes over the table's rows, see table_index.go
+
+	mu       sync.Mutex               // guards PageIDs while inserts pick a page or add one
+	keyLocks [keyLockCount]sync.Mutex // held by writes of unique keys, see lockKeys
 }
 
 // DataType represents supported data types
@@ -27,6 +39,20 @@ const (
 	TypeTimestamp
 )
 
+// Accepts reports whether a value, NULL aside, fits the column's type.
+// TIMESTAMP holds Unix seconds or the text it was given.
+func (c Column) Accepts(value interface{}) bool {
+	switch value.(type) {
+	case int:
+		return c.DataType == TypeInteger || c.DataType == TypeTimestamp
+	case string:
+		return c.DataType == TypeVarchar || c.DataType == TypeTimestamp
+	case bool:
+		return c.DataType == TypeBoolean
+	}
+	return false
+}
+
 func NewTable(name string, columns []Column) *Table {
 	return &Table{
 		Name:    name,
@@ -36,18 +62,74 @@ func NewTable(name string, columns []Column) *Table {
 }
 
 func (t *Table) AddPage(pageID uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.PageIDs = append(t.PageIDs, pageID)
 }
 
+// checkPrimaryKey refuses a record whose primary key is NULL. The unique
+// index on the key lets any number of NULLs in, like any unique index, so the
+// column is kept NOT NULL here.
+func (t *Table) checkPrimaryKey(record *Record) error {
+	if t.PrimaryKey < len(record.Values) && record.Values[t.PrimaryKey] == nil {
+		return fmt.Errorf("column %s: NULL in the primary key of %s", t.Columns[t.PrimaryKey].Name, t.Name)
+	}
+	return nil
+}
+
+// pages returns a copy of the table's page list
+func (t *Table) pages() []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.PageIDs)
+}
+
 // Serialize table metadata for storage
+// The metadata is written as a record: ID, name, primary key, column count
+// and then name, type, length and not-null for each column. Page IDs are not
+// stored, every page records its owner instead.
 func (t *Table) Serialize() []byte {
-	// Implementation for serializing table metadata
-	// This would include table name, column definitions, etc.
-	return nil // Placeholder
+	values := []interface{}{int(t.ID), t.Name, t.PrimaryKey, len(t.Columns)}
+	for _, col := range t.Columns {
+		values = append(values, col.Name, int(col.DataType), col.Length, col.NotNull)
+	}
+	return mustSerializeRecord(values)
 }
 
 // Deserialize table metadata from storage
 func DeserializeTable(data []byte) (*Table, error) {
-	// Implementation for deserializing table metadata
-	return nil, nil // Placeholder
+	record, err := DeserializeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := record.Values
+	if len(values) < 4 {
+		return nil, errors.New("corrupt table metadata")
+	}
+	id, idOK := values[0].(int)
+	name, nameOK := values[1].(string)
+	primaryKey, pkOK := values[2].(int)
+	numColumns, numOK := values[3].(int)
+	if !idOK || !nameOK || !pkOK || !numOK || len(values) != 4+numColumns*4 {
+		return nil, errors.New("corrupt table metadata")
+	}
+
+	columns := make([]Column, numColumns)
+	for i := range columns {
+		fields := values[4+i*4:]
+		colName, ok1 := fields[0].(string)
+		dataType, ok2 := fields[1].(int)
+		length, ok3 := fields[2].(int)
+		notNull, ok4 := fields[3].(bool)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("corrupt metadata for column %d of table %s", i, name)
+		}
+		columns[i] = Column{Name: colName, DataType: DataType(dataType), Length: length, NotNull: notNull}
+	}
+
+	table := NewTable(name, columns)
+	table.ID = uint64(id)
+	table.PrimaryKey = primaryKey
+	return table, nil
 }
@@ -0,0 +1,558 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"slices"
+)
+
+// Indexes over a table map the key columns of each row to its record ID.
+// Most are B-trees; a hash index, see hash_index.go, serves tables that only
+// look rows up by equal keys. Every table gets a unique B-tree on its primary
+// key column when it is created, so RecordManager refuses a second row with
+// the same key, and CreateIndex adds others over any of its columns.
+// Databases written before primary key indexes existed get theirs the first
+// time they are opened for writing. A table whose rows cannot have one, since
+// they share keys or their key column may hold values too long for an index,
+// is opened without it and reported by Check until the rows are fixed. A
+// B-tree built over existing rows sorts their keys and loads them bottom-up,
+// see btree_build.go.
+//
+// RecordManager keeps the indexes in step with the heap: an insert first
+// makes sure no unique index holds the row's keys, then adds the row's entries
+// after the row, a delete removes them, an update replaces those whose key
+// changed, and VACUUM points them at rows it moves. When an index refuses an
+// entry, the entries already added and the row itself are taken back before
+// the error is returned. An insert, a delete or an update is logged as one
+// unit, so that recovery finishes one a crash cut short, see record_log.go. A bulk load, see
+// bulk.go, adds the entries of all its rows at its end instead. All of it goes
+// through Index, which both kinds of index implement.
+
+// unboundedKeySize is the key bytes given to a VARCHAR without a length or a
+// TIMESTAMP, which may hold text
+const unboundedKeySize = 256
+
+// IndexInfo is what every kind of index records about itself in the catalog
+// besides its pages
+type IndexInfo struct {
+	ID      uint64 // owner of the index's pages, handed out like a table ID
+	Name    string
+	Columns []KeyColumn // ordering of the key's columns, see Key
+	Unique  bool        // refuse a second entry with the same key
+
+	TableID      uint64 // table whose rows the index covers, 0 for none
+	TableColumns []int  // column of the table behind each key column
+}
+
+// Info returns the index's description
+func (i *IndexInfo) Info() *IndexInfo {
+	return i
+}
+
+// Key encodes values as a key of the index, ordered by its columns
+func (i *IndexInfo) Key(values ...interface{}) ([]byte, error) {
+	return EncodeKey(i.Columns, values...)
+}
+
+// hasNull reports whether a column of key is NULL. A key that does not decode
+// has none.
+func (i *IndexInfo) hasNull(key []byte) bool {
+	values, _ := DecodeKey(i.Columns, key)
+	return slices.Contains(values, nil)
+}
+
+// recordKey encodes the key of a table row
+func (i *IndexInfo) recordKey(record *Record) ([]byte, error) {
+	values := make([]interface{}, len(i.TableColumns))
+	for n, column := range i.TableColumns {
+		if column >= len(record.Values) {
+			return nil, fmt.Errorf("index %s: record has no column %d", i.Name, column)
+		}
+		values[n] = record.Values[column]
+	}
+	return i.Key(values...)
+}
+
+// Index is an index over a table's rows, a *BTree or a *HashIndex
+type Index interface {
+	Info() *IndexInfo
+	Key(values ...interface{}) ([]byte, error)
+	Insert(key []byte, rid RecordID) error
+	Delete(key []byte, rid RecordID) (bool, error)
+	Search(key []byte) (RecordID, bool, error)
+	SearchAll(key []byte) ([]RecordID, error)
+	Check() []string
+
+	recordKey(record *Record) ([]byte, error)
+	keyLimit() int                               // longest key in bytes
+	count() (int, error)                         // entries in the index, see check.go
+	pages() []uint64                             // the index's pages, see check.go
+	decodePage(pageID uint64, data []byte) error // whether a page of the index decodes
+	freePages() error                            // frees every page of a dropped index
+}
+
+// PrimaryKeyIndexName is the name of the index on a table's primary key
+func PrimaryKeyIndexName(table string) string {
+	return table + "_pkey"
+}
+
+// PrimaryIndex returns the index on the table's primary key, or nil if it has
+// none
+func (t *Table) PrimaryIndex() *BTree {
+	for _, index := range t.Indexes {
+		if tree, ok := index.(*BTree); ok && tree.Name == PrimaryKeyIndexName(t.Name) {
+			return tree
+		}
+	}
+	return nil
+}
+
+// index returns the index of any kind with the given name
+func (db *Database) index(name string) (Index, bool) {
+	if tree, ok := db.Indexes[name]; ok {
+		return tree, true
+	}
+	if hash, ok := db.HashIndexes[name]; ok {
+		return hash, true
+	}
+	return nil, false
+}
+
+// indexExists reports whether an index of any kind has the given name
+func (db *Database) indexExists(name string) bool {
+	_, ok := db.index(name)
+	return ok
+}
+
+// attachIndex adds a new or loaded index to the indexes of its table
+func (db *Database) attachIndex(index Index) {
+	if info := index.Info(); info.TableID != 0 {
+		if table := db.tableByID(info.TableID); table != nil {
+			table.Indexes = append(table.Indexes, index)
+		}
+	}
+}
+
+// IndexOptions describes an index for CreateIndex
+type IndexOptions struct {
+	Columns []int       // table columns behind the key columns, in key order
+	Order   []KeyColumn // ordering of each key column, all ascending when nil
+	Unique  bool        // refuse a second row with the same key
+	Hash    bool        // an extendible hash index instead of a B-tree
+}
+
+// CreateIndex adds an index over columns of a table and fills it with the
+// table's rows. A unique index over rows that already share a key is refused
+// and leaves nothing behind.
+func (db *Database) CreateIndex(name, tableName string, opts IndexOptions) (Index, error) {
+	table, ok := db.Tables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", tableName)
+	}
+	if len(opts.Columns) == 0 {
+		return nil, errors.New("an index needs at least one column")
+	}
+	for _, column := range opts.Columns {
+		if column < 0 || column >= len(table.Columns) {
+			return nil, fmt.Errorf("table %s has no column %d", table.Name, column)
+		}
+	}
+	order := opts.Order
+	if order == nil {
+		order = make([]KeyColumn, len(opts.Columns))
+	}
+	if len(order) != len(opts.Columns) {
+		return nil, fmt.Errorf("%d key column orders for %d columns", len(order), len(opts.Columns))
+	}
+
+	keySize := columnsKeySize(db.PageSize, table, opts.Columns)
+	if opts.Hash {
+		index, err := db.CreateHashIndex(name, HashIndexOptions{
+			KeySize:      keySize,
+			Columns:      order,
+			Unique:       opts.Unique,
+			TableID:      table.ID,
+			TableColumns: opts.Columns,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := db.buildHashIndex(table, index); err != nil {
+			return nil, errors.Join(err, db.dropIndex(index))
+		}
+		return index, nil
+	}
+
+	index, err := db.CreateBTreeWithOptions(name, BTreeOptions{
+		KeySize:      keySize,
+		Columns:      order,
+		Unique:       opts.Unique,
+		TableID:      table.ID,
+		TableColumns: opts.Columns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.buildIndex(table, index, indexSortMemory); err != nil {
+		return nil, errors.Join(err, db.dropIndex(index))
+	}
+	return index, nil
+}
+
+// DropIndex removes an index and frees its pages. The index on a table's
+// primary key goes only with its table.
+func (db *Database) DropIndex(name string) error {
+	if db.ReadOnly {
+		return ErrReadOnly
+	}
+	index, ok := db.index(name)
+	if !ok {
+		return fmt.Errorf("index %s does not exist", name)
+	}
+	if table := db.tableByID(index.Info().TableID); table != nil && table.PrimaryIndex() == index {
+		return fmt.Errorf("index %s is the primary key of table %s", name, table.Name)
+	}
+	return db.dropIndex(index)
+}
+
+// dropIndex takes an index out of the catalog and its table and frees its
+// pages. The catalog row goes first: should the rest not happen, the pages
+// are left to an owner that no longer exists rather than the other way round.
+func (db *Database) dropIndex(index Index) error {
+	info := index.Info()
+	var rows []RecordID
+	err := db.RecordManager.Scan(db.catalog, func(rid RecordID, record *Record) error {
+		kind, definition, err := catalogEntry(record)
+		if err != nil {
+			return err
+		}
+		var id uint64
+		switch kind {
+		case catalogKindIndex:
+			if t, err := deserializeBTree(definition); err == nil {
+				id = t.ID
+			}
+		case catalogKindHashIndex:
+			if h, err := deserializeHashIndex(definition); err == nil {
+				id = h.ID
+			}
+		default:
+			return nil
+		}
+		if id == info.ID {
+			rows = append(rows, rid)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rid := range rows {
+		if err := db.RecordManager.DeleteRecord(db.catalog, &rid); err != nil {
+			return err
+		}
+	}
+
+	delete(db.Indexes, info.Name)
+	delete(db.HashIndexes, info.Name)
+	if table := db.tableByID(info.TableID); table != nil {
+		table.Indexes = slices.DeleteFunc(table.Indexes, func(other Index) bool { return other == index })
+	}
+	return index.freePages()
+}
+
+// createPrimaryIndex adds the unique index on the table's primary key and
+// fills it with the table's rows
+func (db *Database) createPrimaryIndex(table *Table) error {
+	_, err := db.CreateIndex(PrimaryKeyIndexName(table.Name), table.Name, IndexOptions{
+		Columns: []int{table.PrimaryKey},
+		Unique:  true,
+	})
+	return err
+}
+
+// buildIndex fills a new, empty index with the rows of its table. The keys
+// are sorted first, in at most memory bytes and in runs on disk beyond that,
+// and then loaded bottom-up with BuildFromSorted.
+func (db *Database) buildIndex(table *Table, index *BTree, memory int) (err error) {
+	sorter := db.newEntrySorter(index.ID, memory)
+	defer func() { err = errors.Join(err, sorter.close()) }()
+
+	err = db.RecordManager.Scan(table, func(rid RecordID, record *Record) error {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return err
+		}
+		return sorter.add(key, rid)
+	})
+	if err != nil {
+		return err
+	}
+	entries, err := sorter.sorted()
+	if err != nil {
+		return err
+	}
+	return index.BuildFromSorted(entries, DefaultFillFactor)
+}
+
+// ErrDuplicatePrimaryKeys is returned for an insert or update into a table
+// that opened without its primary key index because its rows hold duplicate
+// keys
+var ErrDuplicatePrimaryKeys = errors.New("table holds duplicate primary keys")
+
+// createMissingPrimaryIndexes gives every table without a primary key index
+// one, built from its rows. The rows were written without one, so they may
+// break it: such a table is left without the index, and the reason is kept
+// for Check instead of failing the open and locking the data away. A table
+// whose rows hold duplicate keys takes no new rows or changes until they are
+// deleted, see checkDuplicateKeys.
+func (db *Database) createMissingPrimaryIndexes() error {
+	db.noPrimaryIndex = make(map[string]error)
+	for _, table := range db.checkedTables() {
+		if table == db.catalog || table.PrimaryIndex() != nil {
+			continue
+		}
+		if err := primaryKeyError(db.PageSize, table.Columns[table.PrimaryKey]); err != nil {
+			db.noPrimaryIndex[table.Name] = err
+			continue
+		}
+		err := db.createPrimaryIndex(table)
+		if errors.Is(err, ErrDuplicateKey) {
+			db.noPrimaryIndex[table.Name] = err
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("building the primary key index of table %s: %w", table.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkDuplicateKeys refuses a write that could add to the duplicate primary
+// keys of a table left without its primary key index. Deletes are allowed, so
+// the duplicates can be removed; the index is built the next time the
+// database is opened.
+func (db *Database) checkDuplicateKeys(table *Table) error {
+	if reason := db.noPrimaryIndex[table.Name]; errors.Is(reason, ErrDuplicateKey) {
+		return fmt.Errorf("table %s: %w (%v); delete the duplicates and open the database again", table.Name, ErrDuplicatePrimaryKeys, reason)
+	}
+	return nil
+}
+
+// primaryKeyError returns why a column cannot be a table's primary key, or
+// nil. The primary key index refuses a key longer than its key size, so a
+// column whose values may make longer keys, a VARCHAR without a length or one
+// too long for a B-tree node, would have some of its values refused by an
+// index that was never asked for.
+func primaryKeyError(pageSize uint16, column Column) error {
+	if column.DataType != TypeVarchar {
+		return nil
+	}
+	if column.Length == 0 {
+		return fmt.Errorf("column %s cannot be a primary key: a VARCHAR without a length has no longest key; give it a length or choose another primary key", column.Name)
+	}
+	if limit := maxKeySize(pageSize); 3+2*column.Length > limit {
+		return fmt.Errorf("column %s cannot be a primary key: its keys may take %d bytes, more than the %d an index holds", column.Name, 3+2*column.Length, limit)
+	}
+	return nil
+}
+
+// maxKeySize returns the longest key a B-tree of degree 2 holds
+func maxKeySize(pageSize uint16) int {
+	size := int(pageSize)
+	for maxNodeKeys(pageSize, size) < 3 {
+		size--
+	}
+	return size
+}
+
+// columnsKeySize returns the longest key the table's columns can make,
+// or the longest a page allows with degree 2 if that is less
+func columnsKeySize(pageSize uint16, table *Table, columns []int) int {
+	size := 0
+	for _, i := range columns {
+		column := table.Columns[i]
+		switch {
+		case column.DataType == TypeInteger:
+			size += 9
+		case column.DataType == TypeBoolean:
+			size += 2
+		case column.DataType == TypeVarchar && column.Length > 0:
+			// Every byte may need escaping
+			size += 3 + 2*column.Length
+		default:
+			size += unboundedKeySize
+		}
+	}
+	return min(size, maxKeySize(pageSize))
+}
+
+// uniqueKey is the key a row gives one of its table's unique indexes
+type uniqueKey struct {
+	index Index
+	key   []byte
+}
+
+// uniqueKeys returns the keys a row gives the unique indexes among indexes.
+// NULL keys never collide and are left out.
+func uniqueKeys(indexes []Index, record *Record) ([]uniqueKey, error) {
+	var keys []uniqueKey
+	for _, index := range indexes {
+		info := index.Info()
+		if !info.Unique {
+			continue
+		}
+		key, err := index.recordKey(record)
+		if err != nil {
+			return nil, err
+		}
+		if !info.hasNull(key) {
+			keys = append(keys, uniqueKey{index, key})
+		}
+	}
+	return keys, nil
+}
+
+// lockKeys takes the table's locks of the given keys, in a fixed order, and
+// returns the function that releases them. Writes of one unique key happen one
+// at a time, so a key an insert found absent stays absent until its row and
+// entries are in.
+func (t *Table) lockKeys(keys []uniqueKey) func() {
+	var stripes []uint64
+	for _, k := range keys {
+		var h maphash.Hash
+		h.SetSeed(keyLockSeed)
+		h.Write(binary.LittleEndian.AppendUint64(nil, k.index.Info().ID))
+		h.Write(k.key)
+		stripes = append(stripes, h.Sum64()%keyLockCount)
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+	for _, stripe := range stripes {
+		t.keyLocks[stripe].Lock()
+	}
+	return func() {
+		for _, stripe := range stripes {
+			t.keyLocks[stripe].Unlock()
+		}
+	}
+}
+
+// checkUniqueKeys returns a *DuplicateKeyError for the first key its index
+// holds for a row other than rid. The caller holds the keys' locks.
+func checkUniqueKeys(keys []uniqueKey, rid RecordID) error {
+	for _, k := range keys {
+		rids, err := k.index.SearchAll(k.key)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(rids, func(other RecordID) bool { return other != rid }) {
+			info := k.index.Info()
+			return &DuplicateKeyError{Index: info.Name, Key: formatKey(info.Columns, k.key)}
+		}
+	}
+	return nil
+}
+
+// insertIndexEntries adds the entries of a new row to the given indexes. If
+// an index refuses its entry, the entries already added are removed again.
+func (rm *RecordManager) insertIndexEntries(indexes []Index, record *Record, rid RecordID) error {
+	for i, index := range indexes {
+		key, err := index.recordKey(record)
+		if err == nil {
+			err = index.Insert(key, rid)
+		}
+		if err != nil {
+			return errors.Join(err, rm.deleteIndexEntries(indexes[:i], record, rid))
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntries removes the entries of a row from the given indexes
+func (rm *RecordManager) deleteIndexEntries(indexes []Index, record *Record, rid RecordID) error {
+	for _, index := range indexes {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return err
+		}
+		if _, err := index.Delete(key, rid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addIndexEntries adds the entries of a row to the given indexes that do not
+// hold them yet, so it can run again after stopping part way
+func (rm *RecordManager) addIndexEntries(indexes []Index, record *Record, rid RecordID) error {
+	for _, index := range indexes {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return err
+		}
+		rids, err := index.SearchAll(key)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(rids, rid) {
+			if err := index.Insert(key, rid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setIndexEntries moves the entries of a row in the given indexes from the
+// keys of from to those of to. Entries already moved are left alone, so it can
+// run again after stopping part way, or run back from to to from.
+func (rm *RecordManager) setIndexEntries(indexes []Index, from, to *Record, rid RecordID) error {
+	for _, index := range indexes {
+		fromKey, err := index.recordKey(from)
+		if err != nil {
+			return err
+		}
+		toKey, err := index.recordKey(to)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(fromKey, toKey) {
+			continue
+		}
+		if _, err := index.Delete(fromKey, rid); err != nil {
+			return err
+		}
+		rids, err := index.SearchAll(toKey)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(rids, rid) {
+			if err := index.Insert(toKey, rid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveIndexEntries points the entries of a row at the record ID it was moved
+// to
+func (rm *RecordManager) moveIndexEntries(table *Table, record *Record, from, to RecordID) error {
+	for _, index := range table.Indexes {
+		key, err := index.recordKey(record)
+		if err != nil {
+			return err
+		}
+		if _, err := index.Delete(key, from); err != nil {
+			return err
+		}
+		if err := index.Insert(key, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,679 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestPrimaryKey(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	columns := []Column{
+		{Name: "name", DataType: TypeVarchar, Length: 20},
+		{Name: "id", DataType: TypeInteger},
+	}
+	if err := db.CreateTableWithPrimaryKey("users", columns, 1); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	index := users.PrimaryIndex()
+	if index == nil || !index.Unique || index.TableID != users.ID || fmt.Sprint(index.TableColumns) != "[1]" {
+		t.Fatalf("Expected a unique index on the id column, got %+v", index)
+	}
+	if _, err := db.CreateBTree("orders_pkey", 0); err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+	if err := db.CreateTable("orders", columns); err == nil || db.Tables["orders"] != nil {
+		t.Error("Expected a table whose index name is taken to fail")
+	}
+
+	// A primary key must hold every value its column takes
+	notes := []Column{
+		{Name: "body", DataType: TypeVarchar},
+		{Name: "title", DataType: TypeVarchar, Length: 5000},
+		{Name: "id", DataType: TypeInteger},
+	}
+	for key := 0; key < 2; key++ {
+		if err := db.CreateTableWithPrimaryKey("notes", notes, key); err == nil || db.Tables["notes"] != nil {
+			t.Errorf("Expected column %s to be refused as a primary key", notes[key].Name)
+		}
+	}
+	if err := db.CreateTableWithPrimaryKey("notes", notes, 2); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	long := &Record{Values: []interface{}{strings.Repeat("x", 1000), strings.Repeat("y", 1000), 1}}
+	if _, err := db.RecordManager.InsertRecord(db.Tables["notes"], long); err != nil {
+		t.Errorf("Expected long values outside the key to be stored, got %v", err)
+	}
+
+	rids := make(map[int]*RecordID)
+	for i := 0; i < 300; i++ {
+		rid, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{fmt.Sprintf("user %d", i), i}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids[i] = rid
+	}
+
+	// expectDuplicate checks for the error of key 5 in users_pkey
+	expectDuplicate := func(t *testing.T, err error) {
+		t.Helper()
+		var dup *DuplicateKeyError
+		if !errors.Is(err, ErrDuplicateKey) || !errors.As(err, &dup) || dup.Index != "users_pkey" || dup.Key != "5" {
+			t.Errorf("Expected a duplicate key 5 in users_pkey, got %v", err)
+		}
+	}
+	// rows returns the live rows of the table by ID
+	rows := func(t *testing.T) map[int]string {
+		t.Helper()
+		byID := make(map[int]string)
+		err := db.RecordManager.Scan(users, func(rid RecordID, record *Record) error {
+			id := record.Values[1].(int)
+			if _, seen := byID[id]; seen {
+				t.Errorf("ID %d is stored twice", id)
+			}
+			byID[id] = record.Values[0].(string)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		return byID
+	}
+	// verify checks that the index maps every row's ID to its record
+	verify := func(t *testing.T) {
+		t.Helper()
+		byID := rows(t)
+		count := 0
+		err := users.PrimaryIndex().Range(KeyRange{}, func(key []byte, rid RecordID) error {
+			count++
+			id := keyInt(key)
+			record, err := db.RecordManager.GetRecord(users, &rid)
+			if err != nil || record.Values[1] != id {
+				t.Errorf("Index entry %d points to %v: %v %v", id, rid, record, err)
+			}
+			return nil
+		})
+		if err != nil || count != len(byID) {
+			t.Errorf("Expected %d index entries, got %d: %v", len(byID), count, err)
+		}
+		if problems := users.PrimaryIndex().Check(); problems != nil {
+			t.Errorf("Expected a valid index, got %v", problems)
+		}
+	}
+
+	t.Run("Insert", func(t *testing.T) {
+		_, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{"again", 5}})
+		expectDuplicate(t, err)
+		if name := rows(t)[5]; name != "user 5" {
+			t.Errorf("Expected the first row to stay, got %q", name)
+		}
+
+		// Unlike other unique keys, a primary key may not be NULL
+		for i := 0; i < 2; i++ {
+			if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{"nobody", nil}}); err == nil {
+				t.Error("Expected a NULL primary key to be refused")
+			}
+		}
+		if err := db.RecordManager.UpdateRecord(users, rids[7], &Record{Values: []interface{}{"user 7", nil}}); err == nil {
+			t.Error("Expected an update to a NULL primary key to be refused")
+		}
+
+		loader, err := db.RecordManager.NewBulkLoader(users)
+		if err != nil {
+			t.Fatalf("Failed to start bulk load: %v", err)
+		}
+		dup, err := loader.Insert(&Record{Values: []interface{}{"bulk", 5}})
+		if err != nil {
+			t.Fatalf("Failed to bulk load: %v", err)
+		}
+		if _, err := loader.Insert(&Record{Values: []interface{}{"bulk", 300}}); err != nil {
+			t.Errorf("Failed to bulk load a new key: %v", err)
+		}
+		rejected, err := loader.Finish()
+		if err != nil {
+			t.Fatalf("Failed to finish the bulk load: %v", err)
+		}
+		if len(rejected) != 1 || rejected[0].RID != *dup || loader.Loaded() != 1 {
+			t.Fatalf("Expected the bulk loaded duplicate to be rejected, got %v", rejected)
+		}
+		expectDuplicate(t, rejected[0].Err)
+		verify(t)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := db.RecordManager.DeleteRecord(users, rids[5]); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		rid, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{"new 5", 5}})
+		if err != nil {
+			t.Fatalf("Expected a deleted key to be free again, got %v", err)
+		}
+		rids[5] = rid
+		verify(t)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		err := db.RecordManager.UpdateRecord(users, rids[6], &Record{Values: []interface{}{"six", 5}})
+		expectDuplicate(t, err)
+		if byID := rows(t); byID[6] != "user 6" || byID[5] != "new 5" {
+			t.Errorf("Expected both rows unchanged, got %q and %q", byID[6], byID[5])
+		}
+
+		if err := db.RecordManager.UpdateRecord(users, rids[6], &Record{Values: []interface{}{"six", 1006}}); err != nil {
+			t.Fatalf("Failed to change a key: %v", err)
+		}
+		if err := db.RecordManager.UpdateRecord(users, rids[7], &Record{Values: []interface{}{"seven", 7}}); err != nil {
+			t.Fatalf("Failed to update a row keeping its key: %v", err)
+		}
+		if _, found, _ := users.PrimaryIndex().Search(intKey(6)); found {
+			t.Error("Expected the old key to be gone")
+		}
+		verify(t)
+	})
+
+	t.Run("Vacuum", func(t *testing.T) {
+		// Leave a few rows per page so VACUUM moves them
+		for i := 10; i < 300; i++ {
+			if i%40 != 0 {
+				if err := db.RecordManager.DeleteRecord(users, rids[i]); err != nil {
+					t.Fatalf("Failed to delete: %v", err)
+				}
+			}
+		}
+		report, err := db.Vacuum()
+		if err != nil || report.RecordsMoved == 0 {
+			t.Fatalf("Expected VACUUM to move rows, got %+v %v", report, err)
+		}
+		verify(t)
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer db.Close()
+	users = db.Tables["users"]
+	if users.PrimaryIndex() == nil {
+		t.Fatal("Expected the index to be attached to its table after reopening")
+	}
+	_, err = db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{"again", 5}})
+	expectDuplicate(t, err)
+	verify(t)
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+}
+
+func TestMissingPrimaryIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	columns := []Column{
+		{Name: "level", DataType: TypeVarchar, Length: 10},
+		{Name: "id", DataType: TypeInteger},
+	}
+	if err := db.CreateTableWithPrimaryKey("log", columns, 1); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	log := db.Tables["log"]
+	var rids []*RecordID
+	for i, level := range []string{"info", "warn", "info"} {
+		rid, err := db.RecordManager.InsertRecord(log, &Record{Values: []interface{}{level, i}})
+		if err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		rids = append(rids, rid)
+	}
+
+	// Make the table one written before primary key indexes, keyed by level
+	if err := db.dropIndex(log.PrimaryIndex()); err != nil {
+		t.Fatalf("Failed to drop the index: %v", err)
+	}
+	log.PrimaryKey = 0
+	err = db.RecordManager.Scan(db.catalog, func(rid RecordID, record *Record) error {
+		if kind, definition, _ := catalogEntry(record); kind == catalogKindTable {
+			if table, _ := DeserializeTable(definition); table != nil && table.Name == "log" {
+				record := &Record{Values: []interface{}{kind, string(log.Serialize())}}
+				return db.RecordManager.UpdateRecord(db.catalog, &rid, record)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to rewrite the table: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// The rows share a key, so the table opens without its index
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Expected the database to open, got %v", err)
+	}
+	log = db.Tables["log"]
+	if log.PrimaryIndex() != nil {
+		t.Fatal("Expected no primary key index over duplicate keys")
+	}
+	report, err := db.Check()
+	if err != nil || len(report.Problems) != 1 || report.Problems[0].Kind != CheckPrimary ||
+		!strings.Contains(report.Problems[0].Message, "duplicate key 'info'") {
+		t.Errorf("Expected the missing index to be reported, got %+v %v", report, err)
+	}
+
+	// Without the index nothing refuses another duplicate, so only deletes are taken
+	if _, err := db.RecordManager.InsertRecord(log, &Record{Values: []interface{}{"info", 3}}); !errors.Is(err, ErrDuplicatePrimaryKeys) {
+		t.Errorf("Expected the insert to be refused, got %v", err)
+	}
+	if err := db.RecordManager.UpdateRecord(log, rids[0], &Record{Values: []interface{}{"info", 10}}); !errors.Is(err, ErrDuplicatePrimaryKeys) {
+		t.Errorf("Expected the update to be refused, got %v", err)
+	}
+	if _, err := db.RecordManager.NewBulkLoader(log); !errors.Is(err, ErrDuplicatePrimaryKeys) {
+		t.Errorf("Expected the bulk load to be refused, got %v", err)
+	}
+	if err := db.RecordManager.DeleteRecord(log, rids[2]); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// Once the duplicate is gone, the next open builds the index
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer db.Close()
+	if db.Tables["log"].PrimaryIndex() == nil {
+		t.Error("Expected the primary key index once the keys are unique")
+	}
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	btree, err := db.CreateBTreeWithOptions("emails", BTreeOptions{Degree: 3, Unique: true})
+	if err != nil {
+		t.Fatalf("Failed to create B-tree: %v", err)
+	}
+
+	// NULLs never collide
+	null, _ := btree.Key(nil)
+	for i := 0; i < 3; i++ {
+		if err := btree.Insert(null, RecordID{uint64(i), 1}); err != nil {
+			t.Errorf("Expected NULL keys to be allowed, got %v", err)
+		}
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// Every goroutine tries every key; exactly one insert of each wins
+		const keys = 300
+		wins := make([]int, keys)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < keys; i++ {
+					key, _ := btree.Key(fmt.Sprintf("user%d@example.com", i))
+					err := btree.Insert(key, RecordID{uint64(g + 1), uint16(i)})
+					if err != nil && !errors.Is(err, ErrDuplicateKey) {
+						t.Errorf("Insert failed: %v", err)
+						return
+					}
+					if err == nil {
+						mu.Lock()
+						wins[i]++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for i, n := range wins {
+			if n != 1 {
+				t.Errorf("Key %d was inserted %d times", i, n)
+			}
+		}
+		if problems := btree.Check(); problems != nil {
+			t.Errorf("Expected a valid tree, got %v", problems)
+		}
+	})
+
+	t.Run("ConcurrentRecords", func(t *testing.T) {
+		// Rows race for pages of the table as well as for keys
+		columns := []Column{
+			{Name: "id", DataType: TypeInteger},
+			{Name: "name", DataType: TypeVarchar, Length: 40},
+		}
+		if err := db.CreateTable("accounts", columns); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		accounts := db.Tables["accounts"]
+		const keys = 300
+		wins := make([]int, keys)
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < keys; i++ {
+					record := &Record{Values: []interface{}{i, fmt.Sprintf("account %d of %d", i, g)}}
+					_, err := db.RecordManager.InsertRecord(accounts, record)
+					if err != nil && !errors.Is(err, ErrDuplicateKey) {
+						t.Errorf("Insert failed: %v", err)
+						return
+					}
+					if err == nil {
+						mu.Lock()
+						wins[i]++
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		for i, n := range wins {
+			if n != 1 {
+				t.Errorf("Row %d was inserted %d times", i, n)
+			}
+		}
+		rows := 0
+		err := db.RecordManager.Scan(accounts, func(RecordID, *Record) error {
+			rows++
+			return nil
+		})
+		if err != nil || rows != keys {
+			t.Errorf("Expected %d rows, got %d: %v", keys, rows, err)
+		}
+		// Refused rows were never written, so no slot is dead
+		for _, pageID := range accounts.pages() {
+			page, err := db.GetPage(pageID)
+			if err != nil {
+				t.Fatalf("Failed to read page %d: %v", pageID, err)
+			}
+			for i, slot := range DeserializePageLayout(page.Data).slots {
+				if slot.Flags&SlotFlagDeleted != 0 {
+					t.Fatalf("Page %d: slot %d holds a refused row", pageID, i+1)
+				}
+			}
+		}
+		if report, err := db.Check(); err != nil || !report.OK() {
+			t.Errorf("Check failed: %v %+v", err, report)
+		}
+	})
+}
+
+func TestBuildIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	// Runs of a build that crashed are removed on open, other files are kept
+	for _, name := range []string{"test.db.sort9.1", "test.db.sort9.2", "other.db.sort9.1"} {
+		f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		f.Close()
+	}
+	db, err := NewDatabaseWithOptions("test.db", Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	if runs, _ := fs.Glob("*.sort*"); len(runs) != 1 || runs[0] != "other.db.sort9.1" {
+		t.Errorf("Expected only the runs of test.db to be removed, found %v", runs)
+	}
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "email", DataType: TypeVarchar, Length: 30},
+	}
+	if err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	// Emails in an order unrelated to the rows', some of them shared
+	const n = 600
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("user%d@example.com", (i*7919)%(n/2))
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, email}}); err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+	}
+
+	for _, memory := range []int{indexSortMemory, 1000} {
+		name := fmt.Sprintf("emails%d", memory)
+		index, err := db.CreateBTreeWithOptions(name, BTreeOptions{
+			KeySize:      columnsKeySize(db.PageSize, users, []int{1}),
+			Columns:      make([]KeyColumn, 1),
+			TableID:      users.ID,
+			TableColumns: []int{1},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create B-tree: %v", err)
+		}
+		sorter := db.newEntrySorter(index.ID, memory)
+		if memory < indexSortMemory {
+			// Check that the small budget spills runs
+			for i := 0; i < n; i++ {
+				if err := sorter.add(intKey(i), RecordID{}); err != nil {
+					t.Fatalf("Failed to add: %v", err)
+				}
+			}
+			if len(sorter.runs) < 5 {
+				t.Errorf("Expected %d bytes of memory to spill several runs, got %d", memory, len(sorter.runs))
+			}
+			sorter.close()
+		}
+		if err := db.buildIndex(users, index, memory); err != nil {
+			t.Fatalf("%s: failed to build: %v", name, err)
+		}
+		if problems := index.Check(); problems != nil {
+			t.Fatalf("%s: expected a valid index, got %v", name, problems)
+		}
+
+		count := 0
+		err = index.Range(KeyRange{}, func(key []byte, rid RecordID) error {
+			count++
+			record, err := db.RecordManager.GetRecord(users, &rid)
+			if err != nil {
+				return err
+			}
+			if want, _ := index.recordKey(record); string(want) != string(key) {
+				t.Errorf("%s: entry %s points to record %v", name, formatKey(index.Columns, key), record.Values)
+			}
+			return nil
+		})
+		if err != nil || count != n {
+			t.Errorf("%s: expected %d entries, got %d: %v", name, n, count, err)
+		}
+		if runs, _ := fs.Glob(db.sortRunPattern()); len(runs) != 0 {
+			t.Errorf("%s: expected the sort runs to be removed, found %v", name, runs)
+		}
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "test.wal"}
+	db, err := NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "city", DataType: TypeVarchar, Length: 20},
+		{Name: "score", DataType: TypeInteger},
+	}
+	if err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	rids := make(map[int]*RecordID)
+	for i := 0; i < 300; i++ {
+		var score interface{} = i % 50
+		if i%13 == 0 {
+			score = nil
+		}
+		rid, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("city %d", i%7), score}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids[i] = rid
+	}
+
+	index, err := db.CreateIndex("users_city", "users", IndexOptions{
+		Columns: []int{1, 2},
+		Order:   []KeyColumn{{}, {Descending: true, Nulls: NullsLast}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if len(users.Indexes) != 2 || db.Indexes["users_city"] != index {
+		t.Fatalf("Expected the index to be attached to its table, got %v", users.Indexes)
+	}
+
+	// verify checks that the index holds exactly one entry per row
+	verify := func(t *testing.T) {
+		t.Helper()
+		index := db.Indexes["users_city"]
+		if problems := index.Check(); problems != nil {
+			t.Fatalf("Expected a valid index, got %v", problems)
+		}
+		entries := 0
+		err := index.Range(KeyRange{}, func(key []byte, rid RecordID) error {
+			entries++
+			record, err := db.RecordManager.GetRecord(users, &rid)
+			if err != nil {
+				return err
+			}
+			if want, _ := index.recordKey(record); string(want) != string(key) {
+				t.Errorf("Entry %s points to record %v", formatKey(index.Columns, key), record.Values)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to scan the index: %v", err)
+		}
+		count := 0
+		db.RecordManager.Scan(users, func(RecordID, *Record) error {
+			count++
+			return nil
+		})
+		if entries != count {
+			t.Errorf("Expected %d entries, got %d", count, entries)
+		}
+	}
+	verify(t)
+
+	// Within a city the scores come highest first, NULLs after them
+	key, _ := index.Key("city 0", 49)
+	var first []interface{}
+	stop := errors.New("stop")
+	err = db.Indexes["users_city"].Range(KeyRange{Lo: key, LoInclusive: true}, func(key []byte, rid RecordID) error {
+		record, err := db.RecordManager.GetRecord(users, &rid)
+		if err != nil {
+			return err
+		}
+		first = record.Values
+		return stop
+	})
+	if err != stop || fmt.Sprint(first) != "[49 city 0 49]" {
+		t.Errorf("Expected the row with the highest score in city 0 first, got %v %v", first, err)
+	}
+
+	if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{300, "city 0", 7}}); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := db.RecordManager.UpdateRecord(users, rids[1], &Record{Values: []interface{}{1, "city 9", 1}}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if err := db.RecordManager.DeleteRecord(users, rids[2]); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	verify(t)
+
+	t.Run("Unique", func(t *testing.T) {
+		_, err := db.CreateIndex("users_score", "users", IndexOptions{Columns: []int{2}, Unique: true})
+		if !errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("Expected a duplicate key error, got %v", err)
+		}
+		if db.Indexes["users_score"] != nil || len(users.Indexes) != 2 {
+			t.Error("Expected the failed index to be gone")
+		}
+		// A dropped index gives its pages back to the next one
+		var pages uint64
+		for i := 0; i < 2; i++ {
+			if _, err := db.CreateIndex("users_id", "users", IndexOptions{Columns: []int{0}, Unique: true}); err != nil {
+				t.Fatalf("Failed to create a unique index: %v", err)
+			}
+			if i == 1 && db.PageCount() != pages {
+				t.Errorf("Expected the dropped pages to be reused, %d pages grew to %d", pages, db.PageCount())
+			}
+			pages = db.PageCount()
+			if err := db.DropIndex("users_id"); err != nil {
+				t.Fatalf("Failed to drop: %v", err)
+			}
+		}
+	})
+
+	for _, bad := range []struct {
+		name string
+		opts IndexOptions
+	}{
+		{"users_city", IndexOptions{Columns: []int{1}}},
+		{"users_none", IndexOptions{}},
+		{"users_bad", IndexOptions{Columns: []int{3}}},
+		{"users_order", IndexOptions{Columns: []int{1}, Order: make([]KeyColumn, 2)}},
+	} {
+		if _, err := db.CreateIndex(bad.name, "users", bad.opts); err == nil {
+			t.Errorf("Expected index %s %+v to be refused", bad.name, bad.opts)
+		}
+	}
+	if err := db.DropIndex("users_pkey"); err == nil || db.Indexes["users_pkey"] == nil {
+		t.Error("Expected the primary key index to stay")
+	}
+	if err := db.DropIndex("missing"); err == nil {
+		t.Error("Expected dropping a missing index to fail")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	db, err = NewDatabaseWithOptions("test.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer db.Close()
+	users = db.Tables["users"]
+	if len(users.Indexes) != 2 || db.Indexes["users_city"] == nil || db.Indexes["users_id"] != nil {
+		t.Fatalf("Expected users_pkey and users_city after reopening, got %v", users.Indexes)
+	}
+	verify(t)
+	if report, err := db.Check(); err != nil || !report.OK() {
+		t.Errorf("Check failed: %v %+v", err, report)
+	}
+}
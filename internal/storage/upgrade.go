@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
+)
+
+// Upgrading from version 1, which had no header page and no page types:
+//
+//  1. The version 1 log, if any, is replayed onto the old file, because its
+//     entries name pages by their old numbers.
+//  2. A new file is written next to the old one: the header page, then every
+//     old page moved up by one with its type set from its owner. Pages are
+//     not referenced by number anywhere on disk, so the move is safe.
+//  3. The new file replaces the old one, and the log is replaced by an empty
+//     one that continues after the last old LSN.
+//
+// A crash before step 3 leaves the old file and log untouched. A crash
+// between the two replacements leaves a current file with an old log whose
+// entries are already applied; running the upgrade again only replaces the log.
+
+// UpgradeReport describes what Upgrade did
+type UpgradeReport struct {
+	From     uint32 `json:"from"`     // format version found
+	To       uint32 `json:"to"`       // format version written
+	Pages    uint64 `json:"pages"`    // pages in the upgraded file
+	Replayed int    `json:"replayed"` // log entries applied before rewriting
+}
+
+// Upgraded reports whether anything was rewritten
+func (r *UpgradeReport) Upgraded() bool {
+	return r.From != r.To
+}
+
+// Upgrade rewrites the database at path, and its log at opts.WALPath if set,
+// in the current format version. Files that are already current are left
+// alone. The database must not be open elsewhere; pass the log of a database
+// that has one, or changes only in the log are lost.
+func Upgrade(path string, opts Options) (*UpgradeReport, error) {
+	if opts.FS == nil {
+		opts.FS = vfs.OS
+	}
+	size := int64(DefaultPageSize)
+
+	file, err := opts.FS.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := file.Lock(vfs.LockExclusive); err != nil {
+		if errors.Is(err, vfs.ErrLocked) {
+			return nil, fmt.Errorf("%w: %s", ErrDatabaseLocked, path)
+		}
+		return nil, err
+	}
+
+	version, err := FileVersion(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	report := &UpgradeReport{From: version, To: FormatVersion}
+	if version > FormatVersion {
+		return nil, fmt.Errorf("%w: %s is version %d, written by a newer build than this one (version %d)",
+			ErrUnsupportedVersion, path, version, FormatVersion)
+	}
+
+	logVersion := uint32(FormatVersion)
+	if opts.WALPath != "" {
+		logVersion, err = wal.Version(opts.FS, opts.WALPath)
+		if errors.Is(err, os.ErrNotExist) {
+			logVersion, err = FormatVersion, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if version == FormatVersion {
+		if logVersion == 1 {
+			// Only the log is left over from an interrupted upgrade, and its
+			// entries are in the file already
+			log, err := wal.OpenVersion1(opts.FS, opts.WALPath)
+			if err != nil {
+				return nil, err
+			}
+			lastLSN := log.CurrentLSN()
+			log.Close()
+			if err := replaceLog(opts.FS, opts.WALPath, lastLSN); err != nil {
+				return nil, err
+			}
+		} else if logVersion != FormatVersion {
+			return nil, fmt.Errorf("%w: log %s is version %d", ErrUnsupportedVersion, opts.WALPath, logVersion)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		report.Pages = uint64(info.Size() / size)
+		return report, nil
+	}
+
+	var lastLSN wal.LSN
+	if opts.WALPath != "" && logVersion == 1 {
+		if report.Replayed, lastLSN, err = replayVersion1Log(file, opts.FS, opts.WALPath, size); err != nil {
+			return nil, fmt.Errorf("replaying version 1 log: %w", err)
+		}
+	} else if logVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: log %s is version %d", ErrUnsupportedVersion, opts.WALPath, logVersion)
+	}
+
+	if report.Pages, err = rewriteVersion1(file, opts.FS, path, size); err != nil {
+		return nil, err
+	}
+	if opts.WALPath != "" {
+		if err := replaceLog(opts.FS, opts.WALPath, lastLSN); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// replayVersion1Log applies the entries after the last checkpoint of a
+// version 1 log to the old file and returns how many were applied and the
+// last LSN in the log
+func replayVersion1Log(file vfs.File, fs vfs.FS, walPath string, pageSize int64) (int, wal.LSN, error) {
+	log, err := wal.OpenVersion1(fs, walPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer log.Close()
+
+	r := log.StartRecovery()
+	if err := r.Recover(); err != nil {
+		return 0, 0, err
+	}
+
+	pages := make(map[uint64][]byte)
+	for _, entry := range r.RedoLog() {
+		data, ok := pages[entry.PageID]
+		if !ok {
+			data = make([]byte, pageSize)
+			if _, err := file.ReadAt(data, int64(entry.PageID)*pageSize); err != nil && !errors.Is(err, io.EOF) {
+				return 0, 0, err
+			}
+			pages[entry.PageID] = data
+		}
+
+		change := entry.Record
+		if entry.Type == wal.LogTypeFullPage {
+			copy(data, change.After)
+		} else {
+			if int(change.Offset)+len(change.After) > len(data) {
+				return 0, 0, errors.New("log record does not fit in page")
+			}
+			copy(data[change.Offset:], change.After)
+		}
+		setPageLSN(data, entry.LSN)
+	}
+
+	for pageID, data := range pages {
+		if _, err := file.WriteAt(data, int64(pageID)*pageSize); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(r.RedoLog()), log.CurrentLSN(), file.Sync()
+}
+
+// rewriteVersion1 writes the current format of a version 1 file next to it
+// and moves it into place. It returns the number of pages written.
+func rewriteVersion1(file vfs.File, fs vfs.FS, path string, pageSize int64) (uint64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	oldPages := uint64(info.Size() / pageSize)
+
+	tmpPath := path + ".upgrade"
+	out, err := fs.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer fs.Remove(tmpPath) // fails harmlessly once renamed
+	defer out.Close()
+
+	if _, err := out.WriteAt(encodeHeaderPage(uint16(pageSize)), 0); err != nil {
+		return 0, err
+	}
+	data := make([]byte, pageSize)
+	for pageID := uint64(0); pageID < oldPages; pageID++ {
+		if _, err := file.ReadAt(data, int64(pageID)*pageSize); err != nil {
+			return 0, fmt.Errorf("page %d: %w", pageID, err)
+		}
+		page := upgradePage(data)
+		if _, err := out.WriteAt(page, int64(pageID+1)*pageSize); err != nil {
+			return 0, err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		return 0, err
+	}
+	return oldPages + 1, nil
+}
+
+// upgradePage gives a version 1 page its type. Pages without an owner are
+// formatted as free pages, so zeroed ones get a valid layout too.
+func upgradePage(data []byte) []byte {
+	layout := DeserializePageLayout(data)
+	switch layout.header.TableID {
+	case 0:
+		free := NewPageLayout(uint16(len(data)))
+		free.header.Type = PageTypeFree
+		free.header.LSN = layout.header.LSN
+		return free.Serialize()
+	case catalogTableID:
+		layout.header.Type = PageTypeCatalog
+	default:
+		layout.header.Type = PageTypeHeap
+	}
+	return layout.Serialize()
+}
+
+// replaceLog swaps the log for an empty one in the current format that
+// continues after lastLSN
+func replaceLog(fs vfs.FS, walPath string, lastLSN wal.LSN) error {
+	tmpPath := walPath + ".upgrade"
+	out, err := fs.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if err := wal.WriteHeader(out, lastLSN); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, walPath)
+}
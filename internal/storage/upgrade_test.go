@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"godb/internal/vfs"
+	"godb/internal/wal"
+)
+
+// writeVersion1 turns a closed current database into the version 1 layout:
+// no header page, no page types and no indexes. The last page of the table is
+// moved into a version 1 log as a full page image, so the upgrade has to
+// replay it.
+func writeVersion1(t *testing.T, fs vfs.FS, current, path, walPath string, table *Table) {
+	t.Helper()
+	size := int64(DefaultPageSize)
+
+	src, err := fs.OpenFile(current, os.O_RDONLY, 0666)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", current, err)
+	}
+	defer src.Close()
+	info, _ := src.Stat()
+	pages := info.Size() / size
+
+	dst, _ := fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	defer dst.Close()
+	logged := int64(table.PageIDs[len(table.PageIDs)-1])
+	data := make([]byte, size)
+	var image []byte
+	for pageID := int64(1); pageID < pages; pageID++ {
+		if _, err := src.ReadAt(data, pageID*size); err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageID, err)
+		}
+		switch PageType(data[OffsetPageType]) {
+		case PageTypeBTree:
+			clear(data)
+		case PageTypeCatalog:
+			dropIndexRows(t, data)
+		}
+		data[OffsetPageType] = 0
+		if pageID == logged {
+			image = append([]byte(nil), data...)
+			clear(data)
+		}
+		dst.WriteAt(data, (pageID-1)*size)
+	}
+
+	// A version 1 log is the entries of a current one without its header
+	log, err := wal.NewWALWithFS(fs, "entries.wal")
+	if err != nil {
+		t.Fatalf("Failed to create log: %v", err)
+	}
+	entry := &wal.LogEntry{Type: wal.LogTypeFullPage, PageID: uint64(logged - 1), Record: wal.LogRecord{After: image}}
+	if err := log.Write(entry); err != nil {
+		t.Fatalf("Failed to write log entry: %v", err)
+	}
+	log.Close()
+	entries, _ := fs.OpenFile("entries.wal", os.O_RDONLY, 0666)
+	defer entries.Close()
+	body, err := io.ReadAll(io.NewSectionReader(entries, wal.HeaderSize, 1<<20))
+	if err != nil {
+		t.Fatalf("Failed to read log entries: %v", err)
+	}
+	old, _ := fs.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	old.WriteAt(body, 0)
+	old.Close()
+}
+
+// dropIndexRows deletes the index rows of a catalog page
+func dropIndexRows(t *testing.T, data []byte) {
+	t.Helper()
+	layout := DeserializePageLayout(data)
+	for i, slot := range layout.slots {
+		record, err := DeserializeRecord(data[slot.Offset : slot.Offset+uint32(slot.Length)])
+		if err != nil {
+			t.Fatalf("Failed to read catalog row: %v", err)
+		}
+		if kind, _, _ := catalogEntry(record); kind == catalogKindIndex {
+			layout.slots[i].Flags |= SlotFlagDeleted
+		}
+	}
+	copy(data, layout.Serialize())
+}
+
+func TestUpgrade(t *testing.T) {
+	fs := vfs.NewMemFS()
+	db, err := NewDatabaseWithOptions("current.db", Options{FS: fs})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	columns := []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}
+	if err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	for i := 0; i < 300; i++ {
+		if _, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}}); err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+	}
+	if len(users.PageIDs) < 2 {
+		t.Fatalf("Expected the table to span several pages, got %v", users.PageIDs)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	writeVersion1(t, fs, "current.db", "old.db", "old.wal", users)
+
+	opts := Options{FS: fs, WALPath: "old.wal"}
+	if version, err := FileVersion("old.db", opts); err != nil || version != 1 {
+		t.Fatalf("Expected version 1, got %d, %v", version, err)
+	}
+	if _, err := NewDatabaseWithOptions("old.db", opts); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Expected ErrUnsupportedVersion opening a version 1 file, got %v", err)
+	}
+
+	report, err := Upgrade("old.db", opts)
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if !report.Upgraded() || report.From != 1 || report.To != FormatVersion || report.Replayed != 1 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	log, err := wal.NewWALWithFS(fs, "old.wal")
+	if err != nil {
+		t.Fatalf("Failed to open the upgraded log: %v", err)
+	}
+	if log.CurrentLSN() != 1 {
+		t.Errorf("Expected the log to continue after LSN 1, got %d", log.CurrentLSN())
+	}
+	log.Close()
+
+	db, err = NewDatabaseWithOptions("old.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to open upgraded database: %v", err)
+	}
+	count := 0
+	if err := db.RecordManager.Scan(db.Tables["users"], func(rid RecordID, record *Record) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if count != 300 {
+		t.Errorf("Expected 300 rows after the upgrade, got %d", count)
+	}
+	// Opened for writing, the table gets its primary key index
+	if index := db.Tables["users"].PrimaryIndex(); index == nil {
+		t.Error("Expected the upgraded table to get a primary key index")
+	} else if rid, found, _ := index.Search(intKey(299)); !found || rid.PageID == 0 {
+		t.Errorf("Expected the index to hold the table's rows, got %v %v", rid, found)
+	}
+	check, err := db.Check()
+	if err != nil || !check.OK() {
+		t.Errorf("Check after upgrade: %v, %+v", err, check)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	report, err = Upgrade("old.db", opts)
+	if err != nil || report.Upgraded() {
+		t.Errorf("Expected a second upgrade to do nothing, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"godb/internal/wal"
+)
+
+// VACUUM works table by table. It first compacts every page that has deleted
+// records, then empties sparse pages by moving their rows into free space on
+// pages earlier in the table, and returns every page left empty to the free
+// list. Free pages at the end of the file are then cut off. Moving rows to
+// lower pages is what lets the tail of the file become free. Each move is
+// logged as one unit, see record_log.go.
+//
+// Vacuum must not run concurrently with other changes to the database.
+
+// sparseFraction is how full a page may be, as a fraction of its usable
+// space, and still have its rows moved to other pages
+const sparseFraction = 0.5
+
+// VacuumReport describes what Vacuum did
+type VacuumReport struct {
+	PagesCompacted int    `json:"pages_compacted"`
+	RecordsMoved   int    `json:"records_moved"`
+	PagesFreed     int    `json:"pages_freed"`     // returned to the free list
+	PagesTruncated uint64 `json:"pages_truncated"` // free pages cut off the end of the file
+	BytesReclaimed int64  `json:"bytes_reclaimed"` // how much smaller the file got
+}
+
+// Vacuum reclaims the space of deleted records and empty pages and shrinks
+// the file by the free pages at its end
+func (db *Database) Vacuum() (*VacuumReport, error) {
+	if db.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	report := &VacuumReport{}
+	sizeBefore := int64(db.PageCount()) * int64(db.PageSize)
+	for _, table := range db.checkedTables() {
+		if err := db.vacuumTable(table, report); err != nil {
+			return nil, fmt.Errorf("vacuum %s: %w", table.Name, err)
+		}
+	}
+	if err := db.truncateFreePages(report); err != nil {
+		return nil, err
+	}
+	report.BytesReclaimed = sizeBefore - int64(db.PageCount())*int64(db.PageSize)
+	return report, nil
+}
+
+// vacuumTable compacts the pages of one table and frees the ones it empties
+func (db *Database) vacuumTable(table *Table, report *VacuumReport) error {
+	var kept []uint64
+	for _, pageID := range table.PageIDs {
+		live, err := db.compactPage(pageID, report)
+		if err != nil {
+			return err
+		}
+		if live == 0 && !db.isCatalogRoot(table, pageID) {
+			if err := db.freePage(pageID, report); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, pageID)
+	}
+	table.PageIDs = kept
+
+	// Empty sparse pages from the back so free pages collect at the end of the file
+	sort.Slice(table.PageIDs, func(i, j int) bool { return table.PageIDs[i] < table.PageIDs[j] })
+	for i := len(table.PageIDs) - 1; i > 0; i-- {
+		pageID := table.PageIDs[i]
+		moved, err := db.emptySparsePage(table, pageID, table.PageIDs[:i], report)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			continue
+		}
+		if err := db.freePage(pageID, report); err != nil {
+			return err
+		}
+		table.PageIDs = append(table.PageIDs[:i], table.PageIDs[i+1:]...)
+	}
+	return nil
+}
+
+// isCatalogRoot reports whether the page is the catalog's first page, which
+// always stays with the catalog
+func (db *Database) isCatalogRoot(table *Table, pageID uint64) bool {
+	return table == db.catalog && pageID == catalogRootPage
+}
+
+// compactPage squeezes out deleted records and returns how many live ones the page holds
+func (db *Database) compactPage(pageID uint64, report *VacuumReport) (int, error) {
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return 0, err
+	}
+	page.latch.Lock()
+	defer page.latch.Unlock()
+
+	layout := DeserializePageLayout(page.Data)
+	live := 0
+	for _, slot := range layout.slots {
+		if slot.Flags&SlotFlagDeleted == 0 {
+			live++
+		}
+	}
+	if live == len(layout.slots) {
+		return live, nil
+	}
+
+	before := append([]byte(nil), page.Data...)
+	layout.compact()
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	if err := db.logPageChange(page, before, wal.LogTypeUpdate); err != nil {
+		return 0, err
+	}
+	report.PagesCompacted++
+	return live, nil
+}
+
+// emptySparsePage moves every row of a sparse page into the given target
+// pages. Nothing is moved unless all rows fit. It reports whether the page
+// was emptied.
+func (db *Database) emptySparsePage(table *Table, pageID uint64, targets []uint64, report *VacuumReport) (bool, error) {
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return false, err
+	}
+	page.latch.RLock()
+	layout := DeserializePageLayout(append([]byte(nil), page.Data...))
+	page.latch.RUnlock()
+
+	usable := float64(int(db.PageSize) - PageHeaderSize)
+	if float64(layout.liveBytes()) >= usable*sparseFraction {
+		return false, nil
+	}
+
+	// Plan first-fit placements before moving anything
+	free := make(map[uint64]uint32, len(targets))
+	placement := make(map[int]uint64)
+	for i, slot := range layout.slots {
+		if slot.Flags&SlotFlagDeleted != 0 {
+			continue
+		}
+		need := uint32(slot.Length) + SlotEntrySize
+		placed := false
+		for _, target := range targets {
+			room, ok := free[target]
+			if !ok {
+				if room, err = db.pageFreeSpace(target); err != nil {
+					return false, err
+				}
+			}
+			if room >= need {
+				free[target] = room - need
+				placement[i] = target
+				placed = true
+				break
+			}
+			free[target] = room
+		}
+		if !placed {
+			return false, nil
+		}
+	}
+
+	for i, slot := range layout.slots {
+		target, ok := placement[i]
+		if !ok {
+			continue
+		}
+		data := layout.data[slot.Offset : slot.Offset+uint32(slot.Length)]
+		targetPage, err := db.GetPage(target)
+		if err != nil {
+			return false, err
+		}
+		from := RecordID{PageID: pageID, SlotNum: uint16(i + 1)}
+		var unit *recordUnit
+		slotNum, err := db.RecordManager.insertIntoPage(targetPage, data, func(to RecordID) (err error) {
+			unit, err = db.beginMoveUnit(table, from, to, data)
+			return err
+		})
+		if err != nil {
+			if unit != nil {
+				err = errors.Join(err, unit.undo(func() error { return nil }))
+			}
+			return false, err
+		}
+
+		// From here on the move is finished when the database is next
+		// opened, should it stop part way
+		to := RecordID{PageID: target, SlotNum: slotNum}
+		record, err := db.RecordManager.deleteFromPage(table, &from)
+		if err != nil {
+			return false, err
+		}
+		if err := db.RecordManager.moveIndexEntries(table, record, from, to); err != nil {
+			return false, err
+		}
+		if table == db.catalog {
+			db.sequenceMoved(from, to)
+		}
+		if err := unit.commit(); err != nil {
+			return false, err
+		}
+		report.RecordsMoved++
+	}
+	return true, nil
+}
+
+// pageFreeSpace returns how many bytes a page has left for records and slots
+func (db *Database) pageFreeSpace(pageID uint64) (uint32, error) {
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return 0, err
+	}
+	page.latch.RLock()
+	defer page.latch.RUnlock()
+	return DeserializePageLayout(page.Data).getFreeSpace(), nil
+}
+
+// freePage formats a page as owned by no table and puts it on the free list.
+// report may be nil when the page is freed outside of VACUUM.
+func (db *Database) freePage(pageID uint64, report *VacuumReport) error {
+	page, err := db.GetPage(pageID)
+	if err != nil {
+		return err
+	}
+
+	page.latch.Lock()
+	before := append([]byte(nil), page.Data...)
+	layout := NewPageLayout(db.PageSize)
+	layout.header.Type = PageTypeFree
+	page.Data = layout.Serialize()
+	page.IsDirty = true
+	err = db.logPageChange(page, before, wal.LogTypeDelete)
+	page.latch.Unlock()
+	if err != nil {
+		return err
+	}
+
+	db.allocMu.Lock()
+	i := sort.Search(len(db.freePages), func(i int) bool { return db.freePages[i] >= pageID })
+	db.freePages = append(db.freePages[:i], append([]uint64{pageID}, db.freePages[i:]...)...)
+	db.allocMu.Unlock()
+	if report != nil {
+		report.PagesFreed++
+	}
+	return nil
+}
+
+// truncateFreePages cuts the free pages at the end of the file off. It takes
+// a checkpoint first, so the log never has to redo a page that is gone.
+func (db *Database) truncateFreePages(report *VacuumReport) error {
+	db.allocMu.Lock()
+	cut := db.nextPageID
+	for n := len(db.freePages); n > 0 && db.freePages[n-1] == cut-1; n-- {
+		cut--
+	}
+	db.allocMu.Unlock()
+	if cut == db.PageCount() {
+		return nil
+	}
+
+	if err := db.Checkpoint(); err != nil {
+		return err
+	}
+
+	db.allocMu.Lock()
+	end := db.nextPageID
+	db.freePages = db.freePages[:sort.Search(len(db.freePages), func(i int) bool { return db.freePages[i] >= cut })]
+	db.nextPageID = cut
+	db.allocMu.Unlock()
+
+	for pageID := cut; pageID < end; pageID++ {
+		db.Cache.Remove(pageID)
+	}
+	if err := db.File.Truncate(int64(cut) * int64(db.PageSize)); err != nil {
+		return err
+	}
+	report.PagesTruncated = end - cut
+	return db.File.Sync()
+}
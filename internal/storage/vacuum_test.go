@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestVacuum(t *testing.T) {
+	fs := vfs.NewMemFS()
+	opts := Options{FS: fs, WALPath: "vacuum.wal"}
+	db, err := NewDatabaseWithOptions("vacuum.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	if err := db.CreateTable("users", []Column{
+		{Name: "id", DataType: TypeInteger},
+		{Name: "name", DataType: TypeVarchar, Length: 50},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	users := db.Tables["users"]
+	var rids []*RecordID
+	for i := 0; i < 2000; i++ {
+		rid, err := db.RecordManager.InsertRecord(users, &Record{Values: []interface{}{i, fmt.Sprintf("User %d", i)}})
+		if err != nil {
+			t.Fatalf("Failed to insert record %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+
+	// Keep every tenth row, so every page ends up sparse
+	want := make(map[int]bool)
+	for i, rid := range rids {
+		if i%10 == 0 {
+			want[i] = true
+			continue
+		}
+		if err := db.RecordManager.DeleteRecord(users, rid); err != nil {
+			t.Fatalf("Failed to delete record %d: %v", i, err)
+		}
+	}
+	if err := db.RecordManager.DeleteRecord(users, rids[1]); err == nil {
+		t.Error("Expected deleting a deleted record to fail")
+	}
+
+	pagesBefore := db.PageCount()
+	report, err := db.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	if report.PagesCompacted == 0 || report.RecordsMoved == 0 || report.PagesTruncated == 0 {
+		t.Errorf("Expected compaction, moves and truncation, got %+v", report)
+	}
+	if want := int64(report.PagesTruncated) * int64(db.PageSize); report.BytesReclaimed != want {
+		t.Errorf("Expected %d bytes reclaimed, got %d", want, report.BytesReclaimed)
+	}
+	if db.PageCount() >= pagesBefore {
+		t.Errorf("Expected fewer than %d pages, got %d", pagesBefore, db.PageCount())
+	}
+
+	check := func(db *Database) {
+		t.Helper()
+		got := make(map[int]bool)
+		err := db.RecordManager.Scan(db.Tables["users"], func(rid RecordID, record *Record) error {
+			id := record.Values[0].(int)
+			if record.Values[1] != fmt.Sprintf("User %d", id) {
+				t.Errorf("Record %v has the wrong name", record.Values)
+			}
+			got[id] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("Expected %d rows, got %d", len(want), len(got))
+		}
+		for id := range want {
+			if !got[id] {
+				t.Errorf("Row %d is missing", id)
+			}
+		}
+
+		checkReport, err := db.Check()
+		if err != nil || !checkReport.OK() {
+			t.Errorf("Check after vacuum failed: %v %+v", err, checkReport)
+		}
+	}
+	check(db)
+
+	// The file shrank and the rows survive a reopen
+	pages := db.PageCount()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	info, err := fs.Stat("vacuum.db")
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if info.Size() != int64(pages)*4096 {
+		t.Errorf("Expected a file of %d pages, got %d bytes", pages, info.Size())
+	}
+
+	db, err = NewDatabaseWithOptions("vacuum.db", opts)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	check(db)
+
+	// A second vacuum has nothing left to do
+	report, err = db.Vacuum()
+	if err != nil {
+		t.Fatalf("Second vacuum failed: %v", err)
+	}
+	if report.RecordsMoved != 0 || report.BytesReclaimed != 0 {
+		t.Errorf("Expected nothing to reclaim, got %+v", report)
+	}
+}
+
+func TestFreePageReuse(t *testing.T) {
+	db, err := NewDatabaseWithOptions(MemoryPath, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	for _, name := range []string{"a", "b"} {
+		if err := db.CreateTable(name, []Column{{Name: "v", DataType: TypeVarchar, Length: 100}}); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+	a, b := db.Tables["a"], db.Tables["b"]
+	fill := func(table *Table, n int) []*RecordID {
+		var rids []*RecordID
+		for i := 0; i < n; i++ {
+			rid, err := db.RecordManager.InsertRecord(table, &Record{Values: []interface{}{fmt.Sprintf("%0100d", i)}})
+			if err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+			rids = append(rids, rid)
+		}
+		return rids
+	}
+
+	// Table a's pages sit before table b's, so emptying a frees pages in the middle
+	rids := fill(a, 200)
+	fill(b, 50)
+	for _, rid := range rids {
+		if err := db.RecordManager.DeleteRecord(a, rid); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+	report, err := db.Vacuum()
+	if err != nil {
+		t.Fatalf("Vacuum failed: %v", err)
+	}
+	if report.PagesFreed == 0 || report.PagesTruncated != 0 {
+		t.Fatalf("Expected free pages in the middle of the file, got %+v", report)
+	}
+
+	pages := db.PageCount()
+	fill(a, 50)
+	if db.PageCount() != pages {
+		t.Errorf("Expected new rows to reuse free pages, page count went from %d to %d", pages, db.PageCount())
+	}
+	if checkReport, err := db.Check(); err != nil || !checkReport.OK() {
+		t.Errorf("Check failed: %v %+v", err, checkReport)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package vfs
+
+import (
+	"os"
+)
+
+// Advisory locks are not supported on this platform, so locking always succeeds
+
+func lockFile(file *os.File, lockType LockType) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,278 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemFS keeps every file in memory. Files live until they are removed or the
+// MemFS itself is dropped, so a database can be closed and reopened within
+// the same MemFS.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memNode
+}
+
+// memNode is the contents of one file, shared by every handle opened on it
+type memNode struct {
+	mu        sync.RWMutex
+	data      []byte
+	modTime   time.Time
+	shared    int  // number of shared locks held
+	exclusive bool // whether an exclusive lock is held
+}
+
+// NewMemFS creates an empty in-memory file system
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memNode)}
+}
+
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	node, exists := fs.files[name]
+	switch {
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !exists:
+		node = &memNode{modTime: time.Now()}
+		fs.files[name] = node
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if writable && flag&os.O_TRUNC != 0 {
+		node.mu.Lock()
+		node.data = nil
+		node.modTime = time.Now()
+		node.mu.Unlock()
+	}
+
+	return &memFile{name: name, node: node, writable: writable}, nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, exists := fs.files[name]; !exists {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// Rename moves the contents of oldName to newName. Handles open on either
+// name keep the contents they had.
+func (fs *MemFS) Rename(oldName, newName string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	node, exists := fs.files[oldName]
+	if !exists {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldName)
+	fs.files[newName] = node
+	return nil
+}
+
+// Glob matches the pattern against every file name, in sorted order
+func (fs *MemFS) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var names []string
+	for name := range fs.files {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	node, exists := fs.files[name]
+	fs.mu.Unlock()
+
+	if !exists {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return node.stat(name), nil
+}
+
+func (n *memNode) stat(name string) os.FileInfo {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return memFileInfo{name: name, size: int64(len(n.data)), modTime: n.modTime}
+}
+
+// memFile is one open handle on a memNode
+type memFile struct {
+	name     string
+	node     *memNode
+	writable bool
+	closed   bool
+	lock     *LockType // lock held by this handle, nil if none
+}
+
+var errReadOnly = errors.New("vfs: file is opened read-only")
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !f.writable {
+		return 0, errReadOnly
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.grow(end)
+	}
+	copy(f.node.data[off:], p)
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if !f.writable {
+		return errReadOnly
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	if size > int64(len(f.node.data)) {
+		f.node.grow(size)
+	} else {
+		f.node.data = f.node.data[:size]
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+// grow extends the file with zero bytes. The caller must hold n.mu.
+func (n *memNode) grow(size int64) {
+	if size <= int64(cap(n.data)) {
+		// Bytes past the end may be left from before a truncate
+		old := len(n.data)
+		n.data = n.data[:size]
+		clear(n.data[old:])
+		return
+	}
+	data := make([]byte, size, size*2)
+	copy(data, n.data)
+	n.data = data
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.node.stat(f.name), nil
+}
+
+func (f *memFile) Lock(lockType LockType) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	// Like flock, taking a lock again on the same handle converts it
+	f.node.release(f.lock)
+	f.lock = nil
+
+	if f.node.exclusive || (lockType == LockExclusive && f.node.shared > 0) {
+		return ErrLocked
+	}
+	if lockType == LockExclusive {
+		f.node.exclusive = true
+	} else {
+		f.node.shared++
+	}
+	f.lock = &lockType
+	return nil
+}
+
+func (f *memFile) Unlock() error {
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	f.node.release(f.lock)
+	f.lock = nil
+	return nil
+}
+
+// release drops a lock held by one handle. The caller must hold n.mu.
+func (n *memNode) release(lockType *LockType) {
+	switch {
+	case lockType == nil:
+	case *lockType == LockExclusive:
+		n.exclusive = false
+	default:
+		n.shared--
+	}
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.Unlock()
+	f.closed = true
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0666 }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,135 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+
+	t.Run("Read and Write", func(t *testing.T) {
+		f, err := fs.OpenFile("data.db", os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatalf("Failed to open file: %v", err)
+		}
+		defer f.Close()
+
+		if _, err := f.WriteAt([]byte("world"), 6); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if _, err := f.WriteAt([]byte("hello"), 0); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+
+		buf := make([]byte, 11)
+		if _, err := f.ReadAt(buf, 0); err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if string(buf) != "hello\x00world" {
+			t.Errorf("Unexpected contents %q", buf)
+		}
+
+		if _, err := f.ReadAt(buf, 5); err != io.EOF {
+			t.Errorf("Expected io.EOF on short read, got %v", err)
+		}
+
+		if err := f.Truncate(5); err != nil {
+			t.Fatalf("Failed to truncate: %v", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Failed to stat: %v", err)
+		}
+		if info.Size() != 5 {
+			t.Errorf("Expected size 5 after truncate, got %d", info.Size())
+		}
+
+		// Growing again within the old length reads zeros, not the old bytes
+		if err := f.Truncate(0); err != nil {
+			t.Fatalf("Failed to truncate: %v", err)
+		}
+		if err := f.Truncate(6); err != nil {
+			t.Fatalf("Failed to grow: %v", err)
+		}
+		buf = make([]byte, 6)
+		if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != "\x00\x00\x00\x00\x00\x00" {
+			t.Errorf("Expected zeros after growing, got %q, %v", buf, err)
+		}
+		if _, err := f.WriteAt([]byte("x"), 9); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		buf = make([]byte, 10)
+		if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != "\x00\x00\x00\x00\x00\x00\x00\x00\x00x" {
+			t.Errorf("Expected zeros before the write, got %q, %v", buf, err)
+		}
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		_, err := fs.OpenFile("missing.db", os.O_RDWR, 0666)
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		for name, data := range map[string]string{"old.db": "old", "new.db": "new"} {
+			f, _ := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+			f.WriteAt([]byte(data), 0)
+			f.Close()
+		}
+		if err := fs.Rename("new.db", "old.db"); err != nil {
+			t.Fatalf("Failed to rename: %v", err)
+		}
+		if _, err := fs.Stat("new.db"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the old name to be gone, got %v", err)
+		}
+		f, err := fs.OpenFile("old.db", os.O_RDONLY, 0666)
+		if err != nil {
+			t.Fatalf("Failed to open renamed file: %v", err)
+		}
+		defer f.Close()
+		buf := make([]byte, 3)
+		if _, err := f.ReadAt(buf, 0); err != nil || string(buf) != "new" {
+			t.Errorf("Expected the renamed contents, got %q, %v", buf, err)
+		}
+		if err := fs.Rename("missing.db", "old.db"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected os.ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("Glob", func(t *testing.T) {
+		for _, name := range []string{"runs.db.sort1.2", "runs.db.sort1.1", "runs.db"} {
+			f, _ := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+			f.Close()
+		}
+		names, err := fs.Glob("runs.db.sort*")
+		if err != nil || len(names) != 2 || names[0] != "runs.db.sort1.1" || names[1] != "runs.db.sort1.2" {
+			t.Errorf("Expected the two runs in order, got %v, %v", names, err)
+		}
+		if _, err := fs.Glob("["); err == nil {
+			t.Error("Expected a malformed pattern to fail")
+		}
+	})
+
+	t.Run("Locking", func(t *testing.T) {
+		a, _ := fs.OpenFile("lock.db", os.O_RDWR|os.O_CREATE, 0666)
+		b, _ := fs.OpenFile("lock.db", os.O_RDWR, 0666)
+		defer b.Close()
+
+		if err := a.Lock(LockExclusive); err != nil {
+			t.Fatalf("Failed to take exclusive lock: %v", err)
+		}
+		if err := b.Lock(LockShared); err != ErrLocked {
+			t.Errorf("Expected ErrLocked for shared lock, got %v", err)
+		}
+
+		// Closing the handle releases its lock
+		a.Close()
+		if err := b.Lock(LockShared); err != nil {
+			t.Errorf("Expected shared lock after close, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// OS is the file system backed by the operating system
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: file}, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// osFile adds advisory locking to *os.File, which already provides the rest of File
+type osFile struct {
+	*os.File
+}
+
+func (f *osFile) Lock(lockType LockType) error {
+	return lockFile(f.File, lockType)
+}
+
+func (f *osFile) Unlock() error {
+	return unlockFile(f.File)
+}
@@ -0,0 +1,53 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+)
+
+// vfs hides where the database and WAL bytes actually live. The storage and
+// wal packages only talk to these interfaces, so the same code can run on
+// real files, entirely in memory for tests, or on top of wrapped files that
+// add instrumentation or encryption.
+
+// ErrLocked is returned by Lock when another handle already holds a conflicting lock
+var ErrLocked = errors.New("vfs: file is locked")
+
+// LockType selects between shared and exclusive advisory locks
+type LockType int
+
+const (
+	LockShared    LockType = iota // many holders, used by read-only openers
+	LockExclusive                 // single holder, used by writers
+)
+
+// FS opens files. flag and perm have the same meaning as for os.OpenFile.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+
+	// Rename replaces newName with oldName in one step, like os.Rename
+	Rename(oldName, newName string) error
+
+	// Glob returns the names of the files matching a pattern, like
+	// filepath.Glob
+	Glob(pattern string) ([]string, error)
+}
+
+// File is the set of operations the database needs from an open file.
+// All I/O is positional so callers never depend on a shared file offset.
+type File interface {
+	ReadAt(p []byte, off int64) (int, error)
+	WriteAt(p []byte, off int64) (int, error)
+	Sync() error
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+
+	// Lock takes an advisory lock without blocking. It returns ErrLocked
+	// if the lock is held elsewhere in a conflicting mode.
+	Lock(lockType LockType) error
+	Unlock() error
+
+	Close() error
+}
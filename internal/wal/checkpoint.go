@@ -1,8 +1,14 @@
 package wal
 
 import (
-    "encoding/binary"
-    "time"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"godb/internal/vfs"
 )
 
 // TIRTHRAJ IF YOURE STALKING THIS FUCK YOU GET A LIFE BITCH
@@ -12,7 +18,6 @@ import (
 // Dirty pages are pages that have been modified but not yet pushed to disk
 // This is used for recovery to bring the database to a consistent state
 
-
 // why checkpoint is needed?
 // checkpoint is needed to bring the database to a consistent state
 // checkpoint is needed to reduce the time taken for recovery
@@ -20,36 +25,195 @@ import (
 //without checkpoint, recovery would have to replay the entire log from the beginning
 //with checkpoint, recovery can start from the checkpoint and replay only the changes since the checkpoint
 
-
 type Checkpoint struct {
-    LSN       LSN
-    Timestamp time.Time
-    DirtyPages []PageLSN
+	LSN        LSN
+	Timestamp  time.Time
+	DirtyPages []PageLSN
 }
 
 func (w *WAL) CreateCheckpoint() error {
-    w.mu.Lock()
-    defer w.mu.Unlock()
+	return w.CreateCheckpointFrom(w.CurrentLSN())
+}
+
+// CreateCheckpointFrom writes a checkpoint whose redo point is redoLSN.
+// Recovery replays every entry after redoLSN, so a caller that flushes pages
+// while writers keep logging passes the LSN it saw before the flush began.
+func (w *WAL) CreateCheckpointFrom(redoLSN LSN) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	checkpoint := &Checkpoint{
+		LSN:       redoLSN,    // everything after this LSN must be replayed
+		Timestamp: time.Now(), // timestamp is the current time
+		// Get list of dirty pages from buffer manager
+		DirtyPages: w.getDirtyPages(), // get list of dirty pages from buffer manager
+	}
 
-    checkpoint := &Checkpoint{
-        LSN:       w.currentLSN, // current LSN is the last LSN that was written to the log
-        Timestamp: time.Now(), // timestamp is the current time
-        // Get list of dirty pages from buffer manager
-        DirtyPages: w.getDirtyPages(), // get list of dirty pages from buffer manager
-    }
+	// Write checkpoint to disk
+	if err := w.append(w.serializeCheckpoint(checkpoint)); err != nil {
+		return err
+	}
 
-    // Write checkpoint to disk
-    data := w.serializeCheckpoint(checkpoint)
-    _, err := w.file.Write(data)
-    if err != nil {
-        return err
-    }
+	// Force checkpoint to disk
+	if err := w.sync(); err != nil {
+		return err
+	}
+	w.checkpointPos = w.writePos
+	return w.truncate(redoLSN)
+}
+
+// truncate drops the entries recovery no longer needs now that a checkpoint
+// with redoLSN is on disk: those up to redoLSN, but for any transaction still
+// open, whose begin entry recovery looks for. The entries kept are written to
+// a new log whose start LSN continues the old one, which then replaces the
+// old log, so a crash leaves one or the other. Logs without a header cannot
+// say where they start and are left alone, as is a log someone holds. The
+// caller must hold w.mu.
+func (w *WAL) truncate(redoLSN LSN) error {
+	if w.start == 0 || w.holds > 0 {
+		return nil
+	}
+
+	keep, startLSN, err := w.firstAfter(redoLSN)
+	if err != nil {
+		return err
+	}
+	for _, pos := range w.open {
+		keep = min(keep, pos)
+	}
+	if keep <= w.start {
+		return nil
+	}
+	if keep < w.writePos {
+		entry, _, err := w.readEntryAt(keep)
+		if err != nil {
+			return err
+		}
+		startLSN = entry.LSN - 1
+	}
+
+	// Write the new log next to the old one. Both are closed before the
+	// rename, which some systems refuse for open files.
+	tmpPath := w.filename + ".truncate"
+	if err := w.copyTail(tmpPath, keep, startLSN); err != nil {
+		w.fs.Remove(tmpPath)
+		return err
+	}
+	w.file.Close()
+	renameErr := w.fs.Rename(tmpPath, w.filename)
+	if renameErr != nil {
+		w.fs.Remove(tmpPath)
+	}
+	file, err := w.fs.OpenFile(w.filename, os.O_RDWR, 0666)
+	if err == nil {
+		err = file.Lock(vfs.LockExclusive)
+	}
+	if err != nil {
+		return errors.Join(renameErr, fmt.Errorf("reopening %s: %w", w.filename, err))
+	}
+	w.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+
+	shift := keep - w.start
+	w.writePos -= shift
+	w.flushPos -= shift
+	w.checkpointPos -= shift
+	for txID := range w.open {
+		w.open[txID] -= shift
+	}
+	return nil
+}
 
-    // Force checkpoint to disk
-    return w.file.Sync()
+// firstAfter returns the position of the first entry with an LSN above lsn,
+// or the end of the log and its last LSN when there is none
+func (w *WAL) firstAfter(lsn LSN) (int64, LSN, error) {
+	header := make([]byte, entryHeaderSize)
+	length := make([]byte, 4)
+	for pos := w.start; pos < w.writePos; {
+		if err := w.readFull(header, pos); err != nil {
+			return 0, 0, err
+		}
+		if LSN(binary.BigEndian.Uint64(header[0:])) > lsn {
+			return pos, 0, nil
+		}
+		// Skip the before image, then the after image
+		pos += entryHeaderSize + int64(binary.BigEndian.Uint32(header[40:]))
+		if err := w.readFull(length, pos); err != nil {
+			return 0, 0, err
+		}
+		pos += 4 + int64(binary.BigEndian.Uint32(length))
+	}
+	return w.writePos, w.currentLSN, nil
+}
+
+// copyTail writes a new log to path holding a header with startLSN and the
+// entries from pos on, and syncs it
+func (w *WAL) copyTail(path string, pos int64, startLSN LSN) error {
+	file, err := w.fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	err = WriteHeader(file, startLSN)
+	if err == nil {
+		src := io.NewSectionReader(w.file, pos, w.writePos-pos)
+		_, err = io.Copy(io.NewOffsetWriter(file, HeaderSize), src)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	return errors.Join(err, file.Close())
 }
 
 func (w *WAL) getDirtyPages() []PageLSN {
-    // Implementation to get dirty pages from buffer manager
-    return nil
-} 
\ No newline at end of file
+	// Implementation to get dirty pages from buffer manager
+	return nil
+}
+
+// serializeCheckpoint wraps a checkpoint in a log entry so it is stored and
+// read back like any other entry. The After image holds the checkpoint LSN
+// followed by the dirty page table.
+func (w *WAL) serializeCheckpoint(checkpoint *Checkpoint) *LogEntry {
+	data := make([]byte, 8+4+len(checkpoint.DirtyPages)*16)
+	binary.BigEndian.PutUint64(data[0:], uint64(checkpoint.LSN))
+	binary.BigEndian.PutUint32(data[8:], uint32(len(checkpoint.DirtyPages)))
+
+	offset := 12
+	for _, page := range checkpoint.DirtyPages {
+		binary.BigEndian.PutUint64(data[offset:], page.PageID)
+		binary.BigEndian.PutUint64(data[offset+8:], uint64(page.LSN))
+		offset += 16
+	}
+
+	return &LogEntry{
+		Type:   LogTypeCheckpoint,
+		Record: LogRecord{After: data},
+	}
+}
+
+// deserializeCheckpoint reads back the checkpoint stored in a log entry
+func deserializeCheckpoint(entry *LogEntry) (*Checkpoint, error) {
+	data := entry.Record.After
+	if len(data) < 12 {
+		return nil, errors.New("corrupt checkpoint record")
+	}
+
+	count := binary.BigEndian.Uint32(data[8:])
+	if len(data) < 12+int(count)*16 {
+		return nil, errors.New("corrupt checkpoint record")
+	}
+
+	checkpoint := &Checkpoint{
+		LSN:        LSN(binary.BigEndian.Uint64(data[0:])),
+		Timestamp:  entry.Timestamp,
+		DirtyPages: make([]PageLSN, count),
+	}
+	offset := 12
+	for i := range checkpoint.DirtyPages {
+		checkpoint.DirtyPages[i].PageID = binary.BigEndian.Uint64(data[offset:])
+		checkpoint.DirtyPages[i].LSN = LSN(binary.BigEndian.Uint64(data[offset+8:]))
+		offset += 16
+	}
+	return checkpoint, nil
+}
@@ -3,11 +3,16 @@ package wal
 // TIRTHRAJ IF YOURE STALKING THIS FUCK YOU GET A LIFE BITCH
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
 	"time"
+
+	"godb/internal/vfs"
 )
 
 // gonna implement this last to the project
@@ -27,28 +32,207 @@ type LogEntry struct {
 	Record    LogRecord // Actual changes
 }
 
+// The log starts with a header and is followed by the entries. The header
+// and every entry are big-endian:
+//
+//	0   magic "GODBWAL\x00"
+//	8   format version, shared with the database file
+//	12  reserved, zero
+//	16  start LSN: the log continues after this LSN, so LSNs keep growing
+//	    when a log is replaced by an empty one or truncated by a checkpoint
+//
+// Version 1 logs have no header and start with the first entry.
+
+// FormatVersion is the version of the log and database file formats written
+// by this build
+const FormatVersion = 2
+
+// HeaderSize is the size of the log header; the first entry follows it
+const HeaderSize = 24
+
+var headerMagic = [8]byte{'G', 'O', 'D', 'B', 'W', 'A', 'L', 0}
+
+// ErrUnsupportedVersion is returned when a log was written in a format
+// version this build does not read
+var ErrUnsupportedVersion = errors.New("unsupported log format version")
+
+// entryHeaderSize is the fixed part of a serialized entry: LSN, timestamp,
+// TxID, type, PageID, page offset and the length of the before image
+const entryHeaderSize = 8 + 8 + 8 + 4 + 8 + 4 + 4
+
 type WAL struct {
-	mu       sync.Mutex // using mutex to ensure thread safety
-	file     *os.File
-	filename string
-	currentLSN LSN
-	buffer   []byte      // Buffer for writing
-	bufSize  int        // Size of the buffer
-	flushPos int64      // Position of last flush
+	mu            sync.Mutex // using mutex to ensure thread safety
+	fs            vfs.FS     // holds the log, and the new log a checkpoint truncates it to
+	file          vfs.File
+	filename      string
+	start         int64 // position of the first entry, after the header
+	currentLSN    LSN
+	buffer        []byte // Buffer for writing
+	bufSize       int    // Size of the buffer
+	flushPos      int64  // Position of last flush
+	writePos      int64  // End of the log, where the next entry is appended
+	checkpointPos int64  // Log size right after the last checkpoint
+	flushedLSN    LSN    // Last LSN known to be on disk
+	scheduler     *CheckpointScheduler
+	open          map[uint64]int64 // position of the begin entry of every transaction not ended yet
+	holds         int              // readers keeping the log from being truncated, see Hold
 }
+
+// ErrLocked is returned when another process already has the log open for writing
+var ErrLocked = errors.New("database log is locked")
+
 // creates a new WAL instance
 func NewWAL(filename string) (*WAL, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	return NewWALWithFS(vfs.OS, filename)
+}
+
+// NewWALWithFS opens the log through the given file system. An empty file
+// gets a header; a log in another format version is refused with
+// ErrUnsupportedVersion.
+func NewWALWithFS(fs vfs.FS, filename string) (*WAL, error) {
+	return openWAL(fs, filename, FormatVersion)
+}
+
+// OpenVersion1 opens a log written before logs had a header, so it can be
+// replayed by an upgrade. Entries appended to it keep the version 1 layout.
+func OpenVersion1(fs vfs.FS, filename string) (*WAL, error) {
+	return openWAL(fs, filename, 1)
+}
+
+func openWAL(fs vfs.FS, filename string, version uint32) (*WAL, error) {
+	file, err := fs.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
 
-	return &WAL{
+	// Only one writer may append to the log at a time
+	if err := file.Lock(vfs.LockExclusive); err != nil {
+		file.Close()
+		if errors.Is(err, vfs.ErrLocked) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, filename)
+		}
+		return nil, err
+	}
+
+	w := &WAL{
+		fs:       fs,
 		file:     file,
 		filename: filename,
 		buffer:   make([]byte, 32*1024), // 32KB buffer
 		bufSize:  32 * 1024,
-	}, nil
+		open:     make(map[uint64]int64),
+	}
+	if err := w.checkHeader(version); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Find the end of the existing log so new entries are appended after it
+	// and LSNs keep increasing across restarts
+	if err := w.scanToEnd(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// checkHeader reads the header of an existing log, or writes one to an empty
+// file, and sets the position of the first entry and the start LSN
+func (w *WAL) checkHeader(want uint32) error {
+	found, startLSN, err := readHeader(w.file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", w.filename, err)
+	}
+	if found == 0 {
+		found = want
+		if want == FormatVersion {
+			if err := WriteHeader(w.file, 0); err != nil {
+				return err
+			}
+			if err := w.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	if found != want {
+		return fmt.Errorf("%w: %s is version %d, expected %d", ErrUnsupportedVersion, w.filename, found, want)
+	}
+
+	if found >= 2 {
+		w.start = HeaderSize
+	}
+	w.currentLSN = startLSN
+	return nil
+}
+
+// readHeader returns the format version and start LSN of a log, or version
+// 0 for an empty file
+func readHeader(file vfs.File) (uint32, LSN, error) {
+	header := make([]byte, HeaderSize)
+	n, err := file.ReadAt(header, 0)
+	if n == 0 && (err == nil || errors.Is(err, io.EOF)) {
+		return 0, 0, nil
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, err
+	}
+	if n < len(headerMagic) || !bytes.Equal(header[:len(headerMagic)], headerMagic[:]) {
+		// Version 1 logs start right away with an entry
+		return 1, 0, nil
+	}
+	if n < HeaderSize {
+		return 0, 0, errors.New("log header is truncated")
+	}
+	return binary.BigEndian.Uint32(header[8:]), LSN(binary.BigEndian.Uint64(header[16:])), nil
+}
+
+// WriteHeader writes the header of a log in the current format to the start
+// of w. Entries written after it must have LSNs above startLSN.
+func WriteHeader(w io.WriterAt, startLSN LSN) error {
+	header := make([]byte, HeaderSize)
+	copy(header, headerMagic[:])
+	binary.BigEndian.PutUint32(header[8:], FormatVersion)
+	binary.BigEndian.PutUint64(header[16:], uint64(startLSN))
+	_, err := w.WriteAt(header, 0)
+	return err
+}
+
+// Version returns the format version of the log at filename. An empty file
+// takes the current version when it is opened.
+func Version(fs vfs.FS, filename string) (uint32, error) {
+	file, err := fs.OpenFile(filename, os.O_RDONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	version, _, err := readHeader(file)
+	if version == 0 {
+		version = FormatVersion
+	}
+	return version, err
+}
+
+// scanToEnd walks the existing entries to restore currentLSN and writePos.
+// A partially written entry at the tail is ignored and will be overwritten.
+func (w *WAL) scanToEnd() error {
+	pos := w.start
+	for {
+		entry, next, err := w.readEntryAt(pos)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		w.currentLSN = entry.LSN
+		w.track(entry, pos)
+		pos = next
+	}
+	w.writePos = pos
+	w.flushPos = pos
+	w.checkpointPos = pos
+	w.flushedLSN = w.currentLSN
+	return nil
 }
 
 // For writing log entries to the WAL
@@ -56,6 +240,29 @@ func (w *WAL) Write(entry *LogEntry) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	if err := w.append(entry); err != nil {
+		return err
+	}
+
+	// Force write to disk if this is a commit
+	if entry.Type == LogTypeCommitTx {
+		return w.sync()
+	}
+
+	return nil
+}
+
+// WriteNoSync writes a log entry like Write but never forces it to disk, not
+// even a commit; it reaches disk with the next sync
+func (w *WAL) WriteNoSync(entry *LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.append(entry)
+}
+
+// append assigns the next LSN to entry and writes it at the end of the log.
+// The caller must hold w.mu.
+func (w *WAL) append(entry *LogEntry) error {
 	// Assign next LSN
 	w.currentLSN++
 	entry.LSN = w.currentLSN
@@ -68,27 +275,92 @@ func (w *WAL) Write(entry *LogEntry) error {
 	}
 
 	// Write to file
-	if _, err := w.file.Write(data); err != nil {
+	if _, err := w.file.WriteAt(data, w.writePos); err != nil {
 		return err
 	}
+	w.track(entry, w.writePos)
+	w.writePos += int64(len(data))
+	return nil
+}
 
-	// Force write to disk if this is a commit
-	if entry.Type == LogTypeCommitTx {
-		return w.file.Sync()
+// track keeps the position of the begin entry of every open transaction, as
+// a checkpoint must not truncate the log past it
+func (w *WAL) track(entry *LogEntry, pos int64) {
+	switch entry.Type {
+	case LogTypeBeginTx:
+		w.open[entry.TxID] = pos
+	case LogTypeCommitTx, LogTypeAbortTx:
+		delete(w.open, entry.TxID)
 	}
+}
 
+// sync forces everything written so far to disk. The caller must hold w.mu.
+func (w *WAL) sync() error {
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.flushPos = w.writePos
+	w.flushedLSN = w.currentLSN
 	return nil
 }
 
+// Sync forces all log entries written so far to disk
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+// SyncTo makes sure every entry up to and including lsn is on disk. Pages
+// call this before they are written so the log always reaches disk first.
+func (w *WAL) SyncTo(lsn LSN) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if lsn <= w.flushedLSN {
+		return nil
+	}
+	return w.sync()
+}
+
+// CurrentLSN returns the LSN of the last entry written to the log
+func (w *WAL) CurrentLSN() LSN {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.currentLSN
+}
+
+// Size returns the number of bytes in the log
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writePos
+}
+
+// SinceCheckpoint returns how many bytes were logged after the last checkpoint
+func (w *WAL) SinceCheckpoint() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writePos - w.checkpointPos
+}
+
+// Close stops the checkpoint scheduler, flushes the log and closes the file
+func (w *WAL) Close() error {
+	w.StopCheckpointScheduler()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
 func (w *WAL) serializeEntry(entry *LogEntry) ([]byte, error) {
 	// Calculate total size needed
-	size := 8 + // LSN
-		8 + // Timestamp
-		8 + // TxID
-		4 + // Type
-		8 + // PageID
-		4 + // Record size
+	size := entryHeaderSize +
 		len(entry.Record.Before) +
+		4 + // After size
 		len(entry.Record.After)
 
 	buf := make([]byte, size)
@@ -114,6 +386,10 @@ func (w *WAL) serializeEntry(entry *LogEntry) ([]byte, error) {
 	binary.BigEndian.PutUint64(buf[offset:], entry.PageID)
 	offset += 8
 
+	// Write page offset of the change
+	binary.BigEndian.PutUint32(buf[offset:], entry.Record.Offset)
+	offset += 4
+
 	// Write Record
 	binary.BigEndian.PutUint32(buf[offset:], uint32(len(entry.Record.Before)))
 	offset += 4
@@ -126,3 +402,125 @@ func (w *WAL) serializeEntry(entry *LogEntry) ([]byte, error) {
 
 	return buf, nil
 }
+
+// readEntryAt reads the entry starting at pos and returns it together with
+// the position of the next entry. It returns io.EOF at the end of the log,
+// including when the last entry was only partially written.
+func (w *WAL) readEntryAt(pos int64) (*LogEntry, int64, error) {
+	header := make([]byte, entryHeaderSize)
+	if err := w.readFull(header, pos); err != nil {
+		return nil, 0, err
+	}
+	pos += entryHeaderSize
+
+	entry := &LogEntry{
+		LSN:       LSN(binary.BigEndian.Uint64(header[0:])),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[8:]))),
+		TxID:      binary.BigEndian.Uint64(header[16:]),
+		Type:      LogType(binary.BigEndian.Uint32(header[24:])),
+		PageID:    binary.BigEndian.Uint64(header[28:]),
+	}
+
+	before, err := w.readBytes(pos, binary.BigEndian.Uint32(header[40:]))
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += int64(len(before))
+
+	lenBuf := make([]byte, 4)
+	if err := w.readFull(lenBuf, pos); err != nil {
+		return nil, 0, err
+	}
+	pos += 4
+
+	after, err := w.readBytes(pos, binary.BigEndian.Uint32(lenBuf))
+	if err != nil {
+		return nil, 0, err
+	}
+	pos += int64(len(after))
+
+	entry.Record = LogRecord{
+		Offset: binary.BigEndian.Uint32(header[36:]),
+		Before: before,
+		After:  after,
+	}
+	return entry, pos, nil
+}
+
+// readBytes reads a length-prefixed section of an entry
+func (w *WAL) readBytes(pos int64, length uint32) ([]byte, error) {
+	if length == 0 {
+		return nil, nil
+	}
+	data := make([]byte, length)
+	if err := w.readFull(data, pos); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readFull fills buf from pos. A short read means the tail of the log was
+// torn, which is reported as io.EOF.
+func (w *WAL) readFull(buf []byte, pos int64) error {
+	n, err := w.file.ReadAt(buf, pos)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// Hold keeps checkpoints from truncating the log until release is called, so
+// entries can be copied by position, see CopyEntries
+func (w *WAL) Hold() (release func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.holds++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.holds--
+		})
+	}
+}
+
+// CopyEntries writes every data entry with after < LSN <= upTo to dst in the
+// log's own entry format, without the header, and returns how many entries were copied. Checkpoint
+// records are left out, so replaying the copy never skips an entry.
+// Writers may keep appending while the copy runs; the caller holds the log,
+// see Hold, so no checkpoint truncates it meanwhile.
+func (w *WAL) CopyEntries(dst io.Writer, after, upTo LSN) (int, error) {
+	w.mu.Lock()
+	end := w.writePos
+	w.mu.Unlock()
+
+	copied := 0
+	for pos := w.start; pos < end; {
+		entry, next, err := w.readEntryAt(pos)
+		if err != nil {
+			return copied, err
+		}
+		pos = next
+
+		if entry.LSN <= after || entry.Type == LogTypeCheckpoint {
+			continue
+		}
+		if entry.LSN > upTo {
+			break
+		}
+
+		data, err := w.serializeEntry(entry)
+		if err != nil {
+			return copied, err
+		}
+		if _, err := dst.Write(data); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, nil
+}
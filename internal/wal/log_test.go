@@ -0,0 +1,194 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"godb/internal/vfs"
+)
+
+func TestLogHeader(t *testing.T) {
+	fs := vfs.NewMemFS()
+
+	t.Run("New Log", func(t *testing.T) {
+		w, err := NewWALWithFS(fs, "new.wal")
+		if err != nil {
+			t.Fatalf("Failed to create WAL: %v", err)
+		}
+		if err := w.Write(&LogEntry{Type: LogTypeInsert, Record: LogRecord{After: []byte("a")}}); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		w.Close()
+
+		if version, err := Version(fs, "new.wal"); err != nil || version != FormatVersion {
+			t.Errorf("Expected version %d, got %d, %v", FormatVersion, version, err)
+		}
+		w, err = NewWALWithFS(fs, "new.wal")
+		if err != nil {
+			t.Fatalf("Failed to reopen WAL: %v", err)
+		}
+		defer w.Close()
+		if w.CurrentLSN() != 1 {
+			t.Errorf("Expected LSN 1 after reopening, got %d", w.CurrentLSN())
+		}
+	})
+
+	t.Run("Start LSN", func(t *testing.T) {
+		f, _ := fs.OpenFile("reset.wal", os.O_RDWR|os.O_CREATE, 0666)
+		if err := WriteHeader(f, 41); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		f.Close()
+
+		w, err := NewWALWithFS(fs, "reset.wal")
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		defer w.Close()
+		entry := &LogEntry{Type: LogTypeInsert}
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if entry.LSN != 42 {
+			t.Errorf("Expected the log to continue at LSN 42, got %d", entry.LSN)
+		}
+	})
+
+	t.Run("Version 1", func(t *testing.T) {
+		// A version 1 log is a bare sequence of entries
+		data, _ := (&WAL{}).serializeEntry(&LogEntry{LSN: 7, Type: LogTypeInsert, Record: LogRecord{After: []byte("old")}})
+		f, _ := fs.OpenFile("old.wal", os.O_RDWR|os.O_CREATE, 0666)
+		f.WriteAt(data, 0)
+		f.Close()
+
+		if version, err := Version(fs, "old.wal"); err != nil || version != 1 {
+			t.Errorf("Expected version 1, got %d, %v", version, err)
+		}
+		if _, err := NewWALWithFS(fs, "old.wal"); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+		}
+
+		w, err := OpenVersion1(fs, "old.wal")
+		if err != nil {
+			t.Fatalf("Failed to open version 1 log: %v", err)
+		}
+		defer w.Close()
+		r := w.StartRecovery()
+		if err := r.Recover(); err != nil {
+			t.Fatalf("Recovery failed: %v", err)
+		}
+		if redo := r.RedoLog(); len(redo) != 1 || redo[0].LSN != 7 || string(redo[0].Record.After) != "old" {
+			t.Errorf("Unexpected redo log %+v", redo)
+		}
+	})
+}
+
+func TestIncompleteTransactions(t *testing.T) {
+	w, err := NewWALWithFS(vfs.NewMemFS(), "tx.wal")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries := []*LogEntry{
+		{TxID: 1, Type: LogTypeBeginTx, PageID: 4, Record: LogRecord{Offset: 2, Before: []byte("old")}},
+		{TxID: 2, Type: LogTypeBeginTx, PageID: 5},
+		{TxID: 3, Type: LogTypeBeginTx, PageID: 6},
+		{Type: LogTypeUpdate, PageID: 4, Record: LogRecord{After: []byte("x")}},
+		{TxID: 2, Type: LogTypeCommitTx},
+		{TxID: 3, Type: LogTypeAbortTx},
+	}
+	for _, entry := range entries {
+		if err := w.WriteNoSync(entry); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	r := w.StartRecovery()
+	if err := r.Recover(); err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+	begins := r.Incomplete()
+	if len(begins) != 1 || begins[0].TxID != 1 || begins[0].PageID != 4 || begins[0].Record.Offset != 2 || string(begins[0].Record.Before) != "old" {
+		t.Errorf("Expected transaction 1 with its begin entry, got %+v", begins)
+	}
+	if len(r.RedoLog()) != 1 {
+		t.Errorf("Expected the page change to be redone, got %+v", r.RedoLog())
+	}
+}
+
+func TestLogTruncation(t *testing.T) {
+	fs := vfs.NewMemFS()
+	w, err := NewWALWithFS(fs, "trunc.wal")
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	write := func(entry *LogEntry) {
+		t.Helper()
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	page := make([]byte, 4096)
+
+	// Each checkpoint drops what the one before it covered
+	var largest int64
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 50; i++ {
+			write(&LogEntry{Type: LogTypeFullPage, PageID: uint64(i), Record: LogRecord{After: page}})
+		}
+		if err := w.CreateCheckpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		largest = max(largest, w.Size())
+	}
+	if largest > 1024 {
+		t.Errorf("Expected the log to stay small across checkpoints, it reached %d bytes", largest)
+	}
+	if info, err := fs.Stat("trunc.wal"); err != nil || info.Size() != w.Size() {
+		t.Errorf("Expected the file to hold %d bytes, got %v %v", w.Size(), info, err)
+	}
+
+	// An open transaction keeps its begin entry, and a held log is left alone
+	for i := 0; i < 10; i++ {
+		write(&LogEntry{Type: LogTypeFullPage, PageID: uint64(i), Record: LogRecord{After: page}})
+	}
+	write(&LogEntry{TxID: 7, Type: LogTypeBeginTx, PageID: 3})
+	write(&LogEntry{Type: LogTypeFullPage, PageID: 3, Record: LogRecord{After: page}})
+	release := w.Hold()
+	if err := w.CreateCheckpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	held := w.Size()
+	if held < 10*int64(len(page)) {
+		t.Errorf("Expected a held log to keep its entries, got %d bytes", held)
+	}
+	release()
+	if err := w.CreateCheckpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if w.Size() >= held || w.Size() < int64(len(page)) {
+		t.Errorf("Expected the log to shrink to the open transaction, got %d bytes from %d", w.Size(), held)
+	}
+	lsn := w.CurrentLSN()
+	w.Close()
+
+	if w, err = NewWALWithFS(fs, "trunc.wal"); err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+	if w.CurrentLSN() != lsn {
+		t.Errorf("Expected LSNs to continue at %d, got %d", lsn, w.CurrentLSN())
+	}
+	r := w.StartRecovery()
+	if err := r.Recover(); err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+	if begins := r.Incomplete(); len(begins) != 1 || begins[0].TxID != 7 {
+		t.Errorf("Expected transaction 7 to be open, got %+v", begins)
+	}
+	if len(r.RedoLog()) != 0 {
+		t.Errorf("Expected nothing to redo after the checkpoint, got %d entries", len(r.RedoLog()))
+	}
+}
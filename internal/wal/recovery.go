@@ -1,83 +1,134 @@
 package wal
 
 import (
-    "encoding/binary"
-    "io"
+	"cmp"
+	"io"
+	"slices"
 )
 
 // TIRTHRAJ IF YOURE STALKING THIS FUCK YOU GET A LIFE BITCH
 
 type Recovery struct {
-    wal *WAL
-    activeTxs map[uint64]struct{} // set of active transactions during crash
-    redoLog []LogEntry            // Log entries that need to be replayed
+	wal       *WAL
+	activeTxs map[uint64]LogEntry // begin entry of each transaction active during crash
+	redoLog   []LogEntry          // Log entries that need to be replayed
+	redoLSN   LSN                 // Redo point of the last checkpoint
+	pos       int64               // Position of the next entry to read
 }
 
 func (w *WAL) StartRecovery() *Recovery {
-    return &Recovery{
-        wal: w,
-        activeTxs: make(map[uint64]struct{}),
-    }
+	return &Recovery{
+		wal:       w,
+		activeTxs: make(map[uint64]LogEntry),
+	}
 }
 
 //The recovery happens in three phases (known as ARIES recovery protocol)
 //1. Analysis phase: scan log to identify active transactions
 //2. Redo phase: replay all changes
-//3. Undo phase: rollback incomplete transactions
-
+//3. Undo phase: left to the caller, which gets the transactions that never
+//   ended from Incomplete and closes each with a commit or an abort entry
 
 func (r *Recovery) Recover() error {
-    // Reset file position
-    if _, err := r.wal.file.Seek(0, 0); err != nil {
-        return err
-    }
+	// Reset file position
+	r.pos = r.wal.start
 
-    // Analysis phase: scan log to identify active transactions
+	// Analysis phase: scan log to identify active transactions
 	// analysis phase madhe entire log scan hoto which identifies which transactions were active during crash
 	// this is done by scanning the log and identifying the log entries that are of type LogTypeBeginTx and LogTypeCommitTx
-    if err := r.analysisPhase(); err != nil {
-        return err
-    }
+	if err := r.analysisPhase(); err != nil {
+		return err
+	}
 
-    // Redo phase: replay all changes
+	// Redo phase: replay all changes
 	// Should implement:
-    // 1. Replay all changes in the log
-    // 2. Bring database to state it was in before crash
-    // 3. Apply changes even for transactions that didn't commit
-    if err := r.redoPhase(); err != nil {
-        return err
-    }
-
-    // Undo phase: rollback incomplete transactions
-	 // Should implement:
-    // 1. Rollback all active transactions found in analysis phase
-    // 2. Use 'Before' images to restore original data
-    // 3. Write compensation log records
-    return r.undoPhase()
+	// 1. Replay all changes in the log
+	// 2. Bring database to state it was in before crash
+	// 3. Apply changes even for transactions that didn't commit
+	return r.redoPhase()
 }
 
 func (r *Recovery) analysisPhase() error {
-    for {
-        entry, err := r.readLogEntry()
-        if err == io.EOF {
-            break
-        }
-        if err != nil {
-            return err
-        }
-
-        switch entry.Type {
-        case LogTypeBeginTx:
-            r.activeTxs[entry.TxID] = struct{}{}
-        case LogTypeCommitTx, LogTypeAbortTx:
-            delete(r.activeTxs, entry.TxID)
-        }
-    }
-    return nil
+	for {
+		entry, err := r.readLogEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch entry.Type {
+		case LogTypeBeginTx:
+			r.activeTxs[entry.TxID] = *entry
+		case LogTypeCommitTx, LogTypeAbortTx:
+			delete(r.activeTxs, entry.TxID)
+		case LogTypeCheckpoint:
+			// Everything before the last checkpoint's redo point is already on disk
+			checkpoint, err := deserializeCheckpoint(entry)
+			if err != nil {
+				return err
+			}
+			r.redoLSN = checkpoint.LSN
+		}
+	}
+	return nil
+}
+
+// redoPhase collects every page change after the last checkpoint's redo
+// point, in log order, so it can be replayed. Full page images are included:
+// replaying one restores a page even if it was torn by a crash mid-write.
+func (r *Recovery) redoPhase() error {
+	r.pos = r.wal.start
+	r.redoLog = r.redoLog[:0]
+	for {
+		entry, err := r.readLogEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if entry.LSN <= r.redoLSN {
+			continue
+		}
+		switch entry.Type {
+		case LogTypeInsert, LogTypeUpdate, LogTypeDelete, LogTypeFullPage:
+			r.redoLog = append(r.redoLog, *entry)
+		}
+	}
+	return nil
+}
+
+// Incomplete returns the begin entry of every transaction that neither
+// committed nor aborted, in log order. The caller finishes or takes back each
+// one and then writes its commit or abort entry.
+func (r *Recovery) Incomplete() []LogEntry {
+	begins := make([]LogEntry, 0, len(r.activeTxs))
+	for _, entry := range r.activeTxs {
+		begins = append(begins, entry)
+	}
+	slices.SortFunc(begins, func(a, b LogEntry) int { return cmp.Compare(a.LSN, b.LSN) })
+	return begins
+}
+
+// RedoLog returns the changes collected by the redo phase
+func (r *Recovery) RedoLog() []LogEntry {
+	return r.redoLog
+}
+
+// RedoLSN returns the redo point of the last checkpoint found in the log
+func (r *Recovery) RedoLSN() LSN {
+	return r.redoLSN
 }
 
 func (r *Recovery) readLogEntry() (*LogEntry, error) {
-    // Read and deserialize log entry
-    // Implementation details here
-    return nil, nil
-} 
\ No newline at end of file
+	// Read and deserialize log entry
+	entry, next, err := r.wal.readEntryAt(r.pos)
+	if err != nil {
+		return nil, err
+	}
+	r.pos = next
+	return entry, nil
+}
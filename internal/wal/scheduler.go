@@ -0,0 +1,105 @@
+package wal
+
+import (
+	"sync"
+	"time"
+)
+
+// CheckpointPolicy decides when the scheduler takes a checkpoint. A checkpoint
+// is taken when the log has grown by MaxBytes since the last one, or when
+// Interval has passed, whichever comes first. A zero value disables that trigger.
+type CheckpointPolicy struct {
+	MaxBytes int64
+	Interval time.Duration
+
+	// Checkpoint takes the checkpoint. A database sets this to flush its
+	// dirty pages before the checkpoint record is written. When nil the
+	// scheduler calls CreateCheckpoint on the log.
+	Checkpoint func() error
+	// OnError is called when a scheduled checkpoint fails
+	OnError func(error)
+}
+
+// CheckpointScheduler takes checkpoints in the background so nobody has to
+// call CreateCheckpoint by hand
+type CheckpointScheduler struct {
+	wal    *WAL
+	policy CheckpointPolicy
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// pollInterval is how often the scheduler checks the size of the log
+const pollInterval = 100 * time.Millisecond
+
+// StartCheckpointScheduler starts a scheduler for this log. Only one scheduler
+// runs per log; starting a new one stops the previous one.
+func (w *WAL) StartCheckpointScheduler(policy CheckpointPolicy) *CheckpointScheduler {
+	w.StopCheckpointScheduler()
+
+	s := &CheckpointScheduler{
+		wal:    w,
+		policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	w.mu.Lock()
+	w.scheduler = s
+	w.mu.Unlock()
+
+	go s.run()
+	return s
+}
+
+// StopCheckpointScheduler stops the running scheduler, if any, and waits for it
+func (w *WAL) StopCheckpointScheduler() {
+	w.mu.Lock()
+	s := w.scheduler
+	w.scheduler = nil
+	w.mu.Unlock()
+
+	if s != nil {
+		s.Close()
+	}
+}
+
+func (s *CheckpointScheduler) run() {
+	defer close(s.done)
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	lastCheckpoint := time.Now()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-poll.C:
+			sizeDue := s.policy.MaxBytes > 0 && s.wal.SinceCheckpoint() >= s.policy.MaxBytes
+			timeDue := s.policy.Interval > 0 && now.Sub(lastCheckpoint) >= s.policy.Interval
+			if !sizeDue && !timeDue {
+				continue
+			}
+			if err := s.checkpoint(); err != nil && s.policy.OnError != nil {
+				s.policy.OnError(err)
+			}
+			lastCheckpoint = now
+		}
+	}
+}
+
+func (s *CheckpointScheduler) checkpoint() error {
+	if s.policy.Checkpoint != nil {
+		return s.policy.Checkpoint()
+	}
+	return s.wal.CreateCheckpoint()
+}
+
+// Close stops the scheduler and waits for a checkpoint in progress to finish
+func (s *CheckpointScheduler) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckpointScheduler(t *testing.T) {
+	logPath := "test_scheduler.wal"
+	defer os.Remove(logPath)
+
+	w, err := NewWAL(logPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	flushed := make(chan struct{}, 16)
+	w.StartCheckpointScheduler(CheckpointPolicy{
+		MaxBytes: 256,
+		Checkpoint: func() error {
+			flushed <- struct{}{}
+			return w.CreateCheckpoint()
+		},
+		OnError: func(err error) { t.Errorf("Checkpoint failed: %v", err) },
+	})
+
+	// Grow the log past the size threshold
+	for i := 0; i < 10; i++ {
+		entry := &LogEntry{Type: LogTypeInsert, PageID: uint64(i), Record: LogRecord{After: make([]byte, 64)}}
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+
+	select {
+	case <-flushed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Scheduler did not checkpoint after the log grew past MaxBytes")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	// Reopening must find every entry, including the checkpoint record
+	w, err = NewWAL(logPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	if w.CurrentLSN() < 11 {
+		t.Errorf("Expected at least 11 entries after reopen, got LSN %d", w.CurrentLSN())
+	}
+
+	// Only changes made after the checkpoint need to be replayed
+	for i := 0; i < 2; i++ {
+		entry := &LogEntry{Type: LogTypeUpdate, PageID: 1, Record: LogRecord{Offset: 16, After: []byte{1, 2}}}
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+
+	r := w.StartRecovery()
+	if err := r.Recover(); err != nil {
+		t.Fatalf("Recovery failed: %v", err)
+	}
+	if r.RedoLSN() < 10 {
+		t.Errorf("Expected redo point after the first 10 entries, got %d", r.RedoLSN())
+	}
+	if len(r.RedoLog()) != 2 {
+		t.Fatalf("Expected 2 entries to redo, got %d", len(r.RedoLog()))
+	}
+	if got := r.RedoLog()[0].Record; got.Offset != 16 || len(got.After) != 2 {
+		t.Errorf("Unexpected redo record %+v", got)
+	}
+}
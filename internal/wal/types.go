@@ -5,18 +5,21 @@ package wal
 type LogType int
 
 const (
-	LogTypeBeginTx LogType = iota // this will mark the start of a transaction
-	LogTypeCommitTx              // this will mark the end of a transaction
-	LogTypeAbortTx               // this will mark the abort of a transaction
-	LogTypeInsert                // this will mark the insertion of a record
-	LogTypeUpdate                // this will mark the update of a record
-	LogTypeDelete                // this will mark the deletion of a record
+	LogTypeBeginTx    LogType = iota // this will mark the start of a transaction
+	LogTypeCommitTx                  // this will mark the end of a transaction
+	LogTypeAbortTx                   // this will mark the abort of a transaction
+	LogTypeInsert                    // this will mark the insertion of a record
+	LogTypeUpdate                    // this will mark the update of a record
+	LogTypeDelete                    // this will mark the deletion of a record
+	LogTypeCheckpoint                // this will mark a checkpoint
+	LogTypeFullPage                  // this will carry a full page image, logged on the first change to a page after a checkpoint
 )
 
 // LSN (Log Sequence Number) is used to uniquely identify log records
 type LSN uint64
+
 // LSN tracks what the last operation was that modifies a page
-//tracks which page has been modified
+// tracks which page has been modified
 // PageLSN tracks the latest LSN that modified each page
 type PageLSN struct {
 	PageID uint64
@@ -25,11 +28,11 @@ type PageLSN struct {
 
 // LogRecord does the actual data being logged
 type LogRecord struct {
+	Offset uint32 // Where in the page Before and After start
 	Before []byte // Data change chya adhi - used for undo
 	After  []byte // Data change nantar - used for redo
 }
 
-
 //Example of logging an update to a record
 
 // logEntry := &LogEntry{
@@ -40,9 +43,8 @@ type LogRecord struct {
 //     }
 // }
 
-
 // pageLSN := PageLSN{
 //     PageID: 1,
-//     LSN: 1234,  
+//     LSN: 1234,
 // }
 // this is a simple example of a pageLSN
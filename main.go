@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"sort"
 
 	"godb/internal/query"
 	"godb/internal/storage"
@@ -9,20 +11,74 @@ import (
 	"godb/internal/wal"
 )
 
+// command is a godb subcommand, e.g. `godb backup`
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"backup":  {"write a consistent backup of a database", runBackup},
+	"check":   {"check the integrity of a database and print a JSON report", runCheck},
+	"dump":    {"write a SQL script that recreates the database", runDump},
+	"export":  {"write a table or query result as CSV or NDJSON", runExport},
+	"import":  {"load a CSV file into a table", runImport},
+	"inspect": {"decode a page or list a table's pages", runInspect},
+	"restore": {"restore a backup into a new database and check it", runRestore},
+	"sql":     {"run SQL statements from a file or stdin", runSQL},
+	"upgrade": {"rewrite a database from an older format version", runUpgrade},
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		runSmokeTest()
+		return
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "godb %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: godb <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nRun without a command to smoke test every component.\n\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+func runSmokeTest() {
 	// Test Storage Engine
 	db, err := storage.NewDatabase("testdb.db")
 	if err != nil {
 		fmt.Println("Error initializing database:", err)
 		return
 	}
-	defer db.File.Close() // Close the database file when done
+	defer db.Close() // Flush dirty pages and close the database file when done
 	fmt.Println("Database initialized successfully.")
 
 	// Test B-tree Initialization
-	btree := storage.NewBTree(3)
-	if btree == nil {
-		fmt.Println("Error initializing B-tree.")
+	memDB, err := storage.NewDatabase(storage.MemoryPath)
+	if err != nil {
+		fmt.Println("Error initializing B-tree:", err)
+		return
+	}
+	defer memDB.Close()
+	if _, err := memDB.CreateBTree("smoke_idx", 3); err != nil {
+		fmt.Println("Error initializing B-tree:", err)
 		return
 	}
 	fmt.Println("B-tree initialized successfully.")
@@ -33,10 +89,13 @@ func main() {
 		fmt.Println("Error creating WAL file:", err)
 		return
 	}
-	defer walLog.File.Close() // Close the WAL file when done
+	defer walLog.Close() // Close the WAL file when done
 
 	// Test WAL writing
-	err = walLog.Write([]byte("test data"))
+	err = walLog.Write(&wal.LogEntry{
+		Type:   wal.LogTypeInsert,
+		Record: wal.LogRecord{After: []byte("test data")},
+	})
 	if err != nil {
 		fmt.Println("Error writing to WAL:", err)
 		return
@@ -52,9 +111,9 @@ func main() {
 	fmt.Println("Transaction Manager initialized successfully.")
 
 	// Test Query Processor
-	qp := query.QueryProcessor{}
+	qp := query.NewQueryProcessor(db)
 	// Test query execution
-	_, err = qp.Execute("SELECT * FROM test")
+	_, err = qp.Execute("VACUUM")
 	if err != nil {
 		fmt.Println("Error executing query:", err)
 		return